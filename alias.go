@@ -0,0 +1,158 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"sync"
+	"time"
+)
+
+// alias is a name whose A and AAAA records are those of another host, flattened in the style of an ANAME or ALIAS
+// record. The target's records are held until their TTL expires.
+type alias struct {
+	target string
+
+	lock    sync.Mutex
+	answers map[uint16]aliasAnswer
+}
+
+type aliasAnswer struct {
+	records []dns.RR
+	rcode   int
+	expires time.Time
+}
+
+// AddAlias configures name (typically a zone's apex, where a CNAME is not allowed) as an alias of target. Questions
+// for name's A and AAAA records are answered with target's current addresses, as though they were name's own, and
+// refreshed once their TTL expires. Questions for any other type are resolved as normal. The answers are never
+// DNSSEC validated as a whole, so the AD bit is not set; if DNSSEC was requested, they're reported as Insecure.
+// A name cannot be both an alias, and have static records.
+func (resolver *Resolver) AddAlias(name, target string) error {
+	name = canonicalName(name)
+
+	s := &resolver.static
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.records[name]) > 0 {
+		return fmt.Errorf("%w: %s", ErrStaticRecordConflict, name)
+	}
+
+	if s.aliases == nil {
+		s.aliases = make(map[string]*alias)
+	}
+	s.aliases[name] = &alias{
+		target:  canonicalName(target),
+		answers: make(map[uint16]aliasAnswer),
+	}
+	return nil
+}
+
+// RemoveAlias removes the alias for name, if there is one.
+func (resolver *Resolver) RemoveAlias(name string) {
+	s := &resolver.static
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.aliases, canonicalName(name))
+}
+
+// alias returns the alias that answers the question, if there is one. Otherwise nil.
+func (s *staticRecords) alias(question dns.Question) *alias {
+	if question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA {
+		return nil
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.aliases[canonicalName(question.Name)]
+}
+
+// aliasAnswer returns the synthesised response to qmsg, using the target's records of the same type; resolving them
+// if we don't hold an unexpired copy.
+func (resolver *Resolver) aliasAnswer(ctx context.Context, qmsg *dns.Msg, a *alias) *Response {
+	question := qmsg.Question[0]
+
+	a.lock.Lock()
+	answer, ok := a.answers[question.Qtype]
+	a.lock.Unlock()
+
+	if !ok || !answer.expires.After(time.Now()) {
+		tmsg := new(dns.Msg)
+		tmsg.SetQuestion(a.target, question.Qtype)
+		if isSetDO(qmsg) {
+			tmsg.SetEdns0(4096, true)
+		}
+
+		response := resolver.exchange(ctx, tmsg)
+		if response.HasError() {
+			return response
+		}
+		if response.IsEmpty() {
+			return ResponseError(fmt.Errorf("%w: resolving alias target [%s]", ErrEmptyResponse, a.target))
+		}
+		if response.Auth == dnssec.Bogus {
+			return ResponseError(fmt.Errorf("%w: alias target [%s] is %s", ErrUnableToResolveAnswer, a.target, response.Auth))
+		}
+
+		// If the target was itself a CNAME, we only take the records at the end of the chain. They're held for the
+		// lowest of their own TTLs, regardless of the CNAMEs in front of them.
+		records := make([]dns.RR, 0, len(response.Msg.Answer))
+		ttl := MaxAllowedTTL
+		for _, rr := range response.Msg.Answer {
+			if rr.Header().Rrtype == question.Qtype {
+				records = append(records, rr)
+				ttl = min(ttl, rr.Header().Ttl)
+			}
+		}
+
+		// With no records, we hold the answer for the negative caching TTL given by the SOA, if there is one.
+		// See https://datatracker.ietf.org/doc/html/rfc2308#section-5
+		if len(records) == 0 {
+			ttl = 0
+			if soa := extractRecords[*dns.SOA](response.Msg.Ns); len(soa) > 0 {
+				ttl = min(soa[0].Hdr.Ttl, soa[0].Minttl, MaxAllowedTTL)
+			}
+		}
+
+		answer = aliasAnswer{
+			records: records,
+			rcode:   response.Msg.Rcode,
+			expires: time.Now().Add(time.Duration(ttl) * time.Second),
+		}
+
+		if ttl > 0 && answer.rcode == dns.RcodeSuccess {
+			a.lock.Lock()
+			a.answers[question.Qtype] = answer
+			a.lock.Unlock()
+		}
+	}
+
+	// We return the records as the alias's own, with the TTL remaining.
+	ttl := uint32(max(time.Until(answer.expires).Round(time.Second)/time.Second, 0))
+	records := make([]dns.RR, len(answer.records))
+	for i, rr := range answer.records {
+		rr = dns.Copy(rr)
+		rr.Header().Name = canonicalName(question.Name)
+		rr.Header().Ttl = ttl
+		records[i] = rr
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(qmsg)
+	msg.Authoritative = true
+	msg.RecursionAvailable = true
+	msg.Answer = records
+
+	// An NXDOMAIN for the target doesn't mean the alias itself doesn't exist.
+	if answer.rcode != dns.RcodeNameError {
+		msg.Rcode = answer.rcode
+	}
+
+	response := &Response{Msg: msg}
+	if isSetDO(qmsg) {
+		response.Auth = dnssec.Insecure
+	}
+	return response
+}
@@ -0,0 +1,147 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestResolver_AliasAnswer(t *testing.T) {
+	r := getTestResolverWithRoot()
+
+	var questions []dns.Question
+	r.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		questions = append(questions, qmsg.Question[0])
+
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		switch qmsg.Question[0].Qtype {
+		case dns.TypeA:
+			rmsg.Answer = []dns.RR{
+				newRR("lb.example.net. 300 IN A 192.0.2.1"),
+				newRR("lb.example.net. 300 IN A 192.0.2.2"),
+			}
+		case dns.TypeAAAA:
+			rmsg.Rcode = dns.RcodeNameError
+		default:
+			rmsg.Answer = []dns.RR{newRR("example.com. 300 IN MX 10 mail.example.com.")}
+		}
+		return nil, &Response{Msg: rmsg, Auth: dnssec.Secure}
+	}
+
+	require.NoError(t, r.AddAlias("Example.com", "lb.example.net."))
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("example.com.", dns.TypeA)
+	qmsg.SetEdns0(4096, true)
+
+	// The target's addresses are returned as the alias's own.
+	response := r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	require.Len(t, response.Msg.Answer, 2)
+	for _, rr := range response.Msg.Answer {
+		assert.Equal(t, "example.com.", rr.Header().Name)
+		assert.Equal(t, uint32(300), rr.Header().Ttl)
+	}
+	assert.Equal(t, "192.0.2.1", response.Msg.Answer[0].(*dns.A).A.String())
+
+	// Even though the target was Secure, the synthesised answer is not.
+	assert.Equal(t, dnssec.Insecure, response.Auth)
+	assert.False(t, response.Msg.AuthenticatedData)
+
+	require.Len(t, questions, 1)
+	assert.Equal(t, "lb.example.net.", questions[0].Name)
+
+	// The second time, the answer is held.
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Len(t, response.Msg.Answer, 2)
+	assert.Len(t, questions, 1)
+
+	// Until it expires.
+	a := r.static.aliases["example.com."]
+	a.answers[dns.TypeA] = aliasAnswer{expires: time.Now().Add(-time.Second)}
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Len(t, response.Msg.Answer, 2)
+	assert.Len(t, questions, 2)
+
+	// An NXDOMAIN for the target is NODATA for the alias.
+	qmsg.SetQuestion("example.com.", dns.TypeAAAA)
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, dns.RcodeSuccess, response.Msg.Rcode)
+	assert.Empty(t, response.Msg.Answer)
+
+	// Other types are resolved for the alias itself.
+	qmsg.SetQuestion("example.com.", dns.TypeMX)
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, dns.Question{Name: "example.com.", Qtype: dns.TypeMX, Qclass: dns.ClassINET}, questions[len(questions)-1])
+
+	// Once removed, the address questions are resolved for the name itself too.
+	r.RemoveAlias("example.com.")
+	qmsg.SetQuestion("example.com.", dns.TypeA)
+	r.Exchange(context.Background(), qmsg)
+	assert.Equal(t, "example.com.", questions[len(questions)-1].Name)
+}
+
+func TestResolver_AddAliasConflicts(t *testing.T) {
+	r := getTestResolverWithRoot()
+
+	require.NoError(t, r.AddStaticRecord(newRR("www.example.com. 300 IN A 192.0.2.1")))
+	assert.ErrorIs(t, r.AddAlias("www.example.com.", "lb.example.net."), ErrStaticRecordConflict)
+
+	require.NoError(t, r.AddAlias("example.com.", "lb.example.net."))
+	assert.ErrorIs(t, r.AddStaticRecord(newRR("example.com. 300 IN A 192.0.2.1")), ErrStaticRecordConflict)
+}
+
+func TestResolver_AliasAnswerTTL(t *testing.T) {
+	r := getTestResolverWithRoot()
+
+	r.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		switch qmsg.Question[0].Qtype {
+		case dns.TypeA:
+			// The CNAME's TTL doesn't limit how long the addresses at the end of the chain are held.
+			rmsg.Answer = []dns.RR{
+				newRR("lb.example.net. 30 IN CNAME lb.cdn.example.org."),
+				newRR("lb.cdn.example.org. 300 IN A 192.0.2.1"),
+			}
+		case dns.TypeAAAA:
+			rmsg.Ns = []dns.RR{newRR("example.org. 3600 IN SOA ns1.example.org. hostmaster.example.org. 1 7200 3600 1209600 60")}
+		}
+		return nil, &Response{Msg: rmsg}
+	}
+
+	require.NoError(t, r.AddAlias("example.com.", "lb.example.net."))
+	a := r.static.aliases["example.com."]
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("example.com.", dns.TypeA)
+	response := r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	require.Len(t, response.Msg.Answer, 1)
+	assert.Equal(t, uint32(300), response.Msg.Answer[0].Header().Ttl)
+	assert.WithinDuration(t, time.Now().Add(300*time.Second), a.answers[dns.TypeA].expires, time.Second)
+
+	// NODATA is held for the SOA's negative caching TTL.
+	qmsg.SetQuestion("example.com.", dns.TypeAAAA)
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Empty(t, response.Msg.Answer)
+	assert.WithinDuration(t, time.Now().Add(60*time.Second), a.answers[dns.TypeAAAA].expires, time.Second)
+
+	// Without an SOA, it's not held at all.
+	r.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		return nil, &Response{Msg: new(dns.Msg).SetReply(qmsg)}
+	}
+	delete(a.answers, dns.TypeAAAA)
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Empty(t, response.Msg.Answer)
+	assert.NotContains(t, a.answers, dns.TypeAAAA)
+}
@@ -0,0 +1,53 @@
+package resolver
+
+import (
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+)
+
+// AnyQueryPolicy controls how queries with a QType of ANY are handled. See AnyQueries.
+type AnyQueryPolicy uint8
+
+const (
+	// AnyQueryFull resolves ANY queries as any other type.
+	AnyQueryFull AnyQueryPolicy = iota
+
+	// AnyQueryRefuse answers ANY queries with REFUSED.
+	AnyQueryRefuse
+
+	// AnyQueryMinimal answers ANY queries with a single synthesised HINFO record, as per RFC 8482.
+	AnyQueryMinimal
+)
+
+// anyQueryMinimalTTL is the TTL of the HINFO record returned under AnyQueryMinimal.
+const anyQueryMinimalTTL = uint32(3600)
+
+// anyAnswer returns the response to an ANY query under the AnyQueries policy, or nil if it should be resolved as normal.
+// See https://datatracker.ietf.org/doc/html/rfc8482
+func anyAnswer(qmsg *dns.Msg) *Response {
+	if qmsg.Question[0].Qtype != dns.TypeANY {
+		return nil
+	}
+
+	switch AnyQueries {
+	case AnyQueryRefuse:
+		msg := new(dns.Msg).SetRcode(qmsg, dns.RcodeRefused)
+		msg.RecursionAvailable = true
+		return &Response{Msg: msg, Err: &RcodeError{rcode: dns.RcodeRefused}}
+	case AnyQueryMinimal:
+		msg := new(dns.Msg).SetReply(qmsg)
+		msg.RecursionAvailable = true
+		msg.Answer = []dns.RR{&dns.HINFO{
+			Hdr: dns.RR_Header{Name: qmsg.Question[0].Name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: anyQueryMinimalTTL},
+			Cpu: "RFC8482",
+		}}
+		response := &Response{Msg: msg}
+		if isSetDO(qmsg) {
+			// The record is synthesised, so there's nothing to validate.
+			response.Auth = dnssec.Insecure
+		}
+		return response
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestResolver_Exchange_AnyQueries(t *testing.T) {
+	defer func() { AnyQueries = DefaultAnyQueries }()
+
+	r := getTestResolverWithRoot()
+
+	resolved := 0
+	r.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		resolved++
+		return nil, &Response{Msg: new(dns.Msg).SetReply(qmsg)}
+	}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("example.com.", dns.TypeANY)
+
+	// By default, ANY queries are resolved as normal.
+	response := r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, 1, resolved)
+
+	// Under the refuse policy, they're refused without being resolved.
+	AnyQueries = AnyQueryRefuse
+	response = r.Exchange(context.Background(), qmsg)
+	var rcodeErr *RcodeError
+	require.ErrorAs(t, response.Err, &rcodeErr)
+	assert.Equal(t, dns.RcodeRefused, rcodeErr.Rcode())
+	assert.Equal(t, dns.RcodeRefused, response.Msg.Rcode)
+	assert.Empty(t, response.Msg.Answer)
+	assert.Equal(t, 1, resolved)
+
+	// Under the minimal policy, a single HINFO record is returned.
+	AnyQueries = AnyQueryMinimal
+	qmsg.SetEdns0(4096, true)
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, dns.RcodeSuccess, response.Msg.Rcode)
+	require.Len(t, response.Msg.Answer, 1)
+	hinfo, ok := response.Msg.Answer[0].(*dns.HINFO)
+	require.True(t, ok)
+	assert.Equal(t, "example.com.", hinfo.Hdr.Name)
+	assert.Equal(t, "RFC8482", hinfo.Cpu)
+	assert.Equal(t, dnssec.Insecure, response.Auth)
+	assert.Equal(t, 1, resolved)
+
+	// Other types are unaffected.
+	qmsg.SetQuestion("example.com.", dns.TypeA)
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, 2, resolved)
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"github.com/miekg/dns"
 	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/nsmithuk/resolver/dnssec/doe"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type authenticator struct {
@@ -16,6 +18,9 @@ type authenticator struct {
 
 	errors []error
 
+	// If set, inputs are collected here, rather than being validated. See DeferredValidation.
+	deferred *DeferredHop
+
 	closeOnce  sync.Once
 	queue      chan authenticatorInput
 	finished   atomic.Bool
@@ -36,6 +41,9 @@ func newAuthenticator(ctx context.Context, question dns.Question) *authenticator
 		queue:      make(chan authenticatorInput, 8),
 		processing: &sync.WaitGroup{},
 	}
+	if deferred, _ := ctx.Value(CtxDeferValidation).(bool); deferred {
+		auth.deferred = newDeferredHop(question)
+	}
 	go auth.start()
 	return auth
 }
@@ -49,7 +57,8 @@ func (a *authenticator) close() {
 	})
 }
 
-func (a *authenticator) addDelegationSignerLink(z zone, qname string) {
+// addDelegationSignerLink fetches child's DS records from its parent zone, z, and adds them to the chain.
+func (a *authenticator) addDelegationSignerLink(z zone, child zone) {
 	if a.finished.Load() {
 		return
 	}
@@ -60,11 +69,12 @@ func (a *authenticator) addDelegationSignerLink(z zone, qname string) {
 		go z.dnskeys(a.ctx)
 
 		dsMsg := new(dns.Msg)
-		dsMsg.SetQuestion(dns.Fqdn(qname), dns.TypeDS)
+		dsMsg.SetQuestion(dns.Fqdn(child.name()), dns.TypeDS)
 		dsMsg.SetEdns0(4096, true)
 		dsMsg.RecursionDesired = false
 		response := z.exchange(a.ctx, dsMsg)
 		if !response.IsEmpty() && !response.HasError() {
+			child.linkDelegationSigners(response.Msg.Answer)
 			a.processing.Add(1)
 			a.queue <- authenticatorInput{z, response.Msg}
 		}
@@ -82,7 +92,12 @@ func (a *authenticator) addResponse(z zone, msg *dns.Msg) error {
 
 func (a *authenticator) start() {
 	for in := range a.queue {
-		err := a.auth.AddResponse(&authZoneWrapper{ctx: a.ctx, zone: in.z}, in.msg)
+		var err error
+		if a.deferred != nil {
+			err = a.deferred.add(a.ctx, in.z, in.msg)
+		} else {
+			err = a.auth.AddResponse(&authZoneWrapper{ctx: a.ctx, zone: in.z}, in.msg)
+		}
 		if err != nil {
 			// `Errors` is only accessible from this thread when processing is !Done().
 			a.errors = append(a.errors, err)
@@ -110,6 +125,66 @@ func (a *authenticator) result() (dnssec.AuthenticationResult, dnssec.DenialOfEx
 	return a.auth.Result()
 }
 
+// deferredValidation returns the inputs collected for later validation, or nil if validation isn't deferred.
+func (a *authenticator) deferredValidation() (*DeferredValidation, error) {
+	if a.deferred == nil {
+		return nil, nil
+	}
+
+	a.finished.Store(true)
+	a.processing.Wait()
+	a.close()
+
+	// `Errors` is only accessible from this thread once we've finished Wait().
+	if len(a.errors) > 0 {
+		return nil, errors.Join(a.errors...)
+	}
+
+	return &DeferredValidation{Hops: []*DeferredHop{a.deferred}}, nil
+}
+
+// wildcard returns the wildcard owner name the answer was synthesised from, if it was. Must be called after result().
+func (a *authenticator) wildcard() string {
+	name, _ := a.auth.Wildcard()
+	return name
+}
+
+// deoExplanation returns the records used to prove the denial of existence, if any. Must be called after result().
+func (a *authenticator) deoExplanation() *doe.Explanation {
+	return a.auth.DenialOfExistenceExplanation()
+}
+
+// denialRecords returns the verified NSEC/NSEC3 records, and their RRSIGs, used to prove the denial of existence.
+// Must be called after result().
+func (a *authenticator) denialRecords() []dns.RR {
+	return a.auth.DenialOfExistenceRecords()
+}
+
+// chain returns what was observed for each zone in the validation chain. Must be called after result().
+func (a *authenticator) chain() []dnssec.ChainLink {
+	return a.auth.Chain()
+}
+
+// fetchFailures returns the zones whose DNSKEY or DS records could not be fetched. Must be called after result().
+func (a *authenticator) fetchFailures() []string {
+	return a.auth.FetchFailures()
+}
+
+// insecureReason returns why the result was Insecure, if it was. Must be called after result().
+func (a *authenticator) insecureReason() dnssec.InsecureReason {
+	return a.auth.InsecureReason()
+}
+
+// trustAnchors returns the root trust anchors that anchored the validation chain. Must be called after result().
+func (a *authenticator) trustAnchors() []*dns.DS {
+	return a.auth.TrustAnchors()
+}
+
+// bogusReason returns why the result was Bogus, if it was. Must be called after result().
+func (a *authenticator) bogusReason() dnssec.BogusReason {
+	return a.auth.BogusReason()
+}
+
 // authZoneWrapper wraps our zone such that is supports the dnssec.Zone interface.
 // Note that the dnssec package only needs querying support against this zone's nameservers.
 // i.e. We do not need to try these queries recursively. If the nameservers for this zone do not return
@@ -126,5 +201,10 @@ func (wrapper *authZoneWrapper) Name() string {
 
 // GetDNSKEYRecords Looks up the DNSKEY records for the given QName, in the zone.
 func (wrapper *authZoneWrapper) GetDNSKEYRecords() ([]dns.RR, error) {
+	if timings, ok := wrapper.ctx.Value(ctxTimings).(*resolutionTimings); ok {
+		defer func(start time.Time) {
+			timings.addDNSKEY(wrapper.zone.name(), time.Since(start))
+		}(time.Now())
+	}
 	return wrapper.zone.dnskeys(wrapper.ctx)
 }
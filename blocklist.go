@@ -0,0 +1,70 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+// addressBlocklist tracks nameserver addresses that have repeatedly failed at the network level. Once
+// UnreachableAddressThreshold consecutive failures are seen, the address is skipped for UnreachableAddressCooldown.
+// Each Resolver has its own, shared by all its nameservers across all zones, as the same address is often used by
+// many zones. It's passed to the nameservers via the context; a nil blocklist never blocks anything.
+type addressBlocklist struct {
+	lock     sync.Mutex
+	failures map[string]int
+	expires  map[string]time.Time
+}
+
+func newAddressBlocklist() *addressBlocklist {
+	return &addressBlocklist{
+		failures: make(map[string]int),
+		expires:  make(map[string]time.Time),
+	}
+}
+
+func (b *addressBlocklist) blocked(addr string) bool {
+	if b == nil || UnreachableAddressThreshold <= 0 {
+		return false
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	expires, ok := b.expires[addr]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		// We give the address another chance. A single further failure will block it again.
+		delete(b.expires, addr)
+		b.failures[addr] = UnreachableAddressThreshold - 1
+		return false
+	}
+	return true
+}
+
+func (b *addressBlocklist) failed(addr string) {
+	if b == nil || UnreachableAddressThreshold <= 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures[addr]++
+	if b.failures[addr] >= UnreachableAddressThreshold {
+		b.expires[addr] = time.Now().Add(UnreachableAddressCooldown)
+	}
+}
+
+func (b *addressBlocklist) succeeded(addr string) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.failures, addr)
+	delete(b.expires, addr)
+}
@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState uint8
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker is implemented by exchangers that may choose to not be called for a time.
+type circuitBreaker interface {
+	allow() bool
+}
+
+// poolBreaker tracks consecutive full-pool failures. Once PoolBreakerThreshold is reached, the breaker opens and
+// queries are not sent to the pool for PoolBreakerCooldown. After that, a single probe query is let through
+// (half-open); its outcome either closes the breaker again, or re-opens it for another cooldown.
+type poolBreaker struct {
+	lock     sync.Mutex
+	state    breakerState
+	failures int
+	changed  time.Time
+}
+
+func (b *poolBreaker) allow() bool {
+	if PoolBreakerThreshold <= 0 {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case breakerOpen, breakerHalfOpen:
+		// When half-open, a probe is already in-flight. If it's not reported back within the cooldown, we send another.
+		if time.Since(b.changed) < PoolBreakerCooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.changed = time.Now()
+	}
+
+	return true
+}
+
+func (b *poolBreaker) record(success bool) {
+	if PoolBreakerThreshold <= 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if success {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= PoolBreakerThreshold {
+		b.state = breakerOpen
+		b.changed = time.Now()
+	}
+}
+
+func (b *poolBreaker) isOpen() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state != breakerClosed
+}
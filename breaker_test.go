@@ -0,0 +1,128 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPoolBreaker_OpensAndRecovers(t *testing.T) {
+	defer func() {
+		PoolBreakerThreshold = DefaultPoolBreakerThreshold
+		PoolBreakerCooldown = DefaultPoolBreakerCooldown
+	}()
+	PoolBreakerThreshold = 3
+	PoolBreakerCooldown = 50 * time.Millisecond
+
+	b := &poolBreaker{}
+
+	for i := 0; i < PoolBreakerThreshold-1; i++ {
+		b.record(false)
+		assert.True(t, b.allow())
+	}
+
+	// A success resets the count.
+	b.record(true)
+	for i := 0; i < PoolBreakerThreshold-1; i++ {
+		b.record(false)
+	}
+	assert.False(t, b.isOpen())
+
+	b.record(false)
+	assert.True(t, b.isOpen())
+	assert.False(t, b.allow())
+
+	time.Sleep(PoolBreakerCooldown)
+
+	// Half-open: a single probe is allowed.
+	assert.True(t, b.allow())
+	assert.False(t, b.allow())
+
+	// A failed probe re-opens the breaker straight away.
+	b.record(false)
+	assert.False(t, b.allow())
+
+	time.Sleep(PoolBreakerCooldown)
+
+	// A successful probe closes it.
+	assert.True(t, b.allow())
+	b.record(true)
+	assert.False(t, b.isOpen())
+	assert.True(t, b.allow())
+	assert.True(t, b.allow())
+}
+
+func TestPoolBreaker_Disabled(t *testing.T) {
+	defer func() { PoolBreakerThreshold = DefaultPoolBreakerThreshold }()
+	PoolBreakerThreshold = 0
+
+	b := &poolBreaker{}
+	for i := 0; i < 100; i++ {
+		b.record(false)
+	}
+	assert.False(t, b.isOpen())
+	assert.True(t, b.allow())
+}
+
+func TestZone_Exchange_PoolBreaker(t *testing.T) {
+	defer func() {
+		PoolBreakerThreshold = DefaultPoolBreakerThreshold
+		PoolBreakerCooldown = DefaultPoolBreakerCooldown
+	}()
+	PoolBreakerThreshold = 2
+	PoolBreakerCooldown = 50 * time.Millisecond
+
+	calls := 0
+	healthy := false
+	ns := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			calls++
+			if healthy {
+				return &Response{Msg: new(dns.Msg)}
+			}
+			return ResponseError(&net.OpError{Op: "dial", Net: "udp", Err: errors.New("mock network error")})
+		},
+	}
+
+	pool := &nameserverPool{ipv4: []exchanger{ns}}
+	pool.updateIPCount()
+
+	z := &zoneImpl{zoneName: "example.com.", pool: pool}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("test.example.com.", dns.TypeA)
+
+	// Each query tries the server twice before the pool is deemed to have failed.
+	for i := 0; i < PoolBreakerThreshold; i++ {
+		r := z.exchange(context.Background(), msg)
+		assert.ErrorIs(t, r.Err, ErrNetworkUnreachable)
+	}
+	assert.Equal(t, 2*PoolBreakerThreshold, calls)
+	assert.True(t, pool.breaker.isOpen())
+
+	// Whilst open, the servers are not called, and we get a SERVFAIL.
+	r := z.exchange(context.Background(), msg)
+	assert.ErrorIs(t, r.Err, ErrPoolUnavailable)
+	assert.ErrorIs(t, r.Err, ErrServerFailure)
+	if assert.NotNil(t, r.Msg) {
+		assert.Equal(t, dns.RcodeServerFailure, r.Msg.Rcode)
+	}
+	assert.Equal(t, 2*PoolBreakerThreshold, calls)
+
+	// After the cooldown, a probe gets through, and succeeds.
+	time.Sleep(PoolBreakerCooldown)
+	healthy = true
+
+	r = z.exchange(context.Background(), msg)
+	assert.NoError(t, r.Err)
+	assert.Equal(t, 2*PoolBreakerThreshold+1, calls)
+	assert.False(t, pool.breaker.isOpen())
+
+	r = z.exchange(context.Background(), msg)
+	assert.NoError(t, r.Err)
+	assert.Equal(t, 2*PoolBreakerThreshold+2, calls)
+}
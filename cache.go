@@ -1,8 +1,341 @@
 package resolver
 
-import "github.com/miekg/dns"
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
+// CacheInterface is implemented by any cache the resolver uses. Messages passed to Update() that were fetched with
+// the DO bit set will include a (clean) OPT record with DO set. This allows DO and non-DO queries to be served correctly.
 type CacheInterface interface {
 	Get(zone string, question dns.Question) (*dns.Msg, error)
 	Update(zone string, question dns.Question, msg *dns.Msg) error
 }
+
+// CacheRetentionInterface can optionally be implemented by a cache. If it is, UpdateWithRetention() is called in
+// place of Update(), passing how long the entry should be retained for. This can be longer than the TTLs of the
+// records in the message (see MinCacheRetention), but the records' TTLs must be returned to clients unchanged.
+type CacheRetentionInterface interface {
+	UpdateWithRetention(zone string, question dns.Question, msg *dns.Msg, retention time.Duration) error
+}
+
+// CacheIterationInterface can optionally be implemented by a cache, to allow its contents to be exported with
+// ExportCache(). Range() should call fn for each entry held, stopping if fn returns false.
+type CacheIterationInterface interface {
+	Range(fn func(entry CacheEntry) bool) error
+}
+
+// CacheStaleInterface can optionally be implemented by a cache, to allow expired entries to be served when
+// BestEffortOnDeadline is enabled, and an answer can't be resolved in time. GetStale() should return the entry for
+// the question even if it has expired, for as long as the cache is willing to serve it stale; so such entries need
+// retaining beyond the retention they were updated with. See https://datatracker.ietf.org/doc/html/rfc8767
+type CacheStaleInterface interface {
+	GetStale(zone string, question dns.Question) (*dns.Msg, error)
+}
+
+// CacheEntry is a single cached response, as exported by ExportCache() and imported by ImportCache().
+type CacheEntry struct {
+	Zone     string
+	Question dns.Question
+	Msg      *dns.Msg
+	Expires  time.Time
+}
+
+// ExportCache returns all unexpired entries held by Cache. The cache must implement CacheIterationInterface.
+// The entries can later be passed to ImportCache(), for example to warm a newly started resolver.
+func ExportCache() ([]CacheEntry, error) {
+	cache, ok := Cache.(CacheIterationInterface)
+	if !ok {
+		return nil, ErrCacheNotIterable
+	}
+
+	now := time.Now()
+	entries := make([]CacheEntry, 0)
+	err := cache.Range(func(entry CacheEntry) bool {
+		if entry.Expires.After(now) {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// ImportCache writes the unexpired entries into Cache, returning the number written. Entries are retained until
+// their expiry if the cache implements CacheRetentionInterface. As with the background cache writer, the updates
+// may run concurrently with others, so the cache must be safe for concurrent use.
+func ImportCache(entries []CacheEntry) (int, error) {
+	if Cache == nil {
+		return 0, ErrCacheNotConfigured
+	}
+
+	imported := 0
+	for _, entry := range entries {
+		retention := time.Until(entry.Expires)
+		if retention <= 0 || entry.Msg == nil {
+			continue
+		}
+
+		var err error
+		if cache, ok := Cache.(CacheRetentionInterface); ok {
+			err = cache.UpdateWithRetention(entry.Zone, entry.Question, entry.Msg.Copy(), retention)
+		} else {
+			err = Cache.Update(entry.Zone, entry.Question, entry.Msg.Copy())
+		}
+		if err != nil {
+			return imported, err
+		}
+		imported++
+	}
+
+	return imported, nil
+}
+
+// staleTTL is the TTL given to records served from an expired cache entry.
+// See https://datatracker.ietf.org/doc/html/rfc8767#section-4
+const staleTTL = uint32(30)
+
+// staleAnswer returns a response to qmsg built from an expired cache entry held for the most specific zone we know
+// of for the QName, or nil if Cache doesn't implement CacheStaleInterface, or holds no usable entry. Referrals are
+// not usable, as they don't answer the question. The answer is never DNSSEC validated, so the AD bit is not set.
+func (resolver *Resolver) staleAnswer(qmsg *dns.Msg) *Response {
+	cache, ok := Cache.(CacheStaleInterface)
+	if !ok {
+		return nil
+	}
+
+	question := qmsg.Question[0]
+	for _, z := range resolver.zones.getZoneList(question.Name) {
+		msg, err := cache.GetStale(z.name(), question)
+		if err != nil {
+			Warn(fmt.Errorf("error trying to perform a stale cache lookup for zone [%s]: %w", z.name(), err).Error())
+			continue
+		}
+
+		// As with the cache generally, a DO query can only be served by an entry cached from a DO query.
+		if msg == nil || isReferral(msg) || (isSetDO(qmsg) && !isSetDO(msg)) {
+			continue
+		}
+
+		msg = msg.Copy()
+		if !isSetDO(qmsg) && isSetDO(msg) {
+			removeDNSSECFromResponse(msg, question.Qtype, false)
+		}
+		for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+			capTTLs(section, staleTTL)
+		}
+
+		msg.Id = qmsg.Id
+		msg.RecursionAvailable = true
+		msg.AuthenticatedData = false
+
+		return &Response{Msg: msg, AuthoritativeZone: z.name(), Stale: true}
+	}
+
+	return nil
+}
+
+// cacheRetention returns how long msg should be retained in the cache; the lowest TTL seen, but at least MinCacheRetention.
+func cacheRetention(msg *dns.Msg) time.Duration {
+	return max(time.Duration(minimumTTL(msg))*time.Second, MinCacheRetention)
+}
+
+// minimumTTL returns the lowest TTL seen across all sections of msg, excluding OPT records. Capped at MaxAllowedTTL.
+func minimumTTL(msg *dns.Msg) uint32 {
+	ttl := MaxAllowedTTL
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+	}
+	return ttl
+}
+
+//---
+
+var cacheUpdatesDropped atomic.Uint64
+
+// CacheUpdatesDropped returns the number of cache updates that were dropped because the cache writer's queue was full.
+func CacheUpdatesDropped() uint64 {
+	return cacheUpdatesDropped.Load()
+}
+
+// cacheWriter performs cache updates in the background, using a fixed number of worker goroutines reading from a
+// bounded queue. If the queue is full, the update is dropped; the response is still returned to the client.
+type cacheWriter struct {
+	workers int
+	queue   chan func()
+	start   sync.Once
+}
+
+func newCacheWriter(workers, queueSize int) *cacheWriter {
+	return &cacheWriter{
+		workers: workers,
+		queue:   make(chan func(), max(queueSize, 0)),
+	}
+}
+
+var (
+	sharedCacheWriter     *cacheWriter
+	sharedCacheWriterOnce sync.Once
+)
+
+// getCacheWriter returns the cache writer shared by all zones. It's sized from CacheWriterWorkers and
+// CacheWriterQueueSize when first used.
+func getCacheWriter() *cacheWriter {
+	sharedCacheWriterOnce.Do(func() {
+		sharedCacheWriter = newCacheWriter(CacheWriterWorkers, CacheWriterQueueSize)
+	})
+	return sharedCacheWriter
+}
+
+// enqueue queues f to be run by one of the workers. Returns false if the queue was full, and f was dropped.
+func (w *cacheWriter) enqueue(f func()) bool {
+	if w.workers <= 0 {
+		// No limit has been set.
+		go f()
+		return true
+	}
+
+	w.start.Do(func() {
+		for i := 0; i < w.workers; i++ {
+			go func() {
+				for f := range w.queue {
+					f()
+				}
+			}()
+		}
+	})
+
+	select {
+	case w.queue <- f:
+		return true
+	default:
+		cacheUpdatesDropped.Add(1)
+		return false
+	}
+}
+
+//---
+
+var coalescedQueries atomic.Uint64
+
+// CoalescedQueries returns the number of queries answered from a response received within the CoalescingWindow.
+func CoalescedQueries() uint64 {
+	return coalescedQueries.Load()
+}
+
+type coalescingKey struct {
+	zone     string
+	question dns.Question
+	do       bool
+}
+
+type coalescedResponse struct {
+	msg     *dns.Msg
+	expires time.Time
+}
+
+// coalescer holds the responses received within the last CoalescingWindow, so identical questions that arrive
+// shortly after can be answered without another exchange. Each Resolver has its own, passed to its zones via the
+// context; a nil coalescer holds nothing.
+type coalescer struct {
+	lock      sync.Mutex
+	entries   map[coalescingKey]coalescedResponse
+	lastSweep time.Time
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{entries: make(map[coalescingKey]coalescedResponse)}
+}
+
+func newCoalescingKey(zone string, question dns.Question, do bool) coalescingKey {
+	question.Name = canonicalName(question.Name)
+	return coalescingKey{zone: canonicalName(zone), question: question, do: do}
+}
+
+// get returns a copy of the response to question, if one was received within the window. Nil otherwise.
+func (c *coalescer) get(zone string, question dns.Question, do bool) *dns.Msg {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := newCoalescingKey(zone, question, do)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if !entry.expires.After(time.Now()) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.msg.Copy()
+}
+
+// add records msg as the response to question, for window. Entries whose window has passed are removed when next
+// looked up, or by a sweep of them all, which is run at most once per window.
+func (c *coalescer) add(zone string, question dns.Question, do bool, msg *dns.Msg, window time.Duration) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) >= window {
+		for key, entry := range c.entries {
+			if !entry.expires.After(now) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	c.entries[newCoalescingKey(zone, question, do)] = coalescedResponse{msg: msg.Copy(), expires: now.Add(window)}
+}
+
+//---
+
+var cacheDiscrepancies atomic.Uint64
+
+// CacheDiscrepancies returns the number of times a cached answer was found to differ from the live answer.
+// Only populated when CacheConsistencyCheck is enabled.
+func CacheDiscrepancies() uint64 {
+	return cacheDiscrepancies.Load()
+}
+
+// answersDiffer reports if the Rcode, or the set of Answer records, differ between the two messages. TTLs and
+// record order are ignored.
+func answersDiffer(a, b *dns.Msg) bool {
+	if a.Rcode != b.Rcode || len(a.Answer) != len(b.Answer) {
+		return true
+	}
+
+	normalise := func(rrs []dns.RR) []string {
+		s := make([]string, len(rrs))
+		for i, rr := range rrs {
+			rr = dns.Copy(rr)
+			rr.Header().Ttl = 0
+			rr.Header().Name = canonicalName(rr.Header().Name)
+			s[i] = rr.String()
+		}
+		slices.Sort(s)
+		return s
+	}
+
+	return !slices.Equal(normalise(a.Answer), normalise(b.Answer))
+}
@@ -0,0 +1,273 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMapCache is a minimal, thread-safe, cache supporting iteration.
+type testMapCache struct {
+	lock    sync.Mutex
+	entries map[string]CacheEntry
+}
+
+func newTestMapCache() *testMapCache {
+	return &testMapCache{entries: make(map[string]CacheEntry)}
+}
+
+func (c *testMapCache) key(zone string, question dns.Question) string {
+	return zone + "|" + question.String()
+}
+
+func (c *testMapCache) Get(zone string, question dns.Question) (*dns.Msg, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[c.key(zone, question)]
+	if !ok || time.Now().After(entry.Expires) {
+		return nil, nil
+	}
+	return entry.Msg, nil
+}
+
+func (c *testMapCache) Update(zone string, question dns.Question, msg *dns.Msg) error {
+	return c.UpdateWithRetention(zone, question, msg, cacheRetention(msg))
+}
+
+func (c *testMapCache) UpdateWithRetention(zone string, question dns.Question, msg *dns.Msg, retention time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[c.key(zone, question)] = CacheEntry{Zone: zone, Question: question, Msg: msg, Expires: time.Now().Add(retention)}
+	return nil
+}
+
+func (c *testMapCache) GetStale(zone string, question dns.Question) (*dns.Msg, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.entries[c.key(zone, question)].Msg, nil
+}
+
+func (c *testMapCache) Range(fn func(entry CacheEntry) bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, entry := range c.entries {
+		if !fn(entry) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestCache_ExportImport(t *testing.T) {
+	defer func() { Cache = nil }()
+
+	question := dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(question.Name, question.Qtype)
+	msg.Response = true
+	msg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+
+	populated := newTestMapCache()
+	require.NoError(t, populated.Update("example.com.", question, msg))
+
+	// An expired entry should not be exported.
+	expired := dns.Question{Name: "old.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	require.NoError(t, populated.UpdateWithRetention("example.com.", expired, msg, -time.Second))
+
+	Cache = populated
+	entries, err := ExportCache()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "example.com.", entries[0].Zone)
+	assert.Equal(t, question, entries[0].Question)
+
+	//---
+
+	// Importing into a new cache should result in cache hits, without the zone's nameservers being queried.
+
+	fresh := newTestMapCache()
+	Cache = fresh
+
+	imported, err := ImportCache(entries)
+	require.NoError(t, err)
+	assert.Equal(t, 1, imported)
+
+	mockPool := new(MockExpiringExchanger)
+	z := &zoneImpl{zoneName: "example.com.", pool: mockPool}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion(question.Name, question.Qtype)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	response := z.exchange(ctx, qmsg)
+	require.NoError(t, response.Err)
+	assert.True(t, response.fromCache)
+	require.Len(t, response.Msg.Answer, 1)
+	assert.Equal(t, "192.0.2.1", response.Msg.Answer[0].(*dns.A).A.String())
+	mockPool.AssertNotCalled(t, "exchange", mock.Anything, mock.Anything)
+}
+
+func TestCache_ExportImportNotSupported(t *testing.T) {
+	defer func() { Cache = nil }()
+
+	Cache = nil
+	_, err := ExportCache()
+	assert.ErrorIs(t, err, ErrCacheNotIterable)
+
+	_, err = ImportCache([]CacheEntry{})
+	assert.ErrorIs(t, err, ErrCacheNotConfigured)
+
+	// A cache without Range() can be imported into, but not exported from.
+	Cache = &testZoneMockCache{updated: make(chan *dns.Msg, 1)}
+	_, err = ExportCache()
+	assert.ErrorIs(t, err, ErrCacheNotIterable)
+}
+
+func TestZone_Exchange_CoalescingWindow(t *testing.T) {
+	original := CoalescingWindow
+	CoalescingWindow = 50 * time.Millisecond
+	defer func() { CoalescingWindow = original }()
+
+	z := &zoneImpl{zoneName: "coalesce.example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.coalesce.example.com.", dns.TypeA)
+
+	rmsg := new(dns.Msg).SetReply(qmsg)
+	rmsg.Answer = []dns.RR{newRR("www.coalesce.example.com. 300 IN A 192.0.2.1")}
+	mockPool.On("exchange", mock.Anything, mock.Anything).Return(&Response{Msg: rmsg})
+
+	ctx := context.WithValue(context.Background(), ctxRecentResponses, newCoalescer())
+
+	// Rapid identical queries result in a single upstream exchange.
+	coalescedBefore := CoalescedQueries()
+	for i := 0; i < 5; i++ {
+		response := z.exchange(ctx, qmsg.Copy())
+		require.False(t, response.IsEmpty())
+		assert.Equal(t, rmsg.Answer[0].String(), response.Msg.Answer[0].String())
+	}
+	mockPool.AssertNumberOfCalls(t, "exchange", 1)
+	assert.Equal(t, uint64(4), CoalescedQueries()-coalescedBefore)
+
+	// A query with the DO bit set is a different question.
+	do := qmsg.Copy()
+	do.SetEdns0(4096, true)
+	z.exchange(ctx, do)
+	mockPool.AssertNumberOfCalls(t, "exchange", 2)
+
+	// Another Resolver's responses are not shared, nor are any held without a coalescer.
+	z.exchange(context.WithValue(context.Background(), ctxRecentResponses, newCoalescer()), qmsg.Copy())
+	mockPool.AssertNumberOfCalls(t, "exchange", 3)
+	z.exchange(context.Background(), qmsg.Copy())
+	mockPool.AssertNumberOfCalls(t, "exchange", 4)
+
+	// Once the window has passed, we query again.
+	time.Sleep(CoalescingWindow + 10*time.Millisecond)
+	z.exchange(ctx, qmsg.Copy())
+	mockPool.AssertNumberOfCalls(t, "exchange", 5)
+}
+
+func TestCoalescer_Expiry(t *testing.T) {
+	c := newCoalescer()
+	question := dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	other := dns.Question{Name: "other.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	c.add("example.com.", question, false, new(dns.Msg), 20*time.Millisecond)
+	assert.NotNil(t, c.get("example.com.", question, false))
+
+	// An expired entry is removed when it's looked up.
+	time.Sleep(25 * time.Millisecond)
+	assert.Nil(t, c.get("example.com.", question, false))
+	assert.Empty(t, c.entries)
+
+	// Or by the sweep, once a window has passed since the last one.
+	c.add("example.com.", question, false, new(dns.Msg), 20*time.Millisecond)
+	time.Sleep(25 * time.Millisecond)
+	c.add("example.com.", other, false, new(dns.Msg), 20*time.Millisecond)
+	assert.Len(t, c.entries, 1)
+}
+
+func TestZone_Exchange_CoalescingWindowDisabled(t *testing.T) {
+	z := &zoneImpl{zoneName: "coalesce-disabled.example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.coalesce-disabled.example.com.", dns.TypeA)
+	mockPool.On("exchange", mock.Anything, mock.Anything).Return(&Response{Msg: new(dns.Msg).SetReply(qmsg)})
+
+	for i := 0; i < 3; i++ {
+		z.exchange(context.Background(), qmsg.Copy())
+	}
+	mockPool.AssertNumberOfCalls(t, "exchange", 3)
+}
+
+func TestResolver_Exchange_StaleOnDeadline(t *testing.T) {
+	cache := newTestMapCache()
+
+	defer func() {
+		Cache = nil
+		BestEffortOnDeadline = DefaultBestEffortOnDeadline
+	}()
+	Cache = cache
+	BestEffortOnDeadline = true
+
+	resolver := getTestResolverWithRoot()
+
+	// The resolution never finishes before the deadline.
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		<-ctx.Done()
+		return nil, ResponseError(ctx.Err())
+	}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	exchange := func() (*Response, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		r := resolver.Exchange(ctx, qmsg)
+		return r, ctx.Err()
+	}
+
+	// Without an expired entry, the error is returned.
+	r, _ := exchange()
+	assert.ErrorIs(t, r.Err, context.DeadlineExceeded)
+	assert.False(t, r.Stale)
+
+	// With one, it's returned before the caller's deadline, with a stale TTL.
+	rmsg := new(dns.Msg).SetReply(qmsg)
+	rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+	cache.entries[cache.key(".", qmsg.Question[0])] = CacheEntry{Msg: rmsg, Expires: time.Now().Add(-time.Minute)}
+
+	r, err := exchange()
+	require.NoError(t, r.Err)
+	assert.NoError(t, err)
+	assert.True(t, r.Stale)
+	require.Len(t, r.Msg.Answer, 1)
+	assert.Equal(t, staleTTL, r.Msg.Answer[0].Header().Ttl)
+	assert.False(t, r.Msg.AuthenticatedData)
+
+	// The cached entry itself is unchanged.
+	assert.Equal(t, uint32(300), rmsg.Answer[0].Header().Ttl)
+
+	// A DO query can't be answered by an entry cached without DO.
+	qmsg.SetEdns0(4096, true)
+	r, _ = exchange()
+	assert.ErrorIs(t, r.Err, context.DeadlineExceeded)
+	qmsg.Extra = nil
+
+	// When disabled, the error is returned.
+	BestEffortOnDeadline = false
+	r, _ = exchange()
+	assert.ErrorIs(t, r.Err, context.DeadlineExceeded)
+	assert.False(t, r.Stale)
+}
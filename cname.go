@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"slices"
 	"strings"
+	"time"
 )
 
 func cname(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger) error {
@@ -20,6 +23,16 @@ func cname(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger)
 		strings.Join(targets, ", ")),
 	)
 
+	if len(r.ChainAuth) == 0 {
+		r.ChainAuth = []dnssec.AuthenticationResult{r.Auth}
+	}
+	if len(r.ChainHops) == 0 {
+		r.ChainHops = []ChainHop{{Name: canonicalName(qmsg.Question[0].Name), Zone: r.AuthoritativeZone, Auth: r.Auth}}
+	}
+	if len(r.SourceZones) == 0 && r.AuthoritativeZone != "" {
+		r.SourceZones = []string{r.AuthoritativeZone}
+	}
+
 	for _, c := range cnames {
 		target := dns.CanonicalName(c.Target)
 
@@ -28,6 +41,12 @@ func cname(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger)
 			continue
 		}
 
+		if BestEffortOnDeadline {
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < BestEffortDeadlineMargin {
+				return fmt.Errorf("%w: following cname [%s]", ErrDeadlineApproaching, c.Target)
+			}
+		}
+
 		cnameQMsg := new(dns.Msg)
 		cnameQMsg.SetQuestion(target, qmsg.Question[0].Qtype)
 
@@ -48,14 +67,59 @@ func cname(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger)
 		r.Msg.Ns = append(r.Msg.Ns, cnameRMsg.Msg.Ns...)
 		r.Msg.Extra = append(r.Msg.Extra, cnameRMsg.Msg.Extra...)
 
-		// Ensure we handle differing DNSSEC results correctly.
-		r.Auth = r.Auth.Combine(cnameRMsg.Auth)
+		// Treat the response as truncated if any part of the chain was.
+		r.Msg.Truncated = r.Msg.Truncated || cnameRMsg.Msg.Truncated
+
+		// The chain is only as secure as its weakest hop. If the target's answer itself followed a chain, we
+		// take on each of its hops.
+		hops := cnameRMsg.ChainAuth
+		if len(hops) == 0 {
+			hops = []dnssec.AuthenticationResult{cnameRMsg.Auth}
+		}
+		for _, hop := range hops {
+			r.Auth = r.Auth.Combine(hop)
+		}
+		r.ChainAuth = append(r.ChainAuth, hops...)
+
+		chainHops := cnameRMsg.ChainHops
+		if len(chainHops) == 0 {
+			chainHops = []ChainHop{{Name: target, Zone: cnameRMsg.AuthoritativeZone, Auth: cnameRMsg.Auth}}
+		}
+		r.ChainHops = append(r.ChainHops, chainHops...)
+
+		// If validation was deferred, each hop's chain is needed to validate the whole.
+		if r.DeferredValidation != nil && cnameRMsg.DeferredValidation != nil {
+			r.DeferredValidation.Hops = append(r.DeferredValidation.Hops, cnameRMsg.DeferredValidation.Hops...)
+		}
+
+		// Likewise we take on each zone the target's answer came from.
+		sources := cnameRMsg.SourceZones
+		if len(sources) == 0 && cnameRMsg.AuthoritativeZone != "" {
+			sources = []string{cnameRMsg.AuthoritativeZone}
+		}
+		for _, source := range sources {
+			if !slices.Contains(r.SourceZones, source) {
+				r.SourceZones = append(r.SourceZones, source)
+			}
+		}
 
 		// The overall message is only authoritative if all answers are.
 		r.Msg.Authoritative = r.Msg.Authoritative && cnameRMsg.Msg.Authoritative
 
 		// Ensures we don't return 0 if any message was not 0. TODO: should this be more sophisticated?
 		r.Msg.Rcode = max(r.Msg.Rcode, cnameRMsg.Msg.Rcode)
+
+		if len(r.Msg.Answer) > MaxCNAMEChainAnswerRecords {
+			Debug(fmt.Sprintf("cname chain for [%s] exceeded %d answer records. truncating",
+				qmsg.Question[0].Name,
+				MaxCNAMEChainAnswerRecords,
+			))
+			// The TC bit isn't set, as retrying over TCP would get the same answer.
+			var truncated bool
+			r.Msg.Answer, truncated = truncateRRsets(r.Msg.Answer, MaxCNAMEChainAnswerRecords)
+			r.Partial = r.Partial || truncated
+			break
+		}
 	}
 
 	return nil
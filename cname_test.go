@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
 	"github.com/stretchr/testify/assert"
 	"net"
 	"testing"
@@ -184,3 +185,325 @@ func TestCName_ErrorReturned(t *testing.T) {
 	assert.ErrorIs(t, err, ErrTest)
 	assert.Equal(t, 1, exchangeCalled)
 }
+
+func TestCName_MaxAnswerRecordsExceeded(t *testing.T) {
+
+	// If following the chain results in more than MaxCNAMEChainAnswerRecords, we expect the answer to be
+	// cut to the whole RRsets that fit, each kept with its RRSIGs, and flagged as partial. The TC bit isn't set, as
+	// a retry over TCP would be cut in the same way.
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.Background()
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+
+	rmsg.Answer = []dns.RR{
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME}, Target: "a.example.net."},
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME}, Target: "b.example.net."},
+	}
+	inputResponse := &Response{
+		Msg: rmsg,
+	}
+
+	exchangeCalled := 0
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+			exchangeCalled++
+			name := msg.Question[0].Name
+			answer := []dns.RR{
+				&dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME}, Target: "x." + name},
+				&dns.RRSIG{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeRRSIG}, TypeCovered: dns.TypeCNAME},
+			}
+			for i := range 3 {
+				answer = append(answer, &dns.A{Hdr: dns.RR_Header{Name: "x." + name, Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, byte(i))})
+			}
+			answer = append(answer, &dns.RRSIG{Hdr: dns.RR_Header{Name: "x." + name, Rrtype: dns.TypeRRSIG}, TypeCovered: dns.TypeA})
+			return &Response{
+				Msg: &dns.Msg{Answer: answer},
+			}
+		},
+	}
+
+	MaxCNAMEChainAnswerRecords = 6
+
+	err := cname(ctx, qmsg, inputResponse, exchanger)
+
+	// This is global, so we need to set it back!
+	MaxCNAMEChainAnswerRecords = DefaultMaxCNAMEChainAnswerRecords
+
+	assert.NoError(t, err)
+
+	// Once the limit is reached, the second target should not be followed.
+	assert.Equal(t, 1, exchangeCalled)
+	assert.True(t, inputResponse.Partial)
+	assert.False(t, rmsg.Truncated)
+
+	// The A RRset, with its RRSIG, doesn't fit, so the whole of it is dropped; the CNAME and its RRSIG are kept.
+	assert.Len(t, rmsg.Answer, 4)
+	assert.False(t, recordsOfTypeExist(rmsg.Answer, dns.TypeA))
+	assert.Len(t, extractRecords[*dns.RRSIG](rmsg.Answer), 1)
+	assert.Equal(t, dns.TypeCNAME, extractRecords[*dns.RRSIG](rmsg.Answer)[0].TypeCovered)
+}
+
+func TestTruncateRRsets(t *testing.T) {
+	rr := []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IPv4(192, 0, 2, 1)},
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "a.example.com.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP("2001:db8::1")},
+		&dns.A{Hdr: dns.RR_Header{Name: "A.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IPv4(192, 0, 2, 2)},
+		&dns.RRSIG{Hdr: dns.RR_Header{Name: "a.example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET}, TypeCovered: dns.TypeA},
+	}
+
+	// Within the limit, nothing changes.
+	r, truncated := truncateRRsets(rr, 4)
+	assert.False(t, truncated)
+	assert.Equal(t, rr, r)
+
+	// The A RRset, including its RRSIG, is three records; the AAAA after it doesn't fit.
+	r, truncated = truncateRRsets(rr, 3)
+	assert.True(t, truncated)
+	assert.Equal(t, []dns.RR{rr[0], rr[2], rr[3]}, r)
+
+	// The first RRset alone doesn't fit, so nothing is kept.
+	r, truncated = truncateRRsets(rr, 2)
+	assert.True(t, truncated)
+	assert.Empty(t, r)
+}
+
+func TestCName_MaxAnswerRecordsNotExceeded(t *testing.T) {
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.Background()
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+
+	rmsg.Answer = []dns.RR{
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME}, Target: "a.example.net."},
+	}
+	inputResponse := &Response{
+		Msg: rmsg,
+	}
+
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+			return &Response{
+				Msg: &dns.Msg{Answer: []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, 1)},
+				}},
+			}
+		},
+	}
+
+	err := cname(ctx, qmsg, inputResponse, exchanger)
+
+	assert.NoError(t, err)
+	assert.Len(t, rmsg.Answer, 2)
+	assert.False(t, inputResponse.Partial)
+	assert.False(t, rmsg.Truncated)
+}
+
+func TestCName_ChainAuthIsWeakestHop(t *testing.T) {
+
+	// The overall result of a chain should be that of its least secure hop.
+
+	tests := []struct {
+		origin   dnssec.AuthenticationResult
+		target   dnssec.AuthenticationResult
+		expected dnssec.AuthenticationResult
+	}{
+		{dnssec.Secure, dnssec.Secure, dnssec.Secure},
+		{dnssec.Secure, dnssec.Insecure, dnssec.Insecure},
+		{dnssec.Insecure, dnssec.Secure, dnssec.Insecure},
+		{dnssec.Insecure, dnssec.Insecure, dnssec.Insecure},
+		{dnssec.Secure, dnssec.Bogus, dnssec.Bogus},
+		{dnssec.Bogus, dnssec.Secure, dnssec.Bogus},
+		{dnssec.Insecure, dnssec.Bogus, dnssec.Bogus},
+		{dnssec.Bogus, dnssec.Insecure, dnssec.Bogus},
+		{dnssec.Secure, dnssec.Indeterminate, dnssec.Indeterminate},
+		{dnssec.Secure, dnssec.Unknown, dnssec.Unknown},
+	}
+
+	for _, test := range tests {
+		qmsg := new(dns.Msg)
+		qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN CNAME other.example.net.")}
+		response := &Response{Msg: rmsg, Auth: test.origin}
+
+		exchanger := &mockExchanger{
+			mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+				return &Response{
+					Msg:  &dns.Msg{Answer: []dns.RR{newRR("other.example.net. 300 IN A 192.0.2.1")}},
+					Auth: test.target,
+				}
+			},
+		}
+
+		err := cname(context.Background(), qmsg, response, exchanger)
+		assert.NoError(t, err)
+
+		name := test.origin.String() + "+" + test.target.String()
+		assert.Equal(t, test.expected, response.Auth, name)
+		assert.Equal(t, []dnssec.AuthenticationResult{test.origin, test.target}, response.ChainAuth, name)
+	}
+}
+
+func TestCName_ChainAuthMultipleHops(t *testing.T) {
+
+	// When the target's answer itself followed a chain, each of its hops should be included.
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	rmsg := new(dns.Msg).SetReply(qmsg)
+	rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN CNAME a.example.net.")}
+	response := &Response{Msg: rmsg, Auth: dnssec.Secure}
+
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+			return &Response{
+				Msg: &dns.Msg{Answer: []dns.RR{
+					newRR("a.example.net. 300 IN CNAME b.example.org."),
+					newRR("b.example.org. 300 IN A 192.0.2.1"),
+				}},
+				Auth:      dnssec.Insecure,
+				ChainAuth: []dnssec.AuthenticationResult{dnssec.Secure, dnssec.Insecure},
+			}
+		},
+	}
+
+	err := cname(context.Background(), qmsg, response, exchanger)
+	assert.NoError(t, err)
+	assert.Equal(t, dnssec.Insecure, response.Auth)
+	assert.Equal(t, []dnssec.AuthenticationResult{dnssec.Secure, dnssec.Secure, dnssec.Insecure}, response.ChainAuth)
+}
+
+func TestCName_ChainHops(t *testing.T) {
+
+	// Each hop of a three hop chain is reported, with the zone that answered it, and its own state.
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	qmsg.SetEdns0(4096, true)
+
+	rmsg := new(dns.Msg).SetReply(qmsg)
+	rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN CNAME a.example.net.")}
+	response := &Response{Msg: rmsg, Auth: dnssec.Secure, AuthoritativeZone: "example.com."}
+
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+			return &Response{
+				Msg: &dns.Msg{Answer: []dns.RR{
+					newRR("a.example.net. 300 IN CNAME b.example.org."),
+					newRR("b.example.org. 300 IN A 192.0.2.1"),
+				}},
+				Auth:              dnssec.Insecure,
+				AuthoritativeZone: "example.net.",
+				ChainAuth:         []dnssec.AuthenticationResult{dnssec.Insecure, dnssec.Secure},
+				ChainHops: []ChainHop{
+					{Name: "a.example.net.", Zone: "example.net.", Auth: dnssec.Insecure},
+					{Name: "b.example.org.", Zone: "example.org.", Auth: dnssec.Secure},
+				},
+			}
+		},
+	}
+
+	err := cname(context.Background(), qmsg, response, exchanger)
+	assert.NoError(t, err)
+	assert.Equal(t, dnssec.Insecure, response.Auth)
+	assert.Equal(t, []ChainHop{
+		{Name: "www.example.com.", Zone: "example.com.", Auth: dnssec.Secure},
+		{Name: "a.example.net.", Zone: "example.net.", Auth: dnssec.Insecure},
+		{Name: "b.example.org.", Zone: "example.org.", Auth: dnssec.Secure},
+	}, response.ChainHops)
+	assert.Len(t, response.ChainAuth, len(response.ChainHops))
+}
+
+func TestCName_SourceZones(t *testing.T) {
+
+	// A chain crossing from one zone to another is flagged as having multiple sources.
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	rmsg := new(dns.Msg).SetReply(qmsg)
+	rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN CNAME a.example.net.")}
+	response := &Response{Msg: rmsg, AuthoritativeZone: "example.com."}
+	assert.False(t, response.MultipleSources())
+
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+			return &Response{
+				Msg: &dns.Msg{Answer: []dns.RR{
+					newRR("a.example.net. 300 IN CNAME b.example.com."),
+					newRR("b.example.com. 300 IN A 192.0.2.1"),
+				}},
+				AuthoritativeZone: "example.net.",
+				SourceZones:       []string{"example.net.", "example.com."},
+			}
+		},
+	}
+
+	err := cname(context.Background(), qmsg, response, exchanger)
+	assert.NoError(t, err)
+	assert.True(t, response.MultipleSources())
+	assert.Equal(t, []string{"example.com.", "example.net."}, response.SourceZones)
+
+	// A chain within a single zone has just the one source.
+	response = &Response{Msg: rmsg.Copy(), AuthoritativeZone: "example.com."}
+	response.Msg.Answer = []dns.RR{newRR("www.example.com. 300 IN CNAME other.example.com.")}
+	exchanger.mockExchange = func(ctx context.Context, msg *dns.Msg) *Response {
+		return &Response{
+			Msg:               &dns.Msg{Answer: []dns.RR{newRR("other.example.com. 300 IN A 192.0.2.1")}},
+			AuthoritativeZone: "example.com.",
+		}
+	}
+
+	err = cname(context.Background(), qmsg, response, exchanger)
+	assert.NoError(t, err)
+	assert.False(t, response.MultipleSources())
+	assert.Equal(t, []string{"example.com."}, response.SourceZones)
+}
+
+func TestCName_DeadlineApproaching(t *testing.T) {
+
+	// With BestEffortOnDeadline, we shouldn't start following a hop when the deadline is within the margin.
+
+	defer func() { BestEffortOnDeadline = DefaultBestEffortOnDeadline }()
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	ctx, cancel := context.WithTimeout(context.Background(), BestEffortDeadlineMargin/2)
+	defer cancel()
+
+	exchangeCalled := 0
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+			exchangeCalled++
+			return &Response{Msg: &dns.Msg{Answer: []dns.RR{newRR("other.example.net. 300 IN A 192.0.2.1")}}}
+		},
+	}
+
+	BestEffortOnDeadline = true
+
+	rmsg := new(dns.Msg).SetReply(qmsg)
+	rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN CNAME other.example.net.")}
+	err := cname(ctx, qmsg, &Response{Msg: rmsg}, exchanger)
+	assert.ErrorIs(t, err, ErrDeadlineApproaching)
+	assert.Equal(t, 0, exchangeCalled)
+
+	//---
+
+	// When disabled, we try regardless.
+
+	BestEffortOnDeadline = false
+
+	rmsg = new(dns.Msg).SetReply(qmsg)
+	rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN CNAME other.example.net.")}
+	err = cname(ctx, qmsg, &Response{Msg: rmsg}, exchanger)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, exchangeCalled)
+}
@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"crypto/tls"
 	"github.com/nsmithuk/resolver/dnssec"
 	"time"
 )
@@ -10,39 +11,232 @@ const (
 
 	DefaultMaxQueriesPerRequest = uint32(100)
 
+	DefaultMaxQueriesPerNameserverResolution = uint32(30)
+
 	DefaultDesireNumberOfNameserversPerZone = 3
 
+	DefaultMinNameserversPerZone = 1
+
+	DefaultMaxNameserversPerDelegation = 13
+
 	DefaultLazyEnrichment = false
 
+	DefaultPreferAuthoritativeNameserverAddresses = false
+
 	DefaultSuppressBogusResponseSections = true
 
 	DefaultRemoveAuthoritySectionForPositiveAnswers  = true
 	DefaultRemoveAdditionalSectionForPositiveAnswers = true
 
+	DefaultResolveCNAMETarget = false
+
+	DefaultAnswerRecordsFirst = false
+
+	DefaultStaticRecordTTL = uint32(0)
+
+	DefaultMaxCNAMEChainAnswerRecords = 128
+
+	DefaultMaxResolveDuration = time.Duration(0)
+
+	DefaultBestEffortOnDeadline     = false
+	DefaultBestEffortDeadlineMargin = 50 * time.Millisecond
+
+	DefaultMaxEmptyAnswerRetries = 2
+
+	DefaultMinCacheRetention = 5 * time.Second
+
+	DefaultEmptyDNSKEYRetention = 60 * time.Second
+
+	DefaultSignalDNSSECAlgorithms = false
+
+	DefaultEDNSVersion = uint8(0)
+
+	DefaultEDNSBufferSizeLearning = false
+	DefaultEDNSBufferSizeMin      = uint16(1232)
+	DefaultEDNSBufferSizeMax      = uint16(4096)
+	DefaultEDNSBufferSizeStep     = uint16(256)
+
+	DefaultEDNSOptionRetention  = 1 * time.Hour
+	DefaultEDNSOptionMaxServers = 4096
+
+	DefaultAlwaysValidate = false
+
+	DefaultRequireAuthoritativeAnswers = false
+
+	DefaultIncludeDenialRecords = false
+
+	DefaultIncludeRawResponse = false
+
+	DefaultCacheConsistencyCheck       = false
+	DefaultCacheConsistencyCheckTTL    = uint32(30)
+	DefaultCacheConsistencyCheckUpdate = false
+
+	DefaultCoalescingWindow = time.Duration(0)
+
+	DefaultCacheWriterWorkers   = 8
+	DefaultCacheWriterQueueSize = 1024
+
+	DefaultUnreachableAddressThreshold = 0
+	DefaultUnreachableAddressCooldown  = 30 * time.Second
+
+	DefaultDNSKEYPrefetchConcurrency = 4
+
+	DefaultMissingZoneProbeConcurrency = 1
+
+	DefaultPoolBreakerThreshold = 0
+	DefaultPoolBreakerCooldown  = 30 * time.Second
+
+	DefaultTCPOnly = false
+
+	DefaultNameserverTransport = TransportPlain
+
+	DefaultNSInAnswerReferrals = false
+
+	DefaultAcceptTruncatedTCPResponses = false
+
+	DefaultMaxResponseSize = 0
+
+	DefaultConfirmFinalAnswer = false
+
+	DefaultHedgeDelay = time.Duration(0)
+
+	DefaultQnameMinimisation = true
+
+	DefaultAnyQueries = AnyQueryFull
+
+	DefaultPreferredAddressFamily = AddressFamilyIPv6
+
+	DefaultZoneStoreMissRetention = 5 * time.Second
+
 	DefaultTimeoutUDP = 150 * time.Millisecond
 	DefaultTimeoutTCP = 600 * time.Millisecond
+	DefaultTimeoutTLS = 1500 * time.Millisecond
 )
 
 var (
 	// MaxAllowedTTL define the maximum TTL that we'll cache any record for. This overrides any TTLs set by records
-	// we receive. Shorter TTLs on received records will still be respected.
+	// we receive. Shorter TTLs on received records will still be respected. Records returned to the client have their
+	// TTLs capped at this value.
 	MaxAllowedTTL = DefaultMaxAllowedTTL
 
+	// MinCacheRetention is the minimum time we'll ask a cache to retain a response for, even if its TTLs are lower.
+	// This absorbs bursts of queries for records with a TTL of 0 or 1. The TTLs returned to the client are unchanged.
+	// Only applies to caches that implement CacheRetentionInterface.
+	MinCacheRetention = DefaultMinCacheRetention
+
+	// EmptyDNSKEYRetention is how long a zone remembers that its DNSKEY query returned no records, before asking again.
+	// If the parent published DS records for the zone, validation against the empty set results in Bogus, so this is
+	// kept short to allow a zone to recover. A value of 0 means we ask again each time the keys are needed.
+	EmptyDNSKEYRetention = DefaultEmptyDNSKEYRetention
+
+	// CacheConsistencyCheck - if true, when an answer is served from the cache with CacheConsistencyCheckTTL seconds,
+	// or fewer, remaining on its records, we additionally query the zone's nameservers in the background. If the live
+	// answer differs, the discrepancy is logged and counted (see CacheDiscrepancies()). If CacheConsistencyCheckUpdate
+	// is also true, the cache is then updated with the live answer. Only one check runs at a time for each cached answer,
+	// and only the records that answer the question (not their signatures) are compared.
+	CacheConsistencyCheck       = DefaultCacheConsistencyCheck
+	CacheConsistencyCheckTTL    = DefaultCacheConsistencyCheckTTL
+	CacheConsistencyCheckUpdate = DefaultCacheConsistencyCheckUpdate
+
+	// CoalescingWindow is how long a response received from a nameserver is held in memory, after it arrives, to
+	// answer identical questions (for the same zone, and with the same DO bit) without querying again. This absorbs
+	// bursts of identical lookups that arrive before the response is written to the Cache. It applies whether or not
+	// a Cache is configured. Responses are only shared between lookups made by the same Resolver. A value of 0
+	// disables it.
+	CoalescingWindow = DefaultCoalescingWindow
+
+	// CacheWriterWorkers is the number of goroutines that write responses to the cache in the background, and
+	// CacheWriterQueueSize the number of updates that can be waiting for them. When the queue is full, further updates
+	// are dropped (see CacheUpdatesDropped()). Both are read when the cache is first updated. A CacheWriterWorkers
+	// value of 0 removes the limit, starting a goroutine per update.
+	CacheWriterWorkers   = DefaultCacheWriterWorkers
+	CacheWriterQueueSize = DefaultCacheWriterQueueSize
+
+	// SignalDNSSECAlgorithms indicates if DO queries should include the EDNS0 DAU, DHU and N3U options,
+	// advertising the DNSSEC algorithms we understand. See https://datatracker.ietf.org/doc/html/rfc6975
+	// Disabled by default, as the options grow every DO query.
+	SignalDNSSECAlgorithms = DefaultSignalDNSSECAlgorithms
+
+	// EDNSVersion is the EDNS version used on queries that include an OPT record. If a server responds with BADVERS,
+	// the query is retried at the (lower) version the server indicates it supports.
+	// See https://datatracker.ietf.org/doc/html/rfc6891#section-6.1.3
+	EDNSVersion = DefaultEDNSVersion
+
+	// EDNSBufferSizeLearning - if true, the UDP buffer size we advertise to each nameserver is learnt, rather than
+	// taken from the query. We start each server at EDNSBufferSizeMin, and raise it by EDNSBufferSizeStep (up to
+	// EDNSBufferSizeMax) each time a UDP answer is received without truncation. If a UDP query fails, which is
+	// how fragmented responses being dropped usually appears, the server is returned to EDNSBufferSizeMin.
+	// See https://datatracker.ietf.org/doc/html/rfc6891#section-6.2.5
+	EDNSBufferSizeLearning = DefaultEDNSBufferSizeLearning
+	EDNSBufferSizeMin      = DefaultEDNSBufferSizeMin
+	EDNSBufferSizeMax      = DefaultEDNSBufferSizeMax
+	EDNSBufferSizeStep     = DefaultEDNSBufferSizeStep
+
+	// EDNSOptions are the optional EDNS options added to queries that include an OPT record. The supported options are
+	// dns.EDNS0COOKIE, dns.EDNS0NSID, dns.EDNS0TCPKEEPALIVE (sent over TCP only) and dns.EDNS0PADDING (sent over TLS
+	// only). We learn, per server address, which of these each server supports from its responses. An option that the
+	// server doesn't echo back, or that results in a FORMERR, stops being sent to that server for EDNSOptionRetention.
+	// Each resolver holds what it's learnt for at most EDNSOptionMaxServers addresses.
+	// See https://datatracker.ietf.org/doc/html/rfc7873, rfc5001, rfc7828 and rfc7830
+	EDNSOptions          []uint16
+	EDNSOptionRetention  = DefaultEDNSOptionRetention
+	EDNSOptionMaxServers = DefaultEDNSOptionMaxServers
+
+	// AlwaysValidate - if true, every query is resolved with the DO bit set, and DNSSEC validated, even if the client
+	// didn't set DO. For clients that didn't set DO, DNSSEC records are then removed from the response, so the
+	// validation is transparent to them; other than Bogus responses resulting in SERVFAIL.
+	AlwaysValidate = DefaultAlwaysValidate
+
+	// RequireAuthoritativeAnswers - if true, every answer we return (including each hop of a CNAME chain) must either
+	// have had the AA bit set by the nameserver that sent it, or have come from our own cache. Any other answer is
+	// refused with a SERVFAIL. This protects against answers from non-authoritative servers (e.g. a recursive server
+	// listed as a nameserver) being accepted.
+	RequireAuthoritativeAnswers = DefaultRequireAuthoritativeAnswers
+
+	// IncludeDenialRecords - if true, when a negative answer (NXDOMAIN or NODATA) is validated as Secure, the NSEC or
+	// NSEC3 records that proved it, along with their RRSIGs, are included in Response.DenialRecords.
+	IncludeDenialRecords = DefaultIncludeDenialRecords
+
+	// IncludeRawResponse - if true, Response.Raw holds a copy of the response for the QName, as it was received (or
+	// served from the cache), before we trimmed its sections, followed any CNAME, or otherwise altered it. Disabled by
+	// default to avoid the cost of the copy.
+	IncludeRawResponse = DefaultIncludeRawResponse
+
 	// MaxQueriesPerRequest gives the maximum number of DNS lookups that can occur some a single request to resolver.Exchange().
 	// This will include all requests for all the requests from the root, to the leaf; plus any enrichment needed.
 	// It's main task is to prevent infinite loops.
 	// Note that lookups for DNSKEY and DS records are excluded from this count.
 	MaxQueriesPerRequest = DefaultMaxQueriesPerRequest
 
+	// MaxQueriesPerNameserverResolution gives the maximum number of DNS lookups that can be used, per request, to
+	// resolve the addresses of nameservers for which we were given no glue records. These are counted separately
+	// to, and do not consume, MaxQueriesPerRequest.
+	MaxQueriesPerNameserverResolution = DefaultMaxQueriesPerNameserverResolution
+
 	// DesireNumberOfNameserversPerZone The number of nameservers, with IP addresses, that we ideally know for a zone.
 	// If we know less than this, and LazyEnrichment is _not_ enabled, then we'll set-out to gather more addresses.
 	DesireNumberOfNameserversPerZone = DefaultDesireNumberOfNameserversPerZone
 
+	// MinNameserversPerZone The hard minimum number of nameservers, with usable IP addresses, we want for a zone once
+	// its pool has been enriched. If there are fewer, resolution still proceeds, but the pool is flagged as degraded
+	// and enrichment is tried again, in the background, the next time the zone is used.
+	MinNameserversPerZone = DefaultMinNameserversPerZone
+
+	// MaxNameserversPerDelegation The maximum number of NS records, from a single delegation, that we'll consider
+	// for a zone's pool. If more are received, a stable subset is used, preferring those with glue records.
+	MaxNameserversPerDelegation = DefaultMaxNameserversPerDelegation
+
 	// LazyEnrichment - if true, we put less effort into gathering the IP address details of a zone's nameservers.
 	// We will still always gather the minimum to complete the query, but no more.
 	// Enabling LazyEnrichment can reduce reliability over multiple queries.
 	LazyEnrichment = DefaultLazyEnrichment
 
+	// PreferAuthoritativeNameserverAddresses - if true, when a zone is created from a delegation that included glue
+	// records for nameservers within the zone itself, we additionally resolve those nameservers' addresses in the
+	// background. If the child zone's authoritative addresses differ from the parent's glue, the discrepancy is logged
+	// and counted (see GlueDiscrepancies()), and the pool is updated to use the authoritative addresses.
+	PreferAuthoritativeNameserverAddresses = DefaultPreferAuthoritativeNameserverAddresses
+
 	// SuppressBogusResponseSections indicates if a response Answer, Authority and Extra sections should
 	// be suppressed if a response is Bogus. The default and recommended value is true which
 	// aligns the resolver with https://datatracker.ietf.org/doc/html/rfc4035#section-5.5
@@ -52,6 +246,132 @@ var (
 	// that it's record have no material impact on the result. e.g. it only contains nameserver records.
 	RemoveAuthoritySectionForPositiveAnswers  = DefaultRemoveAuthoritySectionForPositiveAnswers
 	RemoveAdditionalSectionForPositiveAnswers = DefaultRemoveAdditionalSectionForPositiveAnswers
+
+	// ResolveCNAMETarget - if true, when the QType is CNAME, we'll additionally resolve the CNAME's target and append
+	// its A/AAAA records to the answer. The CNAME itself remains the primary answer. This is best-effort; if the target
+	// can't be resolved, the CNAME answer is returned unchanged.
+	ResolveCNAMETarget = DefaultResolveCNAMETarget
+
+	// AnswerRecordsFirst - if true, the records answering the QType (e.g. the A records at the end of a CNAME chain),
+	// along with their RRSIGs, are moved to the front of the Answer section. This helps clients that only read the
+	// first record. By default, the Answer section is ordered following the CNAME chain from the QName.
+	AnswerRecordsFirst = DefaultAnswerRecordsFirst
+
+	// StaticRecordTTL is the TTL set on records answered from a resolver's static records (see AddStaticRecord()).
+	// The default of 0 stops clients caching them, so changes to the records take effect straight away.
+	StaticRecordTTL = DefaultStaticRecordTTL
+
+	// MaxCNAMEChainAnswerRecords is the maximum number of records we'll assemble into the Answer section when following
+	// a CNAME chain. If exceeded, the answer is cut to the whole RRsets (with their signatures) that fit within this
+	// limit, and Response.Partial is set. The TC bit is not set, as the limit applies whatever the transport, so a
+	// client retrying over TCP would only get the same answer.
+	MaxCNAMEChainAnswerRecords = DefaultMaxCNAMEChainAnswerRecords
+
+	// MaxResolveDuration is the maximum time a call to Exchange() may take, including any CNAME chain followed,
+	// regardless of the context passed. If the context has an earlier deadline, that applies instead. When reached,
+	// the response's error wraps ErrResolveTimeout. A value of 0 disables the limit.
+	MaxResolveDuration = DefaultMaxResolveDuration
+
+	// BestEffortOnDeadline - if true, when the context's deadline is within BestEffortDeadlineMargin (or has passed)
+	// part way through following a CNAME chain, we return the part of the chain resolved so far, with
+	// Response.Partial set, rather than an error. If the Cache implements CacheStaleInterface, and holds an expired
+	// answer, the resolution is instead given until BestEffortDeadlineMargin before the deadline; if it's not
+	// finished by then, or MaxResolveDuration is reached, the expired answer is returned, with Response.Stale set.
+	// The AD bit is never set on a partial or stale answer.
+	BestEffortOnDeadline     = DefaultBestEffortOnDeadline
+	BestEffortDeadlineMargin = DefaultBestEffortDeadlineMargin
+
+	// MaxEmptyAnswerRetries is the number of additional nameservers, within a zone's pool, we'll try if a server
+	// returns a NOERROR response with an empty answer that is neither NODATA nor a referral.
+	MaxEmptyAnswerRetries = DefaultMaxEmptyAnswerRetries
+
+	// UnreachableAddressThreshold is the number of consecutive network level failures (over both UDP and TCP) after
+	// which a nameserver address is skipped, by all of a resolver's zones, for UnreachableAddressCooldown. A value of
+	// 0, the default, disables this.
+	UnreachableAddressThreshold = DefaultUnreachableAddressThreshold
+	UnreachableAddressCooldown  = DefaultUnreachableAddressCooldown
+
+	// DNSKEYPrefetchConcurrency is the maximum number of zones, in an already known chain, for which we'll fetch
+	// DNSKEY records concurrently at the start of a DNSSEC request. A value of 0 disables prefetching.
+	DNSKEYPrefetchConcurrency = DefaultDNSKEYPrefetchConcurrency
+
+	// MissingZoneProbeConcurrency is the maximum number of SOA queries sent concurrently when checking if the labels
+	// skipped over by a response (e.g. where several zones share the same nameservers) are zone apexes. With the
+	// default of 1, each label is checked in turn, which costs a round trip per label.
+	MissingZoneProbeConcurrency = DefaultMissingZoneProbeConcurrency
+
+	// PoolBreakerThreshold is the number of consecutive queries on which every server in a zone's pool must fail
+	// before we stop sending queries to that pool. Queries are then answered with SERVFAIL (or from the cache)
+	// for PoolBreakerCooldown, after which a single probe query is sent. A value of 0, the default, disables this
+	// behaviour.
+	PoolBreakerThreshold = DefaultPoolBreakerThreshold
+	PoolBreakerCooldown  = DefaultPoolBreakerCooldown
+
+	// TCPOnly - if true, nameservers are only ever queried over TCP. This is for networks in which UDP DNS traffic is
+	// blocked, where we'd otherwise wait for every UDP query to fail before falling back to TCP.
+	TCPOnly = DefaultTCPOnly
+
+	// NameserverTransport - the transport used to query every nameserver. If TransportTLS, all queries are sent over
+	// DNS-over-TLS on port 853, and there's no fallback to UDP or TCP.
+	NameserverTransport = DefaultNameserverTransport
+
+	// TLSNameservers are the hostnames of nameservers (e.g. ns1.example.com.) to be queried over DNS-over-TLS, even if
+	// NameserverTransport is TransportPlain. It's applied when a nameserver is added to a zone's pool, so only affects
+	// nameservers added after it's set.
+	TLSNameservers []string
+
+	// TLSConfig is the TLS configuration used for DNS-over-TLS. If nil, the system defaults are used. If no ServerName
+	// is set, the SNI sent is the hostname of the nameserver being queried.
+	TLSConfig *tls.Config
+
+	// NSInAnswerReferrals - if true, we tolerate servers that place a referral's NS records in the Answer section,
+	// rather than the Authority section. When a response's Answer contains only NS records, owned by a child of the
+	// zone and an ancestor of the QName, and nothing in the Authority section contradicts it, it's treated as a referral.
+	NSInAnswerReferrals = DefaultNSInAnswerReferrals
+
+	// AcceptTruncatedTCPResponses - if true, a response received over TCP with the TC bit set (which shouldn't happen,
+	// but does with some broken middleboxes) is accepted as it is, with Response.TruncatedTCP set. If false (default),
+	// it's rejected with ErrResponseTruncated, and the next server is tried.
+	AcceptTruncatedTCPResponses = DefaultAcceptTruncatedTCPResponses
+
+	// MaxResponseSize is the largest response, in bytes, that we'll accept from a nameserver. Larger responses are
+	// rejected with ErrResponseTooLarge, and another server is tried. Rejected responses are never cached, which bounds
+	// the memory a server can make us hold by returning huge responses. A value of 0 disables the limit.
+	MaxResponseSize = DefaultMaxResponseSize
+
+	// ConfirmFinalAnswer - if true, the final answer (i.e. not a referral) received from a zone's nameservers is
+	// confirmed by asking a second server in the zone's pool the same question. If the two servers' answers differ,
+	// the response is rejected with ErrAnswersDisagree. Referrals from the zones above aren't cross-checked, which
+	// keeps the cost to one extra query per resolution. If the pool has only one server, or the second server fails
+	// to answer, the original answer is used. RRSIGs aren't compared, as zones signed online produce different
+	// signatures from each server. Note that zones served by a CDN, or GeoDNS, legitimately return different answers
+	// from each server; answers from them will often be rejected.
+	ConfirmFinalAnswer = DefaultConfirmFinalAnswer
+
+	// HedgeDelay - if greater than 0, when querying for the QName itself (i.e. the final query of the resolution),
+	// if no response has been received within HedgeDelay, the query is sent again, to the next server in the zone's
+	// pool. Whichever successful response arrives first is used. This reduces tail latency caused by a slow server, at
+	// the cost of extra queries. A value of 0 disables hedging.
+	HedgeDelay = DefaultHedgeDelay
+
+	// QnameMinimisation - if true, zones above the one authoritative for the QName are only sent the next label of the
+	// QName (with type NS, or A if that fails), rather than the full QName. Only the authoritative zone sees the full
+	// question. See https://datatracker.ietf.org/doc/html/rfc7816
+	QnameMinimisation = DefaultQnameMinimisation
+
+	// AnyQueries is how queries for the ANY type, received via Exchange(), are handled. By default they're resolved as
+	// normal. Following RFC 8482, and to reduce their use in amplification attacks, they can instead be answered with
+	// REFUSED (AnyQueryRefuse), or with a single synthesised HINFO record (AnyQueryMinimal).
+	AnyQueries = DefaultAnyQueries
+
+	// PreferredAddressFamily is the address family queried first when a zone's nameservers have addresses in both.
+	// IPv6 is only used when it's available. Can be overridden per query with CtxAddressFamily; e.g. to match the
+	// client's connectivity.
+	PreferredAddressFamily = DefaultPreferredAddressFamily
+
+	// ZoneStoreMissRetention is how long a zone not found in the ZoneStore backend is remembered as missing, before
+	// the backend is asked for it again. A value of 0 means the backend is asked every time.
+	ZoneStoreMissRetention = DefaultZoneStoreMissRetention
 )
 
 //---
@@ -61,6 +381,17 @@ var Cache CacheInterface = nil
 
 //---
 
+// ZoneStore Default (disabled) external zone store. If set, resolvers created by NewResolver() share the zones
+// they discover via this backend.
+var ZoneStore ZoneStoreBackend = nil
+
+//---
+
+// Tracer Default (no-op) tracer.
+var Tracer TracerInterface = noopTracer{}
+
+//---
+
 type Logger func(string)
 
 // Default logging functions just black-hole the input.
@@ -4,9 +4,22 @@ type ctxKey uint8
 
 const (
 	CtxTrace ctxKey = iota
+	CtxSession
+	CtxStartZone       // A *Zone to start the resolution from. See Zone.
+	CtxNoCache         // If true, the resolution neither reads from, nor writes to, the Cache.
+	CtxDeferValidation // If true, DNSSEC validation is deferred. See DeferredValidation.
+	CtxAddressFamily   // An AddressFamily to prefer for nameservers with both, overriding PreferredAddressFamily.
 
 	ctxSessionQueries
 	ctxIteration
 	ctxZoneName
 	ctxStartTime
+	ctxQueryLimit
+	ctxNameserverQueries
+	ctxNameserverResolutions
+	ctxPath
+	ctxTimings
+	ctxUnreachableAddresses
+	ctxEDNSCapabilities
+	ctxRecentResponses
 )
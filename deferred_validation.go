@@ -0,0 +1,157 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"sync"
+	"time"
+)
+
+// DeferredValidation holds everything needed to DNSSEC validate an answer, without any further network access.
+//
+// When Exchange is passed a context with CtxDeferValidation set to true, DO queries are resolved as normal, but the
+// responses that make up the chain of trust, along with each zone's DNSKEY records, are collected rather than being
+// validated. They're returned in Response.DeferredValidation, and Response.Auth is left Unknown. The validation can
+// then be performed later, by calling Validate(); possibly elsewhere, as the struct can be serialised.
+//
+// Signatures are checked as of when each hop was resolved, so Validate() gives the result the resolver would have
+// given at the time, however much later it's called. It says nothing about whether the records are still current;
+// a caller acting on the answer later should also consider the records' TTLs.
+type DeferredValidation struct {
+	// Hops holds the chain collected for each name answered; i.e. the QName, then any CNAME targets followed.
+	Hops []*DeferredHop `json:"hops"`
+}
+
+// DeferredHop is the chain of trust collected for a single name.
+type DeferredHop struct {
+	Name   string          `json:"name"`
+	Qtype  uint16          `json:"qtype"`
+	Inputs []DeferredInput `json:"inputs"`
+
+	// ResolvedAt is when the hop was resolved. Signatures' validity periods are checked against it. If zero, they're
+	// checked against the time Validate() is called.
+	ResolvedAt time.Time `json:"resolved_at"`
+
+	lock sync.Mutex
+}
+
+// DeferredInput is a single response, from a zone in the chain, and the zone's DNSKEY records at the time.
+// Messages are stored in wire format.
+type DeferredInput struct {
+	Zone        string `json:"zone"`
+	Msg         []byte `json:"msg"`
+	DNSKEYs     []byte `json:"dnskeys,omitempty"`
+	DNSKEYError string `json:"dnskey_error,omitempty"`
+}
+
+func newDeferredHop(question dns.Question) *DeferredHop {
+	return &DeferredHop{
+		Name:       canonicalName(question.Name),
+		Qtype:      question.Qtype,
+		Inputs:     make([]DeferredInput, 0),
+		ResolvedAt: time.Now(),
+	}
+}
+
+// add records msg, from z, along with z's DNSKEY records.
+func (h *DeferredHop) add(ctx context.Context, z zone, msg *dns.Msg) error {
+	input := DeferredInput{Zone: z.name()}
+
+	var err error
+	if input.Msg, err = msg.Pack(); err != nil {
+		return fmt.Errorf("unable to pack response for deferred validation: %w", err)
+	}
+
+	keys, err := z.dnskeys(ctx)
+	if err != nil {
+		input.DNSKEYError = err.Error()
+	} else {
+		if input.DNSKEYs, err = (&dns.Msg{Answer: keys}).Pack(); err != nil {
+			return fmt.Errorf("unable to pack dnskeys for deferred validation: %w", err)
+		}
+	}
+
+	h.lock.Lock()
+	h.Inputs = append(h.Inputs, input)
+	h.lock.Unlock()
+	return nil
+}
+
+// Validate performs the deferred DNSSEC validation. The result is that of the weakest hop; the denial of existence
+// state is that of the answer for the original QName.
+func (d *DeferredValidation) Validate(ctx context.Context) (dnssec.AuthenticationResult, dnssec.DenialOfExistenceState, error) {
+	if d == nil || len(d.Hops) == 0 {
+		return dnssec.Unknown, dnssec.NotFound, ErrDeferredValidationEmpty
+	}
+
+	var result dnssec.AuthenticationResult
+	var deo dnssec.DenialOfExistenceState
+	for i, hop := range d.Hops {
+		state, hopDeo, err := hop.validate(ctx)
+		if err != nil {
+			return state, hopDeo, err
+		}
+		if i == 0 {
+			result, deo = state, hopDeo
+		} else {
+			result = result.Combine(state)
+		}
+	}
+	return result, deo, nil
+}
+
+func (h *DeferredHop) validate(ctx context.Context) (dnssec.AuthenticationResult, dnssec.DenialOfExistenceState, error) {
+	if !h.ResolvedAt.IsZero() {
+		ctx = context.WithValue(ctx, dnssec.CtxValidationTime, h.ResolvedAt)
+	}
+
+	auth := dnssec.NewAuth(ctx, dns.Question{Name: h.Name, Qtype: h.Qtype, Qclass: dns.ClassINET})
+
+	var errs []error
+	for _, input := range h.Inputs {
+		msg := new(dns.Msg)
+		if err := msg.Unpack(input.Msg); err != nil {
+			return dnssec.Unknown, dnssec.NotFound, fmt.Errorf("%w: unable to unpack response from [%s]: %w", ErrDeferredValidationInvalid, input.Zone, err)
+		}
+
+		z := &deferredZone{name: input.Zone}
+		if input.DNSKEYError != "" {
+			z.err = errors.New(input.DNSKEYError)
+		} else if len(input.DNSKEYs) > 0 {
+			keys := new(dns.Msg)
+			if err := keys.Unpack(input.DNSKEYs); err != nil {
+				return dnssec.Unknown, dnssec.NotFound, fmt.Errorf("%w: unable to unpack dnskeys from [%s]: %w", ErrDeferredValidationInvalid, input.Zone, err)
+			}
+			z.keys = keys.Answer
+		}
+
+		// As when validating inline, an input that can't be added doesn't stop the others being.
+		if err := auth.AddResponse(z, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return dnssec.Unknown, dnssec.NotFound, errors.Join(errs...)
+	}
+
+	return auth.Result()
+}
+
+// deferredZone supports the dnssec.Zone interface, answering from the DNSKEY records collected.
+type deferredZone struct {
+	name string
+	keys []dns.RR
+	err  error
+}
+
+func (z *deferredZone) Name() string {
+	return z.name
+}
+
+func (z *deferredZone) GetDNSKEYRecords() ([]dns.RR, error) {
+	return z.keys, z.err
+}
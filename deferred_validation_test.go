@@ -0,0 +1,128 @@
+package resolver
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// signRRset returns an RRSIG over rrset, made with key.
+func signRRset(t *testing.T, key *dns.DNSKEY, signer crypto.Signer, rrset []dns.RR) dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+		Algorithm:  key.Algorithm,
+		SignerName: key.Hdr.Name,
+		KeyTag:     key.KeyTag(),
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	require.NoError(t, sig.Sign(signer, rrset))
+	return sig
+}
+
+func TestResolver_Exchange_DeferredValidation(t *testing.T) {
+	// A root zone, signed with a key we hold the trust anchor for.
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: ".", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	require.NoError(t, err)
+	signer := private.(crypto.Signer)
+
+	originalAnchors := dnssec.RootTrustAnchors
+	dnssec.RootTrustAnchors = []*dns.DS{key.ToDS(dns.SHA256)}
+	defer func() { dnssec.RootTrustAnchors = originalAnchors }()
+
+	keys := []dns.RR{key}
+	keys = append(keys, signRRset(t, key, signer, keys))
+
+	answer := []dns.RR{newRR("example. 300 IN TXT \"hello\"")}
+	answer = append(answer, signRRset(t, key, signer, answer))
+
+	r := getTestResolverWithRoot()
+	r.funcs = resolverFunctions{
+		resolveLabel: r.resolveLabel,
+		checkForMissingZones: func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+			return z
+		},
+		finaliseResponse:  r.finaliseResponse,
+		processDelegation: r.processDelegation,
+		cname:             cname,
+		getExchanger:      r.getExchanger,
+	}
+
+	root := r.zones.getZoneList(".")[0].(*mockZone)
+	root.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+		return keys, nil
+	}
+	root.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Authoritative = true
+		for _, rr := range answer {
+			rmsg.Answer = append(rmsg.Answer, dns.Copy(rr))
+		}
+		return &Response{Msg: rmsg}
+	}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("example.", dns.TypeTXT)
+	qmsg.SetEdns0(4096, true)
+
+	// Validated inline, the answer is Secure.
+	response := r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, dnssec.Secure, response.Auth)
+	assert.Nil(t, response.DeferredValidation)
+
+	// With validation deferred, the state is not known.
+	ctx := context.WithValue(context.Background(), CtxDeferValidation, true)
+	response = r.Exchange(ctx, qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, dnssec.Unknown, response.Auth)
+	assert.False(t, response.Msg.AuthenticatedData)
+	require.NotNil(t, response.DeferredValidation)
+
+	// Until it's validated, which can happen after the deferred validation has been serialised.
+	serialised, err := json.Marshal(response.DeferredValidation)
+	require.NoError(t, err)
+
+	deferred := new(DeferredValidation)
+	require.NoError(t, json.Unmarshal(serialised, deferred))
+
+	state, _, err := deferred.Validate(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, dnssec.Secure, state)
+
+	// Signatures are checked as of when the hop was resolved, rather than when Validate() is called. Had it been
+	// resolved two hours ago, before the signatures' inception, the answer would have been Bogus.
+	resolvedAt := deferred.Hops[0].ResolvedAt
+	assert.WithinDuration(t, time.Now(), resolvedAt, time.Minute)
+
+	deferred.Hops[0].ResolvedAt = time.Now().Add(-2 * time.Hour)
+	state, _, _ = deferred.Validate(context.Background())
+	assert.Equal(t, dnssec.Bogus, state)
+	deferred.Hops[0].ResolvedAt = resolvedAt
+
+	// Tampering with the collected response is detected.
+	msg := new(dns.Msg)
+	require.NoError(t, msg.Unpack(deferred.Hops[0].Inputs[0].Msg))
+	msg.Answer[0].(*dns.TXT).Txt = []string{"goodbye"}
+	deferred.Hops[0].Inputs[0].Msg, err = msg.Pack()
+	require.NoError(t, err)
+
+	state, _, _ = deferred.Validate(context.Background())
+	assert.Equal(t, dnssec.Bogus, state)
+
+	// And there must be something to validate.
+	_, _, err = new(DeferredValidation).Validate(context.Background())
+	assert.ErrorIs(t, err, ErrDeferredValidationEmpty)
+}
@@ -3,13 +3,69 @@ package dnssec
 import (
 	"fmt"
 	"github.com/miekg/dns"
+	"slices"
+	"sync/atomic"
 	"time"
 )
 
-func authenticate(zone string, rrsets []dns.RR, dnskeys []*dns.DNSKEY, section section) (signatures, error) {
+// keyTagCollisions counts the number of signatures seen for which more than one candidate DNSKEY shared
+// the signature's algorithm and key tag.
+var keyTagCollisions atomic.Uint64
+
+// KeyTagCollisions metrics gathering.
+func KeyTagCollisions() uint64 {
+	return keyTagCollisions.Load()
+}
+
+// SignatureExpiry describes a verified RRSIG, and how long remains until it expires. See SignatureExpiryObserver.
+type SignatureExpiry struct {
+	Zone   string
+	Name   string
+	Type   uint16
+	KeyTag uint16
+
+	Inception  time.Time
+	Expiration time.Time
+
+	// Remaining is the time until the signature expires, as of when it was verified.
+	Remaining time.Duration
+}
+
+// reportSignatureExpiry passes the details of a verified rrsig to SignatureExpiryObserver, if one is set.
+func reportSignatureExpiry(zone string, rrsig *dns.RRSIG, now time.Time) {
+	observer := SignatureExpiryObserver
+	if observer == nil {
+		return
+	}
+
+	// RRSIG times use serial number arithmetic, so we work out the differences in the same way as ValidityPeriod().
+	now = time.Unix(now.Unix(), 0)
+	remaining := time.Duration(int32(rrsig.Expiration-uint32(now.Unix()))) * time.Second
+	elapsed := time.Duration(int32(uint32(now.Unix())-rrsig.Inception)) * time.Second
+
+	observer(SignatureExpiry{
+		Zone:       zone,
+		Name:       dns.CanonicalName(rrsig.Header().Name),
+		Type:       rrsig.TypeCovered,
+		KeyTag:     rrsig.KeyTag,
+		Inception:  now.Add(-elapsed),
+		Expiration: now.Add(remaining),
+		Remaining:  remaining,
+	})
+}
+
+// authenticate verifies the signatures over rrsets with dnskeys. Each signature's validity period is checked against now.
+func authenticate(now time.Time, zone string, rrsets []dns.RR, dnskeys []*dns.DNSKEY, section section) (signatures, error) {
 	zone = dns.CanonicalName(zone)
 
-	rrsigs := extractRecords[*dns.RRSIG](rrsets)
+	// Some servers return the same rrsig more than once. We only need to verify, and count, each one once.
+	rrsigs := dedupRRSIGs(extractRecords[*dns.RRSIG](rrsets))
+
+	// We check the number of signatures per rrset before attempting to verify any of them.
+	if err := checkSignatureCount(rrsigs); err != nil {
+		return nil, err
+	}
+
 	signatures := make(signatures, len(rrsigs))
 
 	for i, rrsig := range rrsigs {
@@ -32,8 +88,13 @@ func authenticate(zone string, rrsets []dns.RR, dnskeys []*dns.DNSKEY, section s
 			continue
 		}
 
-		if !rrsig.ValidityPeriod(time.Now()) {
-			sig.err = fmt.Errorf("%w: msg valid %s to %s", ErrInvalidTime, dns.TimeToString(rrsig.Inception), dns.TimeToString(rrsig.Expiration))
+		if !rrsig.ValidityPeriod(now) {
+			// RRSIG times use serial number arithmetic, as per ValidityPeriod().
+			reason := ErrSignatureNotYetValid
+			if int32(rrsig.Expiration-uint32(now.Unix())) < 0 {
+				reason = ErrSignatureExpired
+			}
+			sig.err = fmt.Errorf("%w: %w: msg valid %s to %s", ErrInvalidTime, reason, dns.TimeToString(rrsig.Inception), dns.TimeToString(rrsig.Expiration))
 			continue
 		}
 
@@ -41,32 +102,66 @@ func authenticate(zone string, rrsets []dns.RR, dnskeys []*dns.DNSKEY, section s
 			sig.wildcard = true
 		}
 
-		// Iterate over all the DNS keys to see if one matches the signature.
+		// Find all the DNS keys that match the signature.
+		candidates := make([]*dns.DNSKEY, 0, 1)
 		for _, key := range dnskeys {
+			if key.Algorithm == rrsig.Algorithm && key.KeyTag() == rrsig.KeyTag && dns.CanonicalName(key.Header().Name) == dns.CanonicalName(rrsig.SignerName) {
+				candidates = append(candidates, key)
+			}
+		}
 
-			// https://datatracker.ietf.org/doc/html/rfc4035#section-5.3.1
-			// It is possible for more than one DNSKEY RR to match the conditions
-			// above.  In this case, the validator cannot predetermine which DNSKEY
-			// RR to use to authenticate the signature, and it MUST try each
-			// matching DNSKEY RR until either the signature is validated or the
-			// validator has run out of matching public keys to try.
-			// i.e. A key can have the same owner, Flags, Protocol, Algorithm and KeyTag.
+		// If the zone's keys include one with an algorithm we don't support, we can't verify the signatures it made.
+		// They're noted as unsupported, and ignored if the rrset has another signature. See verifyRRSETs().
+		// Note that we require a matching key; an rrsig with an unknown algorithm, but no key, is just unverified.
+		if len(candidates) > 0 && !slices.Contains(SupportedAlgorithms, rrsig.Algorithm) {
+			sig.unsupported = true
+			sig.err = fmt.Errorf("%w: algorithm %d", ErrUnsupportedAlgorithm, rrsig.Algorithm)
+			continue
+		}
 
-			if key.Algorithm == rrsig.Algorithm && key.KeyTag() == rrsig.KeyTag && dns.CanonicalName(key.Header().Name) == dns.CanonicalName(rrsig.SignerName) {
+		// https://datatracker.ietf.org/doc/html/rfc4035#section-5.3.1
+		// It is possible for more than one DNSKEY RR to match the conditions
+		// above.  In this case, the validator cannot predetermine which DNSKEY
+		// RR to use to authenticate the signature, and it MUST try each
+		// matching DNSKEY RR until either the signature is validated or the
+		// validator has run out of matching public keys to try.
+		// i.e. A key can have the same owner, Flags, Protocol, Algorithm and KeyTag.
+		//
+		// This is rare in the wild, and can indicate misconfiguration or an attack, so we note when it happens.
+		if len(candidates) > 1 {
+			sig.keyTagCollisions = len(candidates)
+			keyTagCollisions.Add(1)
+			Warn(fmt.Sprintf("%d dnskeys in zone [%s] share algorithm %d and key tag %d", len(candidates), zone, rrsig.Algorithm, rrsig.KeyTag))
+		}
 
-				sig.err = rrsig.Verify(key, sig.rrset)
+		// Large rrsets are expensive to verify, so we remember those we've verified.
+		cacheable := VerificationCacheMinRRsetSize > 0 && len(sig.rrset) >= VerificationCacheMinRRsetSize
 
-				if sig.err != nil {
-					// We'll wrap the error
-					sig.err = fmt.Errorf("%w: %w", ErrInvalidSignature, sig.err)
-				} else {
-					// The signature was verified.
-					sig.key = key
-					sig.verified = true
-					sig.dsSha256 = key.ToDS(dns.SHA256).Digest
-					break
+		// Iterate over the candidate keys to see if one verifies the signature.
+		for _, key := range candidates {
+
+			var cacheKey verificationCacheKey
+			if cacheable {
+				cacheKey = newVerificationCacheKey(rrsig, key, sig.rrset)
+				if verifications.seen(cacheKey) {
+					sig.err = nil
+				} else if sig.err = rrsig.Verify(key, sig.rrset); sig.err == nil {
+					verifications.add(cacheKey, rrsig)
 				}
+			} else {
+				sig.err = rrsig.Verify(key, sig.rrset)
+			}
 
+			if sig.err != nil {
+				// We'll wrap the error
+				sig.err = fmt.Errorf("%w: %w", ErrInvalidSignature, sig.err)
+			} else {
+				// The signature was verified.
+				sig.key = key
+				sig.verified = true
+				sig.dsSha256 = key.ToDS(dns.SHA256).Digest
+				reportSignatureExpiry(zone, rrsig, time.Now())
+				break
 			}
 		}
 
@@ -87,6 +182,8 @@ func authenticate(zone string, rrsets []dns.RR, dnskeys []*dns.DNSKEY, section s
 		rrtype uint16
 	}
 
+	policy := RequiredSignatures
+
 	combinations := make(map[combination]bool, len(signatures))
 
 	// So the number of name/Type combinations should equal the number of signatures we have.
@@ -98,15 +195,15 @@ func authenticate(zone string, rrsets []dns.RR, dnskeys []*dns.DNSKEY, section s
 
 		// We _typically_ don't sign NS records in the authority section, but ir can happen:
 		// `dig @l.gtld-servers.net. naughty-nameserver.com. DS +dnssec`
-		if section == authoritySection && rrset.Header().Rrtype == dns.TypeNS {
-			// We check and see if we have any signatures for the NS record. If we do, we count the combination.
-			if len(signatures.filterOnType(dns.TypeNS)) == 0 {
+		if policy.unsignedAllowed(section, rrset.Header().Rrtype) {
+			// We check and see if we have any signatures for the type. If we do, we count the combination.
+			if len(signatures.filterOnType(rrset.Header().Rrtype)) == 0 {
 				continue
 			}
 		}
 
 		combinations[combination{
-			name:   rrset.Header().Name,
+			name:   dns.CanonicalName(rrset.Header().Name),
 			rrtype: rrset.Header().Rrtype,
 		}] = true
 	}
@@ -114,7 +211,56 @@ func authenticate(zone string, rrsets []dns.RR, dnskeys []*dns.DNSKEY, section s
 	var err error
 	if len(combinations) != signatures.countNameTypeCombinations() {
 		err = fmt.Errorf("%w: we found %d signatures but %d rrsets", ErrUnexpectedSignatureCount, signatures.countNameTypeCombinations(), len(combinations))
+
+		if policy.relaxedSignatureCount(zone) {
+			// We only relax the check if every rrset we've seen is signed; the mismatch must be from extra signatures.
+			allSigned := true
+			for c := range combinations {
+				if len(signatures.filterOnNameAndType(c.name, c.rrtype)) == 0 {
+					allSigned = false
+					break
+				}
+			}
+			if allSigned {
+				Warn(fmt.Sprintf("tolerating signature count mismatch in zone [%s]: %s", zone, err.Error()))
+				err = nil
+			}
+		}
 	}
 
 	return signatures, err
 }
+
+// checkSignatureCount returns an error if more than MaxSignaturesPerRRset rrsigs cover any one rrset.
+func checkSignatureCount(rrsigs []*dns.RRSIG) error {
+	if MaxSignaturesPerRRset <= 0 || len(rrsigs) <= MaxSignaturesPerRRset {
+		return nil
+	}
+
+	type rrset struct {
+		name   string
+		rrtype uint16
+	}
+
+	counts := make(map[rrset]int)
+	for _, rrsig := range rrsigs {
+		key := rrset{name: dns.CanonicalName(rrsig.Header().Name), rrtype: rrsig.TypeCovered}
+		counts[key]++
+		if counts[key] > MaxSignaturesPerRRset {
+			return fmt.Errorf("%w: more than %d rrsigs for [%s] %s", ErrTooManySignatures, MaxSignaturesPerRRset, key.name, dns.TypeToString[key.rrtype])
+		}
+	}
+	return nil
+}
+
+// dedupRRSIGs returns the rrsigs with any duplicates removed. Owner names are compared case-insensitively, and TTLs
+// are ignored. The order of the first instance of each rrsig is retained.
+func dedupRRSIGs(rrsigs []*dns.RRSIG) []*dns.RRSIG {
+	deduped := make([]*dns.RRSIG, 0, len(rrsigs))
+	for _, rrsig := range rrsigs {
+		if !slices.ContainsFunc(deduped, func(r *dns.RRSIG) bool { return dns.IsDuplicate(r, rrsig) }) {
+			deduped = append(deduped, rrsig)
+		}
+	}
+	return deduped
+}
@@ -21,7 +21,7 @@ func TestAuthenticate_ValidRSA(t *testing.T) {
 
 	rrset = append(rrset, key.sign(rrset, 0, 0))
 
-	set, err := authenticate(zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -41,6 +41,10 @@ func TestAuthenticate_ValidRSA(t *testing.T) {
 	if set[0].wildcard == true {
 		t.Error("expected wildcard to be false")
 	}
+
+	if set[0].keyTagCollisions != 0 {
+		t.Error("expected no key tag collisions")
+	}
 }
 
 func TestAuthenticate_ValidSECDSA(t *testing.T) {
@@ -53,7 +57,7 @@ func TestAuthenticate_ValidSECDSA(t *testing.T) {
 
 	rrset = append(rrset, key.sign(rrset, 0, 0))
 
-	set, err := authenticate(zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -92,7 +96,7 @@ func TestAuthenticate_ValidWithTwoKeysAndTwoRRSets(t *testing.T) {
 	rrset1 = append(rrset1, key1.sign(rrset1, 0, 0))
 	rrset2 = append(rrset2, key2.sign(rrset2, 0, 0))
 
-	set, err := authenticate(zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key1.key, key2.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key1.key, key2.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -120,7 +124,7 @@ func TestAuthenticate_ValidWildcard(t *testing.T) {
 	rrset[0].Header().Name = dns.Fqdn("test.example.com.") // A records
 	rrset[1].Header().Name = dns.Fqdn("test.example.com.") // RRSIG record
 
-	set, err := authenticate(zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -153,7 +157,7 @@ func TestAuthenticate_InvalidSignature(t *testing.T) {
 	// We amend the record so it should no longer match the signature.
 	rr.Preference = 20
 
-	set, err := authenticate(zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -193,7 +197,7 @@ func TestAuthenticate_InvalidTimePeriod(t *testing.T) {
 	expiration := time.Now().Add(time.Hour * 48).Unix()
 	rrset1 := append(rrset, key.sign(rrset, inception, expiration))
 
-	set, err := authenticate(zoneName, rrset1, []*dns.DNSKEY{key.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, rrset1, []*dns.DNSKEY{key.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -226,7 +230,7 @@ func TestAuthenticate_InvalidTimePeriod(t *testing.T) {
 	expiration = time.Now().Add(time.Hour * -24).Unix()
 	rrset2 := append(rrset, key.sign(rrset, inception, expiration))
 
-	set, err = authenticate(zoneName, rrset2, []*dns.DNSKEY{key.key}, answerSection)
+	set, err = authenticate(time.Now(), zoneName, rrset2, []*dns.DNSKEY{key.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -253,6 +257,49 @@ func TestAuthenticate_InvalidTimePeriod(t *testing.T) {
 	}
 }
 
+func TestAuthenticate_SignatureExpiryObserver(t *testing.T) {
+	rrset := []dns.RR{
+		newRR("example.com. 3600 IN MX 10 mx1.example.com."),
+	}
+
+	key := testEcKey()
+
+	// A signature that expires in 2 hours.
+	inception := time.Now().Add(time.Hour * -24).Unix()
+	expiration := time.Now().Add(time.Hour * 2).Unix()
+	rrset = append(rrset, key.sign(rrset, inception, expiration))
+
+	var reported []SignatureExpiry
+	SignatureExpiryObserver = func(e SignatureExpiry) {
+		reported = append(reported, e)
+	}
+	defer func() { SignatureExpiryObserver = nil }()
+
+	set, err := authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	assert.NoError(t, err)
+	assert.True(t, set.Valid())
+
+	if assert.Len(t, reported, 1) {
+		assert.Equal(t, zoneName, reported[0].Zone)
+		assert.Equal(t, "example.com.", reported[0].Name)
+		assert.Equal(t, dns.TypeMX, reported[0].Type)
+		assert.Equal(t, key.key.KeyTag(), reported[0].KeyTag)
+		assert.Equal(t, expiration, reported[0].Expiration.Unix())
+		assert.Equal(t, inception, reported[0].Inception.Unix())
+		assert.InDelta(t, (2 * time.Hour).Seconds(), reported[0].Remaining.Seconds(), 2)
+		assert.Less(t, reported[0].Remaining, 24*time.Hour)
+	}
+
+	//---
+
+	// Signatures that don't verify are not reported.
+	reported = nil
+	rrset[0].(*dns.MX).Preference = 20
+	set, _ = authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	assert.False(t, set.Valid())
+	assert.Empty(t, reported)
+}
+
 func TestAuthenticate_InvalidSignerName(t *testing.T) {
 	rrset := []dns.RR{newRR("example.com. 3600 IN MX 10 mx1.example.com.")}
 
@@ -261,7 +308,7 @@ func TestAuthenticate_InvalidSignerName(t *testing.T) {
 	rrset = append(rrset, key.sign(rrset, 0, 0))
 
 	// We'll change the expected zone to .net, thus it won't match the signer name of example.com.
-	set, err := authenticate("example.net.", rrset, []*dns.DNSKEY{key.key}, answerSection)
+	set, err := authenticate(time.Now(), "example.net.", rrset, []*dns.DNSKEY{key.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -303,7 +350,7 @@ func TestAuthenticate_InvalidLabelCount(t *testing.T) {
 	rrset[1].Header().Name = "example.com."
 
 	// We'll change the expected zone to .net, thus it won't match the signer name of example.com.
-	set, err := authenticate(zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -354,7 +401,7 @@ func TestAuthenticate_InvalidWithMultipleErrors(t *testing.T) {
 	rrset2 = append(rrset2, key2.sign(rrset2, 0, 0))
 	rr.A = net.ParseIP("192.0.2.54").To4()
 
-	set, err := authenticate(zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key1.key, key2.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key1.key, key2.key}, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -438,7 +485,9 @@ PrivateKey: ho9mEVla4jjpbC5DoebVqsmvqWtFc074kENkCW86gPg=`,
 	// We sign it with the last key, thus it'll try verifying with all the others first.
 	rrset = append(rrset, keys[6].sign(rrset, 0, 0))
 
-	set, err := authenticate(zoneName, rrset, dnskeys, answerSection)
+	collisionsBefore := KeyTagCollisions()
+
+	set, err := authenticate(time.Now(), zoneName, rrset, dnskeys, answerSection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -464,6 +513,10 @@ PrivateKey: ho9mEVla4jjpbC5DoebVqsmvqWtFc074kENkCW86gPg=`,
 		// But we exlect it to be nil by the end of the verify.
 		t.Error("expected signature error to be nil")
 	}
+
+	// The collision should have been reported.
+	assert.Equal(t, len(clashingKeys), set[0].keyTagCollisions)
+	assert.Equal(t, collisionsBefore+1, KeyTagCollisions())
 }
 
 func TestAuthenticate_ValidWithUnsignedNSRecords(t *testing.T) {
@@ -484,7 +537,7 @@ func TestAuthenticate_ValidWithUnsignedNSRecords(t *testing.T) {
 
 	rrset2 = append(rrset2, key.sign(rrset2, 0, 0))
 
-	set, err := authenticate(zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key.key}, authoritySection)
+	set, err := authenticate(time.Now(), zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key.key}, authoritySection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -505,7 +558,7 @@ func TestAuthenticate_ValidWithUnsignedNSRecords(t *testing.T) {
 
 	// If the same records were part of an answer section, we'd expect it to fail as not all RRSETs have a RRSIG.
 
-	_, err = authenticate(zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key.key}, answerSection)
+	_, err = authenticate(time.Now(), zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key.key}, answerSection)
 	if err == nil {
 		t.Error("error expected but not found")
 	}
@@ -533,7 +586,7 @@ func TestAuthenticate_ValidWithSignedNSRecords(t *testing.T) {
 	rrset1 = append(rrset1, key.sign(rrset1, 0, 0))
 	rrset2 = append(rrset2, key.sign(rrset2, 0, 0))
 
-	set, err := authenticate(zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key.key}, authoritySection)
+	set, err := authenticate(time.Now(), zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key.key}, authoritySection)
 	if err != nil {
 		t.Error(err)
 	}
@@ -593,7 +646,7 @@ func TestAuthenticate_ValidWithMultipleRRSigsForSameRRSet(t *testing.T) {
 	rrset3 := append(rrset, key3.sign(rrset, 0, 0))
 	combined := dns.Dedup(slices.Concat(rrset1, rrset2, rrset3), nil)
 
-	set, err := authenticate(zoneName, combined, []*dns.DNSKEY{key1.key, key2.key, key3.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, combined, []*dns.DNSKEY{key1.key, key2.key, key3.key}, answerSection)
 	assert.NoError(t, err)
 
 	assert.Len(t, set, 3)
@@ -623,7 +676,7 @@ func TestAuthenticate_ValidWithMultipleRRSetsOfSameTypeDifferentName(t *testing.
 	rrset2 = append(rrset2, key.sign(rrset2, 0, 0))
 	rrset3 = append(rrset3, key.sign(rrset3, 0, 0))
 
-	set, err := authenticate(zoneName, slices.Concat(rrset1, rrset2, rrset3), []*dns.DNSKEY{key.key}, answerSection)
+	set, err := authenticate(time.Now(), zoneName, slices.Concat(rrset1, rrset2, rrset3), []*dns.DNSKEY{key.key}, answerSection)
 	assert.NoError(t, err)
 
 	assert.Len(t, set, 3)
@@ -634,3 +687,146 @@ func TestAuthenticate_ValidWithMultipleRRSetsOfSameTypeDifferentName(t *testing.
 	assert.False(t, set[1].wildcard)
 	assert.False(t, set[2].wildcard)
 }
+
+func TestAuthenticate_StrictUnsignedNSRecords(t *testing.T) {
+	// With a strict policy, NS records in the authority section must also be signed.
+
+	defer func() { RequiredSignatures = DefaultSignaturePolicy() }()
+	RequiredSignatures = SignaturePolicy{UnsignedAuthorityTypes: nil}
+
+	rrset1 := []dns.RR{
+		newRR("example.com. 3600 IN NS ns1.example.com."),
+	}
+	rrset2 := []dns.RR{
+		newRR("example.com. 3600 IN DS 14056 13 2 5BF7C0CBEC31298BD4BACDE9EBCE1C3A990576D9B581191D6FFBC87FC552AC61"),
+	}
+
+	key := testEcKey()
+
+	rrset2 = append(rrset2, key.sign(rrset2, 0, 0))
+
+	_, err := authenticate(time.Now(), zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key.key}, authoritySection)
+	assert.ErrorIs(t, err, ErrUnexpectedSignatureCount)
+
+	// Once signed, we're fine.
+	rrset1 = append(rrset1, key.sign(rrset1, 0, 0))
+
+	_, err = authenticate(time.Now(), zoneName, slices.Concat(rrset1, rrset2), []*dns.DNSKEY{key.key}, authoritySection)
+	assert.NoError(t, err)
+}
+
+func TestAuthenticate_RelaxedSignatureCount(t *testing.T) {
+	// Some servers return RRSIGs for which the RRset is not included.
+	// These can be tolerated for specific zones, but all RRsets present must still be signed.
+
+	defer func() { RequiredSignatures = DefaultSignaturePolicy() }()
+
+	key := testEcKey()
+
+	rrset1 := []dns.RR{newRR("test.example.com. 3600 IN A 192.0.2.53")}
+	rrset1 = append(rrset1, key.sign(rrset1, 0, 0))
+
+	// We only include the signature for this rrset.
+	rrset2 := []dns.RR{newRR("test.example.com. 3600 IN AAAA 2001:db8::53")}
+	strayRRSIG := key.sign(rrset2, 0, 0)
+
+	records := append(slices.Clone(rrset1), strayRRSIG)
+
+	// By default, the count is strictly checked.
+	_, err := authenticate(time.Now(), zoneName, records, []*dns.DNSKEY{key.key}, answerSection)
+	assert.ErrorIs(t, err, ErrUnexpectedSignatureCount)
+
+	// Relaxed for a different zone has no effect.
+	RequiredSignatures.RelaxSignatureCountZones = []string{"example.net."}
+	_, err = authenticate(time.Now(), zoneName, records, []*dns.DNSKEY{key.key}, answerSection)
+	assert.ErrorIs(t, err, ErrUnexpectedSignatureCount)
+
+	// Relaxed for this zone.
+	RequiredSignatures.RelaxSignatureCountZones = []string{"EXAMPLE.com."}
+	set, err := authenticate(time.Now(), zoneName, records, []*dns.DNSKEY{key.key}, answerSection)
+	assert.NoError(t, err)
+	assert.NoError(t, set.filterOnType(dns.TypeA).Verify())
+
+	// But an unsigned rrset is never tolerated.
+	unsigned := newRR("test.example.com. 3600 IN TXT \"unsigned\"")
+	_, err = authenticate(time.Now(), zoneName, append(slices.Clone(rrset1), unsigned), []*dns.DNSKEY{key.key}, answerSection)
+	assert.ErrorIs(t, err, ErrUnexpectedSignatureCount)
+}
+
+func TestAuthenticate_TooManySignatures(t *testing.T) {
+	defer func() { MaxSignaturesPerRRset = DefaultMaxSignaturesPerRRset }()
+	MaxSignaturesPerRRset = 3
+
+	rrset := []dns.RR{
+		newRR("example.com. 3600 IN MX 10 mx1.example.com."),
+	}
+
+	key := testEcKey()
+
+	// Exactly at the limit is fine.
+	signed := slices.Clone(rrset)
+	for i := 0; i < 3; i++ {
+		signed = append(signed, key.sign(rrset, 0, 0))
+	}
+
+	_, err := authenticate(time.Now(), zoneName, signed, []*dns.DNSKEY{key.key}, answerSection)
+	assert.NotErrorIs(t, err, ErrTooManySignatures)
+
+	// One more, and nothing is verified.
+	signed = append(signed, key.sign(rrset, 0, 0))
+
+	set, err := authenticate(time.Now(), zoneName, signed, []*dns.DNSKEY{key.key}, answerSection)
+	assert.ErrorIs(t, err, ErrTooManySignatures)
+	assert.Nil(t, set)
+
+	// The limit is per rrset, so signatures spread over different rrsets are fine.
+	other := []dns.RR{newRR("www.example.com. 3600 IN A 192.0.2.1")}
+	mixed := slices.Concat(rrset, other)
+	for i := 0; i < 3; i++ {
+		mixed = append(mixed, key.sign(rrset, 0, 0), key.sign(other, 0, 0))
+	}
+
+	_, err = authenticate(time.Now(), zoneName, mixed, []*dns.DNSKEY{key.key}, answerSection)
+	assert.NotErrorIs(t, err, ErrTooManySignatures)
+
+	// And a value of 0 disables the check.
+	MaxSignaturesPerRRset = 0
+	_, err = authenticate(time.Now(), zoneName, signed, []*dns.DNSKEY{key.key}, answerSection)
+	assert.NotErrorIs(t, err, ErrTooManySignatures)
+}
+
+func TestAuthenticate_ValidWithDuplicateRRSigs(t *testing.T) {
+
+	// The same rrsig returned more than once, including with differently cased owner names, should be counted once.
+
+	rrset := []dns.RR{
+		newRR("example.com. 3600 IN MX 10 mx1.example.com."),
+		newRR("example.com. 3600 IN MX 10 mx2.example.com."),
+	}
+
+	key := testEcKey()
+	rrsig := key.sign(rrset, 0, 0)
+
+	upper := dns.Copy(rrsig).(*dns.RRSIG)
+	upper.Hdr.Name = "EXAMPLE.com."
+	upper.Hdr.Ttl = 60
+
+	combined := slices.Concat([]dns.RR{rrsig}, rrset, []dns.RR{dns.Copy(rrsig), upper})
+
+	set, err := authenticate(time.Now(), zoneName, combined, []*dns.DNSKEY{key.key}, answerSection)
+	assert.NoError(t, err)
+
+	assert.Len(t, set, 1)
+	assert.True(t, set.Valid())
+	assert.NoError(t, set.Verify())
+
+	//---
+
+	// Duplicates should not count towards MaxSignaturesPerRRset.
+
+	defer func() { MaxSignaturesPerRRset = DefaultMaxSignaturesPerRRset }()
+	MaxSignaturesPerRRset = 1
+
+	_, err = authenticate(time.Now(), zoneName, combined, []*dns.DNSKEY{key.key}, answerSection)
+	assert.NoError(t, err)
+}
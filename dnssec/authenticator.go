@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/miekg/dns"
 	"slices"
+	"time"
 )
 
 func NewAuth(ctx context.Context, question dns.Question) *Authenticator {
@@ -132,10 +133,14 @@ func (a *Authenticator) processResponse(zone Zone, msg *dns.Msg) error {
 			// If it's not, we're missing a DS record.
 			// We return a MissingDSRecordError error, which includes the next expect record name.
 			// The caller should endeavour to find and pass in the missing records. Then re-try this record.
+			if !slices.Contains(a.missingDS, signerName) {
+				a.missingDS = append(a.missingDS, signerName)
+			}
 			return &MissingDSRecordError{signerName}
 		}
 	}
 
+	start := time.Now()
 	state, r, err := a.verify(a.ctx, zone, msg, last.dsRecords)
 
 	if err != nil {
@@ -147,6 +152,7 @@ func (a *Authenticator) processResponse(zone Zone, msg *dns.Msg) error {
 	}
 
 	if r != nil {
+		r.duration = time.Since(start)
 		a.results = append(a.results, r)
 
 		if state == Unknown {
@@ -215,3 +215,40 @@ func TestAuthenticator_MissingDSRecordError(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestAuthenticator_DNSKEYFetchFailureMidChain(t *testing.T) {
+
+	// If we're unable to fetch a mid-chain zone's DNSKEYs, the result is Indeterminate, not Bogus.
+
+	q := dns.Question{Name: "test.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	a := NewAuth(context.Background(), q)
+
+	ds := newRR("example.com. 300 IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A8 6764247C").(*dns.DS)
+
+	// Zones that can return their keys are treated as Secure. Those that can't go via the real verify().
+	v := getVerifier()
+	a.verify = func(ctx context.Context, zone Zone, msg *dns.Msg, dsRecordsFromParent []*dns.DS) (AuthenticationResult, *result, error) {
+		if zone.(*mockZone).err != nil {
+			return v.verify(ctx, zone, msg, dsRecordsFromParent)
+		}
+		// We pass on DS records, as the zone was a delegation.
+		return Secure, &result{name: zone.Name(), zone: zone, msg: msg, dsRecords: []*dns.DS{ds}}, nil
+	}
+
+	fetchErr := errors.New("network unreachable")
+
+	for _, zone := range []*mockZone{{name: "."}, {name: "com.", err: fetchErr}, {name: "example.com."}} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(q.Name, q.Qtype)
+		msg.Answer = []dns.RR{newRR("test.example.com. 300 IN A 192.0.2.1")}
+		assert.NoError(t, a.AddResponse(zone, msg))
+	}
+
+	state, doe, err := a.Result()
+	assert.Equal(t, Indeterminate, state)
+	assert.Equal(t, NotFound, doe)
+	assert.ErrorIs(t, err, ErrKeysFetchFailed)
+	assert.ErrorIs(t, err, fetchErr)
+	assert.Equal(t, NotBogus, a.BogusReason())
+}
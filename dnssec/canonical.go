@@ -0,0 +1,118 @@
+package dnssec
+
+import (
+	"bytes"
+	"github.com/miekg/dns"
+	"slices"
+	"strings"
+)
+
+// CanonicalizeRRset returns a copy of the RRset in its canonical form, as used when verifying signatures.
+// Owner names, and the domain names within the RDATA of the types listed in RFC 4034 (as updated by RFC 6840),
+// are lower-cased. The records are then sorted by their RDATA's wire format, and any duplicates are removed.
+// The input is not modified.
+// https://datatracker.ietf.org/doc/html/rfc4034#section-6
+func CanonicalizeRRset(rrset []dns.RR) []dns.RR {
+	type canonicalRR struct {
+		rr    dns.RR
+		rdata []byte
+	}
+
+	records := make([]canonicalRR, 0, len(rrset))
+	for _, rr := range rrset {
+		rr = dns.Copy(rr)
+		canonicalizeNames(rr)
+		records = append(records, canonicalRR{rr: rr, rdata: packRdata(rr)})
+	}
+
+	slices.SortStableFunc(records, func(a, b canonicalRR) int {
+		return bytes.Compare(a.rdata, b.rdata)
+	})
+
+	records = slices.CompactFunc(records, func(a, b canonicalRR) bool {
+		return a.rr.Header().Name == b.rr.Header().Name &&
+			a.rr.Header().Rrtype == b.rr.Header().Rrtype &&
+			a.rr.Header().Class == b.rr.Header().Class &&
+			bytes.Equal(a.rdata, b.rdata)
+	})
+
+	result := make([]dns.RR, len(records))
+	for i, r := range records {
+		result[i] = r.rr
+	}
+	return result
+}
+
+// canonicalizeNames lower-cases the owner name, and any RDATA names for the types given in
+// https://datatracker.ietf.org/doc/html/rfc4034#section-6.2 item 3. Note that NSEC is excluded as per
+// https://datatracker.ietf.org/doc/html/rfc6840#section-5.1
+func canonicalizeNames(rr dns.RR) {
+	rr.Header().Name = strings.ToLower(rr.Header().Name)
+
+	switch r := rr.(type) {
+	case *dns.NS:
+		r.Ns = strings.ToLower(r.Ns)
+	case *dns.MD:
+		r.Md = strings.ToLower(r.Md)
+	case *dns.MF:
+		r.Mf = strings.ToLower(r.Mf)
+	case *dns.CNAME:
+		r.Target = strings.ToLower(r.Target)
+	case *dns.SOA:
+		r.Ns = strings.ToLower(r.Ns)
+		r.Mbox = strings.ToLower(r.Mbox)
+	case *dns.MB:
+		r.Mb = strings.ToLower(r.Mb)
+	case *dns.MG:
+		r.Mg = strings.ToLower(r.Mg)
+	case *dns.MR:
+		r.Mr = strings.ToLower(r.Mr)
+	case *dns.PTR:
+		r.Ptr = strings.ToLower(r.Ptr)
+	case *dns.MINFO:
+		r.Rmail = strings.ToLower(r.Rmail)
+		r.Email = strings.ToLower(r.Email)
+	case *dns.MX:
+		r.Mx = strings.ToLower(r.Mx)
+	case *dns.RP:
+		r.Mbox = strings.ToLower(r.Mbox)
+		r.Txt = strings.ToLower(r.Txt)
+	case *dns.AFSDB:
+		r.Hostname = strings.ToLower(r.Hostname)
+	case *dns.RT:
+		r.Host = strings.ToLower(r.Host)
+	case *dns.SIG:
+		r.SignerName = strings.ToLower(r.SignerName)
+	case *dns.PX:
+		r.Map822 = strings.ToLower(r.Map822)
+		r.Mapx400 = strings.ToLower(r.Mapx400)
+	case *dns.NAPTR:
+		r.Replacement = strings.ToLower(r.Replacement)
+	case *dns.KX:
+		r.Exchanger = strings.ToLower(r.Exchanger)
+	case *dns.SRV:
+		r.Target = strings.ToLower(r.Target)
+	case *dns.DNAME:
+		r.Target = strings.ToLower(r.Target)
+	case *dns.RRSIG:
+		r.SignerName = strings.ToLower(r.SignerName)
+	}
+}
+
+// packRdata returns the uncompressed wire format of the record's RDATA.
+func packRdata(rr dns.RR) []byte {
+	buf := make([]byte, dns.Len(rr)+1)
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+
+	// The RDLENGTH is the last two octets of the header, and the RDATA makes up the rest of the record.
+	hdrLen, err := dns.PackDomainName(rr.Header().Name, make([]byte, 256), 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	hdrLen += 10
+
+	return buf[hdrLen:off]
+}
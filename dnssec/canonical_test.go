@@ -0,0 +1,75 @@
+package dnssec
+
+import (
+	"github.com/miekg/dns"
+	"testing"
+)
+
+func TestCanonicalizeRRset_MX(t *testing.T) {
+	rrset := []dns.RR{
+		newRR("Example.COM. 3600 IN MX 20 Mail.Example.com."),
+		newRR("example.com. 3600 IN MX 10 mx2.example.com."),
+		newRR("example.com. 3600 IN MX 10 MX1.example.com."),
+		newRR("example.com. 3600 IN MX 10 mx1.example.com."), // Duplicate, once canonicalised.
+		newRR("example.com. 3600 IN MX 5 z.example.com."),
+	}
+
+	expected := []string{
+		"example.com.\t3600\tIN\tMX\t5 z.example.com.",
+		"example.com.\t3600\tIN\tMX\t10 mx1.example.com.",
+		"example.com.\t3600\tIN\tMX\t10 mx2.example.com.",
+		"example.com.\t3600\tIN\tMX\t20 mail.example.com.",
+	}
+
+	result := CanonicalizeRRset(rrset)
+
+	if len(result) != len(expected) {
+		t.Fatalf("expected %d records, but got %d", len(expected), len(result))
+	}
+	for i, rr := range result {
+		if rr.String() != expected[i] {
+			t.Errorf("at position %d we expected '%s' but got '%s'", i, expected[i], rr.String())
+		}
+	}
+
+	// The input should not have been modified.
+	if rrset[0].Header().Name != "Example.COM." || rrset[0].(*dns.MX).Mx != "Mail.Example.com." {
+		t.Error("the input rrset was modified")
+	}
+}
+
+func TestCanonicalizeRRset_OrderIsByWireFormat(t *testing.T) {
+	// Canonical ordering is by the RDATA's wire format, not its presentation format.
+	// A length prefixed TXT of "b" (01 62) sorts before "aa" (02 61 61).
+	rrset := []dns.RR{
+		newRR(`example.com. 3600 IN TXT "aa"`),
+		newRR(`example.com. 3600 IN TXT "b"`),
+	}
+
+	result := CanonicalizeRRset(rrset)
+
+	if txt := result[0].(*dns.TXT).Txt[0]; txt != "b" {
+		t.Errorf("expected 'b' first, but got '%s'", txt)
+	}
+
+	// TXT data is not a domain name, so its case is preserved.
+	result = CanonicalizeRRset([]dns.RR{newRR(`example.com. 3600 IN TXT "MiXeD"`)})
+	if txt := result[0].(*dns.TXT).Txt[0]; txt != "MiXeD" {
+		t.Errorf("expected 'MiXeD', but got '%s'", txt)
+	}
+}
+
+func TestCanonicalizeRRset_VerifiesSignature(t *testing.T) {
+	// The canonical form should still verify against a signature made over the original.
+	rrset := []dns.RR{
+		newRR("example.com. 3600 IN MX 20 Mail.Example.com."),
+		newRR("example.com. 3600 IN MX 10 mx1.example.com."),
+	}
+
+	key := testEcKey()
+	rrsig := key.sign(rrset, 0, 0)
+
+	if err := rrsig.Verify(key.key, CanonicalizeRRset(rrset)); err != nil {
+		t.Errorf("unexpected error verifying the canonical rrset: %s", err.Error())
+	}
+}
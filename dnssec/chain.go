@@ -0,0 +1,129 @@
+package dnssec
+
+import (
+	"errors"
+	"github.com/miekg/dns"
+	"slices"
+	"time"
+)
+
+// ChainLink reports what was observed, for a single zone, during the validation of a response.
+type ChainLink struct {
+	// Zone is the zone's apex.
+	Zone string
+
+	// State is the result of validating the zone's response.
+	State AuthenticationResult
+
+	// DSDigestTypes are the digest types of the DS records, from the parent (or the trust anchors, for the root),
+	// that the zone's keys were validated against.
+	DSDigestTypes []uint8
+
+	// DNSKEYAlgorithms are the algorithms of the zone's DNSKEY records, once they were validated against the DS
+	// records. Empty if the keys could not be validated.
+	DNSKEYAlgorithms []uint8
+
+	// KeysUnavailable is true if the zone's DNSKEY records could not be fetched, so its response could not be validated.
+	KeysUnavailable bool
+
+	// Duration is how long verifying the zone's response took, including fetching its DNSKEY records.
+	Duration time.Duration
+
+	// NSEC3 are the NSEC3 parameters used by the zone's response, if it contained NSEC3 (or NSEC3PARAM) records.
+	NSEC3 *NSEC3Parameters
+}
+
+// NSEC3Parameters are the hashing parameters a zone uses for NSEC3.
+// See https://datatracker.ietf.org/doc/html/rfc5155#section-4
+type NSEC3Parameters struct {
+	Hash       uint8
+	Iterations uint16
+	Salt       string
+}
+
+// Chain returns a ChainLink for each zone in the validation chain, ordered root to leaf.
+// Should only be called after Result().
+func (a *Authenticator) Chain() []ChainLink {
+	links := make([]ChainLink, len(a.results))
+
+	parentDS := RootTrustAnchors
+	for i, r := range a.results {
+		links[i] = ChainLink{
+			Zone:             r.name,
+			State:            r.state,
+			DSDigestTypes:    dsDigestTypes(parentDS),
+			DNSKEYAlgorithms: r.keys.dnskeyAlgorithms(),
+			KeysUnavailable:  errors.Is(r.err, ErrKeysFetchFailed),
+			Duration:         r.duration,
+			NSEC3:            nsec3Parameters(r.msg),
+		}
+		parentDS = r.dsRecords
+	}
+
+	return links
+}
+
+// FetchFailures returns the names of the zones for which the DNSKEY or DS records, needed to continue the chain,
+// could not be fetched; i.e. where the chain broke. Zones whose keys were unavailable are listed first, ordered root
+// to leaf, followed by those whose DS records were missing. Should only be called after Result().
+func (a *Authenticator) FetchFailures() []string {
+	var zones []string
+	for _, r := range a.results {
+		if errors.Is(r.err, ErrKeysFetchFailed) {
+			zones = append(zones, r.name)
+		}
+	}
+	for _, name := range a.missingDS {
+		if !slices.Contains(zones, name) {
+			zones = append(zones, name)
+		}
+	}
+	return zones
+}
+
+// TrustAnchors returns the RootTrustAnchors that the root zone's keys were validated against; i.e. the anchors that
+// ultimately anchored the chain. Empty if the root's keys were not validated. During a KSK rollover more than one
+// anchor may be returned. Should only be called after Result().
+func (a *Authenticator) TrustAnchors() []*dns.DS {
+	if len(a.results) == 0 || a.results[0].name != "." {
+		return nil
+	}
+	return slices.Clone(a.results[0].anchors)
+}
+
+// nsec3Parameters returns the parameters of the first NSEC3 record in msg's Authority section, as used to prove the
+// denial of existence. If there are none, those of an NSEC3PARAM record in the Answer section are returned. Otherwise nil.
+func nsec3Parameters(msg *dns.Msg) *NSEC3Parameters {
+	if msg == nil {
+		return nil
+	}
+	if records := extractRecords[*dns.NSEC3](msg.Ns); len(records) > 0 {
+		return &NSEC3Parameters{Hash: records[0].Hash, Iterations: records[0].Iterations, Salt: records[0].Salt}
+	}
+	if records := extractRecords[*dns.NSEC3PARAM](msg.Answer); len(records) > 0 {
+		return &NSEC3Parameters{Hash: records[0].Hash, Iterations: records[0].Iterations, Salt: records[0].Salt}
+	}
+	return nil
+}
+
+// dsDigestTypes returns the distinct digest types used by the DS records, in ascending order.
+func dsDigestTypes(dsRecords []*dns.DS) []uint8 {
+	types := make([]uint8, 0, len(dsRecords))
+	for _, ds := range dsRecords {
+		types = append(types, ds.DigestType)
+	}
+	slices.Sort(types)
+	return slices.Compact(types)
+}
+
+// dnskeyAlgorithms returns the distinct algorithms of the DNSKEY records signed by the signatures, in ascending order.
+func (set signatures) dnskeyAlgorithms() []uint8 {
+	algorithms := make([]uint8, 0)
+	for _, sig := range set {
+		for _, key := range extractRecords[*dns.DNSKEY](sig.rrset) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	slices.Sort(algorithms)
+	return slices.Compact(algorithms)
+}
@@ -0,0 +1,200 @@
+package dnssec
+
+import (
+	"context"
+	"errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestAuthenticator_Chain(t *testing.T) {
+
+	// For an EC signed zone, validated against a SHA-256 DS record, we expect those to be reported.
+
+	k := testEcKey()
+	keys := []dns.RR{k.key}
+	keys = append(keys, k.sign(keys, 0, 0))
+
+	ctx := context.Background()
+
+	root := &result{name: ".", zone: &mockZone{name: "."}, state: Secure, dsRecords: []*dns.DS{k.ds}}
+
+	example := &result{name: zoneName, zone: &mockZone{name: zoneName}}
+	state, err := verifyDNSKEYs(ctx, example, keys, root.dsRecords)
+	require.NoError(t, err)
+	require.Equal(t, Unknown, state)
+	example.state = Secure
+
+	a := NewAuth(ctx, dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+	a.results = append(a.results, root, example)
+
+	chain := a.Chain()
+	require.Len(t, chain, 2)
+
+	assert.Equal(t, ".", chain[0].Zone)
+	assert.Equal(t, Secure, chain[0].State)
+	assert.Equal(t, dsDigestTypes(RootTrustAnchors), chain[0].DSDigestTypes)
+	assert.Empty(t, chain[0].DNSKEYAlgorithms)
+
+	assert.Equal(t, zoneName, chain[1].Zone)
+	assert.Equal(t, Secure, chain[1].State)
+	assert.Equal(t, []uint8{dns.SHA256}, chain[1].DSDigestTypes)
+	assert.Equal(t, []uint8{dns.ECDSAP256SHA256}, chain[1].DNSKEYAlgorithms)
+}
+
+func TestAuthenticator_FetchFailures(t *testing.T) {
+
+	// A zone whose DNSKEY records can't be fetched is reported as where the chain broke.
+
+	ctx := context.Background()
+	q := dns.Question{Name: "test.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	a := NewAuth(ctx, q)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(q.Name, q.Qtype)
+	require.NoError(t, a.AddResponse(&mockZone{name: ".", err: errors.New("mock fetch error")}, msg))
+
+	state, _, err := a.Result()
+	assert.ErrorIs(t, err, ErrKeysFetchFailed)
+	assert.Equal(t, Indeterminate, state)
+
+	chain := a.Chain()
+	require.Len(t, chain, 1)
+	assert.True(t, chain[0].KeysUnavailable)
+	assert.Equal(t, []string{"."}, a.FetchFailures())
+
+	//---
+
+	// As is a zone whose DS records were missing.
+
+	a = NewAuth(ctx, q)
+	a.results = append(a.results, &result{
+		name:      "com.",
+		zone:      &mockZone{name: "com."},
+		dsRecords: []*dns.DS{newRR("example.com. 54775 IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A8 6764247C").(*dns.DS)},
+	})
+
+	rrsig := newRR("test.example.com. 955 IN RRSIG A 13 2 3600 20241102170341 20241012065317 19367 test.example.com. XMyTWC8y9WecF5ST67DyRUK3Ptvfpy/+Oetha9r6ZU0RJ4aclvY32uKCojUsjCUHaejma032va/7Z4Yd3Krq8Q==")
+	err = a.processResponse(&mockZone{name: "example.com."}, &dns.Msg{Question: []dns.Question{q}, Answer: []dns.RR{rrsig}})
+
+	var missing *MissingDSRecordError
+	require.ErrorAs(t, err, &missing)
+	assert.Equal(t, []string{"test.example.com."}, a.FetchFailures())
+	assert.False(t, a.Chain()[0].KeysUnavailable)
+}
+
+func TestAuthenticator_ChainNSEC3Parameters(t *testing.T) {
+
+	// The NSEC3 parameters used by a zone's negative response are reported.
+
+	ctx := context.Background()
+	a := NewAuth(ctx, dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+
+	msg := new(dns.Msg)
+	msg.Ns = []dns.RR{
+		newRR("example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 3600"),
+		newRR("111NOTAB271SNH4EA8ESDKBF1C2QINH1.example.com. 3600 IN NSEC3 1 0 2 ABCDEF 211NOTAB271SNH4EA8ESDKBF1C2QINH1 SOA RRSIG"),
+	}
+
+	a.results = append(a.results,
+		&result{name: ".", zone: &mockZone{name: "."}, msg: new(dns.Msg)},
+		&result{name: zoneName, zone: &mockZone{name: zoneName}, msg: msg},
+	)
+
+	chain := a.Chain()
+	require.Len(t, chain, 2)
+	assert.Nil(t, chain[0].NSEC3)
+	require.NotNil(t, chain[1].NSEC3)
+	assert.Equal(t, NSEC3Parameters{Hash: dns.SHA1, Iterations: 2, Salt: "ABCDEF"}, *chain[1].NSEC3)
+
+	//---
+
+	// Without NSEC3 records, an NSEC3PARAM record answering the question is used.
+
+	msg = new(dns.Msg)
+	msg.Answer = []dns.RR{newRR("example.com. 3600 IN NSEC3PARAM 1 0 5 AABB")}
+	assert.Equal(t, &NSEC3Parameters{Hash: dns.SHA1, Iterations: 5, Salt: "AABB"}, nsec3Parameters(msg))
+	assert.Nil(t, nsec3Parameters(nil))
+}
+
+func TestAuthenticator_ChainCanonicalNames(t *testing.T) {
+
+	// Zone names are reported in canonical form, regardless of how the zone presents them.
+
+	ctx := context.Background()
+	a := NewAuth(ctx, dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("test.example.com.", dns.TypeA)
+	require.NoError(t, a.AddResponse(&mockZone{name: "EXAMPLE.COM."}, msg))
+
+	a.Result()
+	chain := a.Chain()
+	require.Len(t, chain, 1)
+	assert.Equal(t, "example.com.", chain[0].Zone)
+}
+
+func TestDSDigestTypes(t *testing.T) {
+	ds := []*dns.DS{
+		{DigestType: dns.SHA384},
+		{DigestType: dns.SHA256},
+		{DigestType: dns.SHA384},
+	}
+	assert.Equal(t, []uint8{dns.SHA256, dns.SHA384}, dsDigestTypes(ds))
+	assert.Empty(t, dsDigestTypes(nil))
+}
+
+func TestAuthenticator_ChainDuration(t *testing.T) {
+
+	// The time taken to verify each zone's response is reported.
+
+	ctx := context.Background()
+	a := NewAuth(ctx, dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+	a.verify = func(ctx context.Context, zone Zone, msg *dns.Msg, dsRecordsFromParent []*dns.DS) (AuthenticationResult, *result, error) {
+		time.Sleep(10 * time.Millisecond)
+		return Insecure, &result{name: zone.Name(), zone: zone, msg: msg}, nil
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("test.example.com.", dns.TypeA)
+	require.NoError(t, a.AddResponse(&mockZone{name: "."}, msg))
+
+	chain := a.Chain()
+	require.Len(t, chain, 1)
+	assert.GreaterOrEqual(t, chain[0].Duration, 10*time.Millisecond)
+}
+
+func TestAuthenticator_TrustAnchors(t *testing.T) {
+
+	// Of the DS records given, only the one matching the key that signed the DNSKEY rrset is recorded. For the
+	// root, that's the trust anchor reported.
+
+	k := testEcKey()
+	other := testEcKey()
+	keys := []dns.RR{k.key}
+	keys = append(keys, k.sign(keys, 0, 0))
+
+	defer func(anchors []*dns.DS) { RootTrustAnchors = anchors }(RootTrustAnchors)
+	RootTrustAnchors = []*dns.DS{other.ds, k.ds}
+
+	ctx := context.Background()
+
+	example := &result{name: zoneName, zone: &mockZone{name: zoneName}}
+	state, err := verifyDNSKEYs(ctx, example, keys, RootTrustAnchors)
+	require.NoError(t, err)
+	require.Equal(t, Unknown, state)
+	assert.Equal(t, []*dns.DS{k.ds}, example.anchors)
+
+	a := NewAuth(ctx, dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+	assert.Empty(t, a.TrustAnchors())
+
+	// A chain that doesn't start at the root has no anchor.
+	a.results = append(a.results, example)
+	assert.Empty(t, a.TrustAnchors())
+
+	a.results = []*result{{name: ".", zone: &mockZone{name: "."}, anchors: example.anchors}, example}
+	assert.Equal(t, []*dns.DS{k.ds}, a.TrustAnchors())
+}
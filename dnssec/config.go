@@ -1,9 +1,22 @@
 package dnssec
 
-import "github.com/nsmithuk/dnssec-root-anchors-go/anchors"
+import (
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/dnssec-root-anchors-go/anchors"
+	"slices"
+)
 
 const (
-	DefaultRequireAllSignaturesValid = false
+	DefaultRequireAllSignaturesValid  = false
+	DefaultValidateQuestionRRsetsOnly = false
+	DefaultMaxSignaturesPerRRset      = 8
+	DefaultDSWithoutDNSKEYIsBogus     = true
+
+	DefaultVerificationCacheMinRRsetSize = 0
+	DefaultVerificationCacheMaxEntries   = 1024
+
+	DefaultCheckOptOutAgainstKnownDS   = false
+	DefaultSignedDelegationsMaxEntries = 4096
 )
 
 var (
@@ -20,8 +33,111 @@ var (
 	//	RRs and how to resolve conflicts if these RRSIG RRs lead to differing
 	//	results.
 	RequireAllSignaturesValid = DefaultRequireAllSignaturesValid
+
+	// ValidateQuestionRRsetsOnly
+	// If false (default), every RRset in the Answer section must validate.
+	// If true, only the Answer section RRsets of the question's type, and any CNAME/DNAME RRsets leading to them,
+	// are validated. Other RRsets are left not-validated, rather than causing the response to be Bogus. As they can't
+	// be vouched for, the resolver removes them from the answer before it's returned.
+	// The Authority section is always fully validated, as it's needed for delegations and denial of existence.
+	ValidateQuestionRRsetsOnly = DefaultValidateQuestionRRsetsOnly
+
+	// MaxSignaturesPerRRset is the maximum number of RRSIGs we'll accept covering a single RRset. Any more, and the
+	// response is considered Bogus without any of the signatures being verified. This stops a response from forcing us
+	// to attempt a large number of (CPU intensive) signature verifications. A value of 0 disables the limit.
+	MaxSignaturesPerRRset = DefaultMaxSignaturesPerRRset
+
+	// DSWithoutDNSKEYIsBogus
+	// If true (default), when the parent zone has DS records for a zone, but the zone has no DNSKEY matching any of
+	// them, the zone is Bogus. The parent has proven the zone is signed, so the missing keys indicate a broken, or
+	// attacked, delegation. DS records using only algorithms or digest types we don't support still result in Insecure.
+	// If false, all such zones are Insecure.
+	DSWithoutDNSKEYIsBogus = DefaultDSWithoutDNSKEYIsBogus
+
+	// VerificationCacheMinRRsetSize is the number of records an RRset must have for the outcome of verifying its
+	// signatures to be remembered. If the same RRset, with the same RRSIG and key, is seen again (e.g. served from
+	// the cache) the verification is skipped. Entries are removed once the RRSIG expires, and any change to the
+	// records, the RRSIG, or the key results in a full verification. Up to VerificationCacheMaxEntries are held.
+	// A value of 0 disables this.
+	VerificationCacheMinRRsetSize = DefaultVerificationCacheMinRRsetSize
+	VerificationCacheMaxEntries   = DefaultVerificationCacheMaxEntries
+
+	// CheckOptOutAgainstKnownDS - if true, we remember the names for which we've validated DS records as Secure, for
+	// the records' TTL. If a parent then uses an NSEC3 opt-out span to show a delegation to one of these names is
+	// insecure, the response is Bogus; opt-out may only cover unsigned delegations, so the parent is contradicting
+	// itself. Up to SignedDelegationsMaxEntries names are held.
+	// See https://datatracker.ietf.org/doc/html/rfc5155#section-6
+	CheckOptOutAgainstKnownDS   = DefaultCheckOptOutAgainstKnownDS
+	SignedDelegationsMaxEntries = DefaultSignedDelegationsMaxEntries
+
+	// SignatureExpiryObserver, if set, is called for each RRSIG that's verified, with the time remaining until it
+	// expires. This allows monitoring to alert on zones whose signatures are close to expiring. It's called during
+	// validation, so it should return quickly.
+	SignatureExpiryObserver func(SignatureExpiry) = nil
+
+	// InsecureZones are zones that are unsigned by design, such as private TLDs. For these zones, and their children,
+	// the absence of DS records (without any proof of their absence) is expected, and results in Insecure, not Bogus.
+	// Unlike a Negative Trust Anchor, which is typically temporary, this is intended to be permanent configuration.
+	InsecureZones []string
+
+	// RequiredSignatures is the policy used to decide which RRsets must be signed. See SignaturePolicy.
+	RequiredSignatures = DefaultSignaturePolicy()
+
+	// SupportedAlgorithms are the DNSKEY/RRSIG algorithms we're able to verify.
+	SupportedAlgorithms = []uint8{
+		dns.RSASHA1,
+		dns.RSASHA1NSEC3SHA1,
+		dns.RSASHA256,
+		dns.RSASHA512,
+		dns.ECDSAP256SHA256,
+		dns.ECDSAP384SHA384,
+		dns.ED25519,
+	}
+
+	// SupportedDigestTypes are the DS digest types we're able to verify.
+	SupportedDigestTypes = []uint8{dns.SHA1, dns.SHA256, dns.SHA384}
+
+	// SupportedNSEC3Hashes are the NSEC3 hash algorithms we're able to verify.
+	SupportedNSEC3Hashes = []uint8{dns.SHA1}
 )
 
+// SignaturePolicy controls how strictly we check that every RRset, within a response, is covered by an RRSIG.
+// https://datatracker.ietf.org/doc/html/rfc4035#section-2.2
+type SignaturePolicy struct {
+	// UnsignedAuthorityTypes are the types that we allow to be unsigned in the authority section. If they are signed,
+	// the signatures are still verified. By default this is NS only, as delegation point NS RRsets are not signed.
+	// Setting this to nil requires every RRset in the authority section to be signed.
+	UnsignedAuthorityTypes []uint16
+
+	// RelaxSignatureCountZones are zones for which we tolerate more signed name/type combinations than RRsets seen.
+	// i.e. RRSIGs returned without the RRset they cover. Every RRset present must still be signed.
+	// Intended for specific zones known to be served by quirky servers.
+	RelaxSignatureCountZones []string
+}
+
+func DefaultSignaturePolicy() SignaturePolicy {
+	return SignaturePolicy{
+		UnsignedAuthorityTypes: []uint16{dns.TypeNS},
+	}
+}
+
+func (p SignaturePolicy) unsignedAllowed(s section, rrtype uint16) bool {
+	return s == authoritySection && slices.Contains(p.UnsignedAuthorityTypes, rrtype)
+}
+
+func (p SignaturePolicy) relaxedSignatureCount(zone string) bool {
+	return slices.ContainsFunc(p.RelaxSignatureCountZones, func(z string) bool {
+		return namesEqual(z, zone)
+	})
+}
+
+// insecureByDesign returns true if name is, or is a child of, a zone in InsecureZones.
+func insecureByDesign(name string) bool {
+	return slices.ContainsFunc(InsecureZones, func(zone string) bool {
+		return dns.IsSubDomain(zone, name)
+	})
+}
+
 type Logger func(string)
 
 // Default logging functions just black-hole the input.
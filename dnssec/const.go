@@ -1,5 +1,7 @@
 package dnssec
 
+import "errors"
+
 type AuthenticationResult uint8
 
 const (
@@ -7,6 +9,10 @@ const (
 	Insecure
 	Secure
 	Bogus
+
+	// Indeterminate is returned when validation could not be completed because records needed for the chain of
+	// trust (e.g. a zone's DNSKEYs) could not be fetched. Unlike Bogus, it doesn't imply the data failed validation.
+	Indeterminate
 )
 
 func (r AuthenticationResult) String() string {
@@ -21,6 +27,8 @@ func (r AuthenticationResult) String() string {
 		return "Secure"
 	case Bogus:
 		return "Bogus"
+	case Indeterminate:
+		return "Indeterminate"
 	}
 }
 
@@ -31,6 +39,10 @@ func (r AuthenticationResult) Combine(r2 AuthenticationResult) AuthenticationRes
 	if r == Bogus || r2 == Bogus {
 		return Bogus
 	}
+	// If either result is Indeterminate, the overall result should be Indeterminate.
+	if r == Indeterminate || r2 == Indeterminate {
+		return Indeterminate
+	}
 	// If either result is Unknown, the overall result should be Unknown.
 	if r == Unknown || r2 == Unknown {
 		return Unknown
@@ -95,6 +107,125 @@ func (d DenialOfExistenceState) String() string {
 
 //---
 
+// BogusReason categorises why a result was deemed Bogus.
+type BogusReason uint8
+
+const (
+	NotBogus BogusReason = iota
+
+	// BogusSignatureInvalid - a signature failed to verify, had expired, or no matching key was found.
+	BogusSignatureInvalid
+	// BogusSignatureMissing - one or more RRsets were not covered by a signature.
+	BogusSignatureMissing
+	// BogusDoeMissing - the Denial of Existence records needed to prove a response were not found.
+	BogusDoeMissing
+	// BogusChainBroken - the chain of trust moved from Secure to Insecure without proof that it should.
+	BogusChainBroken
+	// BogusMultipleWildcards - more than one wildcard signature was seen for an answer.
+	BogusMultipleWildcards
+	// BogusAnswerMissing - a positive response did not contain an answer matching the question.
+	BogusAnswerMissing
+	// BogusFailsafe - the response could not be categorised, so we fail-safe to Bogus.
+	BogusFailsafe
+	// BogusOther - any other reason.
+	BogusOther
+)
+
+func (r BogusReason) String() string {
+	switch r {
+	default:
+		fallthrough
+	case NotBogus:
+		return "NotBogus"
+	case BogusSignatureInvalid:
+		return "SignatureInvalid"
+	case BogusSignatureMissing:
+		return "SignatureMissing"
+	case BogusDoeMissing:
+		return "DoeMissing"
+	case BogusChainBroken:
+		return "ChainBroken"
+	case BogusMultipleWildcards:
+		return "MultipleWildcards"
+	case BogusAnswerMissing:
+		return "AnswerMissing"
+	case BogusFailsafe:
+		return "Failsafe"
+	case BogusOther:
+		return "Other"
+	}
+}
+
+// InsecureReason categorises why a result was deemed Insecure.
+type InsecureReason uint8
+
+const (
+	NotInsecure InsecureReason = iota
+
+	// InsecureUnsigned - no zone in the chain was Secure; i.e. there was no DNSSEC chain to follow at all.
+	InsecureUnsigned
+	// InsecureOptOut - a Secure parent's NSEC3 opt-out span covered the delegation, so it may be unsigned.
+	InsecureOptOut
+	// InsecureMissingDS - a Secure parent proved the delegation has no DS records; i.e. the child is unsigned.
+	InsecureMissingDS
+	// InsecureByDesign - the zone is listed in InsecureZones.
+	InsecureByDesign
+	// InsecureUnsupportedAlgorithm - we support none of the algorithms in the zone's DS records.
+	InsecureUnsupportedAlgorithm
+	// InsecureOther - any other reason.
+	InsecureOther
+)
+
+func (r InsecureReason) String() string {
+	switch r {
+	default:
+		fallthrough
+	case NotInsecure:
+		return "NotInsecure"
+	case InsecureUnsigned:
+		return "Unsigned"
+	case InsecureOptOut:
+		return "OptOut"
+	case InsecureMissingDS:
+		return "MissingDS"
+	case InsecureByDesign:
+		return "ByDesign"
+	case InsecureUnsupportedAlgorithm:
+		return "UnsupportedAlgorithm"
+	case InsecureOther:
+		return "Other"
+	}
+}
+
+// bogusReasonFromError maps the error that caused a result to be Bogus, to its BogusReason.
+func bogusReasonFromError(err error) BogusReason {
+	switch {
+	case err == nil:
+		return BogusOther
+	case errors.Is(err, ErrMultipleWildcardSignatures):
+		return BogusMultipleWildcards
+	case errors.Is(err, ErrBogusDoeRecordsNotFound), errors.Is(err, ErrBogusWildcardDoeNotFound):
+		return BogusDoeMissing
+	case errors.Is(err, ErrFailsafeResponse):
+		return BogusFailsafe
+	case errors.Is(err, ErrDSWithoutMatchingDNSKEY), errors.Is(err, ErrOptOutDeniesKnownDS):
+		return BogusChainBroken
+	case errors.Is(err, ErrUnexpectedSignatureCount):
+		return BogusSignatureMissing
+	case errors.Is(err, ErrVerifyFailed),
+		errors.Is(err, ErrInvalidSignature),
+		errors.Is(err, ErrInvalidTime),
+		errors.Is(err, ErrNoKeyFoundForSignature),
+		errors.Is(err, ErrAuthSignerNameMismatch),
+		errors.Is(err, ErrInvalidLabelCount),
+		errors.Is(err, ErrSignatureSetEmpty):
+		return BogusSignatureInvalid
+	}
+	return BogusOther
+}
+
+//---
+
 type section bool
 
 const (
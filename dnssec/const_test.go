@@ -15,6 +15,7 @@ func TestAuthenticationResult_String(t *testing.T) {
 		{Insecure, "Insecure"},
 		{Secure, "Secure"},
 		{Bogus, "Bogus"},
+		{Indeterminate, "Indeterminate"},
 	}
 
 	for _, test := range tests {
@@ -37,6 +38,11 @@ func TestAuthenticationResult_Combine(t *testing.T) {
 		{Unknown, Unknown, Unknown},
 		{Unknown, Bogus, Bogus},
 		{Bogus, Bogus, Bogus},
+		{Secure, Indeterminate, Indeterminate},
+		{Insecure, Indeterminate, Indeterminate},
+		{Unknown, Indeterminate, Indeterminate},
+		{Indeterminate, Bogus, Bogus},
+		{Indeterminate, Indeterminate, Indeterminate},
 	}
 
 	for _, test := range tests {
@@ -65,3 +71,43 @@ func TestDenialOfExistenceState_String(t *testing.T) {
 		assert.Equal(t, test.expected, test.state.String())
 	}
 }
+
+func TestBogusReason_String(t *testing.T) {
+	tests := []struct {
+		reason   BogusReason
+		expected string
+	}{
+		{NotBogus, "NotBogus"},
+		{BogusSignatureInvalid, "SignatureInvalid"},
+		{BogusSignatureMissing, "SignatureMissing"},
+		{BogusDoeMissing, "DoeMissing"},
+		{BogusChainBroken, "ChainBroken"},
+		{BogusMultipleWildcards, "MultipleWildcards"},
+		{BogusAnswerMissing, "AnswerMissing"},
+		{BogusFailsafe, "Failsafe"},
+		{BogusOther, "Other"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.reason.String())
+	}
+}
+
+func TestInsecureReason_String(t *testing.T) {
+	tests := []struct {
+		reason   InsecureReason
+		expected string
+	}{
+		{NotInsecure, "NotInsecure"},
+		{InsecureUnsigned, "Unsigned"},
+		{InsecureOptOut, "OptOut"},
+		{InsecureMissingDS, "MissingDS"},
+		{InsecureByDesign, "ByDesign"},
+		{InsecureUnsupportedAlgorithm, "UnsupportedAlgorithm"},
+		{InsecureOther, "Other"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.reason.String())
+	}
+}
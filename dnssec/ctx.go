@@ -0,0 +1,7 @@
+package dnssec
+
+type ctxKey uint8
+
+const (
+	CtxValidationTime ctxKey = iota // A time.Time to check signatures' validity periods against, in place of now.
+)
@@ -0,0 +1,87 @@
+package doe
+
+import (
+	"bytes"
+	"github.com/miekg/dns"
+)
+
+// canonicalCmp compares two domain names using the canonical DNS name order.
+// https://datatracker.ietf.org/doc/html/rfc4034#section-6.1
+//
+// Names are compared label by label, starting with the right most label. Each label is compared as its wire format
+// octets, with uppercase US-ASCII letters treated as lowercase. If one name runs out of labels first, it's the shorter
+// name, and sorts first. Presentation format escapes (`\DDD` and `\X`) are decoded before comparison, such that
+// `\000.example.` sorts immediately after `example.`, and `\.` is a dot within a label, not a label separator.
+func canonicalCmp(a, b string) int {
+	labelsA := dns.SplitDomainName(a)
+	labelsB := dns.SplitDomainName(b)
+
+	for i := 1; i <= min(len(labelsA), len(labelsB)); i++ {
+		c := bytes.Compare(
+			canonicalLabel(labelsA[len(labelsA)-i]),
+			canonicalLabel(labelsB[len(labelsB)-i]),
+		)
+		if c != 0 {
+			return c
+		}
+	}
+
+	// If labels are identical so far, the shorter one sorts first
+	switch {
+	case len(labelsA) < len(labelsB):
+		return -1
+	case len(labelsA) > len(labelsB):
+		return 1
+	}
+	return 0
+}
+
+// canonicalCovers returns true if name falls strictly between an NSEC record's owner and next domain name.
+// https://datatracker.ietf.org/doc/html/rfc4034#section-4.1.1
+// The value of the Next Domain Name field in the last NSEC record in the zone is the name of the zone apex.
+// i.e. The last NSEC record wraps around, covering everything after its owner name.
+func canonicalCovers(owner, next, name, apex string) bool {
+	if canonicalCmp(owner, name) >= 0 {
+		return false
+	}
+	if canonicalCmp(next, apex) == 0 {
+		return true
+	}
+	return canonicalCmp(name, next) < 0
+}
+
+// canonicalLabel returns the wire format octets of a presentation format label, with US-ASCII letters lowercased.
+func canonicalLabel(label string) []byte {
+	b := make([]byte, 0, len(label))
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if c == '\\' && i+1 < len(label) {
+			if i+3 < len(label) && canonicalIsDigit(label[i+1]) && canonicalIsDigit(label[i+2]) && canonicalIsDigit(label[i+3]) {
+				// An escaped octet, \DDD
+				v := int(label[i+1]-'0')*100 + int(label[i+2]-'0')*10 + int(label[i+3]-'0')
+				if v > 255 {
+					// Not a valid octet, so we treat the digits as-is.
+					c = label[i+1]
+					i++
+				} else {
+					c = byte(v)
+					i += 3
+				}
+			} else {
+				// An escaped character, \X
+				c = label[i+1]
+				i++
+			}
+		}
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// Check if a character is a digit
+func canonicalIsDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
@@ -0,0 +1,152 @@
+package doe
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCanonical_CmpVectors(t *testing.T) {
+
+	// Each pair, a and b, with the expected result of canonicalCmp(a, b).
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		// Equal names, regardless of case and trailing dot.
+		{"example.", "example.", 0},
+		{"example", "example.", 0},
+		{"EXAMPLE.", "example.", 0},
+		{"a.Example.", "A.example.", 0},
+
+		// Escaped octets are decoded, then lowercased.
+		{`\065.example.`, "a.example.", 0},
+		{`\097.example.`, "A.example.", 0},
+		{`\A.example.`, "a.example.", 0},
+
+		// The shorter name (fewer labels) sorts first.
+		{"example.", "a.example.", -1},
+		{"a.example.", "example.", 1},
+		{".", "example.", -1},
+
+		// Labels are compared right to left.
+		{"z.a.example.", "a.z.example.", -1},
+		{"a.example.", "b.example.", -1},
+		{"b.example.", "a.example.", 1},
+
+		// Within a label, a shorter label sorts first if it's a prefix of the other.
+		{"a.example.", "aa.example.", -1},
+		{"zABC.a.example.", "z.a.example.", 1},
+
+		// The \000 label sorts immediately after the name it prefixes; before anything else below it.
+		{"example.", `\000.example.`, -1},
+		{`\000.example.`, `\001.example.`, -1},
+		{`\000.example.`, "*.example.", -1},
+		{`\000.example.`, "a.example.", -1},
+		{`\000.example.`, `\000\000.example.`, -1},
+
+		// Octets are compared unsigned, so \200 sorts after z.
+		{"z.example.", `\200.example.`, -1},
+		{`\200.example.`, `\255.example.`, -1},
+
+		// `*` (0x2a) sorts before `-` (0x2d), digits, and letters.
+		{"*.example.", "-.example.", -1},
+		{"*.example.", "0.example.", -1},
+		{"*.example.", "a.example.", -1},
+
+		// An escaped dot is part of the label, not a separator.
+		{`a\.b.example.`, "a.example.", 1},
+		{`a\.b.example.`, "b.a.example.", 1},
+		{`a\.b.example.`, "b.example.", -1},
+		{`a\046b.example.`, `a\.b.example.`, 0},
+
+		// Other escaped characters.
+		{`a\\b.example.`, `a\092b.example.`, 0},
+	}
+
+	for _, test := range tests {
+		if c := canonicalCmp(test.a, test.b); c != test.expected {
+			t.Errorf("canonicalCmp(%s, %s): expected %d, got %d", test.a, test.b, test.expected, c)
+		}
+	}
+}
+
+func TestCanonical_RFC4034Order(t *testing.T) {
+
+	// https://datatracker.ietf.org/doc/html/rfc4034#section-6.1
+	expected := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		`\001.z.example.`,
+		"*.z.example.",
+		`\200.z.example.`,
+	}
+
+	for i := 0; i < 10; i++ {
+		domains := slices.Clone(expected)
+		slices.Reverse(domains)
+		if i > 0 {
+			// Rotate, so we're not always sorting from the same starting order.
+			domains = append(domains[i%len(domains):], domains[:i%len(domains)]...)
+		}
+
+		slices.SortFunc(domains, canonicalCmp)
+
+		if !slices.Equal(expected, domains) {
+			t.Errorf("domain ordering does not match: %v", domains)
+		}
+	}
+}
+
+func TestCanonical_Covers(t *testing.T) {
+
+	const apex = "example."
+
+	tests := []struct {
+		owner, next, name string
+		expected          bool
+	}{
+		// Simple cover.
+		{"a.example.", "c.example.", "b.example.", true},
+		{"a.example.", "c.example.", "B.EXAMPLE.", true},
+
+		// The owner and next names themselves are not covered.
+		{"a.example.", "c.example.", "a.example.", false},
+		{"a.example.", "c.example.", "c.example.", false},
+
+		// Names outside the range.
+		{"b.example.", "d.example.", "a.example.", false},
+		{"b.example.", "d.example.", "e.example.", false},
+
+		// Names below the owner are covered, as they sort between it and the next name.
+		{"a.example.", "c.example.", "x.a.example.", true},
+		{"a.example.", "a.a.example.", `\000.a.example.`, true},
+
+		// The wildcard sentinel.
+		{"example.", "a.example.", "*.example.", true},
+		{"example.", "a.example.", `\000.example.`, true},
+		{`\000.example.`, "a.example.", "*.example.", true},
+
+		// The last NSEC in the zone wraps around to the apex.
+		{"z.example.", "example.", "zz.example.", true},
+		{"z.example.", "EXAMPLE.", "a.z.example.", true},
+		{"z.example.", "example.", "a.example.", false},
+
+		// A zone with a single NSEC covers everything below the apex.
+		{"example.", "example.", "a.example.", true},
+		{"example.", "example.", "example.", false},
+
+		// A next name before the owner, that isn't the apex, covers nothing.
+		{"z.example.", "b.example.", "zz.example.", false},
+		{"z.example.", "b.example.", "a.example.", false},
+	}
+
+	for _, test := range tests {
+		if c := canonicalCovers(test.owner, test.next, test.name, apex); c != test.expected {
+			t.Errorf("canonicalCovers(%s, %s, %s): expected %t, got %t", test.owner, test.next, test.name, test.expected, c)
+		}
+	}
+}
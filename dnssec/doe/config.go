@@ -0,0 +1,13 @@
+package doe
+
+const (
+	DefaultRequireConsistentNSEC3Parameters = true
+)
+
+var (
+	// RequireConsistentNSEC3Parameters - if true (default), when the NSEC3 records within a response disagree on their
+	// salt or number of iterations, none of them are used; the proof is treated as unavailable. Every NSEC3 record in a
+	// zone must use the same parameters, so a mixed set is a sign of records spliced together from elsewhere.
+	// See https://datatracker.ietf.org/doc/html/rfc5155#section-7.2
+	RequireConsistentNSEC3Parameters = DefaultRequireConsistentNSEC3Parameters
+)
@@ -3,6 +3,7 @@ package doe
 import (
 	"context"
 	"github.com/miekg/dns"
+	"strings"
 )
 
 type DenialOfExistenceNSEC struct {
@@ -38,6 +39,11 @@ func NewDenialOfExistenceNSEC3(ctx context.Context, zone string, records []*dns.
 
 		checkRecords = append(checkRecords, r)
 	}
+
+	if RequireConsistentNSEC3Parameters && !consistentNSEC3Parameters(checkRecords) {
+		checkRecords = nil
+	}
+
 	return &DenialOfExistenceNSEC3{
 		ctx,
 		zone,
@@ -45,6 +51,16 @@ func NewDenialOfExistenceNSEC3(ctx context.Context, zone string, records []*dns.
 	}
 }
 
+// consistentNSEC3Parameters returns true if all the records use the same salt and number of iterations.
+func consistentNSEC3Parameters(records []*dns.NSEC3) bool {
+	for _, r := range records {
+		if r.Iterations != records[0].Iterations || !strings.EqualFold(r.Salt, records[0].Salt) {
+			return false
+		}
+	}
+	return true
+}
+
 //----------------------------------------------------------
 
 func (doe *DenialOfExistenceNSEC) Empty() bool {
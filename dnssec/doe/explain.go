@@ -0,0 +1,106 @@
+package doe
+
+import (
+	"github.com/miekg/dns"
+)
+
+// Explanation details which NSEC or NSEC3 records were used to prove that a name, or type, does not exist.
+// It's intended to help operators debug why a denial of existence was, or wasn't, accepted.
+// Any record not found is left nil.
+type Explanation struct {
+	// QName is the name the denial of existence was for.
+	QName string
+
+	// Matching is the record whose owner matches the QName. For a NODATA response, its type bitmap
+	// proves the type does not exist.
+	Matching dns.RR
+
+	// ClosestEncloser is the longest existing ancestor of the QName, and ClosestEncloserRecord the NSEC3 record
+	// matching it. NSEC3 only.
+	ClosestEncloser       string
+	ClosestEncloserRecord dns.RR
+
+	// NextCloserName is the name one label longer than the closest encloser, and NextCloserRecord the record
+	// covering it. With NSEC, this is the QName itself.
+	NextCloserName   string
+	NextCloserRecord dns.RR
+
+	// Wildcard is the wildcard that could have matched the QName, and WildcardRecord the record that covers it. For
+	// a wildcard NODATA response, WildcardRecord instead matches it.
+	Wildcard       string
+	WildcardRecord dns.RR
+
+	// OptedOut is true if a record covering the next closer name has the opt-out flag set. NSEC3 only.
+	OptedOut bool
+}
+
+// Explain returns an Explanation detailing the NSEC records that match, or cover, qname and its wildcard.
+func (doe *DenialOfExistenceNSEC) Explain(qname string) *Explanation {
+	qname = dns.CanonicalName(qname)
+
+	e := &Explanation{
+		QName:          qname,
+		NextCloserName: qname,
+		Wildcard:       wildcardName(qname),
+	}
+
+	for _, nsec := range doe.records {
+		owner := dns.CanonicalName(nsec.Header().Name)
+		if owner == qname && e.Matching == nil {
+			e.Matching = nsec
+		}
+		if e.NextCloserRecord == nil && canonicalCovers(nsec.Header().Name, nsec.NextDomain, qname, doe.zone) {
+			e.NextCloserRecord = nsec
+		}
+		if e.WildcardRecord == nil && canonicalCovers(nsec.Header().Name, nsec.NextDomain, e.Wildcard, doe.zone) {
+			e.WildcardRecord = nsec
+		}
+	}
+
+	return e
+}
+
+// Explain returns an Explanation detailing the NSEC3 records that match the QName and its closest encloser,
+// and that cover (or match) the next closer name and wildcard. These are the same records used by
+// PerformClosestEncloserProof() and TypeBitMapContainsAnyOf().
+func (doe *DenialOfExistenceNSEC3) Explain(qname string) *Explanation {
+	e := &Explanation{
+		QName: dns.CanonicalName(qname),
+	}
+
+	for _, nsec3 := range doe.records {
+		if nsec3.Match(e.QName) {
+			e.Matching = nsec3
+			break
+		}
+	}
+
+	closestEncloser, nextCloserName, ok := doe.FindClosestEncloser(qname)
+	if !ok {
+		return e
+	}
+
+	e.ClosestEncloser = closestEncloser
+	e.NextCloserName = nextCloserName
+	e.Wildcard = "*." + closestEncloser
+
+	for _, nsec3 := range doe.records {
+		if e.ClosestEncloserRecord == nil && nsec3.Match(closestEncloser) {
+			e.ClosestEncloserRecord = nsec3
+		}
+		if nsec3.Cover(nextCloserName) {
+			if e.NextCloserRecord == nil {
+				e.NextCloserRecord = nsec3
+			}
+			e.OptedOut = e.OptedOut || nsec3.Flags == 1
+		}
+		if nsec3.Match(e.Wildcard) {
+			// A match takes precedence over a cover.
+			e.WildcardRecord = nsec3
+		} else if e.WildcardRecord == nil && nsec3.Cover(e.Wildcard) {
+			e.WildcardRecord = nsec3
+		}
+	}
+
+	return e
+}
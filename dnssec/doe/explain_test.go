@@ -0,0 +1,90 @@
+package doe
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"slices"
+	"testing"
+)
+
+func TestDenialOfExistenceNSEC3_ExplainNxDomain(t *testing.T) {
+
+	r := getTestNsec3RRSets()
+
+	nsec3 := NewDenialOfExistenceNSEC3(context.Background(), zoneName, slices.Concat(r.closestEncloser, r.nextCloserName, r.wildcardCovers))
+
+	e := nsec3.Explain("test.example.com.")
+
+	if e.QName != "test.example.com." {
+		t.Errorf("unexpected qname: %s", e.QName)
+	}
+	if e.Matching != nil {
+		t.Error("we expected no record to match the qname")
+	}
+	if e.ClosestEncloser != "example.com." || e.ClosestEncloserRecord != r.closestEncloser[0] {
+		t.Errorf("unexpected closest encloser: %s %v", e.ClosestEncloser, e.ClosestEncloserRecord)
+	}
+	if e.NextCloserName != "test.example.com." || e.NextCloserRecord != r.nextCloserName[0] {
+		t.Errorf("unexpected next closer name: %s %v", e.NextCloserName, e.NextCloserRecord)
+	}
+	if e.Wildcard != "*.example.com." || e.WildcardRecord != r.wildcardCovers[0] {
+		t.Errorf("unexpected wildcard: %s %v", e.Wildcard, e.WildcardRecord)
+	}
+	if e.OptedOut {
+		t.Error("we expected no opt-out")
+	}
+
+	//---
+
+	// A record matching the wildcard should be given in preference to one covering it.
+
+	nsec3 = NewDenialOfExistenceNSEC3(context.Background(), zoneName, slices.Concat(r.closestEncloser, r.nextCloserName, r.wildcardCovers, r.wildcardMatches))
+
+	e = nsec3.Explain("test.example.com.")
+	if e.WildcardRecord != r.wildcardMatches[0] {
+		t.Errorf("expected the wildcard matching record. got %v", e.WildcardRecord)
+	}
+
+	//---
+
+	// Without a closest encloser, only the QName's details can be given.
+
+	nsec3 = NewDenialOfExistenceNSEC3(context.Background(), zoneName, slices.Concat(r.nextCloserName, r.wildcardCovers))
+
+	e = nsec3.Explain("test.example.com.")
+	if e.ClosestEncloser != "" || e.ClosestEncloserRecord != nil || e.NextCloserRecord != nil || e.WildcardRecord != nil {
+		t.Errorf("we expected no proof records. got %+v", e)
+	}
+}
+
+func TestDenialOfExistenceNSEC3_ExplainNoData(t *testing.T) {
+
+	r := getTestNsec3RRSets()
+
+	nsec3 := NewDenialOfExistenceNSEC3(context.Background(), zoneName, r.qnameMatches)
+
+	e := nsec3.Explain("test.example.com.")
+	if e.Matching != r.qnameMatches[0] {
+		t.Errorf("expected the qname matching record. got %v", e.Matching)
+	}
+}
+
+func TestDenialOfExistenceNSEC_Explain(t *testing.T) {
+
+	qnameCovers := newRR("a.example.com. 3600 IN NSEC z.example.com. A RRSIG NSEC").(*dns.NSEC)
+	wildcardCovers := newRR("example.com. 3600 IN NSEC a.example.com. SOA NS RRSIG NSEC").(*dns.NSEC)
+
+	nsec := NewDenialOfExistenceNSEC(context.Background(), zoneName, []*dns.NSEC{qnameCovers, wildcardCovers})
+
+	e := nsec.Explain("test.example.com.")
+
+	if e.NextCloserName != "test.example.com." || e.NextCloserRecord != qnameCovers {
+		t.Errorf("unexpected next closer name: %s %v", e.NextCloserName, e.NextCloserRecord)
+	}
+	if e.Wildcard != "*.example.com." || e.WildcardRecord != wildcardCovers {
+		t.Errorf("unexpected wildcard: %s %v", e.Wildcard, e.WildcardRecord)
+	}
+	if e.Matching != nil {
+		t.Error("we expected no record to match the qname")
+	}
+}
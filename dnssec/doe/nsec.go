@@ -23,10 +23,7 @@ func (doe *DenialOfExistenceNSEC) verifyQNameCovered(qname string) bool {
 	*/
 
 	for _, nsec := range doe.records {
-		qnameAfterNsecOwnerName := canonicalCmp(nsec.Header().Name, qname) < 0
-		qnameBeforeNextDomain := dns.CanonicalName(nsec.NextDomain) == doe.zone || canonicalCmp(qname, nsec.NextDomain) < 0
-
-		if qnameAfterNsecOwnerName && qnameBeforeNextDomain {
+		if canonicalCovers(nsec.Header().Name, nsec.NextDomain, qname, doe.zone) {
 			return true
 		}
 	}
@@ -46,10 +43,7 @@ func (doe *DenialOfExistenceNSEC) verifyWildcardCovered(qname string) bool {
 	wildcard := wildcardName(qname)
 
 	for _, nsec := range doe.records {
-		wildcardAfterNsecOwnerName := canonicalCmp(nsec.Header().Name, wildcard) < 0
-		wildcardBeforeNextDomain := dns.CanonicalName(nsec.NextDomain) == doe.zone || canonicalCmp(wildcard, nsec.NextDomain) < 0
-
-		if wildcardAfterNsecOwnerName && wildcardBeforeNextDomain {
+		if canonicalCovers(nsec.Header().Name, nsec.NextDomain, wildcard, doe.zone) {
 			return true
 		}
 	}
@@ -1,6 +1,7 @@
 package doe
 
 import (
+	"context"
 	"github.com/miekg/dns"
 	"slices"
 )
@@ -147,6 +148,8 @@ func (doe *DenialOfExistenceNSEC3) TypeBitMapContainsAnyOf(name string, types []
 	return nameSeen, false
 }
 
+// FindClosestEncloser returns the closest encloser of qname, and its next closer name, as proven by the NSEC3 records.
+// False is returned if no eligible closest encloser was found.
 func (doe *DenialOfExistenceNSEC3) FindClosestEncloser(qname string) (string, string, bool) {
 
 	// https://datatracker.ietf.org/doc/html/rfc7129#section-5.5
@@ -223,3 +226,17 @@ func (doe *DenialOfExistenceNSEC3) FindClosestEncloser(qname string) (string, st
 
 	return contender.ce, contender.ncn, true
 }
+
+// ClosestEncloser returns the closest encloser of qname, and its next closer name, as proven by the NSEC3 records, for
+// zone, found in records. Records of other types are ignored, so a response's Authority section can be passed as is.
+// This exposes the computation used by the validator, for tooling and debugging.
+func ClosestEncloser(zone, qname string, records []dns.RR) (closestEncloser, nextCloserName string, ok bool) {
+	nsec3 := make([]*dns.NSEC3, 0, len(records))
+	for _, rr := range records {
+		if r, ok := rr.(*dns.NSEC3); ok {
+			nsec3 = append(nsec3, r)
+		}
+	}
+	doe := NewDenialOfExistenceNSEC3(context.Background(), dns.CanonicalName(zone), nsec3)
+	return doe.FindClosestEncloser(dns.CanonicalName(qname))
+}
@@ -161,6 +161,37 @@ func TestDenialOfExistenceNSEC3_ClosestEncloserProof(t *testing.T) {
 
 }
 
+func TestClosestEncloser(t *testing.T) {
+
+	r := getTestNsec3RRSets()
+
+	records := []dns.RR{
+		newRR("example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 3600"),
+	}
+	for _, rr := range slices.Concat(r.closestEncloser, r.nextCloserName, r.wildcardCovers) {
+		records = append(records, rr)
+	}
+
+	closestEncloser, nextCloserName, ok := ClosestEncloser("Example.com", "a.test.example.com.", records)
+	if !ok {
+		t.Fatal("we expected a closest encloser to be found")
+	}
+	if closestEncloser != "example.com." {
+		t.Errorf("unexpected closest encloser: %s", closestEncloser)
+	}
+	if nextCloserName != "test.example.com." {
+		t.Errorf("unexpected next closer name: %s", nextCloserName)
+	}
+
+	//---
+
+	// Without the record matching the apex, there's no closest encloser.
+	_, _, ok = ClosestEncloser("example.com.", "a.test.example.com.", records[2:])
+	if ok {
+		t.Error("we expected no closest encloser to be found")
+	}
+}
+
 func TestDenialOfExistenceNSEC3_WildcardProof(t *testing.T) {
 
 	r := getTestNsec3RRSets()
@@ -273,3 +304,40 @@ func TestDenialOfExistenceNSEC3_InvalidValues(t *testing.T) {
 
 	// We've tested in previous tests that proofs fail if nsec3.empty() is true.
 }
+
+func TestDenialOfExistenceNSEC3_InconsistentParameters(t *testing.T) {
+
+	// NSEC3 records, within the same response, that disagree on their salt or iterations must not be used.
+
+	r := getTestNsec3RRSets()
+
+	// An additional record, covering nothing in particular, but with a different salt.
+	mismatchedSalt := newRR("A72QU4B0R4USH96QN17VTCD8395QILEQ.example.com. 3600 IN NSEC3 1 0 2 ABCDEE B72QU4B0R4USH96QN17VTCD8395QILEQ A RRSIG").(*dns.NSEC3)
+
+	nsec3 := NewDenialOfExistenceNSEC3(context.Background(), zoneName, slices.Concat(r.closestEncloser, r.nextCloserName, r.wildcardCovers, []*dns.NSEC3{mismatchedSalt}))
+	if !nsec3.Empty() {
+		t.Error("we expect there to be no nsec3 records to check when their salts differ")
+	}
+
+	_, closestEncloserProof, nextCloserNameProof, wildcardProof := nsec3.PerformClosestEncloserProof("test.example.com.")
+	if closestEncloserProof || nextCloserNameProof || wildcardProof {
+		t.Error("we expect the proofs to be rejected when the nsec3 salts differ")
+	}
+
+	// Likewise for differing iterations.
+	mismatchedIterations := newRR("A72QU4B0R4USH96QN17VTCD8395QILEQ.example.com. 3600 IN NSEC3 1 0 3 ABCDEF B72QU4B0R4USH96QN17VTCD8395QILEQ A RRSIG").(*dns.NSEC3)
+
+	nsec3 = NewDenialOfExistenceNSEC3(context.Background(), zoneName, slices.Concat(r.closestEncloser, r.nextCloserName, r.wildcardCovers, []*dns.NSEC3{mismatchedIterations}))
+	if !nsec3.Empty() {
+		t.Error("we expect there to be no nsec3 records to check when their iterations differ")
+	}
+
+	// Unless the check is disabled.
+	RequireConsistentNSEC3Parameters = false
+	defer func() { RequireConsistentNSEC3Parameters = DefaultRequireConsistentNSEC3Parameters }()
+
+	nsec3 = NewDenialOfExistenceNSEC3(context.Background(), zoneName, slices.Concat(r.closestEncloser, r.nextCloserName, r.wildcardCovers, []*dns.NSEC3{mismatchedSalt}))
+	if nsec3.Empty() {
+		t.Error("we expect the nsec3 records to be checked when consistency is not required")
+	}
+}
@@ -0,0 +1,44 @@
+package dnssec
+
+import (
+	"errors"
+	"github.com/miekg/dns"
+)
+
+// ExtendedErrorCode maps the outcome of validation to the Extended DNS Error info code that best describes it.
+// state and err are as returned by Result(), and reason by BogusReason(). False is returned for outcomes that
+// are not failures; i.e. Secure, Insecure and Unknown.
+// See https://datatracker.ietf.org/doc/html/rfc8914#section-4
+func ExtendedErrorCode(state AuthenticationResult, reason BogusReason, err error) (uint16, bool) {
+	switch state {
+	case Indeterminate:
+		return dns.ExtendedErrorCodeDNSSECIndeterminate, true
+	case Bogus:
+		return reason.extendedErrorCode(err), true
+	}
+	return 0, false
+}
+
+// extendedErrorCode returns the Extended DNS Error info code for a Bogus result with this reason. The error refines
+// the code, where the reason alone is too broad; e.g. an invalid signature that had expired.
+func (r BogusReason) extendedErrorCode(err error) uint16 {
+	switch r {
+	case BogusSignatureMissing:
+		return dns.ExtendedErrorCodeRRSIGsMissing
+	case BogusDoeMissing:
+		return dns.ExtendedErrorCodeNSECMissing
+	}
+
+	switch {
+	case errors.Is(err, ErrSignatureExpired):
+		return dns.ExtendedErrorCodeSignatureExpired
+	case errors.Is(err, ErrSignatureNotYetValid):
+		return dns.ExtendedErrorCodeSignatureNotYetValid
+	case errors.Is(err, ErrNoKeyFoundForSignature), errors.Is(err, ErrDSWithoutMatchingDNSKEY):
+		return dns.ExtendedErrorCodeDNSKEYMissing
+	case errors.Is(err, ErrUnsupportedAlgorithm):
+		return dns.ExtendedErrorCodeUnsupportedDNSKEYAlgorithm
+	}
+
+	return dns.ExtendedErrorCodeDNSBogus
+}
@@ -0,0 +1,60 @@
+package dnssec
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestExtendedErrorCode(t *testing.T) {
+	tests := []struct {
+		reason BogusReason
+		err    error
+		code   uint16
+	}{
+		{BogusSignatureMissing, ErrUnexpectedSignatureCount, dns.ExtendedErrorCodeRRSIGsMissing},
+		{BogusDoeMissing, ErrBogusDoeRecordsNotFound, dns.ExtendedErrorCodeNSECMissing},
+		{BogusDoeMissing, nil, dns.ExtendedErrorCodeNSECMissing},
+		{BogusSignatureInvalid, fmt.Errorf("%w: %w", ErrInvalidTime, ErrSignatureExpired), dns.ExtendedErrorCodeSignatureExpired},
+		{BogusSignatureInvalid, fmt.Errorf("%w: %w", ErrInvalidTime, ErrSignatureNotYetValid), dns.ExtendedErrorCodeSignatureNotYetValid},
+		{BogusSignatureInvalid, ErrNoKeyFoundForSignature, dns.ExtendedErrorCodeDNSKEYMissing},
+		{BogusSignatureInvalid, ErrInvalidSignature, dns.ExtendedErrorCodeDNSBogus},
+		{BogusChainBroken, ErrDSWithoutMatchingDNSKEY, dns.ExtendedErrorCodeDNSKEYMissing},
+		{BogusChainBroken, nil, dns.ExtendedErrorCodeDNSBogus},
+		{BogusMultipleWildcards, ErrMultipleWildcardSignatures, dns.ExtendedErrorCodeDNSBogus},
+		{BogusAnswerMissing, nil, dns.ExtendedErrorCodeDNSBogus},
+		{BogusFailsafe, ErrFailsafeResponse, dns.ExtendedErrorCodeDNSBogus},
+		{BogusOther, ErrUnsupportedAlgorithm, dns.ExtendedErrorCodeUnsupportedDNSKEYAlgorithm},
+	}
+
+	for _, test := range tests {
+		code, ok := ExtendedErrorCode(Bogus, test.reason, test.err)
+		assert.True(t, ok, test.reason.String())
+		assert.Equal(t, test.code, code, "%s: %v", test.reason, test.err)
+	}
+
+	code, ok := ExtendedErrorCode(Indeterminate, NotBogus, ErrKeysFetchFailed)
+	assert.True(t, ok)
+	assert.Equal(t, dns.ExtendedErrorCodeDNSSECIndeterminate, code)
+
+	for _, state := range []AuthenticationResult{Secure, Insecure, Unknown} {
+		_, ok = ExtendedErrorCode(state, NotBogus, nil)
+		assert.False(t, ok, state.String())
+	}
+}
+
+func TestAuthenticate_InvalidTimeReason(t *testing.T) {
+	rrset := []dns.RR{newRR("example.com. 3600 IN MX 10 mx1.example.com.")}
+	key := testEcKey()
+
+	expired := append(rrset, key.sign(rrset, time.Now().Add(-48*time.Hour).Unix(), time.Now().Add(-24*time.Hour).Unix()))
+	set, _ := authenticate(time.Now(), zoneName, expired, []*dns.DNSKEY{key.key}, answerSection)
+	assert.ErrorIs(t, set.Verify(), ErrInvalidTime)
+	assert.ErrorIs(t, set.Verify(), ErrSignatureExpired)
+
+	future := append(rrset, key.sign(rrset, time.Now().Add(24*time.Hour).Unix(), time.Now().Add(48*time.Hour).Unix()))
+	set, _ = authenticate(time.Now(), zoneName, future, []*dns.DNSKEY{key.key}, answerSection)
+	assert.ErrorIs(t, set.Verify(), ErrSignatureNotYetValid)
+}
@@ -9,13 +9,18 @@ var (
 	ErrNoParentDSRecords              = errors.New("no DS records passed")
 	ErrUnableToFetchDSRecord          = errors.New("unable to fetch missing DS record")
 	ErrKeysNotFound                   = errors.New("no dnskey records found for zone")
+	ErrKeysFetchFailed                = errors.New("unable to fetch the dnskey records for zone")
 	ErrKeySigningKeysNotFound         = errors.New("no dnskey records found that match the parent ds records")
+	ErrDSWithoutMatchingDNSKEY        = errors.New("the parent has ds records for the zone, but the zone has no dnskey to match them")
 	ErrAuthSignerNameMismatch         = errors.New("auth signer name does match the zone's origin")
 	ErrSignatureSetEmpty              = errors.New("cannot verify an empty signature set")
 	ErrUnableToVerify                 = errors.New("unable to verify signature")
 	ErrVerifyFailed                   = errors.New("signature verification failed")
 	ErrNoKeyFoundForSignature         = errors.New("no key found for signature")
+	ErrUnsupportedAlgorithm           = errors.New("the zone is only signed with algorithms we cannot verify")
 	ErrInvalidTime                    = errors.New("current time is outside of the msg validity period")
+	ErrSignatureExpired               = errors.New("the signature has expired")
+	ErrSignatureNotYetValid           = errors.New("the signature is not yet valid")
 	ErrInvalidSignature               = errors.New("msg signature is invalid")
 	ErrInvalidLabelCount              = errors.New("number of labels in the rrset owner name is less the value in the rrsig rr's labels field")
 	ErrMultipleVaryingSignerNames     = errors.New("rrsigs in the response contain multiple varying signer names")
@@ -23,6 +28,7 @@ var (
 	ErrFailsafeResponse               = errors.New("unable to determine if response is delegating, positive or negative. we fail-safe to bogus")
 	ErrUnexpectedSignatureCount       = errors.New("an unexpected number of rrsig records were found given the rrsets seen")
 	ErrMultipleWildcardSignatures     = errors.New("multiple wildcard signatures seen")
+	ErrTooManySignatures              = errors.New("the number of rrsigs covering an rrset exceeds the maximum allowed")
 	ErrDSLookupLoop                   = errors.New("the maximum number of ds record lookups has been reached")
 	ErrNotSubdomain                   = errors.New("domain is not a subdomain of another")
 	ErrSameName                       = errors.New("domain names are the same")
@@ -32,6 +38,7 @@ var (
 	ErrBogusResultFound               = errors.New("we've deemed the result bogus")
 	ErrBogusDoeRecordsNotFound        = errors.New("denial of existence records missing")
 	ErrBogusWildcardDoeNotFound       = errors.New("missing doe for qname when answer synthesised from a wildcard")
+	ErrOptOutDeniesKnownDS            = errors.New("an nsec3 opt-out span covers a delegation known to have ds records")
 	ErrNotAllInputsProcessed          = errors.New("not all inputs have been processed")
 	ErrDuplicateInputForZone          = errors.New("duplicate input for zone")
 )
@@ -0,0 +1,60 @@
+package dnssec
+
+import (
+	"sync"
+	"time"
+)
+
+// expiringSet holds keys until they expire. It's bounded by the size passed to add(); once full, anything expired is
+// removed, and if it's still full, room is made by removing an arbitrary key.
+type expiringSet[K comparable] struct {
+	lock    sync.Mutex
+	entries map[K]time.Time
+}
+
+func newExpiringSet[K comparable]() *expiringSet[K] {
+	return &expiringSet[K]{entries: make(map[K]time.Time)}
+}
+
+// contains reports if key is held, and has not expired.
+func (s *expiringSet[K]) contains(key K) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	expires, ok := s.entries[key]
+	if !ok {
+		return false
+	}
+	if !expires.After(time.Now()) {
+		delete(s.entries, key)
+		return false
+	}
+	return true
+}
+
+// add holds key until expires. At most maxEntries keys are held.
+func (s *expiringSet[K]) add(key K, expires time.Time, maxEntries int) {
+	if maxEntries <= 0 {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.entries[key]; !ok && len(s.entries) >= maxEntries {
+		now := time.Now()
+		for k, e := range s.entries {
+			if !e.After(now) {
+				delete(s.entries, k)
+			}
+		}
+		for k := range s.entries {
+			if len(s.entries) < maxEntries {
+				break
+			}
+			delete(s.entries, k)
+		}
+	}
+
+	s.entries[key] = expires
+}
@@ -1,7 +1,9 @@
 package dnssec
 
 import (
+	"context"
 	"github.com/miekg/dns"
+	"time"
 )
 
 func extractRecords[T dns.RR](rr []dns.RR) []T {
@@ -34,6 +36,33 @@ func extractRecordsOfNameAndType(rr []dns.RR, name string, t uint16) []dns.RR {
 	return r
 }
 
+// QuestionRRsets returns the records of type qtype, plus any CNAME/DNAME records that may lead to them,
+// along with the RRSIGs covering those types. For ANY all records are returned. These are the only Answer section
+// records validated when ValidateQuestionRRsetsOnly is set.
+func QuestionRRsets(rr []dns.RR, qtype uint16) []dns.RR {
+	if qtype == dns.TypeANY {
+		return rr
+	}
+
+	relevant := func(t uint16) bool {
+		return t == qtype || t == dns.TypeCNAME || t == dns.TypeDNAME
+	}
+
+	r := make([]dns.RR, 0, len(rr))
+	for _, record := range rr {
+		if rrsig, ok := record.(*dns.RRSIG); ok && qtype != dns.TypeRRSIG {
+			if relevant(rrsig.TypeCovered) {
+				r = append(r, record)
+			}
+			continue
+		}
+		if relevant(record.Header().Rrtype) {
+			r = append(r, record)
+		}
+	}
+	return r
+}
+
 func recordsOfTypeExist(rr []dns.RR, t uint16) bool {
 	for _, record := range rr {
 		if record.Header().Rrtype == t {
@@ -65,6 +94,19 @@ func wildcardName(name string) string {
 	return "*." + name[labelIndexes[1]:]
 }
 
+// wildcardOwnerName returns the wildcard that name was expanded from, based on an RRSIG's labels field.
+// e.g. `a.b.example.com.` with labels of 2 returns `*.example.com.`
+func wildcardOwnerName(name string, labels uint8) string {
+	labelIndexes := dns.Split(name)
+	if int(labels) >= len(labelIndexes) {
+		return dns.CanonicalName(name)
+	}
+	if labels == 0 {
+		return "*."
+	}
+	return dns.CanonicalName("*." + name[labelIndexes[len(labelIndexes)-int(labels)]:])
+}
+
 func namesEqual(s1, s2 string) bool {
 	return dns.CanonicalName(s1) == dns.CanonicalName(s2)
 }
@@ -75,3 +117,12 @@ func getOwnerNameFromSet(rr []dns.RR) (string, bool) {
 	}
 	return "", false
 }
+
+// validationTime returns the time signatures' validity periods should be checked against; the CtxValidationTime
+// set on ctx, if there is one, otherwise now.
+func validationTime(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(CtxValidationTime).(time.Time); ok && !t.IsZero() {
+		return t
+	}
+	return time.Now()
+}
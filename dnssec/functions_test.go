@@ -116,3 +116,42 @@ func TestFunctions_WildcardName(t *testing.T) {
 	}
 
 }
+
+func TestFunctions_WildcardOwnerName(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   uint8
+		expected string
+	}{
+		{"test.example.com.", 2, "*.example.com."},
+		{"a.b.Example.com.", 2, "*.example.com."},
+		{"a.b.example.com.", 3, "*.b.example.com."},
+		{"a.b.example.com.", 0, "*."},
+		{"test.example.com.", 3, "test.example.com."}, // Not actually expanded from a wildcard.
+	}
+
+	for _, test := range tests {
+		if s := wildcardOwnerName(test.name, test.labels); s != test.expected {
+			t.Errorf("we expected '%s' but got '%s'", test.expected, s)
+		}
+	}
+}
+
+func TestQuestionRRsets(t *testing.T) {
+	a := newRR("test.example.com. 3600 IN A 192.0.2.53")
+	cname := newRR("www.example.com. 3600 IN CNAME test.example.com.")
+	txt := newRR("test.example.com. 3600 IN TXT \"unrelated\"")
+	sigA := &dns.RRSIG{Hdr: dns.RR_Header{Name: "test.example.com.", Rrtype: dns.TypeRRSIG}, TypeCovered: dns.TypeA}
+	sigTxt := &dns.RRSIG{Hdr: dns.RR_Header{Name: "test.example.com.", Rrtype: dns.TypeRRSIG}, TypeCovered: dns.TypeTXT}
+
+	rr := []dns.RR{cname, a, sigA, txt, sigTxt}
+
+	result := QuestionRRsets(rr, dns.TypeA)
+	if len(result) != 3 || result[0] != cname || result[1] != a || result[2] != sigA {
+		t.Errorf("unexpected records returned: %v", result)
+	}
+
+	if result = QuestionRRsets(rr, dns.TypeANY); len(result) != len(rr) {
+		t.Errorf("expected all records for ANY, got %d", len(result))
+	}
+}
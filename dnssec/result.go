@@ -1,8 +1,14 @@
 package dnssec
 
-import "github.com/miekg/dns"
+import (
+	"errors"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec/doe"
+)
 
 func (a *Authenticator) Result() (AuthenticationResult, DenialOfExistenceState, error) {
+	a.bogusReason = NotBogus
+	a.insecureReason = NotInsecure
 
 	// Ensure we've processed all items in the input butter.
 	for ; a.inputBufferIdx < len(a.inputBuffer); a.inputBufferIdx++ {
@@ -27,10 +33,20 @@ func (a *Authenticator) Result() (AuthenticationResult, DenialOfExistenceState,
 
 	for _, r := range a.results {
 		if r.state == Bogus {
+			a.bogusReason = bogusReasonFromError(r.err)
 			return Bogus, NotFound, r.err
 		}
 	}
 
+	//-----------------------------------------------------------
+	// If we were unable to fetch what we needed to validate any result, then Indeterminate.
+
+	for _, r := range a.results {
+		if r.state == Indeterminate {
+			return Indeterminate, NotFound, r.err
+		}
+	}
+
 	//-----------------------------------------------------------
 	// If the chain moved from Secure to Insecure,
 	// there must be Denial of Existence on the DS records, otherwise Bogus.
@@ -42,13 +58,21 @@ func (a *Authenticator) Result() (AuthenticationResult, DenialOfExistenceState,
 
 		if i == 0 {
 			// If the first result was not secure, we might as well give up now.
+			if current.state == Insecure {
+				a.insecureReason = InsecureUnsigned
+			}
 			return current.state, current.denialOfExistence, current.err
 		}
 
 		previous := a.results[i-1]
 
 		switch previous.denialOfExistence {
-		case Nsec3OptOut, NsecMissingDS, Nsec3MissingDS:
+		case Nsec3OptOut:
+			a.insecureReason = InsecureOptOut
+			return Insecure, previous.denialOfExistence, current.err
+
+		case NsecMissingDS, Nsec3MissingDS:
+			a.insecureReason = InsecureMissingDS
 			return Insecure, previous.denialOfExistence, current.err
 
 		case NsecNoData, Nsec3NoData:
@@ -57,6 +81,7 @@ func (a *Authenticator) Result() (AuthenticationResult, DenialOfExistenceState,
 			// This is only valid if we'd specifically queried for the DS records we needed.
 			// i.e. The Question we have the DOE for should match the zone apex for the current result's zone.
 			if previousQ.Qtype == dns.TypeDS && dns.CanonicalName(previousQ.Name) == dns.CanonicalName(current.zone.Name()) {
+				a.insecureReason = InsecureMissingDS
 				return Insecure, previous.denialOfExistence, current.err
 			}
 
@@ -64,6 +89,20 @@ func (a *Authenticator) Result() (AuthenticationResult, DenialOfExistenceState,
 			// if we've been delegated to its ancestor.
 		}
 
+		// If the zone is declared as unsigned by design, the break in the chain is expected.
+		if current.state == Insecure && current.zone != nil && insecureByDesign(current.zone.Name()) {
+			a.insecureReason = InsecureByDesign
+			return Insecure, previous.denialOfExistence, current.err
+		}
+
+		// If we support none of the algorithms in the zone's DS records, the chain is intact; we're just unable to
+		// follow it.
+		if current.state == Insecure && errors.Is(current.err, ErrUnsupportedAlgorithm) {
+			a.insecureReason = InsecureUnsupportedAlgorithm
+			return Insecure, previous.denialOfExistence, current.err
+		}
+
+		a.bogusReason = BogusChainBroken
 		return Bogus, previous.denialOfExistence, current.err
 	}
 
@@ -74,15 +113,20 @@ func (a *Authenticator) Result() (AuthenticationResult, DenialOfExistenceState,
 
 	if last.state != Secure {
 		// TODO: check if this can ever be called. I suspect not.
+		if last.state == Insecure {
+			a.insecureReason = InsecureOther
+		}
 		return last.state, last.denialOfExistence, last.err
 	}
 
 	switch last.denialOfExistence {
 	case Nsec3OptOut:
+		a.insecureReason = InsecureOptOut
 		return Insecure, last.denialOfExistence, last.err
 	case NsecNxDomain, Nsec3NxDomain, NsecNoData, Nsec3NoData:
 		return Secure, last.denialOfExistence, last.err
 	default:
+		a.bogusReason = BogusFailsafe
 		return Bogus, last.denialOfExistence, last.err
 	case NotFound, NsecWildcard, Nsec3Wildcard:
 		// We carry on...
@@ -93,6 +137,7 @@ func (a *Authenticator) Result() (AuthenticationResult, DenialOfExistenceState,
 
 	// We should see no SOA in the authority section.
 	if recordsOfTypeExist(last.msg.Ns, dns.TypeSOA) {
+		a.bogusReason = BogusDoeMissing
 		return Bogus, last.denialOfExistence, last.err
 	}
 
@@ -106,5 +151,64 @@ func (a *Authenticator) Result() (AuthenticationResult, DenialOfExistenceState,
 	}
 
 	// We default to worse case.
+	a.bogusReason = BogusAnswerMissing
 	return Bogus, last.denialOfExistence, last.err
 }
+
+// InsecureReason returns the reason the last call to Result() returned Insecure. NotInsecure if it didn't.
+func (a *Authenticator) InsecureReason() InsecureReason {
+	return a.insecureReason
+}
+
+// BogusReason returns the reason the last call to Result() returned Bogus. NotBogus if it didn't.
+func (a *Authenticator) BogusReason() BogusReason {
+	return a.bogusReason
+}
+
+// Wildcard returns the wildcard owner name (e.g. `*.example.com.`) from which the final answer was synthesised.
+// The second return value is false if the answer was not synthesised from a (verified) wildcard.
+// Should only be called after Result().
+func (a *Authenticator) Wildcard() (string, bool) {
+	if len(a.results) == 0 {
+		return "", false
+	}
+
+	last := a.results[len(a.results)-1]
+	for _, sig := range last.answer {
+		if sig.wildcard && sig.verified {
+			return wildcardOwnerName(sig.name, sig.rrsig.Labels), true
+		}
+	}
+
+	return "", false
+}
+
+// DenialOfExistenceExplanation returns the NSEC or NSEC3 records that were used when attempting to prove the final
+// response's denial of existence. Nil if the final response contained no such records.
+// Should only be called after Result().
+func (a *Authenticator) DenialOfExistenceExplanation() *doe.Explanation {
+	if len(a.results) == 0 {
+		return nil
+	}
+	return a.results[len(a.results)-1].explanation
+}
+
+// DenialOfExistenceRecords returns the verified NSEC or NSEC3 records, with their RRSIGs, from the final response's
+// authority section. These are the records the denial of existence was proven with.
+// Should only be called after Result().
+func (a *Authenticator) DenialOfExistenceRecords() []dns.RR {
+	if len(a.results) == 0 {
+		return nil
+	}
+
+	records := make([]dns.RR, 0)
+	for _, sig := range a.results[len(a.results)-1].authority {
+		if !sig.verified || (sig.rtype != dns.TypeNSEC && sig.rtype != dns.TypeNSEC3) {
+			continue
+		}
+		records = append(records, sig.rrset...)
+		records = append(records, sig.rrsig)
+	}
+
+	return dns.Dedup(records, nil)
+}
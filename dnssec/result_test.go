@@ -3,8 +3,11 @@ package dnssec
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/miekg/dns"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestResult_NoRecords(t *testing.T) {
@@ -352,6 +355,9 @@ func TestResult_LastHasSOA(t *testing.T) {
 	if state != Bogus {
 		t.Error("unexpected state")
 	}
+	if a.BogusReason() != BogusDoeMissing {
+		t.Errorf("unexpected bogus reason: %s", a.BogusReason())
+	}
 }
 
 func TestResult_LastHasMatchingNameAndType(t *testing.T) {
@@ -453,3 +459,323 @@ func TestResult_InvalidIfTypeDoesNotMatch(t *testing.T) {
 		t.Error("unexpected state")
 	}
 }
+
+func TestResult_Wildcard(t *testing.T) {
+
+	// An answer synthesised from a wildcard should report the wildcard's owner name.
+
+	rrset := []dns.RR{newRR("*.example.com. 3600 IN A 192.0.2.53")}
+	key := testEcKey()
+
+	rrset = append(rrset, key.sign(rrset, 0, 0))
+
+	// After it's signed, we'll replace `*` with a 'real' label.
+	rrset[0].Header().Name = "a.test.example.com."
+	rrset[1].Header().Name = "a.test.example.com."
+
+	set, err := authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	if err != nil {
+		t.Error(err)
+	}
+
+	a := NewAuth(context.Background(), dns.Question{Name: "a.test.example.com.", Qtype: dns.TypeA})
+	a.results = append(a.results, &result{state: Secure, answer: set})
+
+	name, ok := a.Wildcard()
+	if !ok {
+		t.Error("expected the answer to be from a wildcard")
+	}
+	if name != "*.example.com." {
+		t.Errorf("we expected '*.example.com.' but got '%s'", name)
+	}
+}
+
+func TestResult_NotWildcard(t *testing.T) {
+	rrset := []dns.RR{newRR("test.example.com. 3600 IN A 192.0.2.53")}
+	key := testEcKey()
+
+	rrset = append(rrset, key.sign(rrset, 0, 0))
+
+	set, err := authenticate(time.Now(), zoneName, rrset, []*dns.DNSKEY{key.key}, answerSection)
+	if err != nil {
+		t.Error(err)
+	}
+
+	a := NewAuth(context.Background(), dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+
+	// No results at all.
+	if _, ok := a.Wildcard(); ok {
+		t.Error("expected the answer to not be from a wildcard")
+	}
+
+	a.results = append(a.results, &result{state: Secure, answer: set})
+
+	if name, ok := a.Wildcard(); ok || name != "" {
+		t.Errorf("expected the answer to not be from a wildcard, but got '%s'", name)
+	}
+}
+
+func TestResult_InsecureByDesign(t *testing.T) {
+
+	// A zone declared as unsigned by design should be Insecure, even though no DOE was found for its DS records.
+
+	defer func() { InsecureZones = nil }()
+
+	for _, qname := range []string{"corp.", "test.corp."} {
+		newAuth := func() *Authenticator {
+			a := NewAuth(context.Background(), dns.Question{Name: qname, Qtype: dns.TypeA})
+			a.results = append(a.results, &result{state: Secure, zone: &mockZone{name: "."}})
+			a.results = append(a.results, &result{state: Insecure, zone: &mockZone{name: qname}})
+			return a
+		}
+
+		// Without the policy, we expect Bogus.
+		InsecureZones = nil
+		if state, _, _ := newAuth().Result(); state != Bogus {
+			t.Errorf("unexpected state for [%s]. expected %v, got %v", qname, Bogus, state)
+		}
+
+		InsecureZones = []string{"corp."}
+		if state, _, _ := newAuth().Result(); state != Insecure {
+			t.Errorf("unexpected state for [%s]. expected %v, got %v", qname, Insecure, state)
+		}
+
+		// A different zone is not affected.
+		InsecureZones = []string{"internal."}
+		if state, _, _ := newAuth().Result(); state != Bogus {
+			t.Errorf("unexpected state for [%s]. expected %v, got %v", qname, Bogus, state)
+		}
+	}
+}
+
+func TestResult_UnsupportedAlgorithm(t *testing.T) {
+
+	// A zone whose DS records only use algorithms we don't support is Insecure, even though no DOE was found for
+	// its DS records.
+
+	a := NewAuth(context.Background(), dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+	a.results = append(a.results, &result{state: Secure, zone: &mockZone{name: "."}})
+	a.results = append(a.results, &result{state: Insecure, zone: &mockZone{name: zoneName}, err: ErrUnsupportedAlgorithm})
+
+	state, _, err := a.Result()
+	if state != Insecure {
+		t.Errorf("unexpected state. expected %v, got %v", Insecure, state)
+	}
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("unexpected error. expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+func TestResult_BogusReason(t *testing.T) {
+
+	// The reason is taken from the error that caused the Bogus result.
+
+	tests := []struct {
+		err      error
+		expected BogusReason
+	}{
+		{fmt.Errorf("%w: for key tag 1234", ErrVerifyFailed), BogusSignatureInvalid},
+		{fmt.Errorf("%w: expired", ErrInvalidTime), BogusSignatureInvalid},
+		{fmt.Errorf("%w: for test.example.com.", ErrBogusDoeRecordsNotFound), BogusDoeMissing},
+		{ErrBogusWildcardDoeNotFound, BogusDoeMissing},
+		{ErrUnexpectedSignatureCount, BogusSignatureMissing},
+		{ErrMultipleWildcardSignatures, BogusMultipleWildcards},
+		{ErrFailsafeResponse, BogusFailsafe},
+		{errors.New("something else"), BogusOther},
+	}
+
+	for _, test := range tests {
+		a := NewAuth(context.Background(), dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+		a.results = append(a.results, &result{state: Secure})
+		a.results = append(a.results, &result{state: Bogus, err: test.err})
+
+		state, _, _ := a.Result()
+		if state != Bogus {
+			t.Error("unexpected state")
+		}
+		if a.BogusReason() != test.expected {
+			t.Errorf("expected bogus reason %s for [%v], got %s", test.expected, test.err, a.BogusReason())
+		}
+	}
+
+	//---
+
+	// A break in the chain of trust.
+
+	a := NewAuth(context.Background(), dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+	a.results = append(a.results, &result{state: Secure})
+	a.results = append(a.results, &result{state: Insecure})
+
+	if state, _, _ := a.Result(); state != Bogus {
+		t.Error("unexpected state")
+	}
+	if a.BogusReason() != BogusChainBroken {
+		t.Errorf("unexpected bogus reason: %s", a.BogusReason())
+	}
+
+	//---
+
+	// And the reason is reset if the result is not Bogus.
+
+	a.results = []*result{{state: Insecure}}
+	if state, _, _ := a.Result(); state != Insecure {
+		t.Error("unexpected state")
+	}
+	if a.BogusReason() != NotBogus {
+		t.Errorf("unexpected bogus reason: %s", a.BogusReason())
+	}
+}
+
+func TestResult_InsecureReason(t *testing.T) {
+
+	// An opt-out child, a child proven to be unsigned, and a fully unsigned chain are each Insecure for a different reason.
+
+	tests := []struct {
+		name     string
+		results  []*result
+		expected InsecureReason
+	}{
+		{
+			name: "opt-out child",
+			results: []*result{
+				{state: Secure},
+				{state: Secure, denialOfExistence: Nsec3OptOut},
+				{state: Insecure},
+			},
+			expected: InsecureOptOut,
+		},
+		{
+			name: "opt-out at the last zone",
+			results: []*result{
+				{state: Secure},
+				{state: Secure, denialOfExistence: Nsec3OptOut},
+			},
+			expected: InsecureOptOut,
+		},
+		{
+			name: "child without ds",
+			results: []*result{
+				{state: Secure},
+				{state: Secure, denialOfExistence: Nsec3MissingDS},
+				{state: Insecure},
+			},
+			expected: InsecureMissingDS,
+		},
+		{
+			name: "fully unsigned chain",
+			results: []*result{
+				{state: Insecure},
+				{state: Insecure},
+			},
+			expected: InsecureUnsigned,
+		},
+		{
+			name: "unsupported algorithm",
+			results: []*result{
+				{state: Secure},
+				{state: Insecure, err: ErrUnsupportedAlgorithm},
+			},
+			expected: InsecureUnsupportedAlgorithm,
+		},
+	}
+
+	for _, test := range tests {
+		a := NewAuth(context.Background(), dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+		a.results = test.results
+
+		if state, _, _ := a.Result(); state != Insecure {
+			t.Errorf("unexpected state for %s", test.name)
+		}
+		if a.InsecureReason() != test.expected {
+			t.Errorf("expected insecure reason %s for %s, got %s", test.expected, test.name, a.InsecureReason())
+		}
+	}
+
+	//---
+
+	// The reason is reset if the result is not Insecure.
+
+	a := NewAuth(context.Background(), dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+	a.results = []*result{{state: Insecure}}
+	a.Result()
+	if a.InsecureReason() != InsecureUnsigned {
+		t.Errorf("unexpected insecure reason: %s", a.InsecureReason())
+	}
+
+	a.results = []*result{{state: Secure}, {state: Secure, denialOfExistence: Nsec3NxDomain}}
+	if state, _, _ := a.Result(); state != Secure {
+		t.Error("unexpected state")
+	}
+	if a.InsecureReason() != NotInsecure {
+		t.Errorf("unexpected insecure reason: %s", a.InsecureReason())
+	}
+}
+
+func TestResult_Indeterminate(t *testing.T) {
+
+	// If any result could not be determined, then Indeterminate. Unless another result was Bogus.
+
+	a := NewAuth(context.Background(), dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+
+	a.results = append(a.results, &result{state: Secure})
+	a.results = append(a.results, &result{state: Indeterminate, err: ErrKeysFetchFailed})
+	a.results = append(a.results, &result{state: Secure})
+
+	state, _, err := a.Result()
+	if !errors.Is(err, ErrKeysFetchFailed) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if state != Indeterminate {
+		t.Errorf("unexpected state: %s", state)
+	}
+
+	a.results = append(a.results, &result{state: Bogus})
+
+	if state, _, _ = a.Result(); state != Bogus {
+		t.Errorf("unexpected state: %s", state)
+	}
+}
+
+func TestResult_DenialOfExistenceRecords(t *testing.T) {
+
+	// For an NXDOMAIN, the verified NSEC3 records, and their RRSIGs, should be returned. Nothing else.
+
+	key := testEcKey()
+
+	soa := []dns.RR{newRR("example.com. 3600 IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 300")}
+	nsec3a := []dns.RR{newRR("111NOTAB271SNH4EA8ESDKBF1C2QINH1.example.com. 3600 IN NSEC3 1 0 2 ABCDEF 211NOTAB271SNH4EA8ESDKBF1C2QINH1 SOA RRSIG")}
+	nsec3b := []dns.RR{newRR("K72QU4B0R4USH96QN17VTCD8395QILEQ.example.com. 3600 IN NSEC3 1 0 2 ABCDEF M72QU4B0R4USH96QN17VTCD8395QILEQ A RRSIG")}
+
+	authority := slices.Concat(
+		soa, []dns.RR{key.sign(soa, 0, 0)},
+		nsec3a, []dns.RR{key.sign(nsec3a, 0, 0)},
+		nsec3b, []dns.RR{key.sign(nsec3b, 0, 0)},
+	)
+
+	set, err := authenticate(time.Now(), zoneName, authority, []*dns.DNSKEY{key.key}, authoritySection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAuth(context.Background(), dns.Question{Name: "test.example.com.", Qtype: dns.TypeA})
+
+	if records := a.DenialOfExistenceRecords(); records != nil {
+		t.Errorf("expected no records before any results. got %v", records)
+	}
+
+	a.results = append(a.results, &result{state: Secure, authority: set, denialOfExistence: Nsec3NxDomain})
+
+	records := a.DenialOfExistenceRecords()
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records. got %d", len(records))
+	}
+	if len(extractRecords[*dns.NSEC3](records)) != 2 {
+		t.Error("expected both nsec3 records")
+	}
+	if rrsigs := extractRecords[*dns.RRSIG](records); len(rrsigs) != 2 || rrsigs[0].TypeCovered != dns.TypeNSEC3 || rrsigs[1].TypeCovered != dns.TypeNSEC3 {
+		t.Error("expected the rrsigs covering the nsec3 records")
+	}
+	if recordsOfTypeExist(records, dns.TypeSOA) {
+		t.Error("the soa should not be included")
+	}
+}
@@ -15,6 +15,16 @@ func (ss signatures) filterOnType(rtype uint16) signatures {
 	return set
 }
 
+func (ss signatures) filterOnNameAndType(name string, rtype uint16) signatures {
+	set := make(signatures, 0, len(ss))
+	for _, sig := range ss {
+		if sig.rtype == rtype && namesEqual(sig.name, name) {
+			set = append(set, sig)
+		}
+	}
+	return set
+}
+
 func (ss signatures) countNameTypeCombinations() int {
 	type combination struct {
 		name   string
@@ -23,13 +33,34 @@ func (ss signatures) countNameTypeCombinations() int {
 	combinations := make(map[combination]bool, len(ss))
 	for _, sig := range ss {
 		combinations[combination{
-			name:   sig.name,
+			name:   dns.CanonicalName(sig.name),
 			rrtype: sig.rtype,
 		}] = true
 	}
 	return len(combinations)
 }
 
+// withoutUnsupported returns the signatures with those using an unsupported algorithm removed. Also returns true if
+// any rrset was only signed with unsupported algorithms, and so is left without signatures.
+func (ss signatures) withoutUnsupported() (signatures, bool) {
+	set := make(signatures, 0, len(ss))
+	for _, sig := range ss {
+		if !sig.unsupported {
+			set = append(set, sig)
+		}
+	}
+
+	unverifiable := false
+	for _, sig := range ss {
+		if sig.unsupported && len(set.filterOnNameAndType(sig.name, sig.rtype)) == 0 {
+			unverifiable = true
+			break
+		}
+	}
+
+	return set, unverifiable
+}
+
 // Verify calls one of two local policy strategies for determining if the response is verified.
 func (ss signatures) Verify() error {
 	if RequireAllSignaturesValid {
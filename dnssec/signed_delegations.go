@@ -0,0 +1,32 @@
+package dnssec
+
+import (
+	"github.com/miekg/dns"
+	"time"
+)
+
+// signedDelegationCache holds the names for which we've validated DS records as Secure, until the records' TTL expires.
+type signedDelegationCache struct {
+	*expiringSet[string]
+}
+
+var signedDelegations = &signedDelegationCache{newExpiringSet[string]()}
+
+// known reports if Secure DS records have been seen for name, and they've not since expired.
+func (c *signedDelegationCache) known(name string) bool {
+	return c.contains(dns.CanonicalName(name))
+}
+
+// add records that dsRecords were validated as Secure. They're held for the lowest of their TTLs.
+func (c *signedDelegationCache) add(dsRecords []*dns.DS) {
+	if !CheckOptOutAgainstKnownDS || len(dsRecords) == 0 {
+		return
+	}
+
+	ttl := dsRecords[0].Hdr.Ttl
+	for _, ds := range dsRecords {
+		ttl = min(ttl, ds.Hdr.Ttl)
+	}
+	name := dns.CanonicalName(dsRecords[0].Hdr.Name)
+	c.expiringSet.add(name, time.Now().Add(time.Duration(ttl)*time.Second), SignedDelegationsMaxEntries)
+}
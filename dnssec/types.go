@@ -3,6 +3,8 @@ package dnssec
 import (
 	"context"
 	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec/doe"
+	"time"
 )
 
 type Zone interface {
@@ -19,6 +21,12 @@ type Authenticator struct {
 
 	results []*result
 
+	bogusReason    BogusReason
+	insecureReason InsecureReason
+
+	// The names of zones whose DS records were found to be missing from the chain.
+	missingDS []string
+
 	verify func(ctx context.Context, zone Zone, msg *dns.Msg, dsRecordsFromParent []*dns.DS) (AuthenticationResult, *result, error)
 }
 
@@ -40,8 +48,18 @@ type result struct {
 
 	dsRecords []*dns.DS
 
+	// The DS records, from the parent (or the trust anchors, for the root), that matched a key which verifiably signed
+	// the zone's DNSKEY rrset.
+	anchors []*dns.DS
+
 	state             AuthenticationResult
 	denialOfExistence DenialOfExistenceState
+
+	// The records used when attempting to prove the denial of existence. Nil if no NSEC or NSEC3 records were seen.
+	explanation *doe.Explanation
+
+	// How long verifying the response took, including fetching the zone's DNSKEY records.
+	duration time.Duration
 }
 
 type signatures []*signature
@@ -59,6 +77,12 @@ type signature struct {
 
 	wildcard bool
 
+	// The rrsig, and its key, use an algorithm we're unable to verify.
+	unsupported bool
+
+	// The number of candidate keys that shared the rrsig's algorithm and key tag, when more than one.
+	keyTagCollisions int
+
 	verified bool
 	err      error
 
@@ -0,0 +1,71 @@
+package dnssec
+
+import (
+	"crypto/sha256"
+	"github.com/miekg/dns"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var verificationCacheHits atomic.Uint64
+
+// VerificationCacheHits returns the number of signature verifications skipped as the same RRset, RRSIG and key had
+// already been verified. See VerificationCacheMinRRsetSize.
+func VerificationCacheHits() uint64 {
+	return verificationCacheHits.Load()
+}
+
+// verificationCacheKey is a hash of the RRSIG, the key, and the RRset it covers.
+type verificationCacheKey [sha256.Size]byte
+
+// newVerificationCacheKey hashes the rrsig, key and rrset. TTLs are ignored, as records served from a cache will have
+// had theirs decremented; the RRSIG covers the original TTL. Owner names are compared case-insensitively, and the
+// order of the records in the rrset does not matter.
+func newVerificationCacheKey(rrsig *dns.RRSIG, key *dns.DNSKEY, rrset []dns.RR) verificationCacheKey {
+	normalise := func(rr dns.RR) string {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		rr.Header().Name = dns.CanonicalName(rr.Header().Name)
+		return rr.String()
+	}
+
+	records := make([]string, len(rrset))
+	for i, rr := range rrset {
+		records[i] = normalise(rr)
+	}
+	slices.Sort(records)
+
+	h := sha256.New()
+	h.Write([]byte(normalise(rrsig)))
+	h.Write([]byte{0})
+	h.Write([]byte(normalise(key)))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(records, "\n")))
+
+	var k verificationCacheKey
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// verificationCache holds the signatures that have been verified, until their RRSIG expires.
+type verificationCache struct {
+	*expiringSet[verificationCacheKey]
+}
+
+var verifications = &verificationCache{newExpiringSet[verificationCacheKey]()}
+
+// seen reports if the signature identified by key has been verified, and its RRSIG has not since expired.
+func (c *verificationCache) seen(key verificationCacheKey) bool {
+	if !c.contains(key) {
+		return false
+	}
+	verificationCacheHits.Add(1)
+	return true
+}
+
+// add records that the signature identified by key was verified. It's held until rrsig expires.
+func (c *verificationCache) add(key verificationCacheKey, rrsig *dns.RRSIG) {
+	c.expiringSet.add(key, time.Unix(int64(rrsig.Expiration), 0), VerificationCacheMaxEntries)
+}
@@ -0,0 +1,89 @@
+package dnssec
+
+import (
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate_VerificationCache(t *testing.T) {
+	defer func(size int) { VerificationCacheMinRRsetSize = size }(VerificationCacheMinRRsetSize)
+	VerificationCacheMinRRsetSize = 2
+
+	key := testEcKey()
+
+	rrset := []dns.RR{
+		newRR("test.example.com. 300 IN A 192.0.2.1"),
+		newRR("test.example.com. 300 IN A 192.0.2.2"),
+	}
+	rrsig := key.sign(rrset, 0, 0)
+
+	verify := func(records ...dns.RR) {
+		t.Helper()
+		sigs, err := authenticate(time.Now(), zoneName, records, []*dns.DNSKEY{key.key}, answerSection)
+		require.NoError(t, err)
+		require.Len(t, sigs, 1)
+		require.NoError(t, sigs.Verify())
+	}
+
+	// The first time, the signature is verified in full.
+	hits := VerificationCacheHits()
+	verify(rrset[0], rrset[1], rrsig)
+	assert.Equal(t, hits, VerificationCacheHits())
+
+	// The second time the same signed data is seen, the verification is skipped.
+	verify(rrset[0], rrset[1], rrsig)
+	assert.Equal(t, hits+1, VerificationCacheHits())
+
+	// Including if the TTLs have been decremented (e.g. served from a cache), or the order has changed.
+	served := []dns.RR{dns.Copy(rrset[1]), dns.Copy(rrset[0])}
+	served[0].Header().Ttl = 100
+	served[1].Header().Ttl = 100
+	verify(served[0], served[1], rrsig)
+	assert.Equal(t, hits+2, VerificationCacheHits())
+
+	// A new rrsig is verified in full.
+	verify(rrset[0], rrset[1], key.sign(rrset, 0, 0))
+	assert.Equal(t, hits+2, VerificationCacheHits())
+
+	// As is a change to the records; and the change invalidates the signature.
+	changed := dns.Copy(rrset[1])
+	changed.(*dns.A).A = rrset[0].(*dns.A).A
+	sigs, err := authenticate(time.Now(), zoneName, []dns.RR{rrset[0], changed, rrsig}, []*dns.DNSKEY{key.key}, answerSection)
+	require.NoError(t, err)
+	assert.ErrorIs(t, sigs.Verify(), ErrVerifyFailed)
+	assert.Equal(t, hits+2, VerificationCacheHits())
+
+	// Smaller rrsets are not cached.
+	small := []dns.RR{newRR("small.example.com. 300 IN A 192.0.2.1")}
+	smallSig := key.sign(small, 0, 0)
+	verify(small[0], smallSig)
+	verify(small[0], smallSig)
+	assert.Equal(t, hits+2, VerificationCacheHits())
+}
+
+func TestVerificationCache_Expiry(t *testing.T) {
+	defer func(max int) { VerificationCacheMaxEntries = max }(VerificationCacheMaxEntries)
+	VerificationCacheMaxEntries = 2
+
+	key := testEcKey()
+	rrset := []dns.RR{newRR("test.example.com. 300 IN A 192.0.2.1")}
+
+	c := &verificationCache{newExpiringSet[verificationCacheKey]()}
+
+	// An rrsig that's expired is not seen.
+	expired := key.sign(rrset, 0, time.Now().Add(-time.Second).Unix())
+	expiredKey := newVerificationCacheKey(expired, key.key, rrset)
+	c.add(expiredKey, expired)
+	assert.False(t, c.seen(expiredKey))
+	assert.Empty(t, c.entries)
+
+	// The number of entries is bounded.
+	for i := 0; i < 5; i++ {
+		rrsig := key.sign(rrset, 0, 0)
+		c.add(newVerificationCacheKey(rrsig, key.key, rrset), rrsig)
+		assert.LessOrEqual(t, len(c.entries), 2)
+	}
+}
@@ -2,12 +2,13 @@ package dnssec
 
 import (
 	"context"
+	"fmt"
 	"github.com/miekg/dns"
 )
 
 func (v verifier) verify(ctx context.Context, zone Zone, msg *dns.Msg, dsRecordsFromParent []*dns.DS) (AuthenticationResult, *result, error) {
 	r := &result{
-		name: zone.Name(),
+		name: dns.CanonicalName(zone.Name()),
 		zone: zone,
 		msg:  msg,
 	}
@@ -27,7 +28,8 @@ func (v verifier) verify(ctx context.Context, zone Zone, msg *dns.Msg, dsRecords
 
 	keys, err := zone.GetDNSKEYRecords()
 	if err != nil {
-		return Bogus, r, err
+		// We were unable to get the keys, so cannot say either way if the response is valid.
+		return Indeterminate, r, fmt.Errorf("%w [%s]: %w", ErrKeysFetchFailed, zone.Name(), err)
 	}
 
 	status, err = v.verifyDNSKEYs(ctx, r, keys, dsRecordsFromParent)
@@ -47,12 +49,18 @@ func (v verifier) verify(ctx context.Context, zone Zone, msg *dns.Msg, dsRecords
 	// A Delegating Response has no Answers, no SOA, and at least one NS record in the Authority section.
 	if !soaFoundInAuthority && len(r.msg.Answer) == 0 && recordsOfTypeExist(r.msg.Ns, dns.TypeNS) {
 		status, err = v.validateDelegatingResponse(ctx, r)
+		if status == Secure {
+			signedDelegations.add(r.dsRecords)
+		}
 		return status, r, err
 	}
 
 	// A positive response has at least one answer, and SOA in the Authority section.
 	if !soaFoundInAuthority && len(r.msg.Answer) > 0 {
 		status, err = v.validatePositiveResponse(ctx, r)
+		if status == Secure {
+			signedDelegations.add(r.dsRecords)
+		}
 		return status, r, err
 	}
 
@@ -67,6 +67,10 @@ func validateDelegatingResponse(ctx context.Context, r *result) (AuthenticationR
 		}
 
 		if optedOut, _, _, _ := nsec3.PerformClosestEncloserProof(delegationName); optedOut {
+			if CheckOptOutAgainstKnownDS && signedDelegations.known(delegationName) {
+				return Bogus, fmt.Errorf("%w: [%s]", ErrOptOutDeniesKnownDS, delegationName)
+			}
+
 			// We have found an opt-out, thus we will conclude any children are insecure.
 			// (Although this result itself is Secure).
 			r.denialOfExistence = Nsec3OptOut
@@ -74,6 +78,12 @@ func validateDelegatingResponse(ctx context.Context, r *result) (AuthenticationR
 		}
 	}
 
+	// The lack of DS records is expected, so we don't require DOE.
+	if insecureByDesign(delegationName) {
+		Debug(fmt.Sprintf("delegation to [%s] is insecure by design", delegationName))
+		return Secure, nil
+	}
+
 	// No DOE exists when expected.
 	return Bogus, ErrBogusDoeRecordsNotFound
 }
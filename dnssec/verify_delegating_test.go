@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"slices"
 	"testing"
+	"time"
 )
 
 func TestVerify_DelegatingResponse(t *testing.T) {
@@ -291,3 +292,97 @@ func TestVerify_DelegatingResponseNSEC3Optout(t *testing.T) {
 	assert.Equal(t, Secure, state)
 	assert.Equal(t, Nsec3OptOut, r.denialOfExistence)
 }
+
+func TestVerify_DelegatingResponseNSEC3OptoutKnownDS(t *testing.T) {
+
+	// An opt-out span must not be used to deny a delegation we've already validated DS records for.
+
+	defer func(c *signedDelegationCache) {
+		CheckOptOutAgainstKnownDS = DefaultCheckOptOutAgainstKnownDS
+		signedDelegations = c
+	}(signedDelegations)
+	signedDelegations = &signedDelegationCache{newExpiringSet[string]()}
+
+	ctx := context.Background()
+	newResult := func() *result {
+		return &result{
+			zone: &mockZone{name: zoneName},
+			msg: &dns.Msg{
+				Ns: []dns.RR{
+					newRR("test.example.com. 3600 IN NS ns1.example.com."),
+				},
+			},
+			authority: signatures{{
+				rtype: dns.TypeNSEC3,
+				rrset: []dns.RR{
+					// Matches `example.com.`, (Closest Encloser)
+					newRR("111NOTAB271SNH4EA8ESDKBF1C2QINH1.example.com. 3600 IN NSEC3 1 0 2 ABCDEF 211NOTAB271SNH4EA8ESDKBF1C2QINH1 NS SOA RRSIG"),
+					// Covers `test.example.com.`, with the opt-out flag set. (Next Closer Name)
+					newRR("K72QU4B0R4USH96QN17VTCD8395QILEQ.example.com. 3600 IN NSEC3 1 1 2 ABCDEF M72QU4B0R4USH96QN17VTCD8395QILEQ A RRSIG"),
+				},
+			}},
+		}
+	}
+
+	ds := newRR("Test.Example.com. 3600 IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A86764247C").(*dns.DS)
+
+	// Whilst disabled, nothing is remembered.
+	signedDelegations.add([]*dns.DS{ds})
+	assert.False(t, signedDelegations.known("test.example.com."))
+
+	CheckOptOutAgainstKnownDS = true
+
+	// With no DS known for the name, opt-out is accepted.
+	r := newResult()
+	state, err := validateDelegatingResponse(ctx, r)
+	assert.NoError(t, err)
+	assert.Equal(t, Secure, state)
+	assert.Equal(t, Nsec3OptOut, r.denialOfExistence)
+
+	// Once a DS is known, the same response is Bogus.
+	signedDelegations.add([]*dns.DS{ds})
+	assert.True(t, signedDelegations.known("test.example.com."))
+
+	r = newResult()
+	state, err = validateDelegatingResponse(ctx, r)
+	assert.ErrorIs(t, err, ErrOptOutDeniesKnownDS)
+	assert.Equal(t, Bogus, state)
+	assert.Equal(t, BogusChainBroken, bogusReasonFromError(err))
+
+	// Once the DS has expired, opt-out is accepted again.
+	signedDelegations.entries["test.example.com."] = time.Now().Add(-time.Second)
+	state, err = validateDelegatingResponse(ctx, newResult())
+	assert.NoError(t, err)
+	assert.Equal(t, Secure, state)
+}
+
+func TestVerify_DelegatingResponseInsecureByDesign(t *testing.T) {
+
+	// A delegation to a zone declared as unsigned by design doesn't need DS records, nor DOE.
+
+	defer func() { InsecureZones = nil }()
+
+	newResult := func() *result {
+		return &result{
+			zone: &mockZone{name: "."},
+			msg: &dns.Msg{
+				Ns: []dns.RR{
+					newRR("corp. 3600 IN NS ns1.corp."),
+				},
+			},
+		}
+	}
+
+	state, err := validateDelegatingResponse(context.Background(), newResult())
+	assert.ErrorIs(t, err, ErrBogusDoeRecordsNotFound)
+	assert.Equal(t, Bogus, state)
+
+	InsecureZones = []string{"corp."}
+
+	r := newResult()
+	state, err = validateDelegatingResponse(context.Background(), r)
+	assert.NoError(t, err)
+	assert.Equal(t, Secure, state)
+	assert.Empty(t, r.dsRecords)
+	assert.Equal(t, NotFound, r.denialOfExistence)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/miekg/dns"
+	"slices"
 	"strings"
 )
 
@@ -11,6 +12,9 @@ func verifyDNSKEYs(ctx context.Context, r *result, keys []dns.RR, dsRecordsFromP
 
 	zoneKeys := extractRecords[*dns.DNSKEY](keys)
 	if len(zoneKeys) == 0 {
+		if DSWithoutDNSKEYIsBogus && supportedDSExists(dsRecordsFromParent) {
+			return Bogus, fmt.Errorf("%w [%s]: %w", ErrDSWithoutMatchingDNSKEY, r.zone.Name(), ErrKeysNotFound)
+		}
 		return Insecure, ErrKeysNotFound
 	}
 
@@ -29,12 +33,22 @@ func verifyDNSKEYs(ctx context.Context, r *result, keys []dns.RR, dsRecordsFromP
 	}
 
 	if len(keySigningKeys) == 0 {
+		if DSWithoutDNSKEYIsBogus && supportedDSExists(dsRecordsFromParent) {
+			return Bogus, fmt.Errorf("%w [%s]: %w", ErrDSWithoutMatchingDNSKEY, r.zone.Name(), ErrKeySigningKeysNotFound)
+		}
 		return Insecure, ErrKeysNotFound
 	}
 
+	// If we support none of the algorithms in the parent's DS records, we've no way of authenticating the zone's
+	// keys, nor anything they sign. This is the only case in which an unsupported algorithm makes the zone Insecure.
+	// See https://datatracker.ietf.org/doc/html/rfc4035#section-5.2 and https://datatracker.ietf.org/doc/html/rfc6840#section-5.2
+	if !supportedDSExists(dsRecordsFromParent) {
+		return Insecure, fmt.Errorf("%w: no supported ds algorithm for zone [%s]", ErrUnsupportedAlgorithm, r.zone.Name())
+	}
+
 	//---
 
-	keySignatures, err := authenticate(r.zone.Name(), keys, keySigningKeys, answerSection)
+	keySignatures, err := authenticate(validationTime(ctx), r.zone.Name(), keys, keySigningKeys, answerSection)
 
 	if err != nil {
 		return Bogus, fmt.Errorf("%w: %w", ErrBogusResultFound, err)
@@ -46,5 +60,31 @@ func verifyDNSKEYs(ctx context.Context, r *result, keys []dns.RR, dsRecordsFromP
 		return Bogus, fmt.Errorf("%w: %w", ErrBogusResultFound, err)
 	}
 
+	r.anchors = verifiedDSRecords(keySignatures, dsRecordsFromParent)
+
 	return Unknown, nil
 }
+
+// verifiedDSRecords returns the DS records that match a key with a verified signature over the DNSKEY rrset.
+func verifiedDSRecords(keySignatures signatures, dsRecords []*dns.DS) []*dns.DS {
+	matched := make([]*dns.DS, 0, 1)
+	for _, d := range dsRecords {
+		for _, sig := range keySignatures {
+			k := sig.key
+			if sig.verified && k != nil && d.Algorithm == k.Algorithm && d.KeyTag == k.KeyTag() && strings.EqualFold(d.Digest, k.ToDS(d.DigestType).Digest) {
+				matched = append(matched, d)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// supportedDSExists returns true if any of the DS records use an algorithm and digest type we support. If none do, we
+// have no way of authenticating the child zone, which must be treated as Insecure.
+// See https://datatracker.ietf.org/doc/html/rfc4035#section-5.2
+func supportedDSExists(dsRecords []*dns.DS) bool {
+	return slices.ContainsFunc(dsRecords, func(ds *dns.DS) bool {
+		return slices.Contains(SupportedAlgorithms, ds.Algorithm) && slices.Contains(SupportedDigestTypes, ds.DigestType)
+	})
+}
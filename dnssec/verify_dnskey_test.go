@@ -34,7 +34,8 @@ func TestVerify_DNSKEYs(t *testing.T) {
 
 	//---
 
-	// If keys are passed in, but none of them have an associated DS record from the parent, the answer must be insecure.
+	// If keys are passed in, but none of them have an associated DS record from the parent, the answer must be bogus.
+	// The parent has told us the zone is signed.
 
 	keys = []dns.RR{k.key}
 
@@ -44,11 +45,11 @@ func TestVerify_DNSKEYs(t *testing.T) {
 	}
 
 	state, err = verifyDNSKEYs(ctx, r, keys, dsRecordsFromParent)
-	if err == nil {
-		t.Errorf("verifyDNSKEYs returned no error. expected ErrKeysNotFound")
+	if !errors.Is(err, ErrDSWithoutMatchingDNSKEY) {
+		t.Errorf("verifyDNSKEYs returned unexpected error. expected ErrDSWithoutMatchingDNSKEY, got %v", err)
 	}
-	if state != Insecure {
-		t.Errorf("verifyDNSKEYs returned incorrect state. expected %v, got %v", Insecure, state)
+	if state != Bogus {
+		t.Errorf("verifyDNSKEYs returned incorrect state. expected %v, got %v", Bogus, state)
 	}
 
 	//---
@@ -106,3 +107,53 @@ func TestVerify_DNSKEYs(t *testing.T) {
 	}
 
 }
+
+func TestVerify_DNSKEYsMissingWithParentDS(t *testing.T) {
+
+	// If the parent has DS records, but the zone has no DNSKEYs at all, the answer must be bogus.
+
+	ctx := context.Background()
+	r := &result{
+		zone: &mockZone{name: zoneName},
+	}
+
+	k := testEcKey()
+	dsRecordsFromParent := []*dns.DS{k.ds}
+
+	state, err := verifyDNSKEYs(ctx, r, []dns.RR{}, dsRecordsFromParent)
+	if !errors.Is(err, ErrDSWithoutMatchingDNSKEY) {
+		t.Errorf("verifyDNSKEYs returned unexpected error. expected ErrDSWithoutMatchingDNSKEY, got %v", err)
+	}
+	if state != Bogus {
+		t.Errorf("verifyDNSKEYs returned incorrect state. expected %v, got %v", Bogus, state)
+	}
+	if reason := bogusReasonFromError(err); reason != BogusChainBroken {
+		t.Errorf("unexpected bogus reason. expected %v, got %v", BogusChainBroken, reason)
+	}
+
+	//---
+
+	// If we support none of the DS records' algorithms, we've no way of authenticating the zone. Thus insecure.
+
+	unsupported := *k.ds
+	unsupported.Algorithm = dns.ED448
+	state, err = verifyDNSKEYs(ctx, r, []dns.RR{}, []*dns.DS{&unsupported})
+	if !errors.Is(err, ErrKeysNotFound) {
+		t.Errorf("verifyDNSKEYs returned unexpected error. expected ErrKeysNotFound, got %v", err)
+	}
+	if state != Insecure {
+		t.Errorf("verifyDNSKEYs returned incorrect state. expected %v, got %v", Insecure, state)
+	}
+
+	//---
+
+	// With the check disabled, the answer reverts to insecure.
+
+	DSWithoutDNSKEYIsBogus = false
+	defer func() { DSWithoutDNSKEYIsBogus = DefaultDSWithoutDNSKEYIsBogus }()
+
+	state, _ = verifyDNSKEYs(ctx, r, []dns.RR{}, dsRecordsFromParent)
+	if state != Insecure {
+		t.Errorf("verifyDNSKEYs returned incorrect state. expected %v, got %v", Insecure, state)
+	}
+}
@@ -15,6 +15,8 @@ func validateNegativeResponse(ctx context.Context, r *result) (AuthenticationRes
 	nsec3 := doe.NewDenialOfExistenceNSEC3(ctx, r.zone.Name(), r.authority.extractNSEC3Records())
 
 	if !nsec.Empty() {
+		r.explanation = nsec.Explain(qname)
+
 		if nameSeen, typeSeen := nsec.TypeBitMapContainsAnyOf(qname, []uint16{dns.TypeCNAME, qtype}); nameSeen && !typeSeen {
 			r.denialOfExistence = NsecNoData
 			return Secure, nil
@@ -27,6 +29,8 @@ func validateNegativeResponse(ctx context.Context, r *result) (AuthenticationRes
 	}
 
 	if !nsec3.Empty() {
+		r.explanation = nsec3.Explain(qname)
+
 		// Check for a NODATA response on the QName.
 		if nameSeen, typeSeen := nsec3.TypeBitMapContainsAnyOf(qname, []uint16{dns.TypeCNAME, qtype}); nameSeen && !typeSeen {
 			r.denialOfExistence = Nsec3NoData
@@ -146,6 +146,15 @@ func TestVerify_NegativeResponseNSEC3NxDomain(t *testing.T) {
 	assert.Equal(t, Secure, state)
 	assert.Equal(t, Nsec3NxDomain, r.denialOfExistence)
 
+	// The explanation should name each record used in the proof.
+	if assert.NotNil(t, r.explanation) {
+		assert.Equal(t, "example.com.", r.explanation.ClosestEncloser)
+		assert.Equal(t, nsec3a, r.explanation.ClosestEncloserRecord)
+		assert.Equal(t, "test.example.com.", r.explanation.NextCloserName)
+		assert.Equal(t, nsec3b, r.explanation.NextCloserRecord)
+		assert.Equal(t, "*.example.com.", r.explanation.Wildcard)
+		assert.Equal(t, nsec3c, r.explanation.WildcardRecord)
+	}
 }
 
 func TestVerify_NegativeResponseNSEC3NxDomainWildcard(t *testing.T) {
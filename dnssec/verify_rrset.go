@@ -9,16 +9,33 @@ import (
 
 func verifyRRSETs(ctx context.Context, r *result, keys []*dns.DNSKEY) (AuthenticationResult, error) {
 
-	answerSignatures, err := authenticate(r.zone.Name(), r.msg.Answer, keys, answerSection)
+	answer := r.msg.Answer
+	if ValidateQuestionRRsetsOnly && len(r.msg.Question) > 0 {
+		answer = QuestionRRsets(answer, r.msg.Question[0].Qtype)
+		if skipped := len(r.msg.Answer) - len(answer); skipped > 0 {
+			Debug(fmt.Sprintf("skipping validation of %d answer records not relevant to the question in zone [%s]", skipped, r.zone.Name()))
+		}
+	}
+
+	answerSignatures, err := authenticate(validationTime(ctx), r.zone.Name(), answer, keys, answerSection)
 	if err != nil {
 		return Bogus, fmt.Errorf("%w: %w", ErrBogusResultFound, err)
 	}
 
-	authoritySignatures, err := authenticate(r.zone.Name(), r.msg.Ns, keys, authoritySection)
+	authoritySignatures, err := authenticate(validationTime(ctx), r.zone.Name(), r.msg.Ns, keys, authoritySection)
 	if err != nil {
 		return Bogus, fmt.Errorf("%w: %w", ErrBogusResultFound, err)
 	}
 
+	// Signatures using algorithms we can't verify are ignored. The zone's keys were authenticated via a DS record with
+	// an algorithm we support, so every rrset must also be signed with it. One that isn't is Bogus; otherwise an
+	// attacker could swap the real signatures for one with an unknown algorithm. See RFC 4035 §2.2 and RFC 6840 §5.11.
+	answerSignatures, answerUnverifiable := answerSignatures.withoutUnsupported()
+	authoritySignatures, authorityUnverifiable := authoritySignatures.withoutUnsupported()
+	if answerUnverifiable || authorityUnverifiable {
+		return Bogus, fmt.Errorf("%w: an rrset in zone [%s] is only signed with algorithms we cannot verify", ErrBogusResultFound, r.zone.Name())
+	}
+
 	recordSignatures := slices.Concat(answerSignatures, authoritySignatures)
 
 	if err = recordSignatures.Verify(); err != nil {
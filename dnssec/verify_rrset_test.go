@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"github.com/miekg/dns"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestVerify_RRSETs(t *testing.T) {
@@ -154,3 +156,173 @@ func TestVerify_RRSETs(t *testing.T) {
 	}
 
 }
+
+func TestVerify_RRSETsQuestionOnly(t *testing.T) {
+
+	// With ValidateQuestionRRsetsOnly set, RRsets in the answer that don't relate to the question
+	// are not required to validate.
+
+	defer func() { ValidateQuestionRRsetsOnly = DefaultValidateQuestionRRsetsOnly }()
+
+	key := testEcKey()
+	ctx := context.Background()
+	keys := []*dns.DNSKEY{key.key}
+
+	a := []dns.RR{newRR("test.example.com. 3600 IN A 192.0.2.53")}
+	a = append(a, key.sign(a, 0, 0))
+
+	// An unsigned TXT, and an MX with a signature that won't verify (it's over different data).
+	txt := newRR("test.example.com. 3600 IN TXT \"unrelated\"")
+	mx := []dns.RR{newRR("test.example.com. 3600 IN MX 10 mail.example.com.")}
+	badSig := key.sign(mx, 0, 0)
+	mx = []dns.RR{newRR("test.example.com. 3600 IN MX 20 other.example.com."), badSig}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("test.example.com.", dns.TypeA)
+	msg.Answer = append(append(append([]dns.RR{}, a...), txt), mx...)
+
+	// By default, the extraneous RRsets result in Bogus.
+
+	r := &result{zone: &mockZone{name: zoneName}, msg: msg}
+	state, err := verifyRRSETs(ctx, r, keys)
+	if !errors.Is(err, ErrBogusResultFound) {
+		t.Errorf("expected ErrBogusResultFound, got %v", err)
+	}
+	if state != Bogus {
+		t.Errorf("verifyRRSETs returned incorrect state. expected %v, got %v", Bogus, state)
+	}
+
+	// With the option set, only the A RRset is validated.
+
+	ValidateQuestionRRsetsOnly = true
+
+	r = &result{zone: &mockZone{name: zoneName}, msg: msg}
+	state, err = verifyRRSETs(ctx, r, keys)
+	if err != nil {
+		t.Errorf("verifyRRSETs returned unexpected error: %v", err)
+	}
+	if state != Unknown {
+		t.Errorf("verifyRRSETs returned incorrect state. expected %v, got %v", Unknown, state)
+	}
+	if len(r.answer) != 1 || r.answer[0].rtype != dns.TypeA {
+		t.Errorf("expected only the A answer signature, got %d", len(r.answer))
+	}
+
+	// The RRset matching the question must still validate.
+
+	msg.Answer = []dns.RR{a[0], txt}
+	r = &result{zone: &mockZone{name: zoneName}, msg: msg}
+	state, err = verifyRRSETs(ctx, r, keys)
+	if err == nil {
+		t.Error("expected an error when the question's RRset is unsigned")
+	}
+	if state != Bogus {
+		t.Errorf("verifyRRSETs returned incorrect state. expected %v, got %v", Bogus, state)
+	}
+}
+
+func TestVerify_RRSETsTooManySignatures(t *testing.T) {
+
+	// An rrset with more signatures than allowed results in Bogus.
+
+	key := testEcKey()
+	ctx := context.Background()
+
+	rrset := []dns.RR{newRR("test.example.com. 3600 IN A 192.0.2.53")}
+	answer := slices.Clone(rrset)
+	for i := 0; i <= DefaultMaxSignaturesPerRRset; i++ {
+		answer = append(answer, key.sign(rrset, 0, 0))
+	}
+
+	r := &result{
+		zone: &mockZone{name: zoneName},
+		msg:  &dns.Msg{Answer: answer},
+	}
+
+	state, err := verifyRRSETs(ctx, r, []*dns.DNSKEY{key.key})
+	if !errors.Is(err, ErrTooManySignatures) {
+		t.Errorf("expected ErrTooManySignatures, got %v", err)
+	}
+	if state != Bogus {
+		t.Errorf("verifyRRSETs returned incorrect state. expected %v, got %v", Bogus, state)
+	}
+}
+
+func TestVerify_RRSETsUnsupportedAlgorithm(t *testing.T) {
+
+	// The zone's keys have been authenticated, so every rrset must be signed with a supported algorithm. One only signed
+	// with an algorithm we don't support is Bogus. If the rrset is also signed with an algorithm we do support, the
+	// unsupported signature is ignored.
+
+	ctx := context.Background()
+	key := testEcKey()
+
+	unsupportedKey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zoneName, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+		Flags:     DnskeyFlagCsk,
+		Protocol:  3,
+		Algorithm: dns.PRIVATEDNS,
+		PublicKey: "dGVzdCBrZXkgd2l0aCBhbiB1bnN1cHBvcnRlZCBhbGdvcml0aG0=",
+	}
+
+	rrset := []dns.RR{
+		newRR("ns1.example.com. 3600 IN A 192.0.2.53"),
+	}
+
+	unsupportedRRSig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: "ns1.example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		TypeCovered: dns.TypeA,
+		Algorithm:   dns.PRIVATEDNS,
+		Labels:      3,
+		OrigTtl:     3600,
+		Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+		Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag:      unsupportedKey.KeyTag(),
+		SignerName:  zoneName,
+		Signature:   "c2lnbmF0dXJl",
+	}
+
+	r := &result{
+		zone: &mockZone{name: zoneName},
+		msg:  &dns.Msg{Answer: []dns.RR{rrset[0], unsupportedRRSig}},
+	}
+
+	state, err := verifyRRSETs(ctx, r, []*dns.DNSKEY{key.key, unsupportedKey})
+	if !errors.Is(err, ErrBogusResultFound) {
+		t.Errorf("verifyRRSETs returned unexpected error. expected ErrBogusResultFound, got %v", err)
+	}
+	if state != Bogus {
+		t.Errorf("verifyRRSETs returned incorrect state. expected %v, got %v", Bogus, state)
+	}
+
+	//---
+
+	// When also signed with a supported algorithm, we verify that signature.
+
+	r.msg.Answer = []dns.RR{rrset[0], unsupportedRRSig, key.sign(rrset, 0, 0)}
+
+	state, err = verifyRRSETs(ctx, r, []*dns.DNSKEY{key.key, unsupportedKey})
+	if err != nil {
+		t.Errorf("verifyRRSETs returned unexpected error: %v", err)
+	}
+	if state != Unknown {
+		t.Errorf("verifyRRSETs returned incorrect state. expected %v, got %v", Unknown, state)
+	}
+	if len(r.answer) != 1 {
+		t.Errorf("expected 1 answer signature, got %d", len(r.answer))
+	}
+
+	//---
+
+	// If the zone has no key with the unsupported algorithm, the signature is just unverified; thus Bogus.
+
+	r.msg.Answer = []dns.RR{rrset[0], unsupportedRRSig}
+
+	state, err = verifyRRSETs(ctx, r, []*dns.DNSKEY{key.key})
+	if !errors.Is(err, ErrBogusResultFound) {
+		t.Errorf("verifyRRSETs returned unexpected error. expected ErrBogusResultFound, got %v", err)
+	}
+	if state != Bogus {
+		t.Errorf("verifyRRSETs returned incorrect state. expected %v, got %v", Bogus, state)
+	}
+}
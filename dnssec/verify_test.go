@@ -6,6 +6,7 @@ import (
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 var initErr = errors.New("init error")
@@ -81,8 +82,9 @@ func TestVerify_VerifyGetDNSKEYRecords(t *testing.T) {
 
 	state, r, err := v.verify(ctx, zone, msg, dsSet)
 	assert.ErrorIs(t, err, zone.err)
+	assert.ErrorIs(t, err, ErrKeysFetchFailed)
 	assert.NotNil(t, r)
-	assert.Equal(t, Bogus, state)
+	assert.Equal(t, Indeterminate, state)
 
 	//---
 
@@ -108,6 +110,23 @@ func TestVerify_VerifyGetDNSKEYRecords(t *testing.T) {
 	assert.Equal(t, dsSet, dsRecordsFromParentSeen)
 }
 
+func TestVerify_VerifyEmptyDNSKEYsWithDS(t *testing.T) {
+
+	// If the parent published a DS record, but the zone returns no DNSKEY records, the result is Bogus; not Insecure.
+
+	v := getVerifier()
+	v.verifyDNSKEYs = verifyDNSKEYs
+
+	zone := &mockZone{name: zoneName}
+	ds := newRR("example.com. 300 IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A8 6764247C").(*dns.DS)
+
+	state, r, err := v.verify(context.Background(), zone, &dns.Msg{}, []*dns.DS{ds})
+	assert.ErrorIs(t, err, ErrDSWithoutMatchingDNSKEY)
+	assert.ErrorIs(t, err, ErrKeysNotFound)
+	assert.NotNil(t, r)
+	assert.Equal(t, Bogus, state)
+}
+
 func TestVerify_VerifyDNSKEYsAndRRSETs(t *testing.T) {
 
 	ctx := context.Background()
@@ -171,6 +190,64 @@ func TestVerify_VerifyDNSKEYsAndRRSETs(t *testing.T) {
 	assert.Equal(t, Unknown, state)
 }
 
+func TestVerify_VerifyUnsupportedAlgorithm(t *testing.T) {
+
+	ctx := context.Background()
+	v := verifier{
+		verifyDNSKEYs:              verifyDNSKEYs,
+		verifyRRSETs:               verifyRRSETs,
+		validateDelegatingResponse: validateDelegatingResponse,
+		validatePositiveResponse:   validatePositiveResponse,
+		validateNegativeResponse:   validateNegativeResponse,
+	}
+
+	k := testEcKey()
+	unsupportedKey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zoneName, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+		Flags:     DnskeyFlagCsk,
+		Protocol:  3,
+		Algorithm: dns.PRIVATEDNS,
+		PublicKey: "dGVzdCBrZXkgd2l0aCBhbiB1bnN1cHBvcnRlZCBhbGdvcml0aG0=",
+	}
+	unsupportedSig := func(name string, rtype uint16) *dns.RRSIG {
+		return &dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+			TypeCovered: rtype,
+			Algorithm:   dns.PRIVATEDNS,
+			Labels:      uint8(dns.CountLabel(name)),
+			OrigTtl:     300,
+			Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+			Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+			KeyTag:      unsupportedKey.KeyTag(),
+			SignerName:  zoneName,
+			Signature:   "c2lnbmF0dXJl",
+		}
+	}
+
+	answer := newRR("www.example.com. 300 IN A 192.0.2.1")
+	msg := &dns.Msg{Answer: []dns.RR{answer, unsupportedSig("www.example.com.", dns.TypeA)}}
+
+	// The zone's DS uses an algorithm we support, and its keys are signed with it, so the answer must be too.
+	// An answer only signed with an unknown algorithm is Bogus; not Insecure.
+
+	keys := []dns.RR{k.key, unsupportedKey}
+	zone := &mockZone{name: zoneName, set: append(keys, k.sign(keys, 0, 0))}
+
+	state, _, err := v.verify(ctx, zone, msg, []*dns.DS{k.ds})
+	assert.ErrorIs(t, err, ErrBogusResultFound)
+	assert.Equal(t, Bogus, state)
+
+	//---
+
+	// If we support none of the DS records' algorithms, we're unable to authenticate the zone at all. Thus Insecure.
+
+	zone = &mockZone{name: zoneName, set: []dns.RR{unsupportedKey, unsupportedSig(zoneName, dns.TypeDNSKEY)}}
+
+	state, _, err = v.verify(ctx, zone, msg, []*dns.DS{unsupportedKey.ToDS(dns.SHA256)})
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	assert.Equal(t, Insecure, state)
+}
+
 func TestVerify_VerifyFailsafe(t *testing.T) {
 	ctx := context.Background()
 	v := getVerifierWithKeyAndSetResponses()
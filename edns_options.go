@@ -0,0 +1,239 @@
+package resolver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/miekg/dns"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cookieSecret is used to derive the client cookie we send to each server.
+var cookieSecret = func() []byte {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+}()
+
+// The block size that queries are padded to. See https://datatracker.ietf.org/doc/html/rfc8467#section-4.1
+const paddingBlockSize = 128
+
+type ednsCapability struct {
+	supported bool
+	expires   time.Time
+}
+
+// ednsServer is what we've learnt about a single server address.
+type ednsServer struct {
+	capabilities map[uint16]ednsCapability
+
+	// The last server cookie we received from the address, hex encoded.
+	cookie string
+
+	// Once expired, with nothing further heard from the server, the entry can be removed.
+	expires time.Time
+}
+
+// ednsCapabilityCache records, per server address, which of the EDNSOptions each server has shown it supports, or not.
+// Nothing is known about a server until we've seen a response from it. Each Resolver has its own, shared by all its
+// nameservers across all zones, as the same address is often used by many zones. It's passed to the nameservers via
+// the context; with a nil cache, nothing is learnt, and every option is sent. At most EDNSOptionMaxServers addresses
+// are held.
+type ednsCapabilityCache struct {
+	lock    sync.Mutex
+	servers map[string]*ednsServer
+}
+
+func newEDNSCapabilityCache() *ednsCapabilityCache {
+	return &ednsCapabilityCache{
+		servers: make(map[string]*ednsServer),
+	}
+}
+
+// send returns false if the server at addr is known not to support the option.
+func (c *ednsCapabilityCache) send(addr string, code uint16) bool {
+	if c == nil {
+		return true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	server, ok := c.servers[addr]
+	if !ok {
+		return true
+	}
+	capability, ok := server.capabilities[code]
+	if !ok || capability.supported {
+		return true
+	}
+	if time.Now().After(capability.expires) {
+		// We give the server another chance.
+		delete(server.capabilities, code)
+		return true
+	}
+	return false
+}
+
+// server returns the entry for addr, creating it if needed, and extends its expiry. Must be called with the lock held.
+func (c *ednsCapabilityCache) server(addr string) *ednsServer {
+	now := time.Now()
+
+	server, ok := c.servers[addr]
+	if !ok {
+		if len(c.servers) >= max(EDNSOptionMaxServers, 1) {
+			// Remove anything expired. If we're still full, we make room by removing an arbitrary entry.
+			for a, s := range c.servers {
+				if !s.expires.After(now) {
+					delete(c.servers, a)
+				}
+			}
+			for a := range c.servers {
+				if len(c.servers) < max(EDNSOptionMaxServers, 1) {
+					break
+				}
+				delete(c.servers, a)
+			}
+		}
+		server = &ednsServer{capabilities: make(map[uint16]ednsCapability)}
+		c.servers[addr] = server
+	}
+
+	server.expires = now.Add(EDNSOptionRetention)
+	return server
+}
+
+// set records whether the server at addr supports the option.
+func (c *ednsCapabilityCache) set(addr string, code uint16, supported bool) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.server(addr).capabilities[code] = ednsCapability{supported: supported, expires: time.Now().Add(EDNSOptionRetention)}
+}
+
+// unsupported records that the server at addr does not support any of the options.
+func (c *ednsCapabilityCache) unsupported(addr string, codes []uint16) {
+	for _, code := range codes {
+		c.set(addr, code, false)
+	}
+}
+
+// observe records which of the options sent were echoed back in the response. A response without an OPT record tells
+// us nothing about the individual options, so is ignored. A server cookie is only kept if it follows the client
+// cookie we sent the server. See https://datatracker.ietf.org/doc/html/rfc7873#section-5.3
+func (c *ednsCapabilityCache) observe(addr string, sent []uint16, response *dns.Msg) {
+	if c == nil || len(sent) == 0 || response == nil || response.IsEdns0() == nil {
+		return
+	}
+
+	echoed := make(map[uint16]bool)
+	for _, option := range response.IsEdns0().Option {
+		echoed[option.Option()] = true
+		if cookie, ok := option.(*dns.EDNS0_COOKIE); ok && len(cookie.Cookie) > 16 && strings.EqualFold(cookie.Cookie[:16], clientCookie(addr)) {
+			c.lock.Lock()
+			c.server(addr).cookie = cookie.Cookie[16:]
+			c.lock.Unlock()
+		}
+	}
+
+	for _, code := range sent {
+		c.set(addr, code, echoed[code])
+	}
+}
+
+// cookie returns the cookie to send to the server at addr; our client cookie, followed by the last server cookie
+// we received from it, if any. See https://datatracker.ietf.org/doc/html/rfc7873#section-5.1
+func (c *ednsCapabilityCache) cookie(addr string) string {
+	if c == nil {
+		return clientCookie(addr)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var server string
+	if s, ok := c.servers[addr]; ok {
+		server = s.cookie
+	}
+	return clientCookie(addr) + server
+}
+
+// clientCookie returns our client cookie for the server at addr, hex encoded.
+func clientCookie(addr string) string {
+	h := sha256.New()
+	h.Write(cookieSecret)
+	h.Write([]byte(addr))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// withEDNSOptions returns the message with the EDNSOptions added that capabilities doesn't show to be unsupported by
+// the server at addr, along with the codes of the options that were added. If any are added, a copy of the message is
+// returned; the original is never modified. Messages without an OPT record, and options the message already has, are
+// left as-is.
+func withEDNSOptions(msg *dns.Msg, addr, protocol string, capabilities *ednsCapabilityCache) (*dns.Msg, []uint16) {
+	opt := msg.IsEdns0()
+	if opt == nil || len(EDNSOptions) == 0 {
+		return msg, nil
+	}
+
+	codes := make([]uint16, 0, len(EDNSOptions))
+	for _, code := range EDNSOptions {
+		switch code {
+		case dns.EDNS0COOKIE, dns.EDNS0NSID:
+		case dns.EDNS0TCPKEEPALIVE:
+			// Only valid over TCP. See https://datatracker.ietf.org/doc/html/rfc7828#section-3.1
+			if protocol != "tcp" && protocol != "tcp-tls" {
+				continue
+			}
+		case dns.EDNS0PADDING:
+			// Only of use over an encrypted transport. See https://datatracker.ietf.org/doc/html/rfc7830#section-6
+			if protocol != "tcp-tls" {
+				continue
+			}
+		default:
+			continue
+		}
+		if slices.ContainsFunc(opt.Option, func(o dns.EDNS0) bool { return o.Option() == code }) {
+			continue
+		}
+		if capabilities.send(addr, code) {
+			codes = append(codes, code)
+		}
+	}
+
+	if len(codes) == 0 {
+		return msg, nil
+	}
+
+	msg = msg.Copy()
+	opt = msg.IsEdns0()
+
+	var padding bool
+	for _, code := range codes {
+		switch code {
+		case dns.EDNS0COOKIE:
+			opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: capabilities.cookie(addr)})
+		case dns.EDNS0NSID:
+			opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+		case dns.EDNS0TCPKEEPALIVE:
+			opt.Option = append(opt.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+		case dns.EDNS0PADDING:
+			padding = true
+		}
+	}
+
+	// Padding is added last, as its length depends on the rest of the message. The option's own header is 4 octets.
+	if padding {
+		length := (paddingBlockSize - (msg.Len()+4)%paddingBlockSize) % paddingBlockSize
+		opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, length)})
+	}
+
+	return msg, codes
+}
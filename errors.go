@@ -1,6 +1,10 @@
 package resolver
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+)
 
 var (
 	ErrNotRecursionDesired         = errors.New("only recursive queries are supported via this server")
@@ -9,9 +13,63 @@ var (
 	ErrFailedToGetDNSKEYs          = errors.New("failed looking up DNSKEY records")
 	ErrFailedCreatingZoneAndPool   = errors.New("failed creating nameserver pool for zone")
 	ErrFailedEnrichingPool         = errors.New("failed enriching nameserver pool for zone")
+	ErrNoResolvableNameservers     = errors.New("none of the delegation's nameserver hostnames resolved to an address")
 	ErrUnableToResolveAnswer       = errors.New("failed resolving answer")
 	ErrNextNameserversNotFound     = errors.New("the onward nameservers cannot be found")
 	ErrEmptyResponse               = errors.New("the received response is empty")
 	ErrInternalError               = errors.New("internal error")
 	ErrMaxQueriesPerRequestReached = errors.New("max queries per request reached")
+	ErrNotServiceBindingType       = errors.New("qtype must be SVCB or HTTPS")
+	ErrAddressUnreachable          = errors.New("nameserver address temporarily skipped after repeated network failures")
+	ErrPoolUnavailable             = errors.New("nameserver pool temporarily skipped after repeated failures")
+	ErrEDNSVersionUnsupported      = errors.New("nameserver does not support an edns version we can use")
+	ErrAnswerOutOfBailiwick        = errors.New("the zone that answered is not an ancestor of the qname")
+	ErrInvalidTLSAService          = errors.New("invalid tlsa service port or protocol")
+	ErrTLSANotSecure               = errors.New("tlsa records are only usable when secure")
+	ErrDeadlineApproaching         = errors.New("insufficient time remaining before the context deadline")
+	ErrAmbiguousSOA                = errors.New("multiple differing soa records found for the name")
+	ErrCacheNotConfigured          = errors.New("no cache is configured")
+	ErrNotAuthoritative            = errors.New("the answer was not authoritative")
+	ErrCacheNotIterable            = errors.New("the cache does not support iterating over its entries")
+	ErrResolveTimeout              = errors.New("the maximum time allowed to resolve the question was reached")
+	ErrStaticRecordUnsupported     = errors.New("static records must be of type A, AAAA or CNAME")
+	ErrStaticRecordConflict        = errors.New("a static cname, or alias, cannot exist alongside other records for the same name")
+	ErrResponseTruncated           = errors.New("the response was truncated, even over tcp")
+	ErrResponseTooLarge            = errors.New("the response exceeds the maximum accepted size")
+	ErrAnswersDisagree             = errors.New("the zone's nameservers returned differing answers")
+	ErrDeferredValidationEmpty     = errors.New("there is nothing to validate")
+	ErrDeferredValidationInvalid   = errors.New("the deferred validation is invalid")
+
+	// Categories of failure, wrapped by errors returned when all nameservers in a pool were unsuccessful, and by an
+	// RcodeError for a SERVFAIL or REFUSED.
+
+	ErrNetworkUnreachable = errors.New("nameservers unreachable")
+	ErrServerFailure      = errors.New("nameservers returned a server failure")
+	ErrServerRefused      = errors.New("nameservers refused the query")
 )
+
+// RcodeError is returned when the answer's response code is neither NOERROR nor NXDOMAIN. The Rcode can be
+// extracted with errors.As(), allowing callers to (for example) handle REFUSED differently to SERVFAIL.
+type RcodeError struct {
+	rcode int
+}
+
+func (e *RcodeError) Rcode() int {
+	return e.rcode
+}
+
+// Unwrap returns ErrServerFailure for a SERVFAIL, and ErrServerRefused for a REFUSED, so the category of the failure
+// can be checked with errors.Is(), as it can for a failure from the nameservers themselves.
+func (e *RcodeError) Unwrap() error {
+	switch e.rcode {
+	case dns.RcodeServerFailure:
+		return ErrServerFailure
+	case dns.RcodeRefused:
+		return ErrServerRefused
+	}
+	return nil
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("unsuccessful response code %s (%d)", RcodeToString(e.rcode), e.rcode)
+}
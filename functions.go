@@ -1,7 +1,11 @@
 package resolver
 
 import (
+	"cmp"
+	"fmt"
 	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"slices"
 )
 
 var dnsRecordTypes = map[uint16]string{
@@ -113,6 +117,94 @@ func isSetDO(msg *dns.Msg) bool {
 	return false
 }
 
+// setDO sets the DO bit on the message, adding an OPT record if there isn't one.
+func setDO(msg *dns.Msg) {
+	if opt := msg.IsEdns0(); opt != nil {
+		opt.SetDo()
+		return
+	}
+	msg.SetEdns0(dns.DefaultMsgSize, true)
+}
+
+// removeDNSSECFromResponse removes the DNSSEC records, that the client didn't ask for, from the response.
+// If the client's query had no OPT record, the response's OPT record is also removed; otherwise its DO bit is cleared.
+func removeDNSSECFromResponse(msg *dns.Msg, qtype uint16, clientEDNS bool) {
+	msg.Answer = removeDNSSECRecords(msg.Answer, qtype)
+	msg.Ns = removeDNSSECRecords(msg.Ns, qtype)
+	msg.Extra = removeDNSSECRecords(msg.Extra, qtype)
+
+	if !clientEDNS {
+		msg.Extra = removeRecordsOfType(msg.Extra, dns.TypeOPT)
+	} else if opt := msg.IsEdns0(); opt != nil {
+		opt.SetDo(false)
+	}
+}
+
+// withAlgorithmSignalling returns the message with the EDNS0 DAU, DHU and N3U options added, if the DO bit is set.
+// If options are added, a copy of the message is returned; the original is never modified.
+// See https://datatracker.ietf.org/doc/html/rfc6975
+func withAlgorithmSignalling(msg *dns.Msg) *dns.Msg {
+	if !SignalDNSSECAlgorithms || !isSetDO(msg) {
+		return msg
+	}
+
+	for _, option := range msg.IsEdns0().Option {
+		if option.Option() == dns.EDNS0DAU {
+			// We assume the options have already been set.
+			return msg
+		}
+	}
+
+	msg = msg.Copy()
+	opt := msg.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_DAU{Code: dns.EDNS0DAU, AlgCode: slices.Clone(dnssec.SupportedAlgorithms)},
+		&dns.EDNS0_DHU{Code: dns.EDNS0DHU, AlgCode: slices.Clone(dnssec.SupportedDigestTypes)},
+		&dns.EDNS0_N3U{Code: dns.EDNS0N3U, AlgCode: slices.Clone(dnssec.SupportedNSEC3Hashes)},
+	)
+	return msg
+}
+
+// withEDNSVersion returns the message with its OPT record set to the given EDNS version. If the version is changed,
+// a copy of the message is returned; the original is never modified. Messages without an OPT record are unchanged.
+func withEDNSVersion(msg *dns.Msg, version uint8) *dns.Msg {
+	opt := msg.IsEdns0()
+	if opt == nil || opt.Version() == version {
+		return msg
+	}
+
+	msg = msg.Copy()
+	msg.IsEdns0().SetVersion(version)
+	return msg
+}
+
+// withUDPSize returns the message with its OPT record advertising the given UDP buffer size. If the size is changed,
+// a copy of the message is returned; the original is never modified. Messages without an OPT record are unchanged.
+func withUDPSize(msg *dns.Msg, size uint16) *dns.Msg {
+	opt := msg.IsEdns0()
+	if opt == nil || opt.UDPSize() == size {
+		return msg
+	}
+
+	msg = msg.Copy()
+	msg.IsEdns0().SetUDPSize(size)
+	return msg
+}
+
+// ednsDowngrade returns the EDNS version to retry the query at, if the response was BADVERS.
+// The second return value is false if no retry should be attempted. i.e. the response was not BADVERS,
+// or the server doesn't indicate a version lower than the one we used.
+func ednsDowngrade(query, response *dns.Msg) (uint8, bool) {
+	if response == nil || response.Rcode != dns.RcodeBadVers {
+		return 0, false
+	}
+	qopt, ropt := query.IsEdns0(), response.IsEdns0()
+	if qopt == nil || ropt == nil || ropt.Version() >= qopt.Version() {
+		return 0, false
+	}
+	return ropt.Version(), true
+}
+
 func canonicalName(name string) string {
 	return dns.CanonicalName(name)
 }
@@ -149,6 +241,25 @@ func removeRecordsOfType(rr []dns.RR, t uint16) []dns.RR {
 	return r
 }
 
+// capTTLs lowers the TTL of any record above ttl, to ttl. OPT records are skipped, as their TTL field holds flags.
+func capTTLs(rr []dns.RR, ttl uint32) {
+	for _, record := range rr {
+		if record.Header().Rrtype != dns.TypeOPT && record.Header().Ttl > ttl {
+			record.Header().Ttl = ttl
+		}
+	}
+}
+
+// removeDNSSECRecords removes any RRSIG, NSEC and NSEC3 records, unless they're of the type asked for by qtype.
+func removeDNSSECRecords(rr []dns.RR, qtype uint16) []dns.RR {
+	for _, t := range []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3} {
+		if t != qtype {
+			rr = removeRecordsOfType(rr, t)
+		}
+	}
+	return rr
+}
+
 func extractRecordsOfType(rr []dns.RR, t uint16) []dns.RR {
 	r := make([]dns.RR, 0, len(rr))
 	for _, record := range rr {
@@ -171,3 +282,184 @@ func recordsOfNameAndTypeExist(rr []dns.RR, name string, t uint16) bool {
 func namesEqual(s1, s2 string) bool {
 	return dns.CanonicalName(s1) == dns.CanonicalName(s2)
 }
+
+// sortRRsets returns the records ordered deterministically, such that the same logical set of records always results
+// in the same order. Records are grouped into RRsets, with each RRSIG following the RRset it covers. The order of the
+// records within an RRset is preserved.
+// RRsets owned by qname come first, followed by those along any CNAME chain from qname; the rest are ordered by name then type.
+func sortRRsets(rr []dns.RR, qname string) []dns.RR {
+	if len(rr) < 2 {
+		return rr
+	}
+
+	chain := cnameChain(rr, qname)
+
+	rank := func(name string) int {
+		if i, ok := chain[name]; ok {
+			return i
+		}
+		return len(chain)
+	}
+
+	typeOf := func(record dns.RR) (uint16, bool) {
+		if rrsig, ok := record.(*dns.RRSIG); ok {
+			return rrsig.TypeCovered, true
+		}
+		return record.Header().Rrtype, false
+	}
+
+	sorted := slices.Clone(rr)
+	slices.SortStableFunc(sorted, func(a, b dns.RR) int {
+		nameA, nameB := canonicalName(a.Header().Name), canonicalName(b.Header().Name)
+		typeA, sigA := typeOf(a)
+		typeB, sigB := typeOf(b)
+
+		c := cmp.Compare(rank(nameA), rank(nameB))
+		if c == 0 {
+			c = cmp.Compare(nameA, nameB)
+		}
+		if c == 0 {
+			c = cmp.Compare(typeA, typeB)
+		}
+		if c == 0 && sigA != sigB {
+			if sigA {
+				return 1
+			}
+			return -1
+		}
+		return c
+	})
+	return sorted
+}
+
+// answerRecordsFirst returns rr with the records of type qtype moved to the front, followed by the RRSIGs covering
+// them. The relative order of all records is otherwise kept. CNAME and ANY questions are returned unchanged, as the
+// first record already answers them.
+func answerRecordsFirst(rr []dns.RR, qtype uint16) []dns.RR {
+	if qtype == dns.TypeCNAME || qtype == dns.TypeANY {
+		return rr
+	}
+
+	records := make([]dns.RR, 0, len(rr))
+	signatures := make([]dns.RR, 0, len(rr))
+	others := make([]dns.RR, 0, len(rr))
+	for _, record := range rr {
+		if record.Header().Rrtype == qtype {
+			records = append(records, record)
+		} else if rrsig, ok := record.(*dns.RRSIG); ok && rrsig.TypeCovered == qtype {
+			signatures = append(signatures, record)
+		} else {
+			others = append(others, record)
+		}
+	}
+
+	return slices.Concat(records, signatures, others)
+}
+
+// cnameChain returns the (canonical) names in the chain from qname, following any CNAMEs in rr, mapped to their
+// position in the chain. qname is at position 0.
+func cnameChain(rr []dns.RR, qname string) map[string]int {
+	chain := make(map[string]int)
+	if qname != "" {
+		qname = canonicalName(qname)
+	}
+	for name := qname; name != ""; {
+		if _, seen := chain[name]; seen {
+			// Loop protection.
+			break
+		}
+		chain[name] = len(chain)
+
+		next := ""
+		for _, record := range rr {
+			if c, ok := record.(*dns.CNAME); ok && canonicalName(c.Hdr.Name) == name {
+				next = canonicalName(c.Target)
+				break
+			}
+		}
+		name = next
+	}
+	return chain
+}
+
+// removeUnsolicitedRecords removes any records whose owner is neither qname, nor a name in the CNAME chain from qname.
+// DNAMEs, and their signatures, are kept if they're owned by an ancestor of a name in the chain. Such records were
+// not asked for, and could be an attempt to fill the cache with bad data.
+func removeUnsolicitedRecords(rr []dns.RR, qname string) []dns.RR {
+	if len(rr) == 0 {
+		return rr
+	}
+
+	chain := cnameChain(rr, qname)
+
+	solicited := func(record dns.RR) bool {
+		owner := canonicalName(record.Header().Name)
+		if _, ok := chain[owner]; ok {
+			return true
+		}
+
+		rrtype := record.Header().Rrtype
+		if rrsig, ok := record.(*dns.RRSIG); ok {
+			rrtype = rrsig.TypeCovered
+		}
+		if rrtype == dns.TypeDNAME {
+			for name := range chain {
+				if dns.IsSubDomain(owner, name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	r := make([]dns.RR, 0, len(rr))
+	for _, record := range rr {
+		if solicited(record) {
+			r = append(r, record)
+		} else {
+			Warn(fmt.Sprintf("removing unsolicited record from answer to [%s]: %s", qname, record.String()))
+		}
+	}
+	return r
+}
+
+// truncateRRsets returns the RRsets from rr, in the order they first appear, for as long as their total number of
+// records (including the RRSIGs covering them) is within limit. An RRset is never split, nor separated from its
+// signatures. The bool returned is true if anything was dropped.
+func truncateRRsets(rr []dns.RR, limit int) ([]dns.RR, bool) {
+	if len(rr) <= limit {
+		return rr, false
+	}
+
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+		class  uint16
+	}
+	keyOf := func(record dns.RR) rrsetKey {
+		rrtype := record.Header().Rrtype
+		if rrsig, ok := record.(*dns.RRSIG); ok {
+			rrtype = rrsig.TypeCovered
+		}
+		return rrsetKey{canonicalName(record.Header().Name), rrtype, record.Header().Class}
+	}
+
+	var order []rrsetKey
+	rrsets := make(map[rrsetKey][]dns.RR)
+	for _, record := range rr {
+		key := keyOf(record)
+		if _, ok := rrsets[key]; !ok {
+			order = append(order, key)
+		}
+		rrsets[key] = append(rrsets[key], record)
+	}
+
+	r := make([]dns.RR, 0, limit)
+	for _, key := range order {
+		if len(r)+len(rrsets[key]) > limit {
+			break
+		}
+		r = append(r, rrsets[key]...)
+	}
+	return r, true
+}
@@ -0,0 +1,195 @@
+package resolver
+
+import (
+	"cmp"
+	"context"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"net"
+	"slices"
+	"strings"
+)
+
+// MXResult is a single MX record, along with the addresses of its exchange host.
+type MXResult struct {
+	Preference uint16
+	Host       string
+	Addresses  []net.IP
+
+	// Auth is the combined DNSSEC state of the MX RRset and of the host's addresses.
+	Auth dnssec.AuthenticationResult
+}
+
+// SRVResult is a single SRV record, along with the addresses of its target host.
+type SRVResult struct {
+	Priority  uint16
+	Weight    uint16
+	Port      uint16
+	Target    string
+	Addresses []net.IP
+
+	// Auth is the combined DNSSEC state of the SRV RRset and of the target's addresses.
+	Auth dnssec.AuthenticationResult
+}
+
+// LookupMX looks up the MX records for name, along with the addresses of each exchange host, ordered by preference.
+// A host's addresses are taken from the Additional section of the MX response when present (which requires
+// RemoveAdditionalSectionForPositiveAnswers to be disabled); otherwise they're resolved. If the MX response was
+// validated as Secure, the addresses are always resolved, so they're validated too. A null MX (a host of ".";
+// see https://datatracker.ietf.org/doc/html/rfc7505) is returned without addresses.
+func (resolver *Resolver) LookupMX(ctx context.Context, name string) ([]MXResult, error) {
+	response, err := resolver.lookupWithTargets(ctx, name, dns.TypeMX)
+	if err != nil || response.IsEmpty() {
+		return nil, err
+	}
+
+	records := extractRecords[*dns.MX](response.Msg.Answer)
+	results := make([]MXResult, 0, len(records))
+	for _, mx := range records {
+		addresses, auth, err := resolver.targetAddresses(ctx, mx.Mx, response)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, MXResult{
+			Preference: mx.Preference,
+			Host:       canonicalName(mx.Mx),
+			Addresses:  addresses,
+			Auth:       auth,
+		})
+	}
+
+	slices.SortStableFunc(results, func(a, b MXResult) int {
+		return cmp.Compare(a.Preference, b.Preference)
+	})
+
+	return results, nil
+}
+
+// LookupSRV looks up the SRV records for name (e.g. _sip._tcp.example.com.), along with the addresses of each target
+// host, ordered by priority. Addresses are found as per LookupMX(). A target of "." means the service is not
+// available at the domain, and is returned without addresses.
+func (resolver *Resolver) LookupSRV(ctx context.Context, name string) ([]SRVResult, error) {
+	response, err := resolver.lookupWithTargets(ctx, name, dns.TypeSRV)
+	if err != nil || response.IsEmpty() {
+		return nil, err
+	}
+
+	records := extractRecords[*dns.SRV](response.Msg.Answer)
+	results := make([]SRVResult, 0, len(records))
+	for _, srv := range records {
+		addresses, auth, err := resolver.targetAddresses(ctx, srv.Target, response)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, SRVResult{
+			Priority:  srv.Priority,
+			Weight:    srv.Weight,
+			Port:      srv.Port,
+			Target:    canonicalName(srv.Target),
+			Addresses: addresses,
+			Auth:      auth,
+		})
+	}
+
+	slices.SortStableFunc(results, func(a, b SRVResult) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+
+	return results, nil
+}
+
+// LookupWithSearch looks up name/qtype as a stub resolver would with search domains (as per the search option in
+// /etc/resolv.conf). Each suffix is appended to name in turn, until one results in a NOERROR response with an answer,
+// which is returned. If none do, the last response is returned. A fully qualified name (one ending in a dot), or no
+// suffixes, results in name being looked up as-is. A response with an error ends the search.
+func (resolver *Resolver) LookupWithSearch(ctx context.Context, name string, suffixes []string, qtype uint16) *Response {
+	names := []string{dns.Fqdn(name)}
+	if !dns.IsFqdn(name) && len(suffixes) > 0 {
+		names = make([]string, 0, len(suffixes))
+		for _, suffix := range suffixes {
+			names = append(names, dns.Fqdn(name+"."+strings.Trim(suffix, ".")))
+		}
+	}
+
+	var response *Response
+	for _, n := range names {
+		qmsg := new(dns.Msg)
+		qmsg.SetQuestion(n, qtype)
+		qmsg.SetEdns0(4096, true)
+
+		response = resolver.Exchange(ctx, qmsg)
+		if response.HasError() {
+			return response
+		}
+		if !response.IsEmpty() && response.Msg.Rcode == dns.RcodeSuccess && len(response.Msg.Answer) > 0 {
+			return response
+		}
+	}
+
+	return response
+}
+
+// lookupWithTargets sends a DO query for name/qtype, returning the response.
+func (resolver *Resolver) lookupWithTargets(ctx context.Context, name string, qtype uint16) (*Response, error) {
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion(dns.Fqdn(name), qtype)
+	qmsg.SetEdns0(4096, true)
+
+	response := resolver.Exchange(ctx, qmsg)
+	if response.HasError() {
+		return nil, response.Err
+	}
+	return response, nil
+}
+
+// targetAddresses returns the addresses of host, and the DNSSEC state of response combined with that of the addresses.
+// Addresses in response's Additional section are used, unless response was Secure; as the Additional section is not
+// validated, we'd otherwise lose that state.
+func (resolver *Resolver) targetAddresses(ctx context.Context, host string, response *Response) ([]net.IP, dnssec.AuthenticationResult, error) {
+	host = canonicalName(host)
+	auth := response.Auth
+
+	if host == "." {
+		return nil, auth, nil
+	}
+
+	if response.Auth != dnssec.Secure {
+		a, aaaa, _ := findAddressesForHostname(host, response.Msg.Extra)
+		if len(a) > 0 || len(aaaa) > 0 {
+			addresses := make([]net.IP, 0, len(a)+len(aaaa))
+			for _, rr := range a {
+				addresses = append(addresses, rr.A)
+			}
+			for _, rr := range aaaa {
+				addresses = append(addresses, rr.AAAA)
+			}
+			return addresses, auth, nil
+		}
+	}
+
+	var addresses []net.IP
+	for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		qmsg := new(dns.Msg)
+		qmsg.SetQuestion(host, t)
+		qmsg.SetEdns0(4096, true)
+
+		r := resolver.Exchange(ctx, qmsg)
+		if r.HasError() {
+			return nil, auth, r.Err
+		}
+		if r.IsEmpty() {
+			continue
+		}
+
+		auth = auth.Combine(r.Auth)
+
+		for _, rr := range extractRecords[*dns.A](r.Msg.Answer) {
+			addresses = append(addresses, rr.A)
+		}
+		for _, rr := range extractRecords[*dns.AAAA](r.Msg.Answer) {
+			addresses = append(addresses, rr.AAAA)
+		}
+	}
+
+	return addresses, auth, nil
+}
@@ -0,0 +1,187 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestResolver_LookupMX_AddressesFromAdditional(t *testing.T) {
+	resolver := getTestResolverWithRoot()
+
+	exchanges := 0
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, _ *authenticator) (zone, *Response) {
+		exchanges++
+		assert.True(t, isSetDO(qmsg))
+		assert.Equal(t, "example.com.", qmsg.Question[0].Name)
+		assert.Equal(t, dns.TypeMX, qmsg.Question[0].Qtype)
+
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{
+			newRR("example.com. 300 IN MX 20 mx2.example.com."),
+			newRR("example.com. 300 IN MX 10 mx1.example.com."),
+		}
+		rmsg.Extra = []dns.RR{
+			newRR("mx1.example.com. 300 IN A 192.0.2.1"),
+			newRR("mx1.example.com. 300 IN AAAA 2001:db8::1"),
+			newRR("mx2.example.com. 300 IN A 192.0.2.2"),
+		}
+		return nil, &Response{Msg: rmsg, Auth: dnssec.Insecure}
+	}
+
+	results, err := resolver.LookupMX(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	// The addresses were all found in the Additional section, so no further exchanges were needed.
+	assert.Equal(t, 1, exchanges)
+
+	// Ordered by preference.
+	assert.Equal(t, uint16(10), results[0].Preference)
+	assert.Equal(t, "mx1.example.com.", results[0].Host)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}, results[0].Addresses)
+	assert.Equal(t, dnssec.Insecure, results[0].Auth)
+
+	assert.Equal(t, uint16(20), results[1].Preference)
+	assert.Equal(t, "mx2.example.com.", results[1].Host)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.2")}, results[1].Addresses)
+}
+
+func TestResolver_LookupMX_AddressesResolved(t *testing.T) {
+
+	// When the MX response is Secure, the addresses are resolved, so they're validated too. The states are combined.
+
+	resolver := getTestResolverWithRoot()
+
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, _ *authenticator) (zone, *Response) {
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		auth := dnssec.Secure
+
+		switch qmsg.Question[0].Qtype {
+		case dns.TypeMX:
+			rmsg.Answer = []dns.RR{
+				newRR("example.com. 300 IN MX 10 mx1.example.com."),
+				newRR("example.com. 300 IN MX 20 mx.example.net."),
+			}
+			rmsg.Extra = []dns.RR{
+				newRR("mx1.example.com. 300 IN A 192.0.2.99"),
+			}
+		case dns.TypeA:
+			switch qmsg.Question[0].Name {
+			case "mx1.example.com.":
+				rmsg.Answer = []dns.RR{newRR("mx1.example.com. 300 IN A 192.0.2.1")}
+			case "mx.example.net.":
+				rmsg.Answer = []dns.RR{newRR("mx.example.net. 300 IN A 192.0.2.2")}
+				auth = dnssec.Insecure
+			}
+		}
+		return nil, &Response{Msg: rmsg, Auth: auth}
+	}
+
+	results, err := resolver.LookupMX(context.Background(), "example.com.")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, results[0].Addresses)
+	assert.Equal(t, dnssec.Secure, results[0].Auth)
+
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.2")}, results[1].Addresses)
+	assert.Equal(t, dnssec.Insecure, results[1].Auth)
+}
+
+func TestResolver_LookupMX_NullMX(t *testing.T) {
+	resolver := getTestResolverWithRoot()
+
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, _ *authenticator) (zone, *Response) {
+		require.Equal(t, dns.TypeMX, qmsg.Question[0].Qtype)
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{newRR("example.com. 300 IN MX 0 .")}
+		return nil, &Response{Msg: rmsg, Auth: dnssec.Secure}
+	}
+
+	results, err := resolver.LookupMX(context.Background(), "example.com.")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, ".", results[0].Host)
+	assert.Empty(t, results[0].Addresses)
+}
+
+func TestResolver_LookupSRV(t *testing.T) {
+	resolver := getTestResolverWithRoot()
+
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, _ *authenticator) (zone, *Response) {
+		require.Equal(t, dns.TypeSRV, qmsg.Question[0].Qtype)
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{
+			newRR("_sip._tcp.example.com. 300 IN SRV 20 0 5060 sip2.example.com."),
+			newRR("_sip._tcp.example.com. 300 IN SRV 10 5 5061 sip1.example.com."),
+		}
+		rmsg.Extra = []dns.RR{
+			newRR("sip1.example.com. 300 IN A 192.0.2.1"),
+			newRR("sip2.example.com. 300 IN AAAA 2001:db8::2"),
+		}
+		return nil, &Response{Msg: rmsg, Auth: dnssec.Insecure}
+	}
+
+	results, err := resolver.LookupSRV(context.Background(), "_sip._tcp.example.com.")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	assert.Equal(t, SRVResult{
+		Priority:  10,
+		Weight:    5,
+		Port:      5061,
+		Target:    "sip1.example.com.",
+		Addresses: []net.IP{net.ParseIP("192.0.2.1")},
+		Auth:      dnssec.Insecure,
+	}, results[0])
+	assert.Equal(t, "sip2.example.com.", results[1].Target)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::2")}, results[1].Addresses)
+}
+
+func TestResolver_LookupWithSearch(t *testing.T) {
+	resolver := getTestResolverWithRoot()
+
+	var queried []string
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, _ *authenticator) (zone, *Response) {
+		queried = append(queried, qmsg.Question[0].Name)
+		assert.Equal(t, dns.TypeA, qmsg.Question[0].Qtype)
+
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		switch qmsg.Question[0].Name {
+		case "www.example.net.":
+			rmsg.Answer = []dns.RR{newRR("www.example.net. 300 IN A 192.0.2.1")}
+		default:
+			rmsg.Rcode = dns.RcodeNameError
+		}
+		return nil, &Response{Msg: rmsg, Auth: dnssec.Insecure}
+	}
+
+	// Found via the second suffix, so the third is never tried.
+	response := resolver.LookupWithSearch(context.Background(), "www", []string{"example.com", "example.net.", "example.org"}, dns.TypeA)
+	require.NoError(t, response.Err)
+	require.False(t, response.IsEmpty())
+	assert.Equal(t, dns.RcodeSuccess, response.Msg.Rcode)
+	assert.Equal(t, "www.example.net.", response.Msg.Question[0].Name)
+	assert.Len(t, response.Msg.Answer, 1)
+	assert.Equal(t, []string{"www.example.com.", "www.example.net."}, queried)
+
+	// When no suffix results in an answer, the last NXDOMAIN is returned.
+	queried = nil
+	response = resolver.LookupWithSearch(context.Background(), "www", []string{"example.com", "example.org"}, dns.TypeA)
+	require.NoError(t, response.Err)
+	require.False(t, response.IsEmpty())
+	assert.Equal(t, dns.RcodeNameError, response.Msg.Rcode)
+	assert.Equal(t, "www.example.org.", response.Msg.Question[0].Name)
+	assert.Equal(t, []string{"www.example.com.", "www.example.org."}, queried)
+
+	// A fully qualified name isn't searched.
+	queried = nil
+	response = resolver.LookupWithSearch(context.Background(), "www.example.net.", []string{"example.com"}, dns.TypeA)
+	require.NoError(t, response.Err)
+	assert.Equal(t, []string{"www.example.net."}, queried)
+}
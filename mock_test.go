@@ -65,6 +65,8 @@ type mockZone struct {
 	mockSoa      func(ctx context.Context, name string) (*dns.SOA, error)
 	mockDnskeys  func(ctx context.Context) ([]dns.RR, error)
 	mockExchange func(ctx context.Context, m *dns.Msg) *Response
+
+	mockLinkDelegationSigners func(records []dns.RR)
 }
 
 func (z *mockZone) name() string {
@@ -91,6 +93,12 @@ func (z *mockZone) dnskeys(ctx context.Context) ([]dns.RR, error) {
 	return z.mockDnskeys(ctx)
 }
 
+func (z *mockZone) linkDelegationSigners(records []dns.RR) {
+	if z.mockLinkDelegationSigners != nil {
+		z.mockLinkDelegationSigners(records)
+	}
+}
+
 func (z *mockZone) exchange(ctx context.Context, m *dns.Msg) *Response {
 	return z.mockExchange(ctx, m)
 }
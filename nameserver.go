@@ -2,16 +2,31 @@ package resolver
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/miekg/dns"
 	"net"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // dnsClientFactory defines a factory function for creating a DNS client.
 type dnsClientFactory func(string) dnsClient
 
+// Transport defines how queries are sent to a nameserver.
+type Transport uint8
+
+const (
+	// TransportPlain sends queries over UDP, falling back to TCP if the response is truncated.
+	TransportPlain Transport = iota
+
+	// TransportTLS sends queries over DNS-over-TLS, on port 853. See https://datatracker.ietf.org/doc/html/rfc7858
+	TransportTLS
+)
+
 type dnsClient interface {
 	ExchangeContext(context.Context, *dns.Msg, string) (*dns.Msg, time.Duration, error)
 }
@@ -20,6 +35,9 @@ type nameserver struct {
 	hostname string
 	addr     string
 
+	// The transport used for this nameserver. If TransportPlain, NameserverTransport applies. See TLSNameservers.
+	transport Transport
+
 	dnsClientFactory dnsClientFactory
 
 	metricsLock         sync.Mutex
@@ -28,14 +46,45 @@ type nameserver struct {
 	averageResponseTime time.Duration
 	numberOfTcpRequests uint32
 	protocolRatio       float32
+
+	// The learnt UDP buffer size to advertise to this server. Zero until first learnt.
+	bufferSize atomic.Uint32
+}
+
+// newNameserver returns the nameserver hostname, at addr. Its transport is TransportTLS if it's in TLSNameservers.
+func newNameserver(hostname, addr string) *nameserver {
+	ns := &nameserver{hostname: hostname, addr: addr}
+	if slices.ContainsFunc(TLSNameservers, func(h string) bool { return namesEqual(h, hostname) }) {
+		ns.transport = TransportTLS
+	}
+	return ns
 }
 
-func (*nameserver) defaultDnsClientFactory(protocol string) dnsClient {
-	timeout := DefaultTimeoutUDP
-	if protocol == "tcp" {
-		timeout = DefaultTimeoutTCP
+func (nameserver *nameserver) defaultDnsClientFactory(protocol string) dnsClient {
+	switch protocol {
+	case "tcp":
+		return &dns.Client{Net: protocol, Timeout: DefaultTimeoutTCP}
+	case "tcp-tls":
+		return &dns.Client{Net: protocol, Timeout: DefaultTimeoutTLS, TLSConfig: nameserver.tlsConfig()}
 	}
-	return &dns.Client{Net: protocol, Timeout: timeout}
+	return &dns.Client{Net: protocol, Timeout: DefaultTimeoutUDP}
+}
+
+// usesTLS returns true if queries to this nameserver should be sent over DNS-over-TLS.
+func (nameserver *nameserver) usesTLS() bool {
+	return nameserver.transport == TransportTLS || NameserverTransport == TransportTLS
+}
+
+// tlsConfig returns a copy of TLSConfig, with the SNI server name set to the nameserver's hostname if not already set.
+func (nameserver *nameserver) tlsConfig() *tls.Config {
+	config := new(tls.Config)
+	if TLSConfig != nil {
+		config = TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = strings.TrimSuffix(nameserver.hostname, ".")
+	}
+	return config
 }
 
 func (nameserver *nameserver) exchange(ctx context.Context, m *dns.Msg) *Response {
@@ -53,27 +102,95 @@ func (nameserver *nameserver) exchange(ctx context.Context, m *dns.Msg) *Respons
 		return ResponseError(fmt.Errorf("%w in zone [%s]", ErrNilMessageSentToExchange, zoneName))
 	}
 
+	m = withEDNSVersion(withAlgorithmSignalling(m), EDNSVersion)
+	if EDNSBufferSizeLearning {
+		m = withUDPSize(m, nameserver.ednsBufferSize())
+	}
+
+	port := "53"
+	if nameserver.usesTLS() {
+		port = "853"
+	}
+
 	// Formats correctly for both ipv4 and ipv6.
-	addr := net.JoinHostPort(nameserver.addr, "53")
+	addr := net.JoinHostPort(nameserver.addr, port)
+
+	// If we're replaying a recorded session, the answer comes from that, and not the network.
+	session, _ := ctx.Value(CtxSession).(*Session)
+	if session != nil && session.replaying() {
+		return session.exchange(ctx, m)
+	}
+
+	unreachableAddresses, _ := ctx.Value(ctxUnreachableAddresses).(*addressBlocklist)
+	if unreachableAddresses.blocked(nameserver.addr) {
+		// Returning straight away allows the pool to quickly move onto another server.
+		return ResponseError(fmt.Errorf("%w: %s in zone [%s]", ErrAddressUnreachable, addr, zoneName))
+	}
+
+	ctx, span := Tracer.Start(ctx, "resolver.nameserver.exchange")
+	defer span.End()
+	traceQuestion(span, m)
+	span.SetAttribute(TraceAttrZone, zoneName)
+	span.SetAttribute(TraceAttrServerAddr, addr)
+
+	// Each query we send upstream has its own ID, shared by its retries, so they can be correlated in the logs.
+	shortId := "unknown"
+	queryId := "unknown"
+	if trace, _ := ctx.Value(CtxTrace).(*Trace); trace != nil {
+		shortId = trace.ShortID()
+		queryId = trace.nextQueryID()
+	}
+	span.SetAttribute(TraceAttrQueryID, queryId)
 
-	r := Response{}
-	for _, protocol := range []string{"udp", "tcp"} {
+	r := Response{queryId: queryId}
+	defer traceResponse(span, &r)
+
+	if session != nil {
+		defer func() {
+			session.record(ctx, addr, m, &r)
+		}()
+	}
+	// Set to false once a server has responded FORMERR to the optional EDNS options we sent it.
+	options := true
+	ednsCapabilities, _ := ctx.Value(ctxEDNSCapabilities).(*ednsCapabilityCache)
+
+	protocols := []string{"udp", "tcp"}
+	if TCPOnly {
+		protocols = []string{"tcp"}
+	}
+	if nameserver.usesTLS() {
+		// There's no fallback; a response over TLS will never be truncated.
+		protocols = []string{"tcp-tls"}
+	}
+
+	// The number of times the query has been sent, including retries.
+	attempt := 0
+	for i := 0; i < len(protocols); i++ {
+		protocol := protocols[i]
 		client := factory(protocol)
 
-		r.Msg, r.Duration, r.Err = client.ExchangeContext(ctx, m, addr)
+		query, sent := m, []uint16(nil)
+		if options {
+			query, sent = withEDNSOptions(m, nameserver.addr, protocol, ednsCapabilities)
+		}
+
+		r.Msg, r.Duration, r.Err = client.ExchangeContext(ctx, query, addr)
+		r.server = addr
+
+		attempt++
 
 		//---
 
-		shortId := "unknown"
 		iteration := uint32(0)
 		if trace, _ := ctx.Value(CtxTrace).(*Trace); trace != nil {
-			shortId = trace.ShortID()
 			iteration = trace.Iteration()
 		}
 		Query(fmt.Sprintf(
-			"%s-%d: %s taken querying [%s] %s in zone [%s] on %s://%s (%s)",
+			"%s-%d: query %s attempt %d: %s taken querying [%s] %s in zone [%s] on %s://%s (%s)",
 			shortId,
 			iteration,
+			queryId,
+			attempt,
 			r.Duration,
 			m.Question[0].Name,
 			TypeToString(m.Question[0].Qtype),
@@ -87,19 +204,111 @@ func (nameserver *nameserver) exchange(ctx context.Context, m *dns.Msg) *Respons
 
 		// If we got an error back, we'll continue to maybe try again.
 		if r.HasError() {
+			if protocol == "udp" && ctx.Err() == nil {
+				nameserver.lowerBufferSize()
+			}
 			continue
 		}
 
+		if protocol == "udp" && !r.IsEmpty() && !r.Msg.Truncated {
+			nameserver.raiseBufferSize()
+		}
+
+		unreachableAddresses.succeeded(nameserver.addr)
+
+		// We don't hold onto responses larger than we're willing to accept. Retrying elsewhere is down to the pool.
+		if size, oversized := oversizedResponse(r.Msg); oversized {
+			r.Msg = nil
+			r.Err = fmt.Errorf("%w: %d bytes from %s in zone [%s]", ErrResponseTooLarge, size, addr, zoneName)
+			return &r
+		}
+
+		// If the server didn't understand the optional EDNS options we sent, we retry, over the same protocol, without them.
+		if len(sent) > 0 && !r.IsEmpty() && r.Msg.Rcode == dns.RcodeFormatError {
+			Debug(fmt.Sprintf("query %s: retrying [%s] on %s without edns options after FORMERR", queryId, m.Question[0].Name, addr))
+			ednsCapabilities.unsupported(nameserver.addr, sent)
+			options = false
+			i--
+			continue
+		}
+		ednsCapabilities.observe(nameserver.addr, sent, r.Msg)
+
+		// If the server doesn't support the EDNS version we used, we retry, over the same protocol, at the
+		// version it indicates. The version only ever decreases, so this is bounded.
+		if version, retry := ednsDowngrade(m, r.Msg); retry {
+			Debug(fmt.Sprintf("query %s: retrying [%s] on %s with edns version %d after BADVERS", queryId, m.Question[0].Name, addr, version))
+			m = withEDNSVersion(m, version)
+			i--
+			continue
+		}
+		if !r.IsEmpty() && r.Msg.Rcode == dns.RcodeBadVers {
+			r.Err = fmt.Errorf("%w: %s in zone [%s]", ErrEDNSVersionUnsupported, addr, zoneName)
+			return &r
+		}
+
 		// Then we can return straight away.
 		if !r.Msg.Truncated {
 			return &r
 		}
 	}
 
+	// A failure caused by the caller giving up tells us nothing about the address.
+	if r.HasError() && ctx.Err() == nil {
+		unreachableAddresses.failed(nameserver.addr)
+	}
+
+	// Over TCP, the whole response should always fit. If it didn't, the response can't be relied on.
+	if !r.HasError() && r.truncated() && protocols[len(protocols)-1] != "udp" {
+		if !AcceptTruncatedTCPResponses {
+			r.Err = fmt.Errorf("%w: %s in zone [%s]", ErrResponseTruncated, addr, zoneName)
+			return &r
+		}
+		r.TruncatedTCP = true
+	}
+
 	// r here may have an error. It might be truncated. But it's the best we've got.
 	return &r
 }
 
+// oversizedResponse reports if msg exceeds MaxResponseSize, along with its size in bytes. The size is that of msg
+// once packed with name compression, as it would have been on the wire.
+func oversizedResponse(msg *dns.Msg) (int, bool) {
+	if MaxResponseSize <= 0 || msg == nil {
+		return 0, false
+	}
+	// An unpacked message doesn't have Compress set, so we measure a shallow copy, rather than change msg.
+	packed := *msg
+	packed.Compress = true
+	size := packed.Len()
+	return size, size > MaxResponseSize
+}
+
+// ednsBufferSize returns the UDP buffer size to advertise to this server.
+func (nameserver *nameserver) ednsBufferSize() uint16 {
+	size := nameserver.bufferSize.Load()
+	if size == 0 {
+		return EDNSBufferSizeMin
+	}
+	return uint16(min(max(size, uint32(EDNSBufferSizeMin)), uint32(EDNSBufferSizeMax)))
+}
+
+// raiseBufferSize increases the advertised buffer size by EDNSBufferSizeStep, up to EDNSBufferSizeMax.
+func (nameserver *nameserver) raiseBufferSize() {
+	if !EDNSBufferSizeLearning {
+		return
+	}
+	size := min(uint32(nameserver.ednsBufferSize())+uint32(EDNSBufferSizeStep), uint32(EDNSBufferSizeMax))
+	nameserver.bufferSize.Store(size)
+}
+
+// lowerBufferSize returns the advertised buffer size to EDNSBufferSizeMin.
+func (nameserver *nameserver) lowerBufferSize() {
+	if !EDNSBufferSizeLearning {
+		return
+	}
+	nameserver.bufferSize.Store(uint32(EDNSBufferSizeMin))
+}
+
 func (nameserver *nameserver) updateMetrics(protocol string, duration time.Duration) {
 	nameserver.metricsLock.Lock()
 
@@ -108,7 +317,7 @@ func (nameserver *nameserver) updateMetrics(protocol string, duration time.Durat
 	nameserver.totalResponseTime = nameserver.totalResponseTime + duration
 	nameserver.averageResponseTime = nameserver.totalResponseTime / time.Duration(nameserver.numberOfRequests)
 
-	if protocol == "tcp" {
+	if protocol == "tcp" || protocol == "tcp-tls" {
 		nameserver.numberOfTcpRequests++
 	}
 
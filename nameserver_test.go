@@ -2,13 +2,19 @@ package resolver
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"net"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // Mock DNS Client
@@ -46,6 +52,7 @@ func TestExchange_ValidDNSMessage(t *testing.T) {
 	assert.NoError(t, response.Err)
 	assert.Equal(t, expectedResponse, response.Msg)
 	assert.Equal(t, expectedDuration, response.Duration)
+	assert.Equal(t, "192.0.2.53:53", response.server)
 }
 
 func TestExchange_NilDNSMessage(t *testing.T) {
@@ -204,6 +211,170 @@ func TestExchange_BothUDPAndTCPReturnErrors(t *testing.T) {
 	tcpClient.AssertNumberOfCalls(t, "ExchangeContext", 1)
 }
 
+func TestExchange_TCPOnly(t *testing.T) {
+	defer func() { TCPOnly = DefaultTCPOnly }()
+	TCPOnly = true
+
+	tcpClient := new(MockDNSClient)
+
+	var protocolsCreated []string
+	factory := func(protocol string) dnsClient {
+		protocolsCreated = append(protocolsCreated, protocol)
+		return tcpClient
+	}
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.TODO()
+
+	expectedResponse := new(dns.Msg)
+	tcpClient.On("ExchangeContext", ctx, msg, "192.0.2.53:53").Return(expectedResponse, time.Millisecond, nil).Once()
+
+	response := ns.exchange(ctx, msg)
+
+	assert.NoError(t, response.Err)
+	assert.Equal(t, expectedResponse, response.Msg)
+	assert.Equal(t, []string{"tcp"}, protocolsCreated)
+	tcpClient.AssertExpectations(t)
+}
+
+func TestExchange_TCPOnlyError(t *testing.T) {
+	defer func() { TCPOnly = DefaultTCPOnly }()
+	TCPOnly = true
+
+	tcpClient := new(MockDNSClient)
+
+	var protocolsCreated []string
+	factory := func(protocol string) dnsClient {
+		protocolsCreated = append(protocolsCreated, protocol)
+		return tcpClient
+	}
+	ns := &nameserver{addr: "192.0.2.55", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.TODO()
+
+	tcpClient.On("ExchangeContext", ctx, msg, "192.0.2.55:53").Return((*dns.Msg)(nil), time.Duration(0), errors.New("mock TCP error")).Once()
+
+	response := ns.exchange(ctx, msg)
+
+	// We don't fall back to UDP.
+	assert.Error(t, response.Err)
+	assert.Equal(t, []string{"tcp"}, protocolsCreated)
+	tcpClient.AssertExpectations(t)
+}
+
+func TestExchange_TruncatedOverTCP(t *testing.T) {
+	defer func() { AcceptTruncatedTCPResponses = DefaultAcceptTruncatedTCPResponses }()
+
+	udpClient := new(MockDNSClient)
+	tcpClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		if protocol == "udp" {
+			return udpClient
+		}
+		return tcpClient
+	}
+	ns := &nameserver{addr: "192.0.2.56", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.TODO()
+
+	truncated := new(dns.Msg)
+	truncated.SetReply(msg)
+	truncated.Truncated = true
+
+	udpClient.On("ExchangeContext", ctx, msg, "192.0.2.56:53").Return(truncated, time.Millisecond, nil)
+	tcpClient.On("ExchangeContext", ctx, msg, "192.0.2.56:53").Return(truncated, time.Millisecond, nil)
+
+	// By default, the truncated TCP response is rejected.
+	response := ns.exchange(ctx, msg)
+	assert.ErrorIs(t, response.Err, ErrResponseTruncated)
+	assert.False(t, response.TruncatedTCP)
+
+	// Or it can be accepted, but flagged.
+	AcceptTruncatedTCPResponses = true
+	response = ns.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+	assert.Equal(t, truncated, response.Msg)
+	assert.True(t, response.TruncatedTCP)
+
+	tcpClient.AssertNumberOfCalls(t, "ExchangeContext", 2)
+}
+
+func TestExchange_ResponseTooLarge(t *testing.T) {
+	defer func() { MaxResponseSize = DefaultMaxResponseSize }()
+
+	mockClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		return mockClient
+	}
+	ns := &nameserver{addr: "192.0.2.57", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeTXT)
+	ctx := context.TODO()
+
+	large := new(dns.Msg)
+	large.SetReply(msg)
+	for i := 0; i < 20; i++ {
+		large.Answer = append(large.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+			Txt: []string{strings.Repeat("x", 200)},
+		})
+	}
+
+	mockClient.On("ExchangeContext", ctx, msg, "192.0.2.57:53").Return(large, time.Millisecond, nil)
+
+	// By default, there's no limit.
+	response := ns.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+	assert.Equal(t, large, response.Msg)
+
+	// Over the limit, the response is rejected, and not held onto.
+	MaxResponseSize = 1024
+	response = ns.exchange(ctx, msg)
+	assert.ErrorIs(t, response.Err, ErrResponseTooLarge)
+	assert.Nil(t, response.Msg)
+
+	// And only the one query was needed to find that out.
+	mockClient.AssertNumberOfCalls(t, "ExchangeContext", 2)
+}
+
+func TestOversizedResponse_Compressed(t *testing.T) {
+	defer func() { MaxResponseSize = DefaultMaxResponseSize }()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("a-fairly-long-label-that-compresses-well.example.com.", dns.TypeA)
+	for i := 0; i < 50; i++ {
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(192, 0, 2, byte(i)),
+		})
+	}
+
+	compressed := msg.Copy()
+	compressed.Compress = true
+	packed, err := compressed.Pack()
+	require.NoError(t, err)
+
+	// Uncompressed the message is well over the limit, but it's what's on the wire that counts.
+	MaxResponseSize = len(packed)
+	require.Greater(t, msg.Len(), MaxResponseSize)
+
+	size, oversized := oversizedResponse(msg)
+	assert.False(t, oversized)
+	assert.Equal(t, len(packed), size)
+	assert.False(t, msg.Compress)
+
+	MaxResponseSize = len(packed) - 1
+	_, oversized = oversizedResponse(msg)
+	assert.True(t, oversized)
+}
+
 func TestExchange_IPv6AddressFormatting(t *testing.T) {
 	// Setup
 	mockClient := new(MockDNSClient)
@@ -261,3 +432,629 @@ func TestDefaultDnsClientFactory_TCP(t *testing.T) {
 	}
 
 }
+
+func TestDefaultDnsClientFactory_TLS(t *testing.T) {
+
+	ns := &nameserver{hostname: "ns1.example.com.", addr: "2001:db8::1"}
+
+	client := ns.defaultDnsClientFactory("tcp-tls")
+	assert.IsType(t, new(dns.Client), client)
+	typedClient, ok := client.(*dns.Client)
+	assert.True(t, ok)
+	if ok {
+		assert.Equal(t, "tcp-tls", typedClient.Net)
+		assert.Equal(t, DefaultTimeoutTLS, typedClient.Timeout)
+		if assert.NotNil(t, typedClient.TLSConfig) {
+			assert.Equal(t, "ns1.example.com", typedClient.TLSConfig.ServerName)
+		}
+	}
+
+	// A configured ServerName is respected, and the configuration itself isn't modified.
+	defer func() { TLSConfig = nil }()
+	TLSConfig = &tls.Config{ServerName: "dot.example.net"}
+
+	typedClient, ok = ns.defaultDnsClientFactory("tcp-tls").(*dns.Client)
+	assert.True(t, ok)
+	if ok {
+		assert.Equal(t, "dot.example.net", typedClient.TLSConfig.ServerName)
+		assert.NotSame(t, TLSConfig, typedClient.TLSConfig)
+	}
+
+}
+
+func TestExchange_TLS(t *testing.T) {
+	mockClient := new(MockDNSClient)
+	var protocols []string
+	factory := func(protocol string) dnsClient {
+		protocols = append(protocols, protocol)
+		return mockClient
+	}
+	ns := &nameserver{hostname: "ns1.example.com.", addr: "192.0.2.85", transport: TransportTLS, dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.TODO()
+
+	// Even a truncated response isn't retried over another protocol.
+	expectedResponse := new(dns.Msg)
+	expectedResponse.Truncated = true
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.85:853").Return(expectedResponse, 10*time.Millisecond, nil).Once()
+
+	response := ns.exchange(ctx, msg)
+	assert.ErrorIs(t, response.Err, ErrResponseTruncated)
+	assert.Equal(t, []string{"tcp-tls"}, protocols)
+	mockClient.AssertNumberOfCalls(t, "ExchangeContext", 1)
+
+	// And a full response is returned as-is.
+	protocols = nil
+	expectedResponse = new(dns.Msg)
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.85:853").Return(expectedResponse, 10*time.Millisecond, nil).Once()
+
+	response = ns.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+	assert.Equal(t, expectedResponse, response.Msg)
+	assert.Equal(t, "192.0.2.85:853", response.server)
+	assert.Equal(t, []string{"tcp-tls"}, protocols)
+}
+
+func TestNewNameserverPool_TLSNameservers(t *testing.T) {
+	defer func() { TLSNameservers = nil }()
+	TLSNameservers = []string{"NS1.example.com."}
+
+	nameservers := []*dns.NS{
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns1.example.com."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns2.example.com."},
+	}
+	extra := []dns.RR{
+		newRR("ns1.example.com. 300 IN A 192.0.2.1"),
+		newRR("ns2.example.com. 300 IN A 192.0.2.2"),
+	}
+
+	// Only the nameserver listed is queried over TLS.
+	pool := newNameserverPool(nameservers, extra)
+	require.Len(t, pool.ipv4, 2)
+	for _, ns := range pool.ipv4 {
+		ns := ns.(*nameserver)
+		assert.Equal(t, ns.hostname == "ns1.example.com.", ns.usesTLS(), ns.hostname)
+	}
+}
+
+func TestExchange_TLSHandshakeFailure(t *testing.T) {
+	mockClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		return mockClient
+	}
+	ns := &nameserver{hostname: "ns1.example.com.", addr: "192.0.2.86", dnsClientFactory: factory}
+
+	defer func() { NameserverTransport = DefaultNameserverTransport }()
+	NameserverTransport = TransportTLS
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.TODO()
+
+	handshakeErr := tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.86:853").Return((*dns.Msg)(nil), time.Duration(0), handshakeErr).Once()
+
+	response := ns.exchange(ctx, msg)
+	assert.ErrorAs(t, response.Err, new(tls.RecordHeaderError))
+	assert.Nil(t, response.Msg)
+	mockClient.AssertNumberOfCalls(t, "ExchangeContext", 1)
+}
+
+func TestExchange_DOQueryAdvertisesAlgorithms(t *testing.T) {
+	SignalDNSSECAlgorithms = true
+	defer func() { SignalDNSSECAlgorithms = DefaultSignalDNSSECAlgorithms }()
+
+	mockClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		return mockClient
+	}
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, true)
+	ctx := context.TODO()
+
+	var sent *dns.Msg
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(func(args mock.Arguments) {
+		sent = args.Get(1).(*dns.Msg)
+	}).Return(new(dns.Msg), time.Duration(0), nil).Once()
+
+	response := ns.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+
+	if !assert.NotNil(t, sent) {
+		return
+	}
+
+	options := map[uint16][]uint8{}
+	for _, option := range sent.IsEdns0().Option {
+		switch o := option.(type) {
+		case *dns.EDNS0_DAU:
+			options[dns.EDNS0DAU] = o.AlgCode
+		case *dns.EDNS0_DHU:
+			options[dns.EDNS0DHU] = o.AlgCode
+		case *dns.EDNS0_N3U:
+			options[dns.EDNS0N3U] = o.AlgCode
+		}
+	}
+
+	assert.Equal(t, dnssec.SupportedAlgorithms, options[dns.EDNS0DAU])
+	assert.Equal(t, dnssec.SupportedDigestTypes, options[dns.EDNS0DHU])
+	assert.Equal(t, dnssec.SupportedNSEC3Hashes, options[dns.EDNS0N3U])
+
+	// The original message should not have been modified.
+	assert.Len(t, msg.IsEdns0().Option, 0)
+}
+
+func TestWithAlgorithmSignalling(t *testing.T) {
+	SignalDNSSECAlgorithms = true
+	defer func() { SignalDNSSECAlgorithms = DefaultSignalDNSSECAlgorithms }()
+
+	// Non-DO queries are left as-is.
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	assert.Same(t, msg, withAlgorithmSignalling(msg))
+
+	msg.SetEdns0(4096, false)
+	assert.Same(t, msg, withAlgorithmSignalling(msg))
+	assert.Len(t, msg.IsEdns0().Option, 0)
+
+	// Options are only added once.
+	msg = new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, true)
+	signalled := withAlgorithmSignalling(msg)
+	assert.NotSame(t, msg, signalled)
+	assert.Len(t, signalled.IsEdns0().Option, 3)
+	assert.Same(t, signalled, withAlgorithmSignalling(signalled))
+	assert.Len(t, signalled.IsEdns0().Option, 3)
+
+	// Disabled by config.
+	SignalDNSSECAlgorithms = false
+	assert.Same(t, msg, withAlgorithmSignalling(msg))
+}
+
+func TestExchange_UnreachableAddressSkipped(t *testing.T) {
+	defer func() {
+		UnreachableAddressThreshold = DefaultUnreachableAddressThreshold
+		UnreachableAddressCooldown = DefaultUnreachableAddressCooldown
+	}()
+	UnreachableAddressThreshold = 2
+	UnreachableAddressCooldown = 50 * time.Millisecond
+
+	mockClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		return mockClient
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	unreachableAddresses := newAddressBlocklist()
+	ctx := context.WithValue(context.TODO(), ctxUnreachableAddresses, unreachableAddresses)
+
+	mockClient.On("ExchangeContext", ctx, msg, "192.0.2.99:53").Return((*dns.Msg)(nil), time.Duration(0), errors.New("mock network error"))
+
+	ns := &nameserver{addr: "192.0.2.99", dnsClientFactory: factory}
+
+	for i := 0; i < UnreachableAddressThreshold; i++ {
+		response := ns.exchange(ctx, msg)
+		assert.Error(t, response.Err)
+		assert.NotErrorIs(t, response.Err, ErrAddressUnreachable)
+	}
+
+	// Two calls (UDP and TCP) per exchange.
+	mockClient.AssertNumberOfCalls(t, "ExchangeContext", 2*UnreachableAddressThreshold)
+
+	// The address is now skipped. Including by a different nameserver instance (e.g. in another zone) with the same address.
+	other := &nameserver{addr: "192.0.2.99", dnsClientFactory: factory}
+	response := other.exchange(ctx, msg)
+	assert.ErrorIs(t, response.Err, ErrAddressUnreachable)
+	mockClient.AssertNumberOfCalls(t, "ExchangeContext", 2*UnreachableAddressThreshold)
+
+	// After the cooldown, it's tried again.
+	time.Sleep(UnreachableAddressCooldown)
+	response = other.exchange(ctx, msg)
+	assert.NotErrorIs(t, response.Err, ErrAddressUnreachable)
+	mockClient.AssertNumberOfCalls(t, "ExchangeContext", 2*UnreachableAddressThreshold+2)
+
+	// Without a blocklist, e.g. outside of a resolver's exchange, nothing is blocked.
+	var none *addressBlocklist
+	none.failed("192.0.2.99")
+	assert.False(t, none.blocked("192.0.2.99"))
+}
+
+func TestExchange_UnreachableAddressResetOnSuccess(t *testing.T) {
+	defer func() { UnreachableAddressThreshold = DefaultUnreachableAddressThreshold }()
+	UnreachableAddressThreshold = 3
+
+	unreachableAddresses := newAddressBlocklist()
+	unreachableAddresses.failed("192.0.2.98")
+	unreachableAddresses.failed("192.0.2.98")
+
+	mockClient := new(MockDNSClient)
+	ns := &nameserver{addr: "192.0.2.98", dnsClientFactory: func(protocol string) dnsClient {
+		return mockClient
+	}}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.WithValue(context.TODO(), ctxUnreachableAddresses, unreachableAddresses)
+
+	mockClient.On("ExchangeContext", ctx, msg, "192.0.2.98:53").Return(new(dns.Msg), time.Duration(0), nil).Once()
+
+	response := ns.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+
+	// A further failure is no longer enough to block the address; two more are needed.
+	unreachableAddresses.failed("192.0.2.98")
+	assert.False(t, unreachableAddresses.blocked("192.0.2.98"))
+}
+
+func TestExchange_BadVersRetriesAtLowerVersion(t *testing.T) {
+	defer func() { EDNSVersion = DefaultEDNSVersion }()
+	EDNSVersion = 1
+
+	mockClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		return mockClient
+	}
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, false)
+	ctx := context.TODO()
+
+	// The server only supports version 0.
+	badVers := new(dns.Msg)
+	badVers.SetEdns0(4096, false)
+	badVers.Rcode = dns.RcodeBadVers
+
+	expectedResponse := new(dns.Msg)
+
+	var versionsSent []uint8
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(func(args mock.Arguments) {
+		versionsSent = append(versionsSent, args.Get(1).(*dns.Msg).IsEdns0().Version())
+	}).Return(badVers, time.Millisecond, nil).Once()
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(func(args mock.Arguments) {
+		versionsSent = append(versionsSent, args.Get(1).(*dns.Msg).IsEdns0().Version())
+	}).Return(expectedResponse, time.Millisecond, nil).Once()
+
+	response := ns.exchange(ctx, msg)
+
+	assert.NoError(t, response.Err)
+	assert.Equal(t, expectedResponse, response.Msg)
+	assert.Equal(t, []uint8{1, 0}, versionsSent)
+	mockClient.AssertExpectations(t)
+
+	// The original message should not have been modified.
+	assert.Equal(t, uint8(0), msg.IsEdns0().Version())
+}
+
+func TestExchange_BadVersWithoutLowerVersion(t *testing.T) {
+	mockClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		return mockClient
+	}
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, false)
+	ctx := context.TODO()
+
+	// We're already at version 0, so there's nothing lower to retry with.
+	badVers := new(dns.Msg)
+	badVers.SetEdns0(4096, false)
+	badVers.Rcode = dns.RcodeBadVers
+
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Return(badVers, time.Millisecond, nil).Once()
+
+	response := ns.exchange(ctx, msg)
+
+	assert.ErrorIs(t, response.Err, ErrEDNSVersionUnsupported)
+	mockClient.AssertExpectations(t)
+}
+
+func TestWithEDNSVersion(t *testing.T) {
+	// Messages without an OPT record are left as-is.
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	assert.Same(t, msg, withEDNSVersion(msg, 1))
+
+	// As are those already at the version.
+	msg.SetEdns0(4096, false)
+	assert.Same(t, msg, withEDNSVersion(msg, 0))
+
+	versioned := withEDNSVersion(msg, 1)
+	assert.NotSame(t, msg, versioned)
+	assert.Equal(t, uint8(1), versioned.IsEdns0().Version())
+	assert.Equal(t, uint8(0), msg.IsEdns0().Version())
+}
+
+func TestExchange_EDNSBufferSizeLearning(t *testing.T) {
+	defer func() { EDNSBufferSizeLearning = DefaultEDNSBufferSizeLearning }()
+	EDNSBufferSizeLearning = true
+
+	udpClient := new(MockDNSClient)
+	tcpClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		if protocol == "udp" {
+			return udpClient
+		}
+		return tcpClient
+	}
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, false)
+	ctx := context.TODO()
+
+	var sizesSent []uint16
+	record := func(args mock.Arguments) {
+		sizesSent = append(sizesSent, args.Get(1).(*dns.Msg).IsEdns0().UDPSize())
+	}
+
+	// Two answers that fit, then a failure (e.g. a dropped fragmented response), then another that fits.
+	udpClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(record).Return(new(dns.Msg), time.Millisecond, nil).Twice()
+	udpClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(record).Return((*dns.Msg)(nil), time.Millisecond, errors.New("i/o timeout")).Once()
+	udpClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(record).Return(new(dns.Msg), time.Millisecond, nil).Once()
+	tcpClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Return(new(dns.Msg), time.Millisecond, nil)
+
+	for i := 0; i < 4; i++ {
+		response := ns.exchange(ctx, msg)
+		assert.NoError(t, response.Err)
+	}
+
+	step := EDNSBufferSizeStep
+	assert.Equal(t, []uint16{EDNSBufferSizeMin, EDNSBufferSizeMin + step, EDNSBufferSizeMin + 2*step, EDNSBufferSizeMin}, sizesSent)
+	assert.Equal(t, EDNSBufferSizeMin+step, ns.ednsBufferSize())
+
+	// The original message should not have been modified.
+	assert.Equal(t, uint16(4096), msg.IsEdns0().UDPSize())
+}
+
+func TestNameserver_EDNSBufferSizeBounds(t *testing.T) {
+	defer func() { EDNSBufferSizeLearning = DefaultEDNSBufferSizeLearning }()
+	EDNSBufferSizeLearning = true
+
+	ns := &nameserver{addr: "192.0.2.53"}
+	assert.Equal(t, EDNSBufferSizeMin, ns.ednsBufferSize())
+
+	// Truncation-free answers can't raise the size past the maximum.
+	for i := 0; i < 100; i++ {
+		ns.raiseBufferSize()
+	}
+	assert.Equal(t, EDNSBufferSizeMax, ns.ednsBufferSize())
+
+	ns.lowerBufferSize()
+	assert.Equal(t, EDNSBufferSizeMin, ns.ednsBufferSize())
+
+	// When disabled, nothing is learnt.
+	EDNSBufferSizeLearning = false
+	ns.raiseBufferSize()
+	assert.Equal(t, EDNSBufferSizeMin, ns.ednsBufferSize())
+}
+
+func TestExchange_FormErrOnCookiesStopsSendingThem(t *testing.T) {
+	defer func() { EDNSOptions = nil }()
+	EDNSOptions = []uint16{dns.EDNS0COOKIE}
+	capabilities := newEDNSCapabilityCache()
+
+	mockClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		return mockClient
+	}
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, false)
+	ctx := context.WithValue(context.TODO(), ctxEDNSCapabilities, capabilities)
+
+	formErr := new(dns.Msg)
+	formErr.Rcode = dns.RcodeFormatError
+
+	expectedResponse := new(dns.Msg)
+	expectedResponse.SetEdns0(4096, false)
+
+	var cookiesSent []bool
+	record := func(args mock.Arguments) {
+		sent := args.Get(1).(*dns.Msg).IsEdns0().Option
+		cookiesSent = append(cookiesSent, slices.ContainsFunc(sent, func(o dns.EDNS0) bool {
+			return o.Option() == dns.EDNS0COOKIE
+		}))
+	}
+
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(record).Return(formErr, time.Millisecond, nil).Once()
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(record).Return(expectedResponse, time.Millisecond, nil).Twice()
+
+	// The first exchange is retried without the cookie.
+	response := ns.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+	assert.Equal(t, expectedResponse, response.Msg)
+
+	// And the cookie is no longer sent to the server.
+	response = ns.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+
+	assert.Equal(t, []bool{true, false, false}, cookiesSent)
+	mockClient.AssertExpectations(t)
+
+	// Other servers are unaffected.
+	_, codes := withEDNSOptions(msg, "192.0.2.54", "udp", capabilities)
+	assert.Equal(t, []uint16{dns.EDNS0COOKIE}, codes)
+
+	// The original message should not have been modified.
+	assert.Empty(t, msg.IsEdns0().Option)
+}
+
+func TestExchange_IgnoredEDNSOptionsStopBeingSent(t *testing.T) {
+	defer func() { EDNSOptions = nil }()
+	EDNSOptions = []uint16{dns.EDNS0COOKIE, dns.EDNS0NSID}
+	capabilities := newEDNSCapabilityCache()
+
+	mockClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		return mockClient
+	}
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, false)
+	ctx := context.WithValue(context.TODO(), ctxEDNSCapabilities, capabilities)
+
+	// The server supports cookies, returning its own server cookie, but ignores NSID.
+	clientCookie := capabilities.cookie("192.0.2.53")
+	serverCookie := "0102030405060708"
+	r := new(dns.Msg)
+	r.SetEdns0(4096, false)
+	r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: clientCookie + serverCookie})
+
+	var queries []*dns.Msg
+	mockClient.On("ExchangeContext", ctx, mock.Anything, "192.0.2.53:53").Run(func(args mock.Arguments) {
+		queries = append(queries, args.Get(1).(*dns.Msg))
+	}).Return(r, time.Millisecond, nil)
+
+	for i := 0; i < 2; i++ {
+		response := ns.exchange(ctx, msg)
+		assert.NoError(t, response.Err)
+	}
+
+	assert.Len(t, queries, 2)
+	assert.Len(t, extractOptions[*dns.EDNS0_NSID](queries[0]), 1)
+	assert.Empty(t, extractOptions[*dns.EDNS0_NSID](queries[1]))
+
+	// The second query includes the server cookie, after the same client cookie.
+	assert.Equal(t, clientCookie, extractOptions[*dns.EDNS0_COOKIE](queries[0])[0].Cookie)
+	assert.Equal(t, clientCookie+serverCookie, extractOptions[*dns.EDNS0_COOKIE](queries[1])[0].Cookie)
+
+	// A server cookie that doesn't follow our client cookie is ignored.
+	r.IsEdns0().Option = []dns.EDNS0{&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "1112131415161718" + "2122232425262728"}}
+	capabilities.observe("192.0.2.53", []uint16{dns.EDNS0COOKIE}, r)
+	assert.Equal(t, clientCookie+serverCookie, capabilities.cookie("192.0.2.53"))
+}
+
+func TestWithEDNSOptions(t *testing.T) {
+	defer func() {
+		EDNSOptions = nil
+		EDNSOptionMaxServers = DefaultEDNSOptionMaxServers
+	}()
+	capabilities := newEDNSCapabilityCache()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+
+	// Nothing is added when no options are configured.
+	msg.SetEdns0(4096, false)
+	m, codes := withEDNSOptions(msg, "192.0.2.53", "udp", capabilities)
+	assert.Same(t, msg, m)
+	assert.Empty(t, codes)
+
+	EDNSOptions = []uint16{dns.EDNS0TCPKEEPALIVE, dns.EDNS0PADDING}
+
+	// Keepalive is only sent over TCP, and padding only over TLS.
+	m, codes = withEDNSOptions(msg, "192.0.2.53", "udp", capabilities)
+	assert.Same(t, msg, m)
+	assert.Empty(t, codes)
+
+	_, codes = withEDNSOptions(msg, "192.0.2.53", "tcp", capabilities)
+	assert.Equal(t, []uint16{dns.EDNS0TCPKEEPALIVE}, codes)
+
+	m, codes = withEDNSOptions(msg, "192.0.2.53", "tcp-tls", capabilities)
+	assert.Equal(t, []uint16{dns.EDNS0TCPKEEPALIVE, dns.EDNS0PADDING}, codes)
+	assert.Len(t, extractOptions[*dns.EDNS0_TCP_KEEPALIVE](m), 1)
+
+	// Padding takes the message to a multiple of the block size.
+	assert.Zero(t, m.Len()%paddingBlockSize)
+
+	// Messages without an OPT record are left as-is.
+	plain := new(dns.Msg)
+	plain.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	m, codes = withEDNSOptions(plain, "192.0.2.53", "tcp", capabilities)
+	assert.Same(t, plain, m)
+	assert.Empty(t, codes)
+
+	// Options a server is known not to support are left off, until EDNSOptionRetention has passed.
+	capabilities.unsupported("192.0.2.53", []uint16{dns.EDNS0PADDING})
+	_, codes = withEDNSOptions(msg, "192.0.2.53", "tcp-tls", capabilities)
+	assert.Equal(t, []uint16{dns.EDNS0TCPKEEPALIVE}, codes)
+
+	capabilities.servers["192.0.2.53"].capabilities[dns.EDNS0PADDING] = ednsCapability{expires: time.Now().Add(-time.Second)}
+	_, codes = withEDNSOptions(msg, "192.0.2.53", "tcp-tls", capabilities)
+	assert.Equal(t, []uint16{dns.EDNS0TCPKEEPALIVE, dns.EDNS0PADDING}, codes)
+
+	// Without a cache, nothing is learnt, and every option is sent.
+	var none *ednsCapabilityCache
+	none.unsupported("192.0.2.53", []uint16{dns.EDNS0PADDING})
+	_, codes = withEDNSOptions(msg, "192.0.2.53", "tcp-tls", none)
+	assert.Equal(t, []uint16{dns.EDNS0TCPKEEPALIVE, dns.EDNS0PADDING}, codes)
+
+	// At most EDNSOptionMaxServers addresses are held.
+	EDNSOptionMaxServers = 2
+	for _, addr := range []string{"192.0.2.54", "192.0.2.55", "192.0.2.56"} {
+		capabilities.unsupported(addr, []uint16{dns.EDNS0PADDING})
+	}
+	assert.Len(t, capabilities.servers, 2)
+	assert.Contains(t, capabilities.servers, "192.0.2.56")
+}
+
+func extractOptions[T dns.EDNS0](msg *dns.Msg) []T {
+	var options []T
+	for _, option := range msg.IsEdns0().Option {
+		if o, ok := option.(T); ok {
+			options = append(options, o)
+		}
+	}
+	return options
+}
+
+func TestExchange_QueryIDs(t *testing.T) {
+	defer func(original Logger) { Query = original }(Query)
+
+	var lines []string
+	Query = func(s string) {
+		lines = append(lines, s)
+	}
+
+	udpClient := new(MockDNSClient)
+	tcpClient := new(MockDNSClient)
+	factory := func(protocol string) dnsClient {
+		if protocol == "udp" {
+			return udpClient
+		}
+		return tcpClient
+	}
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: factory}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+
+	trace := NewTrace()
+	ctx := context.WithValue(context.TODO(), CtxTrace, trace)
+
+	// The first query is retried over TCP. The second is answered over UDP.
+	udpClient.On("ExchangeContext", ctx, msg, "192.0.2.53:53").Return((*dns.Msg)(nil), time.Duration(0), errors.New("mock UDP error")).Once()
+	tcpClient.On("ExchangeContext", ctx, msg, "192.0.2.53:53").Return(new(dns.Msg), time.Millisecond, nil).Once()
+	udpClient.On("ExchangeContext", ctx, msg, "192.0.2.53:53").Return(new(dns.Msg), time.Millisecond, nil).Once()
+
+	first := ns.exchange(ctx, msg)
+	second := ns.exchange(ctx, msg)
+	assert.NoError(t, first.Err)
+	assert.NoError(t, second.Err)
+
+	// Each upstream query has its own ID, derived from the trace.
+	assert.Equal(t, trace.ShortID()+"-q1", first.queryId)
+	assert.Equal(t, trace.ShortID()+"-q2", second.queryId)
+
+	// The retry shares the ID of the query it's retrying.
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "query "+first.queryId+" attempt 1:")
+	assert.Contains(t, lines[1], "query "+first.queryId+" attempt 2:")
+	assert.Contains(t, lines[2], "query "+second.queryId+" attempt 1:")
+}
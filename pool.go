@@ -3,6 +3,7 @@ package resolver
 import (
 	"github.com/miekg/dns"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,9 +31,20 @@ type nameserverPool struct {
 	ipv6Count atomic.Uint32
 
 	updating sync.RWMutex
-	enriched sync.Once
+
+	// enrichment is the enrichment of the pool currently in progress, if any. Guarded by enrichmentLock.
+	enrichmentLock sync.Mutex
+	enrichment     *poolEnrichment
+
+	// minNameservers is MinNameserversPerZone, as it was when the pool was created.
+	minNameservers int
+
+	// degraded is set when, after enrichment, the pool has fewer than minNameservers usable addresses.
+	degraded atomic.Bool
 
 	expires atomic.Int64
+
+	breaker poolBreaker
 }
 
 func (pool *nameserverPool) hasIPv4() bool {
@@ -89,6 +101,11 @@ func (pool *nameserverPool) getIPv6() exchanger {
 
 //---
 
+// allow reports if queries should currently be sent to the pool. See poolBreaker.
+func (pool *nameserverPool) allow() bool {
+	return pool.breaker.allow()
+}
+
 func (pool *nameserverPool) expired() bool {
 	expires := pool.expires.Load()
 	return expires > 0 && expires < time.Now().Unix()
@@ -98,17 +115,11 @@ func (pool *nameserverPool) status() NameserverPoolStatus {
 	pool.updating.RLock()
 	defer pool.updating.RUnlock()
 
-	ipv4Count := len(pool.ipv4)
-	ipv6Count := len(pool.ipv6)
-
-	if ipv4Count == 0 && ipv6Count == 0 && len(pool.hostsWithoutAddresses) == 0 {
+	if len(pool.ipv4) == 0 && len(pool.ipv6) == 0 && len(pool.hostsWithoutAddresses) == 0 {
 		return PoolEmpty
 	}
 
-	total := ipv4Count
-	if IPv6Available() {
-		total = total + ipv6Count
-	}
+	total := pool.usableAddresses()
 
 	if total == 0 {
 		return PoolHasHostnamesButNoIpAddresses
@@ -122,8 +133,37 @@ func (pool *nameserverPool) status() NameserverPoolStatus {
 	return PoolPrimed
 }
 
+// usableAddresses returns the number of addresses we can send queries to. IPv6 addresses only count if IPv6 is available.
+// The caller must hold the updating lock.
+func (pool *nameserverPool) usableAddresses() int {
+	total := len(pool.ipv4)
+	if IPv6Available() {
+		total = total + len(pool.ipv6)
+	}
+	return total
+}
+
+// checkDegraded flags the pool as degraded if it has fewer than minNameservers usable addresses, whilst still having
+// hostnames we could try to resolve. Otherwise the flag is cleared. Returns the flag's new value.
+func (pool *nameserverPool) checkDegraded() bool {
+	pool.updating.RLock()
+	degraded := pool.usableAddresses() < pool.minNameservers && len(pool.hostsWithoutAddresses) > 0
+	pool.updating.RUnlock()
+
+	pool.degraded.Store(degraded)
+	return degraded
+}
+
+// takeDegraded reports if the pool is flagged as degraded, clearing the flag. Only one caller will see the flag
+// as set, so only one re-enrichment is started.
+func (pool *nameserverPool) takeDegraded() bool {
+	return pool.degraded.CompareAndSwap(true, false)
+}
+
 func newNameserverPool(nameservers []*dns.NS, extra []dns.RR) *nameserverPool {
-	pool := &nameserverPool{}
+	pool := &nameserverPool{minNameservers: MinNameserversPerZone}
+
+	nameservers = limitNameservers(nameservers, extra)
 
 	var ttl = MaxAllowedTTL
 	pool.hostsWithoutAddresses = make([]string, 0, len(nameservers))
@@ -147,17 +187,11 @@ func newNameserverPool(nameservers []*dns.NS, extra []dns.RR) *nameserverPool {
 		ttl = min(minTtlSeen, ttl)
 
 		for _, addr := range a {
-			pool.ipv4 = append(pool.ipv4, &nameserver{
-				hostname: addr.Header().Name,
-				addr:     addr.A.String(),
-			})
+			pool.ipv4 = append(pool.ipv4, newNameserver(addr.Header().Name, addr.A.String()))
 		}
 
 		for _, addr := range aaaa {
-			pool.ipv6 = append(pool.ipv6, &nameserver{
-				hostname: addr.Header().Name,
-				addr:     addr.AAAA.String(),
-			})
+			pool.ipv6 = append(pool.ipv6, newNameserver(addr.Header().Name, addr.AAAA.String()))
 		}
 
 	}
@@ -172,6 +206,32 @@ func newNameserverPool(nameservers []*dns.NS, extra []dns.RR) *nameserverPool {
 	return pool
 }
 
+// limitNameservers returns, at most, MaxNameserversPerDelegation nameservers. If the limit is exceeded, the subset
+// returned is stable: nameservers with glue records are preferred, then ordered by hostname.
+func limitNameservers(nameservers []*dns.NS, extra []dns.RR) []*dns.NS {
+	if len(nameservers) <= MaxNameserversPerDelegation {
+		return nameservers
+	}
+
+	hasGlue := func(ns *dns.NS) bool {
+		a, aaaa, _ := findAddressesForHostname(canonicalName(ns.Ns), extra)
+		return len(a) > 0 || len(aaaa) > 0
+	}
+
+	sorted := slices.Clone(nameservers)
+	slices.SortStableFunc(sorted, func(a, b *dns.NS) int {
+		if glueA, glueB := hasGlue(a), hasGlue(b); glueA != glueB {
+			if glueA {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(canonicalName(a.Ns), canonicalName(b.Ns))
+	})
+
+	return sorted[:MaxNameserversPerDelegation]
+}
+
 func (pool *nameserverPool) enrich(records []dns.RR) {
 	if len(records) == 0 {
 		return
@@ -197,17 +257,17 @@ func (pool *nameserverPool) enrich(records []dns.RR) {
 		ttl = min(minTtlSeen, ttl)
 
 		for _, addr := range a {
-			pool.ipv4 = append(pool.ipv4, &nameserver{
-				hostname: addr.Header().Name,
-				addr:     addr.A.String(),
-			})
+			if pool.hasAddress(pool.ipv4, addr.A.String()) {
+				continue
+			}
+			pool.ipv4 = append(pool.ipv4, newNameserver(addr.Header().Name, addr.A.String()))
 		}
 
 		for _, addr := range aaaa {
-			pool.ipv6 = append(pool.ipv6, &nameserver{
-				hostname: addr.Header().Name,
-				addr:     addr.AAAA.String(),
-			})
+			if pool.hasAddress(pool.ipv6, addr.AAAA.String()) {
+				continue
+			}
+			pool.ipv6 = append(pool.ipv6, newNameserver(addr.Header().Name, addr.AAAA.String()))
 		}
 	}
 
@@ -228,6 +288,102 @@ func (pool *nameserverPool) enrich(records []dns.RR) {
 	pool.updateIPCount()
 }
 
+// hostnamesWithin returns the distinct hostnames, of nameservers in the pool with addresses, that are within zoneName.
+// i.e. those whose addresses were given as glue by the parent, and for which the zone itself is authoritative.
+func (pool *nameserverPool) hostnamesWithin(zoneName string) []string {
+	pool.updating.RLock()
+	defer pool.updating.RUnlock()
+
+	hostnames := make([]string, 0, len(pool.ipv4)+len(pool.ipv6))
+	for _, e := range slices.Concat(pool.ipv4, pool.ipv6) {
+		ns, ok := e.(*nameserver)
+		if !ok {
+			continue
+		}
+		hostname := canonicalName(ns.hostname)
+		if dns.IsSubDomain(zoneName, hostname) && !slices.Contains(hostnames, hostname) {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames
+}
+
+// replaceAddresses replaces the addresses held for hostname with those found in records. Each address family is only
+// replaced if records contains at least one address for it, and the set of addresses differs from that held.
+// Returns true if the pool was changed.
+func (pool *nameserverPool) replaceAddresses(hostname string, records []dns.RR) bool {
+	hostname = canonicalName(hostname)
+	a, aaaa, _ := findAddressesForHostname(hostname, records)
+
+	addrsA := make([]string, 0, len(a))
+	for _, rr := range a {
+		addrsA = append(addrsA, rr.A.String())
+	}
+	addrsAAAA := make([]string, 0, len(aaaa))
+	for _, rr := range aaaa {
+		addrsAAAA = append(addrsAAAA, rr.AAAA.String())
+	}
+
+	pool.updating.Lock()
+	defer pool.updating.Unlock()
+
+	var changed bool
+	var ok bool
+	if pool.ipv4, ok = pool.replaceHostnameAddresses(pool.ipv4, hostname, addrsA); ok {
+		changed = true
+	}
+	if pool.ipv6, ok = pool.replaceHostnameAddresses(pool.ipv6, hostname, addrsAAAA); ok {
+		changed = true
+	}
+
+	pool.updateIPCount()
+
+	return changed
+}
+
+// replaceHostnameAddresses returns nameservers with those for hostname replaced by ones using addrs. If addrs is
+// empty, or matches the addresses already held for hostname, nameservers is returned unchanged, along with false.
+// The caller must hold the lock.
+func (pool *nameserverPool) replaceHostnameAddresses(nameservers []exchanger, hostname string, addrs []string) ([]exchanger, bool) {
+	if len(addrs) == 0 {
+		return nameservers, false
+	}
+
+	current := make([]string, 0, len(addrs))
+	for _, e := range nameservers {
+		if ns, ok := e.(*nameserver); ok && canonicalName(ns.hostname) == hostname {
+			current = append(current, ns.addr)
+		}
+	}
+
+	slices.Sort(current)
+	sorted := slices.Compact(slices.Sorted(slices.Values(addrs)))
+	if slices.Equal(slices.Compact(current), sorted) {
+		return nameservers, false
+	}
+
+	replaced := slices.DeleteFunc(slices.Clone(nameservers), func(e exchanger) bool {
+		ns, ok := e.(*nameserver)
+		return ok && canonicalName(ns.hostname) == hostname
+	})
+	for _, addr := range sorted {
+		if pool.hasAddress(replaced, addr) {
+			continue
+		}
+		replaced = append(replaced, newNameserver(hostname, addr))
+	}
+
+	return replaced, true
+}
+
+// hasAddress returns true if one of the nameservers already uses addr. The caller must hold the lock.
+func (pool *nameserverPool) hasAddress(nameservers []exchanger, addr string) bool {
+	return slices.ContainsFunc(nameservers, func(e exchanger) bool {
+		ns, ok := e.(*nameserver)
+		return ok && ns.addr == addr
+	})
+}
+
 func (pool *nameserverPool) updateIPCount() {
 	pool.ipv4Count.Store(uint32(len(pool.ipv4)))
 	pool.ipv6Count.Store(uint32(len(pool.ipv6)))
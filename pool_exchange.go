@@ -2,10 +2,32 @@ package resolver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/miekg/dns"
+	"net"
+	"slices"
 )
 
+// AddressFamily is an IP address family. See PreferredAddressFamily.
+type AddressFamily uint8
+
+const (
+	// AddressFamilyIPv6 prefers IPv6 addresses, when IPv6 is available.
+	AddressFamilyIPv6 AddressFamily = iota
+
+	// AddressFamilyIPv4 prefers IPv4 addresses.
+	AddressFamilyIPv4
+)
+
+// preferredAddressFamily returns the AddressFamily from the context if set, otherwise PreferredAddressFamily.
+func preferredAddressFamily(ctx context.Context) AddressFamily {
+	if family, ok := ctx.Value(CtxAddressFamily).(AddressFamily); ok {
+		return family
+	}
+	return PreferredAddressFamily
+}
+
 func (pool *nameserverPool) exchange(ctx context.Context, m *dns.Msg) *Response {
 	hasIPv4 := pool.hasIPv4()
 	hasIPv6 := pool.hasIPv6()
@@ -19,33 +41,71 @@ func (pool *nameserverPool) exchange(ctx context.Context, m *dns.Msg) *Response
 
 	//---
 
+	// Every response received, in order, so a failure can be classified across all the servers tried.
+	var attempts []*Response
+	send := func(server exchanger) *Response {
+		r := server.exchange(ctx, m)
+		attempts = append(attempts, r)
+		return r
+	}
+
 	var response *Response
 
-	if hasIPv6 && IPv6Available() {
+	preferIPv6 := !hasIPv4 || preferredAddressFamily(ctx) == AddressFamilyIPv6
+
+	if hasIPv6 && preferIPv6 && IPv6Available() {
 		if server := pool.getIPv6(); server != nil {
-			response = server.exchange(ctx, m)
+			response = send(server)
 		}
 	} else {
 		if server := pool.getIPv4(); server != nil {
-			response = server.exchange(ctx, m)
+			response = send(server)
 		}
 	}
 
-	if response.IsEmpty() || response.HasError() || response.truncated() {
+	if response.IsEmpty() || response.HasError() || response.truncated() || response.unsuccessful() {
 		// If there was an issue, we give it one more try.
 		// If we have more than one nameserver, this will try a different one.
 		if hasIPv4 {
 			if server := pool.getIPv4(); server != nil {
-				response = server.exchange(ctx, m)
+				response = send(server)
 			}
 		} else {
 			if server := pool.getIPv6(); server != nil {
-				response = server.exchange(ctx, m)
+				response = send(server)
 			}
 		}
 	}
 
-	if response.IsEmpty() || response.HasError() {
+	// Some servers occasionally return NOERROR with an empty answer that's neither NODATA nor a referral.
+	// Another server in the pool may well return the real answer, so we try (a bounded number of) others.
+	retries := min(MaxEmptyAnswerRetries, int(pool.countIPv4()+pool.countIPv6())-1)
+	for i := 0; i < retries && response.emptyNoError(); i++ {
+		var server exchanger
+		if hasIPv4 {
+			server = pool.getIPv4()
+		} else {
+			server = pool.getIPv6()
+		}
+		if server == nil {
+			break
+		}
+		if r := send(server); !r.IsEmpty() && !r.HasError() {
+			response = r
+		}
+	}
+
+	// A SERVFAIL or REFUSED is still a valid response, so it's returned as-is, without an error; the client is owed the
+	// rcode. The category is then carried by the RcodeError that finaliseResponse() returns.
+	failed := response.IsEmpty() || response.HasError()
+
+	// Only failing to get a response from any server counts against the health of the pool; a SERVFAIL or REFUSED
+	// is specific to the name asked about. A failure caused by the caller giving up tells us nothing.
+	if ctx.Err() == nil {
+		pool.breaker.record(slices.ContainsFunc(attempts, func(r *Response) bool { return !r.IsEmpty() }))
+	}
+
+	if failed {
 		errMsg := fmt.Sprintf("all nameservers tried returned an unsucessful response for qname [%s]", m.Question[0].Name)
 		if z, ok := ctx.Value(ctxZoneName).(string); ok {
 			errMsg = errMsg + fmt.Sprintf(" in zone [%s]", z)
@@ -53,6 +113,11 @@ func (pool *nameserverPool) exchange(ctx context.Context, m *dns.Msg) *Response
 
 		err := fmt.Errorf("%w: %s", ErrUnableToResolveAnswer, errMsg)
 
+		// We classify the failure, so callers can choose how to react.
+		if category := failureCategory(ctx, attempts); category != nil {
+			err = fmt.Errorf("%w: %w", err, category)
+		}
+
 		if response.HasError() {
 			// If we already had an error, we'll wrap it with this one.
 			response.Err = fmt.Errorf("%w: %w", response.Err, err)
@@ -63,3 +128,51 @@ func (pool *nameserverPool) exchange(ctx context.Context, m *dns.Msg) *Response
 
 	return response
 }
+
+// failureCategory classifies why every attempt to get an answer from a pool failed. If any server answered with a
+// failure rcode, the servers were reachable, so the category comes from the rcodes; ErrServerRefused only if every
+// such answer was REFUSED. Otherwise, if the context ended, its error is returned. ErrNetworkUnreachable is only
+// returned if every attempt failed with a network error. Nil is returned for failures that fit no category; e.g. a
+// failed TLS handshake, or a truncated response, where the error already says what went wrong.
+func failureCategory(ctx context.Context, attempts []*Response) error {
+	failures, refused, networkErrors := 0, 0, 0
+	for _, r := range attempts {
+		switch {
+		case r.HasError():
+			if networkError(r.Err) {
+				networkErrors++
+			}
+		case r.unsuccessful():
+			failures++
+			if r.Msg.Rcode == dns.RcodeRefused {
+				refused++
+			}
+		}
+	}
+
+	switch {
+	case failures > 0 && refused == failures:
+		return ErrServerRefused
+	case failures > 0:
+		return ErrServerFailure
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case networkErrors > 0 && networkErrors == len(attempts):
+		return ErrNetworkUnreachable
+	}
+	return nil
+}
+
+// networkError returns true if err means the server could not be reached; e.g. a timeout, or a refused connection.
+// TLS alerts are returned as a *net.OpError too, but mean the server was reached, so are excluded.
+func networkError(err error) bool {
+	if errors.Is(err, ErrAddressUnreachable) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
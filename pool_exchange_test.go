@@ -5,6 +5,8 @@ import (
 	"errors"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
 	"testing"
 )
 
@@ -174,6 +176,63 @@ func TestPoolExchange_IPv6Unavailable(t *testing.T) {
 	assert.True(t, ns2Called)
 }
 
+func TestPoolExchange_PreferredAddressFamily(t *testing.T) {
+	var called []string
+	ns4 := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			called = append(called, "ipv4")
+			return &Response{Msg: new(dns.Msg)}
+		},
+	}
+	ns6 := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			called = append(called, "ipv6")
+			return &Response{Msg: new(dns.Msg)}
+		},
+	}
+
+	// A dual-stack host.
+	pool := nameserverPool{
+		ipv4: []exchanger{ns4},
+		ipv6: []exchanger{ns6},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(true)
+
+	defer func() { PreferredAddressFamily = DefaultPreferredAddressFamily }()
+
+	// By default, IPv6 is preferred.
+	pool.exchange(context.Background(), &dns.Msg{})
+	assert.Equal(t, []string{"ipv6"}, called)
+
+	// The context can ask for IPv4.
+	called = nil
+	pool.exchange(context.WithValue(context.Background(), CtxAddressFamily, AddressFamilyIPv4), &dns.Msg{})
+	assert.Equal(t, []string{"ipv4"}, called)
+
+	// As can the operator.
+	called = nil
+	PreferredAddressFamily = AddressFamilyIPv4
+	pool.exchange(context.Background(), &dns.Msg{})
+	assert.Equal(t, []string{"ipv4"}, called)
+
+	// With the context taking precedence.
+	called = nil
+	pool.exchange(context.WithValue(context.Background(), CtxAddressFamily, AddressFamilyIPv6), &dns.Msg{})
+	assert.Equal(t, []string{"ipv6"}, called)
+
+	// Preferring IPv4 doesn't stop an IPv6-only pool from being used.
+	called = nil
+	pool = nameserverPool{
+		ipv6: []exchanger{ns6},
+	}
+	pool.updateIPCount()
+	pool.exchange(context.Background(), &dns.Msg{})
+	assert.Equal(t, []string{"ipv6"}, called)
+}
+
 func TestPoolExchange_IPv6OnlyFirstTry(t *testing.T) {
 	ns1Called := false
 	ns1 := TestPoolExchangeMockNameserver{
@@ -321,3 +380,329 @@ func TestPoolExchange_Error(t *testing.T) {
 		assert.Contains(t, r.Err.Error(), "test.zone")
 	}
 }
+
+func TestPoolExchange_EmptyNoErrorRetried(t *testing.T) {
+
+	// A NOERROR response, with no answer, that's neither NODATA nor a referral, should result in us trying another server.
+
+	ns1Called := 0
+	ns1 := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			ns1Called++
+			msg := new(dns.Msg)
+			msg.Response = true // i.e. NOERROR, with no records.
+			return &Response{
+				Msg: msg,
+			}
+		},
+	}
+
+	a := &dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, 1)}
+
+	ns2Called := 0
+	ns2 := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			ns2Called++
+			msg := new(dns.Msg)
+			msg.Response = true
+			msg.Answer = []dns.RR{a}
+			return &Response{
+				Msg: msg,
+			}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns1, ns1, ns2},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	r := pool.exchange(context.Background(), &dns.Msg{})
+	assert.False(t, r.IsEmpty())
+	assert.False(t, r.HasError())
+	assert.Equal(t, []dns.RR{a}, r.Msg.Answer)
+
+	assert.Equal(t, 2, ns1Called)
+	assert.Equal(t, 1, ns2Called)
+}
+
+func TestPoolExchange_EmptyNoErrorRetriesBounded(t *testing.T) {
+
+	// If every server returns an empty NOERROR, we give up after MaxEmptyAnswerRetries and return what we have.
+
+	nsCalled := 0
+	ns := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			nsCalled++
+			msg := new(dns.Msg)
+			msg.Response = true
+			return &Response{
+				Msg: msg,
+			}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns, ns, ns, ns, ns, ns},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	r := pool.exchange(context.Background(), &dns.Msg{})
+	assert.False(t, r.IsEmpty())
+	assert.False(t, r.HasError())
+	assert.Empty(t, r.Msg.Answer)
+
+	// The initial query, then MaxEmptyAnswerRetries.
+	assert.Equal(t, 1+MaxEmptyAnswerRetries, nsCalled)
+}
+
+func TestPoolExchange_NoDataNotRetried(t *testing.T) {
+
+	// A NODATA response (i.e. with a SOA) is a valid answer, so should not be retried.
+
+	nsCalled := 0
+	ns := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			nsCalled++
+			msg := new(dns.Msg)
+			msg.Response = true
+			msg.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA}}}
+			return &Response{
+				Msg: msg,
+			}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns, ns, ns},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	r := pool.exchange(context.Background(), &dns.Msg{})
+	assert.False(t, r.IsEmpty())
+	assert.False(t, r.HasError())
+	assert.Equal(t, 1, nsCalled)
+}
+
+func TestPoolExchange_ErrorCategoryNetwork(t *testing.T) {
+
+	// When all servers time out, we expect the error to be categorised as the network being unreachable.
+
+	nsCalled := 0
+	ns := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			nsCalled++
+			return &Response{
+				Err: &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded},
+			}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns, ns},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+
+	r := pool.exchange(context.Background(), msg)
+	assert.True(t, r.HasError())
+	assert.ErrorIs(t, r.Err, ErrUnableToResolveAnswer)
+	assert.ErrorIs(t, r.Err, ErrNetworkUnreachable)
+	assert.NotErrorIs(t, r.Err, ErrServerFailure)
+	assert.NotErrorIs(t, r.Err, ErrServerRefused)
+	assert.Equal(t, 2, nsCalled)
+}
+
+func TestPoolExchange_ErrorCategoryServerFailure(t *testing.T) {
+
+	// When all servers return SERVFAIL, each is tried, and the SERVFAIL is returned as-is; it's a valid response, so the
+	// client is owed the rcode. The category comes from the RcodeError that finaliseResponse() returns.
+
+	nsCalled := 0
+	ns := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			nsCalled++
+			msg := new(dns.Msg)
+			msg.Rcode = dns.RcodeServerFailure
+			return &Response{
+				Msg: msg,
+			}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns, ns},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+
+	r := pool.exchange(context.Background(), msg)
+	assert.False(t, r.IsEmpty())
+	assert.False(t, r.HasError())
+	assert.Equal(t, dns.RcodeServerFailure, r.Msg.Rcode)
+	assert.Equal(t, 2, nsCalled)
+
+	err := &RcodeError{rcode: r.Msg.Rcode}
+	assert.ErrorIs(t, err, ErrServerFailure)
+	assert.NotErrorIs(t, err, ErrServerRefused)
+}
+
+func TestPoolExchange_ErrorCategoryRefused(t *testing.T) {
+
+	ns := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			msg := new(dns.Msg)
+			msg.Rcode = dns.RcodeRefused
+			return &Response{
+				Msg: msg,
+			}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+
+	r := pool.exchange(context.Background(), msg)
+	assert.False(t, r.HasError())
+	assert.Equal(t, dns.RcodeRefused, r.Msg.Rcode)
+
+	err := &RcodeError{rcode: r.Msg.Rcode}
+	assert.ErrorIs(t, err, ErrServerRefused)
+	assert.NotErrorIs(t, err, ErrServerFailure)
+}
+
+func TestPoolExchange_ErrorCategoryAcrossAttempts(t *testing.T) {
+
+	// A SERVFAIL from the first server, then a timeout from the second, is a server failure; not the network, as a
+	// server was reached. And as a server responded, the pool's health isn't affected.
+
+	defer func() { PoolBreakerThreshold = DefaultPoolBreakerThreshold }()
+	PoolBreakerThreshold = 1
+
+	ns1 := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			msg := new(dns.Msg)
+			msg.Rcode = dns.RcodeServerFailure
+			return &Response{Msg: msg}
+		},
+	}
+	ns2 := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			return &Response{Err: &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns1, ns2},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+
+	r := pool.exchange(context.Background(), msg)
+	assert.ErrorIs(t, r.Err, ErrServerFailure)
+	assert.NotErrorIs(t, r.Err, ErrNetworkUnreachable)
+	assert.False(t, pool.breaker.isOpen())
+}
+
+func TestPoolExchange_ErrorCategoryNotNetwork(t *testing.T) {
+
+	// Errors that don't come from the network, such as a TLS alert from the server, or the caller's context ending,
+	// are not categorised as the network being unreachable.
+
+	var err error
+	ns := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			return &Response{Err: err}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+
+	err = &net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}
+	r := pool.exchange(context.Background(), msg)
+	assert.ErrorIs(t, r.Err, ErrUnableToResolveAnswer)
+	assert.NotErrorIs(t, r.Err, ErrNetworkUnreachable)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ctx.Err()
+	r = pool.exchange(ctx, msg)
+	assert.ErrorIs(t, r.Err, context.Canceled)
+	assert.NotErrorIs(t, r.Err, ErrNetworkUnreachable)
+}
+
+func TestPoolExchange_ServerFailureThenSuccess(t *testing.T) {
+
+	// A SERVFAIL from the first server should result in the second being tried.
+
+	ns1 := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			msg := new(dns.Msg)
+			msg.Rcode = dns.RcodeServerFailure
+			return &Response{
+				Msg: msg,
+			}
+		},
+	}
+
+	ns2 := TestPoolExchangeMockNameserver{
+		func(context.Context, *dns.Msg) *Response {
+			return &Response{
+				Msg: new(dns.Msg),
+			}
+		},
+	}
+
+	pool := nameserverPool{
+		ipv4: []exchanger{ns1, ns2},
+	}
+	pool.updateIPCount()
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	r := pool.exchange(context.Background(), &dns.Msg{})
+	assert.False(t, r.HasError())
+	assert.Equal(t, dns.RcodeSuccess, r.Msg.Rcode)
+}
@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"fmt"
 	"net"
 	"testing"
 	"time"
@@ -177,3 +178,41 @@ func TestExpired_True(t *testing.T) {
 
 	assert.True(t, pool.expired())
 }
+
+func TestNewNameserverPool_LimitNameservers(t *testing.T) {
+
+	// An oversized set of NS records should be capped at MaxNameserversPerDelegation,
+	// preferring those with glue, then in hostname order.
+
+	nsRecords := make([]*dns.NS, 0, 30)
+	extraRecords := make([]dns.RR, 0, 5)
+	for i := 29; i >= 0; i-- {
+		hostname := fmt.Sprintf("ns%02d.example.com.", i)
+		nsRecords = append(nsRecords, &dns.NS{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: hostname})
+
+		// Only the last 5 have glue.
+		if i >= 25 {
+			extraRecords = append(extraRecords, &dns.A{Hdr: dns.RR_Header{Name: hostname, Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, byte(i))})
+		}
+	}
+
+	pool := newNameserverPool(nsRecords, extraRecords)
+
+	assert.Len(t, pool.ipv4, 5)
+	assert.Len(t, pool.hostsWithoutAddresses, MaxNameserversPerDelegation-5)
+
+	// The remaining hostnames should be the first (by name) of those without glue.
+	for i, hostname := range pool.hostsWithoutAddresses {
+		assert.Equal(t, fmt.Sprintf("ns%02d.example.com.", i), hostname)
+	}
+}
+
+func TestNewNameserverPool_LimitNameserversNotExceeded(t *testing.T) {
+	nsRecords := []*dns.NS{
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns2.example.com."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns1.example.com."},
+	}
+
+	// When under the limit, the records are returned as-is.
+	assert.Equal(t, nsRecords, limitNameservers(nsRecords, nil))
+}
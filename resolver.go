@@ -7,8 +7,18 @@ import (
 )
 
 type Resolver struct {
-	zones zoneStore
-	funcs resolverFunctions
+	zones  zoneStore
+	funcs  resolverFunctions
+	static staticRecords
+
+	// unreachableAddresses are the nameserver addresses that have repeatedly failed at the network level.
+	unreachableAddresses *addressBlocklist
+
+	// ednsCapabilities are the EDNSOptions each nameserver address has shown it supports, or not.
+	ednsCapabilities *ednsCapabilityCache
+
+	// recentResponses are the responses received within the last CoalescingWindow.
+	recentResponses *coalescer
 }
 
 // The core, top level, resolving functions. They're defined as variables to aid overriding them for testing.
@@ -29,15 +39,23 @@ func NewResolver() *Resolver {
 		panic(err)
 	}
 
-	z := new(zones)
+	resolver := &Resolver{
+		unreachableAddresses: newAddressBlocklist(),
+		ednsCapabilities:     newEDNSCapabilityCache(),
+		recentResponses:      newCoalescer(),
+	}
+
+	var z zoneStore = new(zones)
+	if ZoneStore != nil {
+		z = newBackedZones(ZoneStore, resolver.getExchanger())
+	}
+
 	z.add(&zoneImpl{
 		zoneName: ".",
 		pool:     pool,
 	})
 
-	resolver := &Resolver{
-		zones: z,
-	}
+	resolver.zones = z
 
 	// When not testing, we point to the concrete instances of the functions.
 	resolver.funcs = resolverFunctions{
@@ -72,15 +90,9 @@ func buildRootServerPool() (*nameserverPool, error) {
 	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
 		switch rr := rr.(type) {
 		case *dns.A:
-			pool.ipv4 = append(pool.ipv4, &nameserver{
-				hostname: canonicalName(rr.Header().Name),
-				addr:     rr.A.String(),
-			})
+			pool.ipv4 = append(pool.ipv4, newNameserver(canonicalName(rr.Header().Name), rr.A.String()))
 		case *dns.AAAA:
-			pool.ipv6 = append(pool.ipv6, &nameserver{
-				hostname: canonicalName(rr.Header().Name),
-				addr:     rr.AAAA.String(),
-			})
+			pool.ipv6 = append(pool.ipv6, newNameserver(canonicalName(rr.Header().Name), rr.AAAA.String()))
 		default:
 			// Continue
 		}
@@ -2,9 +2,12 @@ package resolver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/miekg/dns"
 	"github.com/nsmithuk/resolver/dnssec"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -17,12 +20,85 @@ func (resolver *Resolver) Exchange(ctx context.Context, qmsg *dns.Msg) *Response
 		return ResponseError(ErrNotRecursionDesired)
 	}
 
+	ctx, span := Tracer.Start(ctx, "resolver.Exchange")
+	defer span.End()
+	traceQuestion(span, qmsg)
+
+	if response := anyAnswer(qmsg); response != nil {
+		traceResponse(span, response)
+		return response
+	}
+
 	// We'll copy the message we'll likely want to mutate some values.
 	// And it might be confusing to the caller if the values in their instance change.
-	return resolver.exchange(ctx, qmsg.Copy())
+	qmsg = qmsg.Copy()
+
+	// If we're always validating, we do so regardless of what the client asked for.
+	clientDO, clientEDNS := isSetDO(qmsg), qmsg.IsEdns0() != nil
+	if AlwaysValidate && !clientDO {
+		setDO(qmsg)
+	}
+
+	// An expired cached answer is better than none at all. If we have one, the resolution is given until
+	// BestEffortDeadlineMargin before the deadline, so there's still time to return it.
+	var stale *Response
+	resolveCtx := ctx
+	if BestEffortOnDeadline {
+		if stale = resolver.staleAnswer(qmsg); stale != nil {
+			if deadline, ok := ctx.Deadline(); ok {
+				var cancel context.CancelFunc
+				resolveCtx, cancel = context.WithDeadline(ctx, deadline.Add(-BestEffortDeadlineMargin))
+				defer cancel()
+			}
+		}
+	}
+
+	response := resolver.exchange(resolveCtx, qmsg)
+
+	if stale != nil && response.HasError() && bestEffort(resolveCtx, response.Err) {
+		Debug(fmt.Sprintf("returning a stale answer for [%s]: %s", qmsg.Question[0].Name, response.Err.Error()))
+		response = stale
+	}
+
+	// And then remove what the client didn't ask for.
+	if AlwaysValidate && !clientDO && !response.IsEmpty() {
+		removeDNSSECFromResponse(response.Msg, qmsg.Question[0].Qtype, clientEDNS)
+	}
+
+	traceResponse(span, response)
+	if response != nil && response.Auth != dnssec.Unknown {
+		span.SetAttribute(TraceAttrDNSSECResult, response.Auth.String())
+	}
+
+	return response
 }
 
 func (resolver *Resolver) exchange(ctx context.Context, qmsg *dns.Msg) *Response {
+	if MaxResolveDuration <= 0 {
+		return resolver.resolve(ctx, qmsg)
+	}
+
+	// The limit applies from the start of the whole request, so calls nested within it share the same deadline.
+	start, ok := ctx.Value(ctxStartTime).(time.Time)
+	if !ok {
+		start = time.Now()
+		ctx = context.WithValue(ctx, ctxStartTime, start)
+	}
+
+	// If the caller's context has an earlier deadline, that still applies.
+	bounded, cancel := context.WithDeadline(ctx, start.Add(MaxResolveDuration))
+	defer cancel()
+
+	response := resolver.resolve(bounded, qmsg)
+
+	// We only report our own limit; if the caller's context also ended, that's the more useful error to see.
+	if response.HasError() && bounded.Err() != nil && ctx.Err() == nil {
+		return ResponseError(fmt.Errorf("%w: exceeded %s: %w", ErrResolveTimeout, MaxResolveDuration, response.Err))
+	}
+	return response
+}
+
+func (resolver *Resolver) resolve(ctx context.Context, qmsg *dns.Msg) *Response {
 
 	//----------------------------------------------------------------------------
 	// We setup our context
@@ -32,6 +108,16 @@ func (resolver *Resolver) exchange(ctx context.Context, qmsg *dns.Msg) *Response
 		ctx = context.WithValue(ctx, ctxStartTime, start)
 	}
 
+	if v := ctx.Value(ctxUnreachableAddresses); v == nil && resolver.unreachableAddresses != nil {
+		ctx = context.WithValue(ctx, ctxUnreachableAddresses, resolver.unreachableAddresses)
+	}
+	if v := ctx.Value(ctxEDNSCapabilities); v == nil && resolver.ednsCapabilities != nil {
+		ctx = context.WithValue(ctx, ctxEDNSCapabilities, resolver.ednsCapabilities)
+	}
+	if v := ctx.Value(ctxRecentResponses); v == nil && resolver.recentResponses != nil {
+		ctx = context.WithValue(ctx, ctxRecentResponses, resolver.recentResponses)
+	}
+
 	//---
 
 	trace, ok := ctx.Value(CtxTrace).(*Trace)
@@ -54,6 +140,51 @@ func (resolver *Resolver) exchange(ctx context.Context, qmsg *dns.Msg) *Response
 		ctx = context.WithValue(ctx, ctxSessionQueries, counter)
 	}
 
+	// Each call to exchange() has its own path, so those nested within this one (e.g. to resolve a nameserver's
+	// address) don't appear in it.
+	path := new(resolutionPath)
+	ctx = context.WithValue(ctx, ctxPath, path)
+	timings := new(resolutionTimings)
+	ctx = context.WithValue(ctx, ctxTimings, timings)
+
+	// The limit is lower when we're resolving the address of a nameserver. See nameserverResolutionContext().
+	limit := MaxQueriesPerRequest
+	if l, ok := ctx.Value(ctxQueryLimit).(uint32); ok {
+		limit = l
+	}
+
+	//----------------------------------------------------------------------------
+	// Non-IN classes (e.g. CHAOS) are not delegated, nor signed, so we just ask the closest zone we know directly.
+
+	if qmsg.Question[0].Qclass != dns.ClassINET {
+		if counter.Add(1) > limit {
+			return ResponseError(fmt.Errorf("%w. value is currently set to: %d", ErrMaxQueriesPerRequestReached, limit))
+		}
+		return resolver.exchangeNonInet(ctx, qmsg)
+	}
+
+	//----------------------------------------------------------------------------
+	// Names with static records, or that are aliases, are answered directly, without querying upstream for the name.
+
+	if answer := resolver.static.lookup(qmsg.Question[0]); answer != nil {
+		// Counted, as a chain of static CNAMEs could otherwise loop.
+		if counter.Add(1) > limit {
+			return ResponseError(fmt.Errorf("%w. value is currently set to: %d", ErrMaxQueriesPerRequestReached, limit))
+		}
+		response := resolver.staticAnswer(ctx, qmsg, answer)
+		response.Duration = time.Since(start)
+		return response
+	}
+
+	if a := resolver.static.alias(qmsg.Question[0]); a != nil {
+		if counter.Add(1) > limit {
+			return ResponseError(fmt.Errorf("%w. value is currently set to: %d", ErrMaxQueriesPerRequestReached, limit))
+		}
+		response := resolver.aliasAnswer(ctx, qmsg, a)
+		response.Duration = time.Since(start)
+		return response
+	}
+
 	//----------------------------------------------------------------------------
 	// We setup the DNSSEC Authenticator
 
@@ -70,17 +201,31 @@ func (resolver *Resolver) exchange(ctx context.Context, qmsg *dns.Msg) *Response
 	// Returns a list zones that make up the QName that we already have nameservers for.
 	// Items are only included is we have a valid chain from leaf to root.
 	// They are ordered most specific (i.e. longest FQDN), to shortest.
-	// The last element will always be the root (.).
+	// The last element will always be the root (.), unless we're not validating, and started from a CtxStartZone.
 	knownZones := resolver.zones.getZoneList(qmsg.Question[0].Name)
 
+	// Unless the caller has given us a zone to start from.
+	if start, ok := ctx.Value(CtxStartZone).(*Zone); ok {
+		if zones := resolver.startZones(start, qmsg.Question[0], auth != nil); zones != nil {
+			knownZones = zones
+		}
+	}
+
+	// A zone's DS records are served by its parent, so if we know the zone itself, we still need to ask the parent.
+	// See https://datatracker.ietf.org/doc/html/rfc4035#section-3.1.4.1
+	if qmsg.Question[0].Qtype == dns.TypeDS && len(knownZones) > 1 && knownZones[0].name() == canonicalName(qmsg.Question[0].Name) {
+		knownZones = knownZones[1:]
+	}
+
 	if auth != nil {
+		// We'll need the keys for every zone in the chain, so we start fetching them now.
+		prefetchDNSKEYs(ctx, knownZones)
+
 		// Lookup the DNSSEC details for these zones.
 		// We don't do this lookup for the root, thus len()-1.
 		for i := 0; i < len(knownZones)-1; i++ {
 			// We never look directly at the first zone.
-			z := knownZones[i+1]
-			dsName := knownZones[i].name()
-			auth.addDelegationSignerLink(z, dsName)
+			auth.addDelegationSignerLink(knownZones[i+1], knownZones[i])
 		}
 	}
 
@@ -100,8 +245,8 @@ func (resolver *Resolver) exchange(ctx context.Context, qmsg *dns.Msg) *Response
 	var z zone = knownZones[0]
 
 	for ; d.more(); d.next() {
-		if counter.Add(1) > MaxQueriesPerRequest {
-			return ResponseError(fmt.Errorf("%w. value is currently set to: %d", ErrMaxQueriesPerRequestReached, MaxQueriesPerRequest))
+		if counter.Add(1) > limit {
+			return ResponseError(fmt.Errorf("%w. value is currently set to: %d", ErrMaxQueriesPerRequestReached, limit))
 		}
 
 		c := d.current()
@@ -117,6 +262,8 @@ func (resolver *Resolver) exchange(ctx context.Context, qmsg *dns.Msg) *Response
 
 		if response != nil {
 			Debug(fmt.Sprintf("counter at end of exchange for iteration %d is %d", trace.Iterations.Load(), counter.Load()))
+			response.Path = *path
+			response.Timings = timings.list()
 			return response
 		}
 	}
@@ -124,18 +271,134 @@ func (resolver *Resolver) exchange(ctx context.Context, qmsg *dns.Msg) *Response
 	return ResponseError(ErrUnableToResolveAnswer)
 }
 
+// negativeDenialOfExistence returns true if the state proves the QName, or QType, does not exist.
+func negativeDenialOfExistence(deo dnssec.DenialOfExistenceState) bool {
+	switch deo {
+	case dnssec.NsecNxDomain, dnssec.NsecNoData, dnssec.Nsec3NxDomain, dnssec.Nsec3NoData:
+		return true
+	}
+	return false
+}
+
+// bestEffort returns true if, rather than returning err, we should return the best-effort answer we have so far.
+// This is only the case when BestEffortOnDeadline is enabled, and err is due to the context's deadline, or the
+// MaxResolveDuration.
+func bestEffort(ctx context.Context, err error) bool {
+	return BestEffortOnDeadline && (errors.Is(err, ErrDeadlineApproaching) || errors.Is(err, ErrResolveTimeout) || errors.Is(ctx.Err(), context.DeadlineExceeded))
+}
+
+// prefetchDNSKEYs fetches, in the background, the DNSKEY records for the passed zones. At most
+// DNSKEYPrefetchConcurrency are fetched concurrently. Once the context is done, no further fetches are started.
+func prefetchDNSKEYs(ctx context.Context, zones []zone) {
+	if DNSKEYPrefetchConcurrency <= 0 || len(zones) == 0 {
+		return
+	}
+
+	sem := make(chan struct{}, DNSKEYPrefetchConcurrency)
+	go func() {
+		for _, z := range zones {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(z zone) {
+				defer func() { <-sem }()
+				if _, err := z.dnskeys(ctx); err != nil {
+					Debug(fmt.Sprintf("unable to prefetch dnskeys for zone [%s]: %s", z.name(), err.Error()))
+				}
+			}(z)
+		}
+	}()
+}
+
+// resolutionPath records the server addresses used, in order, during a single call to exchange().
+type resolutionPath []string
+
+func (p *resolutionPath) add(server string) {
+	if server != "" {
+		*p = append(*p, server)
+	}
+}
+
+// exchangeNonInet sends the question, as-is, to the nameservers of the most specific zone we know for the QName.
+// No DNSSEC validation is performed, and no delegations are followed.
+func (resolver *Resolver) exchangeNonInet(ctx context.Context, qmsg *dns.Msg) *Response {
+	knownZones := resolver.zones.getZoneList(qmsg.Question[0].Name)
+	if len(knownZones) == 0 {
+		return ResponseError(fmt.Errorf("%w: no zone known for [%s]", ErrInternalError, qmsg.Question[0].Name))
+	}
+
+	response := knownZones[0].exchange(ctx, qmsg)
+
+	if response.HasError() {
+		return response
+	}
+
+	if response.IsEmpty() {
+		return ResponseError(fmt.Errorf("%w - without an error. mysterious", ErrEmptyResponse))
+	}
+
+	response.Msg.RecursionAvailable = true
+	response.AuthoritativeZone = knownZones[0].name()
+	if response.server != "" {
+		response.Path = []string{response.server}
+	}
+
+	start, _ := ctx.Value(ctxStartTime).(time.Time)
+	response.Duration = time.Since(start)
+	return response
+}
+
 func (resolver *Resolver) resolveLabel(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
 	if z == nil {
 		// We must have a zone passed.
 		return nil, ResponseError(fmt.Errorf("%w: zone cannot be nil", ErrInternalError))
 	}
 
+	ctx, span := Tracer.Start(ctx, "resolver.resolveLabel")
+	defer span.End()
+	traceQuestion(span, qmsg)
+	span.SetAttribute(TraceAttrZone, z.name())
+
 	if auth != nil {
 		// If we're going to need the DNSKEY, we can pre-fetch it.
 		go z.dnskeys(ctx)
 	}
 
-	response := z.exchange(ctx, qmsg)
+	exchangeStart := time.Now()
+
+	// With QNAME minimisation, query is the minimised question if its response is used; otherwise it's qmsg.
+	query := qmsg
+	var response *Response
+	if QnameMinimisation && d != nil && !d.last() {
+		query, response = minimisedExchange(ctx, z, d.current(), qmsg)
+
+		// Some servers wrongly return NXDOMAIN for empty non-terminals, so an NXDOMAIN for a minimised name is only
+		// trusted once the full QName confirms it. See https://datatracker.ietf.org/doc/html/rfc7816#section-3
+		if query != qmsg && !response.IsEmpty() && response.Msg.Rcode == dns.RcodeNameError {
+			Debug(fmt.Sprintf("confirming NXDOMAIN for minimised name [%s] from zone [%s] with the full qname", d.current(), z.name()))
+			query, response = qmsg, nil
+		}
+	}
+
+	if response == nil && HedgeDelay > 0 && d != nil && d.last() {
+		response = hedgedExchange(ctx, z, qmsg)
+	} else if response == nil {
+		response = z.exchange(ctx, qmsg)
+	}
+	traceResponse(span, response)
+
+	if timings, ok := ctx.Value(ctxTimings).(*resolutionTimings); ok {
+		timings.addNetwork(z.name(), time.Since(exchangeStart))
+	}
+
+	if path, ok := ctx.Value(ctxPath).(*resolutionPath); ok && response != nil {
+		path.add(response.server)
+	}
 
 	if !response.IsEmpty() {
 		response.Msg.RecursionAvailable = true
@@ -149,6 +412,26 @@ func (resolver *Resolver) resolveLabel(ctx context.Context, d *domain, z zone, q
 		return nil, ResponseError(fmt.Errorf("%w - without an error. mysterious", ErrEmptyResponse))
 	}
 
+	if query != qmsg && !isReferral(response.Msg) {
+		// The minimised name exists, but is not a zone cut. We move onto the next label, in the same zone; unless the
+		// response shows the servers also host a zone at, or beneath, the name, without having referred us to it.
+		return resolver.funcs.checkForMissingZones(ctx, d, z, response.Msg, auth), nil
+	}
+
+	if NSInAnswerReferrals && isAnswerSectionReferral(z.name(), qmsg.Question[0], response.Msg) {
+		// Some servers place a referral's NS records in the Answer section. We move them to where they belong.
+		Debug(fmt.Sprintf("treating ns records in the answer from zone [%s] for [%s] as a referral", z.name(), qmsg.Question[0].Name))
+		response.Msg.Ns = append(response.Msg.Ns, response.Msg.Answer...)
+		response.Msg.Answer = nil
+	}
+
+	if isMixedReferral(qmsg.Question[0], response.Msg) {
+		// Some servers return a referral along with an answer that doesn't satisfy the question. We follow the referral,
+		// dropping the answer, as the records in it are not from a server that's authoritative for them.
+		Debug(fmt.Sprintf("ignoring %d unrelated answer records in a referral from zone [%s] for [%s]", len(response.Msg.Answer), z.name(), qmsg.Question[0].Name))
+		response.Msg.Answer = nil
+	}
+
 	//---
 
 	z = resolver.funcs.checkForMissingZones(ctx, d, z, response.Msg, auth)
@@ -159,15 +442,191 @@ func (resolver *Resolver) resolveLabel(ctx context.Context, d *domain, z zone, q
 		auth.addResponse(z, response.Msg)
 	}
 
-	if len(response.Msg.Answer) == 0 && recordsOfTypeExist(response.Msg.Ns, dns.TypeNS) && !recordsOfTypeExist(response.Msg.Ns, dns.TypeSOA) {
+	if isReferral(response.Msg) {
 		return resolver.funcs.processDelegation(ctx, z, response.Msg)
 	}
 
+	if ConfirmFinalAnswer && !response.fromCache {
+		if err := confirmAnswer(ctx, z, qmsg, response); err != nil {
+			return nil, ResponseError(err)
+		}
+	}
+
+	response.AuthoritativeZone = z.name()
 	response = resolver.funcs.finaliseResponse(ctx, auth, qmsg, response)
 	return nil, response
 
 }
 
+// confirmAnswer asks a second server in z's pool for qmsg, returning ErrAnswersDisagree if its answer differs from
+// the one in response. Only the records that answer the question are compared; see questionAnswer(). The pool's
+// rotation means the query normally goes to a different server; if it doesn't (e.g. there's only one), or the second
+// server doesn't answer, we have nothing to compare and the answer stands.
+func confirmAnswer(ctx context.Context, z zone, qmsg *dns.Msg, response *Response) error {
+	// We must not be given back the answer we're trying to confirm.
+	confirmation := z.exchange(context.WithValue(ctx, CtxNoCache, true), qmsg)
+	if confirmation.IsEmpty() || confirmation.HasError() || confirmation.server == response.server {
+		Debug(fmt.Sprintf("unable to confirm the answer for [%s] from zone [%s] with a second server", qmsg.Question[0].Name, z.name()))
+		return nil
+	}
+
+	if answersDiffer(questionAnswer(qmsg.Question[0], response.Msg), questionAnswer(qmsg.Question[0], confirmation.Msg)) {
+		Warn(fmt.Sprintf("servers %s and %s in zone [%s] returned differing answers for [%s] %s", response.server, confirmation.server, z.name(), qmsg.Question[0].Name, TypeToString(qmsg.Question[0].Qtype)))
+		return fmt.Errorf("%w: %s and %s in zone [%s] for [%s]", ErrAnswersDisagree, response.server, confirmation.server, z.name(), qmsg.Question[0].Name)
+	}
+
+	return nil
+}
+
+// questionAnswer returns a copy of msg's header and the records in its Answer section that answer question: those of
+// the QType, and any CNAME or DNAME records leading to them. DNSSEC records (e.g. RRSIGs) are dropped, unless asked
+// for, as zones signed online produce a different signature from each server for the same data.
+func questionAnswer(question dns.Question, msg *dns.Msg) *dns.Msg {
+	answer := &dns.Msg{MsgHdr: msg.MsgHdr}
+	for _, rr := range msg.Answer {
+		rtype := rr.Header().Rrtype
+		switch {
+		case rtype == question.Qtype, rtype == dns.TypeCNAME, rtype == dns.TypeDNAME:
+		case question.Qtype == dns.TypeANY && rtype != dns.TypeRRSIG && rtype != dns.TypeNSEC && rtype != dns.TypeNSEC3:
+		default:
+			continue
+		}
+		answer.Answer = append(answer.Answer, rr)
+	}
+	return answer
+}
+
+// hedgedExchange sends qmsg to z and, if no response has arrived within HedgeDelay, sends it again. The pool's
+// rotation means the second query normally goes to a different server. The first successful response is returned.
+// If both fail, the first failure is returned. Once we have a response, the other query is cancelled.
+func hedgedExchange(ctx context.Context, z zone, qmsg *dns.Msg) *Response {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	responses := make(chan *Response, 2)
+	send := func() {
+		responses <- z.exchange(ctx, qmsg)
+	}
+	go send()
+
+	hedge := time.NewTimer(HedgeDelay)
+	defer hedge.Stop()
+
+	var failed *Response
+	for sent, received := 1, 0; received < sent; {
+		select {
+		case <-hedge.C:
+			if sent == 1 {
+				Debug(fmt.Sprintf("no response for [%s] from zone [%s] after %s; sending a hedged query", qmsg.Question[0].Name, z.name(), HedgeDelay))
+				go send()
+				sent++
+			}
+		case response := <-responses:
+			received++
+			// Before the hedge is sent, the first response is used regardless; the pool will have already retried it.
+			if sent == 1 || (!response.IsEmpty() && !response.HasError()) {
+				return response
+			}
+			if failed == nil {
+				failed = response
+			}
+		}
+	}
+
+	return failed
+}
+
+// minimisedExchange asks z for name, the next label of qmsg's QName, with type NS; or type A, if the server doesn't
+// implement, or understand, NS queries. If the response is a referral, an NXDOMAIN, or shows name exists without being
+// a zone cut, the minimised question and its response are returned. A failed exchange is also returned, as the pool
+// will have already retried it. Otherwise, including when the server fails or refuses the minimised query (whether or
+// not the response also carries an error), qmsg and nil are returned, and qmsg should be sent as-is.
+func minimisedExchange(ctx context.Context, z zone, name string, qmsg *dns.Msg) (*dns.Msg, *Response) {
+	for _, qtype := range []uint16{dns.TypeNS, dns.TypeA} {
+		mmsg := qmsg.Copy()
+		mmsg.Question[0].Name = name
+		mmsg.Question[0].Qtype = qtype
+
+		response := z.exchange(ctx, mmsg)
+
+		// The rcode is checked first, as a response carrying one may also carry an error.
+		if !response.IsEmpty() {
+			switch response.Msg.Rcode {
+			case dns.RcodeSuccess, dns.RcodeNameError:
+			case dns.RcodeNotImplemented, dns.RcodeFormatError:
+				continue
+			default:
+				Debug(fmt.Sprintf("minimised query for [%s] in zone [%s] returned %s; sending the full qname", name, z.name(), RcodeToString(response.Msg.Rcode)))
+				return qmsg, nil
+			}
+		}
+
+		if response.IsEmpty() || response.HasError() || response.Msg.Rcode == dns.RcodeNameError {
+			return mmsg, response
+		}
+
+		if isReferral(response.Msg) || len(response.Msg.Answer) == 0 {
+			return mmsg, response
+		}
+
+		// Any answer means the zone is authoritative for name, so may be for the QName too.
+		return qmsg, nil
+	}
+
+	Debug(fmt.Sprintf("unable to use a minimised query for [%s] in zone [%s]; sending the full qname", name, z.name()))
+	return qmsg, nil
+}
+
+// isReferral reports if rmsg is a referral. i.e. it has no answer, and NS records, but no SOA, in the Authority section.
+func isReferral(rmsg *dns.Msg) bool {
+	return len(rmsg.Answer) == 0 && recordsOfTypeExist(rmsg.Ns, dns.TypeNS) && !recordsOfTypeExist(rmsg.Ns, dns.TypeSOA)
+}
+
+// isMixedReferral reports if rmsg is a non-authoritative referral (NS records, but no SOA, in the Authority section)
+// that also carries Answer records, none of which satisfy the question. i.e. none are owned by the QName with the
+// QType, or a CNAME.
+func isMixedReferral(question dns.Question, rmsg *dns.Msg) bool {
+	if rmsg.Authoritative || len(rmsg.Answer) == 0 {
+		return false
+	}
+	if !recordsOfTypeExist(rmsg.Ns, dns.TypeNS) || recordsOfTypeExist(rmsg.Ns, dns.TypeSOA) {
+		return false
+	}
+	for _, rr := range rmsg.Answer {
+		if namesEqual(rr.Header().Name, question.Name) && (rr.Header().Rrtype == question.Qtype || rr.Header().Rrtype == dns.TypeCNAME) {
+			return false
+		}
+	}
+	return true
+}
+
+// isAnswerSectionReferral reports if rmsg is a referral from zone whose NS records were placed in the Answer section.
+// i.e. the Answer contains only NS records, all owned by the same strict child of zone that's also an ancestor of the
+// QName (or the QName itself, unless the QType is NS), and the Authority section has no NS or SOA records.
+func isAnswerSectionReferral(zone string, question dns.Question, rmsg *dns.Msg) bool {
+	if len(rmsg.Answer) == 0 || recordsOfTypeExist(rmsg.Ns, dns.TypeNS) || recordsOfTypeExist(rmsg.Ns, dns.TypeSOA) {
+		return false
+	}
+
+	owner := rmsg.Answer[0].Header().Name
+	for _, rr := range rmsg.Answer {
+		if rr.Header().Rrtype != dns.TypeNS || !namesEqual(rr.Header().Name, owner) {
+			return false
+		}
+	}
+
+	if namesEqual(owner, zone) || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, question.Name) {
+		return false
+	}
+
+	// The NS records may legitimately be the answer.
+	if question.Qtype == dns.TypeNS && namesEqual(owner, question.Name) {
+		return false
+	}
+
+	return true
+}
+
 func (resolver *Resolver) checkForMissingZones(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
 	records := append(rmsg.Ns, rmsg.Answer...)
 	if len(records) == 0 {
@@ -198,17 +657,42 @@ func (resolver *Resolver) checkForMissingZones(ctx context.Context, d *domain, z
 	// So if it's not what we get, we expect it to be included in the missing zones slice.
 
 	missingZoneNames := d.gap(nextRecordsOwner)
-	for _, missingDomain := range missingZoneNames {
 
-		soa, err := z.soa(ctx, missingDomain)
+	// An SOA owned by nextRecordsOwner suggests it's the apex of a zone too. e.g. the same servers host it and its
+	// parent, so answered from it without a referral. Unlike the names before it, it's the label the response is for,
+	// so we don't skip over it.
+	gapLength := len(missingZoneNames)
+	if nextRecordsOwner != z.name() && dns.IsSubDomain(d.current(), nextRecordsOwner) && slices.ContainsFunc(rmsg.Ns, func(rr dns.RR) bool {
+		return rr.Header().Rrtype == dns.TypeSOA && namesEqual(rr.Header().Name, nextRecordsOwner)
+	}) {
+		missingZoneNames = append(missingZoneNames, nextRecordsOwner)
+	}
+
+	// Where there are several missing domains, we can probe them all at once. Each zone found shares its parent's
+	// nameservers, so asking the parent gives the same answers as asking each new zone in turn.
+	var isApex []bool
+	concurrent := MissingZoneProbeConcurrency > 1 && len(missingZoneNames) > 1
+	if concurrent {
+		isApex = probeZoneApexes(ctx, z, missingZoneNames)
+	}
+
+	for i, missingDomain := range missingZoneNames {
+
+		var found bool
+		if concurrent {
+			found = isApex[i]
+		} else {
+			soa, err := z.soa(ctx, missingDomain)
+			found = err == nil && soa != nil
+		}
 
 		// If a SOA was found, then the missingDomain is its own zone.
-		if err == nil && soa != nil {
+		if found {
 
 			newZone := z.clone(missingDomain, z.name())
 
 			if auth != nil {
-				auth.addDelegationSignerLink(z, newZone.name())
+				auth.addDelegationSignerLink(z, newZone)
 			}
 
 			resolver.zones.add(newZone)
@@ -217,12 +701,35 @@ func (resolver *Resolver) checkForMissingZones(ctx context.Context, d *domain, z
 		}
 
 		// We skip over these missing domains in our lookup loop.
-		d.next()
+		if i < gapLength {
+			d.next()
+		}
 	}
 
 	return z
 }
 
+// probeZoneApexes asks z, concurrently, for the SOA of each of the names. The result reports, for each name in the
+// same position, if it's the apex of a zone. At most MissingZoneProbeConcurrency are asked at the same time.
+func probeZoneApexes(ctx context.Context, z zone, names []string) []bool {
+	isApex := make([]bool, len(names))
+
+	sem := make(chan struct{}, max(MissingZoneProbeConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			soa, err := z.soa(ctx, name)
+			isApex[i] = err == nil && soa != nil
+		}()
+	}
+	wg.Wait()
+
+	return isApex
+}
+
 func (resolver *Resolver) processDelegation(ctx context.Context, z zone, rmsg *dns.Msg) (zone, *Response) {
 	// Otherwise - onwards to the next zone...
 	nameservers := extractRecords[*dns.NS](rmsg.Ns)
@@ -246,16 +753,58 @@ func (resolver *Resolver) processDelegation(ctx context.Context, z zone, rmsg *d
 		return nil, ResponseError(err)
 	}
 
+	// Any DS records included with the delegation bound how long the new zone's DNSKEYs can be held for.
+	newZone.linkDelegationSigners(rmsg.Ns)
+
 	resolver.zones.add(newZone)
 
 	return newZone, nil
 }
 
 func (resolver *Resolver) finaliseResponse(ctx context.Context, auth *authenticator, qmsg *dns.Msg, response *Response) *Response {
-	if auth != nil {
+	// The zone that answered must be authoritative for the QName. If it's not, we somehow took a wrong turn.
+	if response.AuthoritativeZone != "" && !dns.IsSubDomain(response.AuthoritativeZone, qmsg.Question[0].Name) {
+		return ResponseError(fmt.Errorf("%w: zone [%s] answered for [%s]", ErrAnswerOutOfBailiwick, response.AuthoritativeZone, qmsg.Question[0].Name))
+	}
+
+	if RequireAuthoritativeAnswers && !response.Msg.Authoritative && !response.fromCache {
+		return ResponseError(fmt.Errorf("%w: for [%s] from zone [%s] on %s", ErrNotAuthoritative, qmsg.Question[0].Name, response.AuthoritativeZone, response.server))
+	}
+
+	if IncludeRawResponse {
+		response.Raw = response.Msg.Copy()
+	}
+
+	if auth != nil && auth.deferred != nil {
+		// The validation is left to the caller.
+		response.DeferredValidation, response.Err = auth.deferredValidation()
+	} else if auth != nil {
+		_, span := Tracer.Start(ctx, "resolver.dnssec")
 		authTime := time.Now()
 		response.Auth, response.Deo, response.Err = auth.result()
-		Info(fmt.Sprintf("DNSSEC took %s to return an answer of %s and DOE %s", time.Since(authTime), response.Auth.String(), response.Deo.String()))
+		response.Validated = true
+		response.Wildcard = auth.wildcard()
+		response.BogusReason = auth.bogusReason()
+		response.InsecureReason = auth.insecureReason()
+		response.DeoExplanation = auth.deoExplanation()
+		response.Chain = auth.chain()
+		response.TrustAnchors = auth.trustAnchors()
+		response.FetchFailures = auth.fetchFailures()
+		if timings, ok := ctx.Value(ctxTimings).(*resolutionTimings); ok {
+			timings.addValidation(response.Chain)
+		}
+		if IncludeDenialRecords && response.Auth == dnssec.Secure && negativeDenialOfExistence(response.Deo) {
+			response.DenialRecords = auth.denialRecords()
+		}
+		if dnssec.ValidateQuestionRRsetsOnly && !response.IsEmpty() {
+			// Records that weren't validated mustn't be returned alongside an AD bit that vouches for them.
+			response.Msg.Answer = dnssec.QuestionRRsets(response.Msg.Answer, qmsg.Question[0].Qtype)
+		}
+		response.ValidationDuration = time.Since(authTime)
+		Info(fmt.Sprintf("DNSSEC took %s to return an answer of %s and DOE %s", response.ValidationDuration, response.Auth.String(), response.Deo.String()))
+		span.SetAttribute(TraceAttrDNSSECResult, response.Auth.String())
+		span.SetAttribute(TraceAttrDNSSECDenial, response.Deo.String())
+		span.End()
 	}
 
 	//---
@@ -264,16 +813,41 @@ func (resolver *Resolver) finaliseResponse(ctx context.Context, auth *authentica
 	if qmsg.Question[0].Qtype != dns.TypeCNAME && recordsOfTypeExist(response.Msg.Answer, dns.TypeCNAME) {
 		// The results from this are added to `response.Msg`.
 		err := resolver.funcs.cname(ctx, qmsg, response, resolver.funcs.getExchanger())
-		if err != nil {
+		if err != nil && bestEffort(ctx, err) {
+			// We return what we have of the chain so far.
+			Debug(fmt.Sprintf("returning a partial answer for [%s]: %s", qmsg.Question[0].Name, err.Error()))
+			response.Partial = true
+		} else if err != nil {
 			return &Response{
 				Err: err,
 			}
 		}
 	}
 
+	// Optionally resolve the address of the CNAME's target, even though the CNAME itself was asked for.
+	if ResolveCNAMETarget && qmsg.Question[0].Qtype == dns.TypeCNAME && recordsOfTypeExist(response.Msg.Answer, dns.TypeCNAME) {
+		for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			targetQMsg := qmsg.Copy()
+			targetQMsg.Question[0].Qtype = t
+
+			// This is only a convenience, so it's done on a copy of the response, which is only kept if the target
+			// was resolved successfully. Otherwise the CNAME is returned as it was, with its own rcode.
+			attempt := *response
+			attempt.Msg = response.Msg.Copy()
+
+			// The results from this are appended to `attempt.Msg`, after the CNAME.
+			err := resolver.funcs.cname(ctx, targetQMsg, &attempt, resolver.funcs.getExchanger())
+			if err != nil || attempt.Msg.Rcode != response.Msg.Rcode {
+				Debug(fmt.Sprintf("unable to resolve the %s records of the cname target for [%s]", TypeToString(t), qmsg.Question[0].Name))
+				continue
+			}
+			*response = attempt
+		}
+	}
+
 	// We'll consider both of these 'normal' responses.
 	if !(response.Msg.Rcode == dns.RcodeSuccess || response.Msg.Rcode == dns.RcodeNameError) {
-		response.Err = fmt.Errorf("unsuccessful response code %s (%d)", RcodeToString(response.Msg.Rcode), response.Msg.Rcode)
+		response.Err = &RcodeError{rcode: response.Msg.Rcode}
 	}
 
 	//---
@@ -298,17 +872,29 @@ func (resolver *Resolver) finaliseResponse(ctx context.Context, auth *authentica
 		}
 	}
 
+	// Records in the answer that are not part of the answer to the question are dropped.
+	response.Msg.Answer = removeUnsolicitedRecords(response.Msg.Answer, qmsg.Question[0].Name)
+
+	// A very high TTL could be used to pin a bad record in downstream caches, so we cap them.
+	capTTLs(response.Msg.Answer, MaxAllowedTTL)
+	capTTLs(response.Msg.Ns, MaxAllowedTTL)
+	capTTLs(response.Msg.Extra, MaxAllowedTTL)
+
+	// Once deduplicated, we sort the sections so that identical logical answers always result in identical messages.
 	dedup := make(map[string]dns.RR)
 	if len(response.Msg.Answer) > 0 {
-		response.Msg.Answer = dns.Dedup(response.Msg.Answer, dedup)
+		response.Msg.Answer = sortRRsets(dns.Dedup(response.Msg.Answer, dedup), qmsg.Question[0].Name)
+		if AnswerRecordsFirst {
+			response.Msg.Answer = answerRecordsFirst(response.Msg.Answer, qmsg.Question[0].Qtype)
+		}
 	}
 	if len(response.Msg.Ns) > 0 {
 		clear(dedup)
-		response.Msg.Ns = dns.Dedup(response.Msg.Ns, dedup)
+		response.Msg.Ns = sortRRsets(dns.Dedup(response.Msg.Ns, dedup), "")
 	}
 	if len(response.Msg.Extra) > 0 {
 		clear(dedup)
-		response.Msg.Extra = dns.Dedup(response.Msg.Extra, dedup)
+		response.Msg.Extra = sortRRsets(dns.Dedup(response.Msg.Extra, dedup), "")
 	}
 
 	if auth != nil {
@@ -324,11 +910,15 @@ func (resolver *Resolver) finaliseResponse(ctx context.Context, auth *authentica
 			      current time.
 		*/
 
+		response.EnforcementSkipped = response.Validated && qmsg.CheckingDisabled
+
 		if !qmsg.CheckingDisabled {
-			response.Msg.AuthenticatedData = response.Auth == dnssec.Secure
+			// We can't vouch for the whole of a partial answer.
+			response.Msg.AuthenticatedData = response.Auth == dnssec.Secure && !response.Partial
 
 			// If a response is Bogus, we return a Server Failure with all the response removed.
-			if response.Auth == dnssec.Bogus {
+			// The same applies if we were unable to determine the response's validity, as it cannot be trusted.
+			if response.Auth == dnssec.Bogus || response.Auth == dnssec.Indeterminate {
 				response.Msg.Rcode = dns.RcodeServerFailure
 				if SuppressBogusResponseSections {
 					response.Msg.Answer = []dns.RR{}
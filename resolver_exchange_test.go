@@ -3,11 +3,16 @@ package resolver
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"math/rand"
 	"net"
 	"regexp"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -161,6 +166,50 @@ func TestResolver_Exchange_Context(t *testing.T) {
 
 }
 
+func TestResolver_Exchange_MaxResolveDuration(t *testing.T) {
+	defer func() { MaxResolveDuration = DefaultMaxResolveDuration }()
+
+	resolver := getTestResolverWithRoot()
+
+	// A slow zone, that only returns once the context ends.
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		select {
+		case <-ctx.Done():
+			return nil, ResponseError(ctx.Err())
+		case <-time.After(5 * time.Second):
+			return nil, ResponseError(ErrInternalError)
+		}
+	}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("example.com.", dns.TypeA)
+
+	// With no context deadline, the resolver's limit applies.
+	MaxResolveDuration = 20 * time.Millisecond
+	start := time.Now()
+	response := resolver.Exchange(context.Background(), qmsg)
+	assert.ErrorIs(t, response.Err, ErrResolveTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// A longer context deadline doesn't extend it.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start = time.Now()
+	response = resolver.Exchange(ctx, qmsg)
+	assert.ErrorIs(t, response.Err, ErrResolveTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// A shorter context deadline wins, and is reported as such.
+	MaxResolveDuration = 5 * time.Second
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	response = resolver.Exchange(ctx, qmsg)
+	assert.ErrorIs(t, response.Err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, response.Err, ErrResolveTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
 func TestResolver_Exchange_DOSet(t *testing.T) {
 
 	// Tests that an authenticator is created when the DO-bit is set.
@@ -181,6 +230,51 @@ func TestResolver_Exchange_DOSet(t *testing.T) {
 	assert.NotNil(t, authSeen)
 }
 
+func TestResolver_Exchange_ChaosClass(t *testing.T) {
+
+	// Non-IN class queries are sent directly to the closest known zone, without walking the labels or DNSSEC.
+
+	resolver := getTestResolverWithRoot()
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("version.bind.", dns.TypeTXT)
+	qmsg.Question[0].Qclass = dns.ClassCHAOS
+	qmsg.SetEdns0(4096, true)
+
+	resolveLabelCalled := 0
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		resolveLabelCalled++
+		return nil, &Response{}
+	}
+
+	txt := &dns.TXT{Hdr: dns.RR_Header{Name: "version.bind.", Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS}, Txt: []string{"test"}}
+
+	root := resolver.zones.getZoneList(".")[0].(*mockZone)
+	rootExchangeCalled := 0
+	root.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		rootExchangeCalled++
+		assert.Equal(t, "version.bind.", m.Question[0].Name)
+		assert.Equal(t, dns.TypeTXT, m.Question[0].Qtype)
+		assert.Equal(t, uint16(dns.ClassCHAOS), m.Question[0].Qclass)
+		rmsg := m.SetReply(&dns.Msg{})
+		rmsg.Answer = []dns.RR{txt}
+		return &Response{Msg: rmsg}
+	}
+
+	response := resolver.Exchange(context.Background(), qmsg)
+
+	assert.False(t, response.HasError())
+	assert.Equal(t, 0, resolveLabelCalled)
+	assert.Equal(t, 1, rootExchangeCalled)
+	require.False(t, response.IsEmpty())
+	assert.Equal(t, []dns.RR{txt}, response.Msg.Answer)
+	assert.True(t, response.Msg.RecursionAvailable)
+	assert.Equal(t, uint16(dns.ClassCHAOS), response.Msg.Question[0].Qclass)
+
+	// No DNSSEC validation is attempted.
+	assert.Equal(t, dnssec.Unknown, response.Auth)
+}
+
 func TestResolver_Exchange_NonApexResultWithKnownHosts(t *testing.T) {
 
 	resolver, root, com, example, _ := getTestResolverWithExample()
@@ -352,6 +446,8 @@ func TestResolver_ResolveLabel_ZoneIsNil(t *testing.T) {
 }
 
 func TestResolver_ResolveLabel_ErrorFromExchange(t *testing.T) {
+	defer func() { QnameMinimisation = DefaultQnameMinimisation }()
+	QnameMinimisation = false
 
 	ErrTest := errors.New("test error")
 
@@ -385,6 +481,8 @@ func TestResolver_ResolveLabel_ErrorFromExchange(t *testing.T) {
 }
 
 func TestResolver_ResolveLabel_EmptyFromExchange(t *testing.T) {
+	defer func() { QnameMinimisation = DefaultQnameMinimisation }()
+	QnameMinimisation = false
 
 	resolver, _, _, example, _ := getTestResolverWithExample()
 
@@ -413,6 +511,8 @@ func TestResolver_ResolveLabel_EmptyFromExchange(t *testing.T) {
 }
 
 func TestResolver_ResolveLabel_Process(t *testing.T) {
+	defer func() { QnameMinimisation = DefaultQnameMinimisation }()
+	QnameMinimisation = false
 
 	// We consider a query as needing further delegation if:
 	//	- Zero Answers are returned; and
@@ -506,10 +606,10 @@ func TestResolver_ResolveLabel_Process(t *testing.T) {
 
 	//---
 
-	// If there was also a record in the Answer section, we should end up back at finaliseResponse().
+	// If there was also a record in the Answer section that satisfies the question, we should end up back at finaliseResponse().
 
 	testResponse1.Msg.Answer = []dns.RR{
-		&dns.MX{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX}, Mx: "mx.example.com."},
+		&dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA}, A: net.ParseIP("192.0.2.1")},
 	}
 
 	z, r = resolver.resolveLabel(ctx, &d, example, qmsg, nil)
@@ -542,6 +642,625 @@ func TestResolver_ResolveLabel_Process(t *testing.T) {
 
 }
 
+func TestResolver_ResolveLabel_ConfirmFinalAnswer(t *testing.T) {
+	defer func() { ConfirmFinalAnswer = DefaultConfirmFinalAnswer }()
+	ConfirmFinalAnswer = true
+
+	resolver, _, _, example, _ := getTestResolverWithExample()
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.Background()
+	d := newDomain(qmsg.Question[0].Name)
+	require.NoError(t, d.windTo("www.example.com."))
+
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return z
+	}
+	resolver.funcs.finaliseResponse = func(ctx context.Context, auth *authenticator, qmsg *dns.Msg, r *Response) *Response {
+		return r
+	}
+
+	// Each call goes to the next server in the pool, each of which answers with its entry in answers.
+	var answers []string
+	var calls int
+	var noCache []bool
+	var signed bool
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		answer := answers[calls%len(answers)]
+		calls++
+		skip, _ := ctx.Value(CtxNoCache).(bool)
+		noCache = append(noCache, skip)
+
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A " + answer)}
+		if signed {
+			// As a zone signed online would, each server returns a different signature.
+			rmsg.Answer = append(rmsg.Answer, &dns.RRSIG{
+				Hdr:         dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+				TypeCovered: dns.TypeA,
+				Algorithm:   dns.ECDSAP256SHA256,
+				Labels:      3,
+				OrigTtl:     300,
+				SignerName:  "example.com.",
+				Signature:   fmt.Sprintf("c2lnbmF0dXJl%d", calls),
+			})
+		}
+		return &Response{Msg: rmsg, server: fmt.Sprintf("192.0.2.%d:53", calls)}
+	}
+
+	// When the two servers disagree, the answer is rejected.
+	answers = []string{"198.51.100.1", "198.51.100.2"}
+	_, r := resolver.resolveLabel(ctx, &d, example, qmsg, nil)
+	assert.ErrorIs(t, r.Err, ErrAnswersDisagree)
+	assert.Equal(t, 2, calls)
+
+	// The confirmation must be asked of a server, not the cache.
+	assert.Equal(t, []bool{false, true}, noCache)
+
+	// When they agree, it's accepted.
+	answers, calls = []string{"198.51.100.1"}, 0
+	_, r = resolver.resolveLabel(ctx, &d, example, qmsg, nil)
+	assert.NoError(t, r.Err)
+	assert.Len(t, r.Msg.Answer, 1)
+	assert.Equal(t, 2, calls)
+
+	// Only the records answering the question are compared; differing signatures over the same data are accepted.
+	answers, calls, signed = []string{"198.51.100.1"}, 0, true
+	_, r = resolver.resolveLabel(ctx, &d, example, qmsg, nil)
+	assert.NoError(t, r.Err)
+	assert.Len(t, r.Msg.Answer, 2)
+	assert.Equal(t, 2, calls)
+}
+
+func TestResolver_ResolveLabel_HedgeDelay(t *testing.T) {
+	defer func() { QnameMinimisation = DefaultQnameMinimisation }()
+	QnameMinimisation = false
+	defer func() { HedgeDelay = DefaultHedgeDelay }()
+	HedgeDelay = 20 * time.Millisecond
+
+	resolver, _, _, example, _ := getTestResolverWithExample()
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.Background()
+
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return z
+	}
+	resolver.funcs.finaliseResponse = func(ctx context.Context, auth *authenticator, qmsg *dns.Msg, r *Response) *Response {
+		return r
+	}
+
+	// The first server is slow to respond; the second is quick.
+	var calls atomic.Int32
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		call := calls.Add(1)
+		if call == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Answer = []dns.RR{newRR(fmt.Sprintf("www.example.com. 300 IN A 192.0.2.%d", call))}
+		return &Response{Msg: rmsg}
+	}
+
+	// Querying for the QName itself, the hedged query to the second server wins.
+	d := newDomain(qmsg.Question[0].Name)
+	require.NoError(t, d.windTo("www.example.com."))
+	require.True(t, d.last())
+
+	start := time.Now()
+	_, r := resolver.resolveLabel(ctx, &d, example, qmsg, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, "192.0.2.2", r.Msg.Answer[0].(*dns.A).A.String())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Queries earlier in the walk are not hedged.
+	calls.Store(1)
+	d = newDomain(qmsg.Question[0].Name)
+	require.NoError(t, d.windTo("example.com."))
+	require.False(t, d.last())
+
+	_, r = resolver.resolveLabel(ctx, &d, example, qmsg, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, "192.0.2.2", r.Msg.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+// getMinimisationTestResolver returns a resolver that knows only the root, with com. and example.com. delegated
+// beneath it. Each zone's exchanges are answered by answer, and the questions each zone was asked are recorded. A
+// zone's SOA lookups are also answered by answer, but aren't recorded.
+func getMinimisationTestResolver(answer func(zone string, m *dns.Msg) *dns.Msg) (*Resolver, map[string][]string) {
+	var lock sync.Mutex
+	asked := make(map[string][]string)
+
+	var newZone func(name, parent string) *mockZone
+	newZone = func(name, parent string) *mockZone {
+		z := getMockZone(name, parent)
+		z.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+			lock.Lock()
+			asked[name] = append(asked[name], m.Question[0].Name+" "+TypeToString(m.Question[0].Qtype))
+			lock.Unlock()
+			return &Response{Msg: answer(name, m)}
+		}
+		z.mockSoa = func(ctx context.Context, name string) (*dns.SOA, error) {
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeSOA)
+			if soa := extractRecords[*dns.SOA](answer(z.name(), m).Answer); len(soa) > 0 && namesEqual(soa[0].Hdr.Name, name) {
+				return soa[0], nil
+			}
+			return nil, nil
+		}
+		z.mockClone = func(name, parent string) zone {
+			return newZone(name, parent)
+		}
+		return z
+	}
+
+	root := newZone(".", "")
+	zones := map[string]zone{".": root}
+
+	resolver := &Resolver{
+		zones: mockZoneStore{
+			mockGet: func(name string) zone {
+				return zones[name]
+			},
+			mockAdd: func(z zone) {
+				zones[z.name()] = z
+			},
+			mockZoneList: func(name string) []zone {
+				return []zone{root}
+			},
+		},
+	}
+
+	resolver.funcs = resolverFunctions{
+		resolveLabel:         resolver.resolveLabel,
+		checkForMissingZones: resolver.checkForMissingZones,
+		createZone: func(ctx context.Context, name, parent string, nameservers []*dns.NS, extra []dns.RR, exchanger exchanger) (zone, error) {
+			return newZone(name, parent), nil
+		},
+		finaliseResponse:  resolver.finaliseResponse,
+		processDelegation: resolver.processDelegation,
+		cname:             cname,
+		getExchanger:      resolver.getExchanger,
+	}
+
+	return resolver, asked
+}
+
+// minimisationTestReferral returns a referral to child, in reply to m.
+func minimisationTestReferral(m *dns.Msg, child string) *dns.Msg {
+	rmsg := new(dns.Msg).SetReply(m)
+	rmsg.Ns = []dns.RR{newRR(child + " 3600 IN NS ns1." + child)}
+	return rmsg
+}
+
+func TestResolver_Exchange_QnameMinimisation(t *testing.T) {
+	answer := func(zone string, m *dns.Msg) *dns.Msg {
+		switch zone {
+		case ".":
+			return minimisationTestReferral(m, "com.")
+		case "com.":
+			return minimisationTestReferral(m, "example.com.")
+		}
+
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Authoritative = true
+		if m.Question[0].Name == "www.sub.example.com." {
+			rmsg.Answer = []dns.RR{newRR("www.sub.example.com. 300 IN A 192.0.2.1")}
+		} else {
+			// sub.example.com. is an empty non-terminal.
+			rmsg.Ns = []dns.RR{newRR("example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300")}
+		}
+		return rmsg
+	}
+
+	resolver, asked := getMinimisationTestResolver(answer)
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.sub.example.com.", dns.TypeA)
+
+	r := resolver.Exchange(context.Background(), qmsg)
+	require.NoError(t, r.Err)
+	require.Len(t, r.Msg.Answer, 1)
+
+	// Each zone above the authoritative one only sees the next label; only example.com. sees the full question.
+	assert.Equal(t, []string{"com. NS"}, asked["."])
+	assert.Equal(t, []string{"example.com. NS"}, asked["com."])
+	assert.Equal(t, []string{"sub.example.com. NS", "www.sub.example.com. A"}, asked["example.com."])
+
+	// When disabled, every zone sees the full question.
+	defer func() { QnameMinimisation = DefaultQnameMinimisation }()
+	QnameMinimisation = false
+
+	resolver, asked = getMinimisationTestResolver(answer)
+	r = resolver.Exchange(context.Background(), qmsg)
+	require.NoError(t, r.Err)
+	assert.Equal(t, []string{"www.sub.example.com. A"}, asked["."])
+	assert.Equal(t, []string{"www.sub.example.com. A"}, asked["com."])
+	assert.Equal(t, []string{"www.sub.example.com. A"}, asked["example.com."])
+}
+
+func TestResolver_Exchange_QnameMinimisationFallbacks(t *testing.T) {
+
+	// How the minimised query to com. is answered, and the questions we then expect com. to have been asked.
+	tests := []struct {
+		name   string
+		rcode  int
+		err    error
+		asked  []string
+		answer bool
+	}{
+		{"refused goes straight to the full qname", dns.RcodeRefused, nil, []string{"example.com. NS", "www.sub.example.com. A"}, true},
+		{"servfail goes straight to the full qname", dns.RcodeServerFailure, nil, []string{"example.com. NS", "www.sub.example.com. A"}, true},
+		{"notimp retries with type A", dns.RcodeNotImplemented, nil, []string{"example.com. NS", "example.com. A"}, true},
+		{"an error is returned without further queries", dns.RcodeSuccess, ErrNetworkUnreachable, []string{"example.com. NS"}, false},
+		{"servfail with an error goes straight to the full qname", dns.RcodeServerFailure, ErrPoolUnavailable, []string{"example.com. NS", "www.sub.example.com. A"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, asked := getMinimisationTestResolver(func(zone string, m *dns.Msg) *dns.Msg {
+				rmsg := new(dns.Msg).SetReply(m)
+				switch {
+				case zone == ".":
+					return minimisationTestReferral(m, "com.")
+				case zone == "com." && m.Question[0].Qtype == dns.TypeNS:
+					rmsg.Rcode = tt.rcode
+				case zone == "com.":
+					return minimisationTestReferral(m, "example.com.")
+				case m.Question[0].Name == "www.sub.example.com.":
+					rmsg.Answer = []dns.RR{newRR("www.sub.example.com. 300 IN A 192.0.2.1")}
+				default:
+					rmsg.Ns = []dns.RR{newRR("example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300")}
+				}
+				return rmsg
+			})
+
+			if tt.err != nil {
+				com := getMockZone("com.", ".")
+				com.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+					asked["com."] = append(asked["com."], m.Question[0].Name+" "+TypeToString(m.Question[0].Qtype))
+					if tt.rcode == dns.RcodeSuccess {
+						return ResponseError(tt.err)
+					}
+					if m.Question[0].Qtype == dns.TypeNS {
+						rmsg := new(dns.Msg).SetReply(m)
+						rmsg.Rcode = tt.rcode
+						return &Response{Msg: rmsg, Err: tt.err}
+					}
+					return &Response{Msg: minimisationTestReferral(m, "example.com.")}
+				}
+				resolver.zones.add(com)
+			}
+
+			qmsg := new(dns.Msg)
+			qmsg.SetQuestion("www.sub.example.com.", dns.TypeA)
+
+			r := resolver.Exchange(context.Background(), qmsg)
+			assert.Equal(t, tt.asked, asked["com."])
+
+			if tt.answer {
+				require.NoError(t, r.Err)
+				assert.Len(t, r.Msg.Answer, 1)
+			} else {
+				assert.ErrorIs(t, r.Err, tt.err)
+			}
+		})
+	}
+}
+
+func TestResolver_Exchange_QnameMinimisationFallbacksThroughPool(t *testing.T) {
+
+	// As above, but through a real zone and nameserver pool, which retries a SERVFAIL or REFUSED before returning it.
+	// The minimised query failing should still result in the full qname being sent.
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	for _, rcode := range []int{dns.RcodeServerFailure, dns.RcodeRefused} {
+		t.Run(dns.RcodeToString[rcode], func(t *testing.T) {
+			var asked []string
+			client := &testSessionDNSClient{
+				f: func(msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+					rmsg := new(dns.Msg).SetReply(msg)
+					switch {
+					case addr == "198.41.0.4:53":
+						asked = append(asked, msg.Question[0].Name+" "+TypeToString(msg.Question[0].Qtype))
+						if msg.Question[0].Name != "www.example." {
+							rmsg.Rcode = rcode
+							break
+						}
+						rmsg.Ns = []dns.RR{newRR("example. 60 IN NS ns1.example.")}
+						rmsg.Extra = []dns.RR{newRR("ns1.example. 60 IN A 192.0.2.53")}
+					default:
+						rmsg.Authoritative = true
+						rmsg.Answer = []dns.RR{newRR("www.example. 60 IN A 192.0.2.1")}
+					}
+					return rmsg, time.Millisecond, nil
+				},
+			}
+
+			qmsg := new(dns.Msg)
+			qmsg.SetQuestion("www.example.", dns.TypeA)
+
+			r := getTestSessionResolver(client).Exchange(context.Background(), qmsg)
+			require.NoError(t, r.Err)
+			assert.Len(t, r.Msg.Answer, 1)
+
+			// The pool tries the minimised query twice, before the full qname is sent.
+			assert.Equal(t, []string{"example. NS", "example. NS", "www.example. A"}, asked)
+		})
+	}
+}
+
+func TestResolver_Exchange_QnameMinimisationHostedChildZone(t *testing.T) {
+
+	// example.com.'s servers also host sub.example.com., so answer the minimised query from it, with NODATA, rather
+	// than a referral. We expect sub.example.com. to be found as a zone, and to be sent the full QName.
+
+	resolver, asked := getMinimisationTestResolver(func(zone string, m *dns.Msg) *dns.Msg {
+		switch zone {
+		case ".":
+			return minimisationTestReferral(m, "com.")
+		case "com.":
+			return minimisationTestReferral(m, "example.com.")
+		}
+
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Authoritative = true
+		soa := newRR("sub.example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300")
+		switch {
+		case m.Question[0].Name == "www.sub.example.com.":
+			rmsg.Answer = []dns.RR{newRR("www.sub.example.com. 300 IN A 192.0.2.1")}
+		case m.Question[0].Qtype == dns.TypeSOA:
+			rmsg.Answer = []dns.RR{soa}
+		default:
+			rmsg.Ns = []dns.RR{soa}
+		}
+		return rmsg
+	})
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.sub.example.com.", dns.TypeA)
+
+	r := resolver.Exchange(context.Background(), qmsg)
+	require.NoError(t, r.Err)
+	require.Len(t, r.Msg.Answer, 1)
+
+	assert.Equal(t, []string{"sub.example.com. NS"}, asked["example.com."])
+	assert.Equal(t, []string{"www.sub.example.com. A"}, asked["sub.example.com."])
+	assert.Equal(t, "sub.example.com.", r.AuthoritativeZone)
+	assert.NotNil(t, resolver.zones.get("sub.example.com."))
+}
+
+func TestResolver_Exchange_QnameMinimisationNXDOMAIN(t *testing.T) {
+
+	// example.com. returns NXDOMAIN for the minimised name. Rather than trusting it, or carrying on down the labels,
+	// the full QName is asked straight away.
+
+	for _, exists := range []bool{true, false} {
+		resolver, asked := getMinimisationTestResolver(func(zone string, m *dns.Msg) *dns.Msg {
+			switch zone {
+			case ".":
+				return minimisationTestReferral(m, "com.")
+			case "com.":
+				return minimisationTestReferral(m, "example.com.")
+			}
+
+			rmsg := new(dns.Msg).SetReply(m)
+			rmsg.Authoritative = true
+			if exists && m.Question[0].Name == "www.a.sub.example.com." {
+				rmsg.Answer = []dns.RR{newRR("www.a.sub.example.com. 300 IN A 192.0.2.1")}
+			} else {
+				rmsg.Rcode = dns.RcodeNameError
+				rmsg.Ns = []dns.RR{newRR("example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300")}
+			}
+			return rmsg
+		})
+
+		qmsg := new(dns.Msg)
+		qmsg.SetQuestion("www.a.sub.example.com.", dns.TypeA)
+
+		r := resolver.Exchange(context.Background(), qmsg)
+		require.NoError(t, r.Err)
+
+		// The minimised name is asked once, then the full QName; we don't carry on down the labels.
+		assert.Equal(t, []string{"sub.example.com. NS", "www.a.sub.example.com. A"}, asked["example.com."])
+
+		if exists {
+			assert.Equal(t, dns.RcodeSuccess, r.Msg.Rcode)
+			assert.Len(t, r.Msg.Answer, 1)
+		} else {
+			// The NXDOMAIN returned is the one for the full QName.
+			assert.Equal(t, dns.RcodeNameError, r.Msg.Rcode)
+			assert.Equal(t, "www.a.sub.example.com.", r.Msg.Question[0].Name)
+		}
+	}
+}
+
+func TestResolver_ResolveLabel_MixedReferral(t *testing.T) {
+
+	// A non-authoritative response carrying both an unrelated answer, and a valid delegation to a child zone.
+	// The answer doesn't satisfy the question, so we expect the referral to be followed, with the answer dropped.
+
+	resolver, _, _, example, _ := getTestResolverWithExample()
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.test.example.com.", dns.TypeA)
+	d := newDomain(qmsg.Question[0].Name)
+
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Answer = []dns.RR{
+			newRR("example.com. 300 IN MX 10 mx.example.com."),
+		}
+		rmsg.Ns = []dns.RR{
+			newRR("test.example.com. 300 IN NS ns1.test.example.com."),
+		}
+		rmsg.Extra = []dns.RR{
+			newRR("ns1.test.example.com. 300 IN A 192.0.2.53"),
+		}
+		return &Response{Msg: rmsg}
+	}
+
+	testZone := new(mockZone)
+
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return z
+	}
+
+	processDelegationCallsSeen := 0
+	resolver.funcs.processDelegation = func(ctx context.Context, z zone, rmsg *dns.Msg) (zone, *Response) {
+		processDelegationCallsSeen++
+		assert.Empty(t, rmsg.Answer)
+		assert.Len(t, rmsg.Ns, 1)
+		return testZone, nil
+	}
+
+	resolver.funcs.finaliseResponse = func(ctx context.Context, auth *authenticator, qmsg *dns.Msg, response *Response) *Response {
+		t.Error("finaliseResponse() should not be called for a mixed referral")
+		return response
+	}
+
+	z, r := resolver.resolveLabel(context.Background(), &d, example, qmsg, nil)
+
+	assert.Equal(t, testZone, z)
+	assert.Nil(t, r)
+	assert.Equal(t, 1, processDelegationCallsSeen)
+}
+
+func TestIsMixedReferral(t *testing.T) {
+	question := dns.Question{Name: "www.test.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	referral := func(answer ...dns.RR) *dns.Msg {
+		return &dns.Msg{
+			Answer: answer,
+			Ns:     []dns.RR{newRR("test.example.com. 300 IN NS ns1.test.example.com.")},
+		}
+	}
+
+	// An unrelated answer.
+	assert.True(t, isMixedReferral(question, referral(newRR("example.com. 300 IN MX 10 mx.example.com."))))
+
+	// The right name, but the wrong type.
+	assert.True(t, isMixedReferral(question, referral(newRR("www.test.example.com. 300 IN TXT \"text\""))))
+
+	// An answer that satisfies the question.
+	assert.False(t, isMixedReferral(question, referral(newRR("WWW.test.example.com. 300 IN A 192.0.2.1"))))
+
+	// A CNAME on the QName also satisfies the question.
+	assert.False(t, isMixedReferral(question, referral(newRR("www.test.example.com. 300 IN CNAME www.example.net."))))
+
+	// No answer is just a referral.
+	assert.False(t, isMixedReferral(question, referral()))
+
+	// Authoritative responses are left alone.
+	msg := referral(newRR("example.com. 300 IN MX 10 mx.example.com."))
+	msg.Authoritative = true
+	assert.False(t, isMixedReferral(question, msg))
+
+	// As are those with a SOA.
+	msg = referral(newRR("example.com. 300 IN MX 10 mx.example.com."))
+	msg.Ns = append(msg.Ns, newRR("test.example.com. 300 IN SOA ns1.test.example.com. admin.test.example.com. 1 7200 3600 1209600 300"))
+	assert.False(t, isMixedReferral(question, msg))
+}
+
+func TestResolver_ResolveLabel_AnswerSectionReferral(t *testing.T) {
+
+	// A server that places a delegation's NS records in the Answer section. With NSInAnswerReferrals enabled, we
+	// expect the records to be moved to the Authority section, and the referral followed.
+
+	defer func() { NSInAnswerReferrals = DefaultNSInAnswerReferrals }()
+	NSInAnswerReferrals = true
+
+	resolver, _, _, example, _ := getTestResolverWithExample()
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.test.example.com.", dns.TypeA)
+	d := newDomain(qmsg.Question[0].Name)
+
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Answer = []dns.RR{
+			newRR("test.example.com. 300 IN NS ns1.test.example.com."),
+			newRR("test.example.com. 300 IN NS ns2.test.example.com."),
+		}
+		rmsg.Extra = []dns.RR{
+			newRR("ns1.test.example.com. 300 IN A 192.0.2.53"),
+		}
+		return &Response{Msg: rmsg}
+	}
+
+	testZone := new(mockZone)
+
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return z
+	}
+
+	processDelegationCallsSeen := 0
+	resolver.funcs.processDelegation = func(ctx context.Context, z zone, rmsg *dns.Msg) (zone, *Response) {
+		processDelegationCallsSeen++
+		assert.Empty(t, rmsg.Answer)
+		assert.Len(t, rmsg.Ns, 2)
+		return testZone, nil
+	}
+
+	resolver.funcs.finaliseResponse = func(ctx context.Context, auth *authenticator, qmsg *dns.Msg, response *Response) *Response {
+		t.Error("finaliseResponse() should not be called for a referral")
+		return response
+	}
+
+	z, r := resolver.resolveLabel(context.Background(), &d, example, qmsg, nil)
+
+	assert.Equal(t, testZone, z)
+	assert.Nil(t, r)
+	assert.Equal(t, 1, processDelegationCallsSeen)
+}
+
+func TestIsAnswerSectionReferral(t *testing.T) {
+	question := dns.Question{Name: "www.test.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	msg := func(answer ...dns.RR) *dns.Msg {
+		return &dns.Msg{Answer: answer}
+	}
+	ns := newRR("test.example.com. 300 IN NS ns1.test.example.com.")
+
+	// NS records for a child zone, that's an ancestor of the QName.
+	assert.True(t, isAnswerSectionReferral("example.com.", question, msg(ns)))
+
+	// Including when the child is the QName itself.
+	assert.True(t, isAnswerSectionReferral("example.com.", dns.Question{Name: "test.example.com.", Qtype: dns.TypeA}, msg(ns)))
+
+	// Unless the NS records were asked for.
+	assert.False(t, isAnswerSectionReferral("example.com.", dns.Question{Name: "test.example.com.", Qtype: dns.TypeNS}, msg(ns)))
+
+	// NS records for the zone itself are not a referral.
+	assert.False(t, isAnswerSectionReferral("test.example.com.", question, msg(ns)))
+
+	// Nor are those for a name that's not an ancestor of the QName.
+	assert.False(t, isAnswerSectionReferral("example.com.", question, msg(newRR("other.example.com. 300 IN NS ns1.other.example.com."))))
+
+	// Nor those outside of the zone.
+	assert.False(t, isAnswerSectionReferral("example.net.", question, msg(ns)))
+
+	// Any other record in the Answer means it's not a referral.
+	assert.False(t, isAnswerSectionReferral("example.com.", question, msg(ns, newRR("www.test.example.com. 300 IN A 192.0.2.1"))))
+
+	// Nor when the Authority section has NS or SOA records.
+	m := msg(ns)
+	m.Ns = []dns.RR{newRR("example.com. 300 IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 300")}
+	assert.False(t, isAnswerSectionReferral("example.com.", question, m))
+
+	// Or when there's no Answer.
+	assert.False(t, isAnswerSectionReferral("example.com.", question, msg()))
+}
+
 func TestResolver_CheckForMissingZones_NoRecords(t *testing.T) {
 
 	resolver, _, _, example, _ := getTestResolverWithExample()
@@ -698,29 +1417,90 @@ func TestResolver_CheckForMissingZones_WithMissingZones(t *testing.T) {
 	assert.Equal(t, "a.b.c.d.example.com.", d.current())
 }
 
-func TestResolver_ProcessDelegation_NoNameservers(t *testing.T) {
+func TestResolver_CheckForMissingZones_ConcurrentProbing(t *testing.T) {
+	defer func() { MissingZoneProbeConcurrency = DefaultMissingZoneProbeConcurrency }()
+	MissingZoneProbeConcurrency = 3
 
 	resolver, _, _, example, _ := getTestResolverWithExample()
 
 	qmsg := &dns.Msg{}
-	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	qmsg.SetQuestion("a.b.c.d.example.com.", dns.TypeA)
 	ctx := context.Background()
 
-	rmsg := qmsg.SetReply(&dns.Msg{})
-
-	z, r := resolver.processDelegation(ctx, example, rmsg)
+	d := newDomain(qmsg.Question[0].Name)
+	d.windTo("d.example.com.")
 
-	assert.Nil(t, z)
-	assert.True(t, r.HasError())
-	assert.ErrorIs(t, r.Err, ErrNextNameserversNotFound)
-}
+	qmsg.Ns = []dns.RR{
+		&dns.NS{Hdr: dns.RR_Header{Name: "a.b.c.d.example.com.", Rrtype: dns.TypeNS}, Ns: "ns1.example.com."},
+	}
 
-func TestResolver_ProcessDelegation_NotSubdomain(t *testing.T) {
+	// Each probe waits until all three are in flight, so they can only complete if they're sent concurrently.
+	var arrived sync.WaitGroup
+	arrived.Add(3)
+	example.mockSoa = func(ctx context.Context, name string) (*dns.SOA, error) {
+		arrived.Done()
+		arrived.Wait()
 
-	resolver, _, _, example, _ := getTestResolverWithExample()
+		// Two of the three missing domains are zones.
+		if name == "c.d.example.com." || name == "b.c.d.example.com." {
+			return &dns.SOA{}, nil
+		}
+		return nil, nil
+	}
 
-	qmsg := &dns.Msg{}
-	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	var clone func(name, parent string) zone
+	clone = func(name, parent string) zone {
+		z := getMockZone(name, parent)
+		z.mockClone = clone
+		z.mockSoa = func(ctx context.Context, name string) (*dns.SOA, error) {
+			t.Errorf("unexpected sequential soa probe for [%s]", name)
+			return nil, nil
+		}
+		return z
+	}
+	example.mockClone = clone
+
+	done := make(chan zone)
+	go func() {
+		done <- resolver.checkForMissingZones(ctx, &d, example, qmsg, nil)
+	}()
+
+	var z zone
+	select {
+	case z = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("soa probes were not sent concurrently")
+	}
+
+	// The longest zone found is used, as the child of the one above it.
+	assert.Equal(t, "b.c.d.example.com.", z.name())
+	assert.Equal(t, "c.d.example.com.", z.parent())
+	assert.Equal(t, "a.b.c.d.example.com.", d.current())
+}
+
+func TestResolver_ProcessDelegation_NoNameservers(t *testing.T) {
+
+	resolver, _, _, example, _ := getTestResolverWithExample()
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.Background()
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+
+	z, r := resolver.processDelegation(ctx, example, rmsg)
+
+	assert.Nil(t, z)
+	assert.True(t, r.HasError())
+	assert.ErrorIs(t, r.Err, ErrNextNameserversNotFound)
+}
+
+func TestResolver_ProcessDelegation_NotSubdomain(t *testing.T) {
+
+	resolver, _, _, example, _ := getTestResolverWithExample()
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
 	ctx := context.Background()
 
 	rmsg := qmsg.SetReply(&dns.Msg{})
@@ -826,7 +1606,7 @@ func TestResolver_FinaliseResponse_ARecord(t *testing.T) {
 	rmsg := qmsg.SetReply(&dns.Msg{})
 
 	rmsg.Answer = []dns.RR{
-		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, 1)},
+		&dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, 1)},
 	}
 
 	inputResponse := &Response{Msg: rmsg}
@@ -834,6 +1614,331 @@ func TestResolver_FinaliseResponse_ARecord(t *testing.T) {
 	r := resolver.finaliseResponse(ctx, nil, qmsg, inputResponse)
 
 	assert.Equal(t, inputResponse, r)
+	assert.Len(t, r.Msg.Answer, 1)
+}
+
+func TestResolver_FinaliseResponse_UnsolicitedAnswers(t *testing.T) {
+
+	// Records not owned by the QName, nor by a name in the CNAME chain from it, are dropped.
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	resolver.funcs.cname = func(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger) error {
+		return nil
+	}
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+	rmsg.Answer = []dns.RR{
+		newRR("www.example.com. 300 IN CNAME other.example.com."),
+		newRR("other.example.com. 300 IN A 192.0.2.1"),
+		newRR("bank.example.net. 300 IN A 192.0.2.66"),
+		newRR("bank.example.net. 300 IN RRSIG A 13 3 300 20300101000000 20200101000000 1234 example.net. aaaa"),
+	}
+
+	r := resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg})
+	require.False(t, r.HasError())
+
+	require.Len(t, r.Msg.Answer, 2)
+	assert.Equal(t, "www.example.com.", r.Msg.Answer[0].Header().Name)
+	assert.Equal(t, "other.example.com.", r.Msg.Answer[1].Header().Name)
+
+	//---
+
+	// A DNAME owned by an ancestor of the QName, and its synthesised CNAME, are kept.
+
+	rmsg = qmsg.SetReply(&dns.Msg{})
+	rmsg.Answer = []dns.RR{
+		newRR("example.com. 300 IN DNAME example.org."),
+		newRR("www.example.com. 300 IN CNAME www.example.org."),
+		newRR("www.example.org. 300 IN A 192.0.2.1"),
+		newRR("unrelated.example.org. 300 IN A 192.0.2.66"),
+	}
+
+	r = resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg})
+	require.False(t, r.HasError())
+	assert.Len(t, r.Msg.Answer, 3)
+	assert.False(t, recordsOfNameAndTypeExist(r.Msg.Answer, "unrelated.example.org.", dns.TypeA))
+}
+
+func TestResolver_FinaliseResponse_AnswerRecordsFirst(t *testing.T) {
+	defer func() { AnswerRecordsFirst = DefaultAnswerRecordsFirst }()
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	resolver.funcs.cname = func(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger) error {
+		return nil
+	}
+
+	answer := func() *dns.Msg {
+		rmsg := qmsg.SetReply(&dns.Msg{})
+		rmsg.Answer = []dns.RR{
+			newRR("other.example.com. 300 IN RRSIG A 13 3 300 20300101000000 20200101000000 1234 example.com. aaaa"),
+			newRR("other.example.com. 300 IN A 192.0.2.2"),
+			newRR("www.example.com. 300 IN CNAME other.example.com."),
+			newRR("other.example.com. 300 IN A 192.0.2.1"),
+		}
+		return rmsg
+	}
+
+	// By default, the answer follows the chain from the QName.
+	r := resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: answer()})
+	require.False(t, r.HasError())
+	require.Len(t, r.Msg.Answer, 4)
+	assert.Equal(t, dns.TypeCNAME, r.Msg.Answer[0].Header().Rrtype)
+
+	// Otherwise the A records come first, in the order received, followed by their signature, then the CNAME.
+	AnswerRecordsFirst = true
+	for i := 0; i < 3; i++ {
+		r = resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: answer()})
+		require.False(t, r.HasError())
+		require.Len(t, r.Msg.Answer, 4)
+		assert.Equal(t, "192.0.2.2", r.Msg.Answer[0].(*dns.A).A.String())
+		assert.Equal(t, "192.0.2.1", r.Msg.Answer[1].(*dns.A).A.String())
+		assert.Equal(t, dns.TypeRRSIG, r.Msg.Answer[2].Header().Rrtype)
+		assert.Equal(t, dns.TypeCNAME, r.Msg.Answer[3].Header().Rrtype)
+	}
+}
+
+func TestResolver_FinaliseResponse_ValidationDuration(t *testing.T) {
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+	rmsg.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, 1)},
+	}
+
+	// Without DO set there's no authenticator, so no validation time.
+	r := resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg})
+	assert.Zero(t, r.ValidationDuration)
+
+	// With DO set, the time taken to get the DNSSEC result is recorded.
+	qmsg.SetEdns0(4096, true)
+	auth := newAuthenticator(ctx, qmsg.Question[0])
+	defer auth.close()
+
+	r = resolver.finaliseResponse(ctx, auth, qmsg, &Response{Msg: rmsg})
+	assert.Greater(t, r.ValidationDuration, time.Duration(0))
+}
+
+func TestResolver_FinaliseResponse_Indeterminate(t *testing.T) {
+
+	// If the keys needed for validation cannot be fetched, the result is Indeterminate, and a SERVFAIL.
+
+	resolver, root, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	qmsg.SetEdns0(4096, true)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	root.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+		return nil, ErrFailedToGetDNSKEYs
+	}
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+	rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+
+	auth := newAuthenticator(ctx, qmsg.Question[0])
+	defer auth.close()
+	require.NoError(t, auth.addResponse(root, rmsg))
+
+	r := resolver.finaliseResponse(ctx, auth, qmsg, &Response{Msg: rmsg})
+
+	assert.Equal(t, dnssec.Indeterminate, r.Auth)
+	assert.ErrorIs(t, r.Err, ErrFailedToGetDNSKEYs)
+	assert.Equal(t, dns.RcodeServerFailure, r.Msg.Rcode)
+	assert.Empty(t, r.Msg.Answer)
+	assert.Equal(t, dnssec.NotBogus, r.BogusReason)
+}
+
+func TestResolver_FinaliseResponse_ValidationFlags(t *testing.T) {
+	resolver, root, _, _, _ := getTestResolverWithExample()
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now())
+
+	root.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+		return nil, ErrFailedToGetDNSKEYs
+	}
+
+	finalise := func(do, cd bool) *Response {
+		qmsg := &dns.Msg{}
+		qmsg.SetQuestion("www.example.com.", dns.TypeA)
+		qmsg.CheckingDisabled = cd
+
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+
+		if !do {
+			return resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg})
+		}
+
+		qmsg.SetEdns0(4096, true)
+		auth := newAuthenticator(ctx, qmsg.Question[0])
+		defer auth.close()
+		require.NoError(t, auth.addResponse(root, rmsg))
+		return resolver.finaliseResponse(ctx, auth, qmsg, &Response{Msg: rmsg})
+	}
+
+	// Without DO, nothing is validated.
+	r := finalise(false, false)
+	assert.False(t, r.Validated)
+	assert.False(t, r.EnforcementSkipped)
+
+	// With DO, the answer is validated and the result enforced.
+	r = finalise(true, false)
+	assert.True(t, r.Validated)
+	assert.False(t, r.EnforcementSkipped)
+	assert.Equal(t, dnssec.Indeterminate, r.Auth)
+	assert.Equal(t, dns.RcodeServerFailure, r.Msg.Rcode)
+
+	// With CD as well, the answer is validated, but the result isn't enforced.
+	r = finalise(true, true)
+	assert.True(t, r.Validated)
+	assert.True(t, r.EnforcementSkipped)
+	assert.Equal(t, dnssec.Indeterminate, r.Auth)
+	assert.Equal(t, dns.RcodeSuccess, r.Msg.Rcode)
+	assert.Len(t, r.Msg.Answer, 1)
+
+	// CD alone skips nothing, as there was nothing to enforce.
+	r = finalise(false, true)
+	assert.False(t, r.Validated)
+	assert.False(t, r.EnforcementSkipped)
+}
+
+func TestResolver_FinaliseResponse_CapsTTLs(t *testing.T) {
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	week := uint32(7 * 24 * 60 * 60)
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+	rmsg.Answer = []dns.RR{
+		newRR(fmt.Sprintf("www.example.com. %d IN A 192.0.2.1", week)),
+		newRR("www.example.com. 300 IN A 192.0.2.2"),
+	}
+	rmsg.Ns = []dns.RR{newRR(fmt.Sprintf("example.com. %d IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 300", week))}
+	rmsg.SetEdns0(4096, true)
+	opt := rmsg.IsEdns0()
+	optTtl := opt.Hdr.Ttl
+
+	r := resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg})
+	require.False(t, r.HasError())
+
+	require.Len(t, r.Msg.Answer, 2)
+	for _, rr := range r.Msg.Answer {
+		assert.LessOrEqual(t, rr.Header().Ttl, MaxAllowedTTL)
+	}
+	assert.Equal(t, MaxAllowedTTL, r.Msg.Answer[0].Header().Ttl)
+	assert.Equal(t, uint32(300), r.Msg.Answer[1].Header().Ttl)
+	assert.Equal(t, MaxAllowedTTL, r.Msg.Ns[0].Header().Ttl)
+
+	// The OPT record's TTL field holds flags, so is left alone.
+	assert.Equal(t, optTtl, opt.Hdr.Ttl)
+}
+
+func TestResolver_FinaliseResponse_OutOfBailiwick(t *testing.T) {
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+	rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+
+	// The answer came from a zone that's not an ancestor of the QName.
+	r := resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg, AuthoritativeZone: "example.net."})
+	assert.ErrorIs(t, r.Err, ErrAnswerOutOfBailiwick)
+	assert.True(t, r.IsEmpty())
+
+	// Nor one that's a descendant of it.
+	r = resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg, AuthoritativeZone: "sub.www.example.com."})
+	assert.ErrorIs(t, r.Err, ErrAnswerOutOfBailiwick)
+
+	// But the apex, or any ancestor, is fine.
+	for _, zone := range []string{"www.example.com.", "example.com.", "com.", "."} {
+		r = resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg.Copy(), AuthoritativeZone: zone})
+		assert.NoError(t, r.Err, zone)
+		assert.Len(t, r.Msg.Answer, 1)
+	}
+}
+
+func TestResolver_FinaliseResponse_RequireAuthoritativeAnswers(t *testing.T) {
+	defer func() { RequireAuthoritativeAnswers = DefaultRequireAuthoritativeAnswers }()
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	getResponse := func(authoritative, fromCache bool) *Response {
+		rmsg := qmsg.SetReply(&dns.Msg{})
+		rmsg.Authoritative = authoritative
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+		return &Response{Msg: rmsg, AuthoritativeZone: "example.com.", fromCache: fromCache}
+	}
+
+	// By default, a non-authoritative answer is accepted.
+	r := resolver.finaliseResponse(ctx, nil, qmsg, getResponse(false, false))
+	assert.NoError(t, r.Err)
+
+	RequireAuthoritativeAnswers = true
+
+	// A non-authoritative answer, not from the cache, is refused.
+	r = resolver.finaliseResponse(ctx, nil, qmsg, getResponse(false, false))
+	assert.ErrorIs(t, r.Err, ErrNotAuthoritative)
+	assert.True(t, r.IsEmpty())
+
+	// An authoritative answer is fine.
+	r = resolver.finaliseResponse(ctx, nil, qmsg, getResponse(true, false))
+	assert.NoError(t, r.Err)
+	assert.Len(t, r.Msg.Answer, 1)
+
+	// As is one from the cache.
+	r = resolver.finaliseResponse(ctx, nil, qmsg, getResponse(false, true))
+	assert.NoError(t, r.Err)
+	assert.Len(t, r.Msg.Answer, 1)
+}
+
+func TestResolver_FinaliseResponse_IncludeRawResponse(t *testing.T) {
+	defer func() { IncludeRawResponse = DefaultIncludeRawResponse }()
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	getResponse := func() *Response {
+		rmsg := qmsg.SetReply(&dns.Msg{})
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+		rmsg.Ns = []dns.RR{newRR("example.com. 300 IN NS ns1.example.com.")}
+		rmsg.Extra = []dns.RR{newRR("ns1.example.com. 300 IN A 192.0.2.53")}
+		return &Response{Msg: rmsg}
+	}
+
+	// By default, there's no raw copy.
+	r := resolver.finaliseResponse(ctx, nil, qmsg, getResponse())
+	assert.Nil(t, r.Raw)
+
+	IncludeRawResponse = true
+
+	// The finalised message has its Authority and Additional sections trimmed; the raw message does not.
+	r = resolver.finaliseResponse(ctx, nil, qmsg, getResponse())
+	require.NotNil(t, r.Raw)
+	assert.Empty(t, r.Msg.Ns)
+	assert.Empty(t, r.Msg.Extra)
+	assert.Len(t, r.Raw.Ns, 1)
+	assert.Len(t, r.Raw.Extra, 1)
+	assert.Equal(t, r.Msg.Answer, r.Raw.Answer)
+	assert.NotSame(t, r.Msg, r.Raw)
 }
 
 func TestResolver_FinaliseResponse_CNameQuestion(t *testing.T) {
@@ -864,6 +1969,116 @@ func TestResolver_FinaliseResponse_CNameQuestion(t *testing.T) {
 	assert.Equal(t, 0, cnameCalled)
 }
 
+func TestResolver_FinaliseResponse_CNameQuestionResolveTarget(t *testing.T) {
+
+	// When the QType is CNAME, and ResolveCNAMETarget is enabled, the target's A/AAAA records should be appended.
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeCNAME)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+
+	c := &dns.CNAME{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME}, Target: "other.example.net."}
+	rmsg.Answer = []dns.RR{c}
+	inputResponse := &Response{Msg: rmsg}
+
+	a := &dns.A{Hdr: dns.RR_Header{Name: "other.example.net.", Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, 1)}
+	aaaa := &dns.AAAA{Hdr: dns.RR_Header{Name: "other.example.net.", Rrtype: dns.TypeAAAA}, AAAA: net.ParseIP("2001:db8::1")}
+
+	typesSeen := make([]uint16, 0)
+	resolver.funcs.cname = cname
+	resolver.funcs.getExchanger = func() exchanger {
+		return &mockExchanger{
+			mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+				assert.Equal(t, "other.example.net.", msg.Question[0].Name)
+				typesSeen = append(typesSeen, msg.Question[0].Qtype)
+
+				answer := []dns.RR{a}
+				if msg.Question[0].Qtype == dns.TypeAAAA {
+					answer = []dns.RR{aaaa}
+				}
+				return &Response{
+					Msg: &dns.Msg{Answer: answer},
+				}
+			},
+		}
+	}
+
+	ResolveCNAMETarget = true
+	r := resolver.finaliseResponse(ctx, nil, qmsg, inputResponse)
+
+	// This is global, so we need to set it back!
+	ResolveCNAMETarget = DefaultResolveCNAMETarget
+
+	assert.Equal(t, inputResponse, r)
+	assert.False(t, r.HasError())
+	assert.Equal(t, []uint16{dns.TypeA, dns.TypeAAAA}, typesSeen)
+
+	// The CNAME should remain the first answer.
+	require.Len(t, r.Msg.Answer, 3)
+	assert.Equal(t, c, r.Msg.Answer[0])
+	assert.Contains(t, r.Msg.Answer, a)
+	assert.Contains(t, r.Msg.Answer, aaaa)
+}
+
+func TestResolver_FinaliseResponse_CNameQuestionResolveTargetFails(t *testing.T) {
+
+	// Resolving the target is best-effort. If a lookup fails, or the target doesn't exist, the CNAME answer is
+	// returned unchanged, with its own rcode; only a successful lookup's records are appended.
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeCNAME)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	aaaa := &dns.AAAA{Hdr: dns.RR_Header{Name: "other.example.net.", Rrtype: dns.TypeAAAA}, AAAA: net.ParseIP("2001:db8::1")}
+
+	tests := []struct {
+		name string
+		a    *Response
+	}{
+		{"error", ResponseError(errors.New("test error"))},
+		{"servfail", &Response{Msg: &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeServerFailure}}}},
+		{"nxdomain", &Response{Msg: &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeNameError}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rmsg := new(dns.Msg).SetReply(qmsg)
+			c := &dns.CNAME{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME}, Target: "other.example.net."}
+			rmsg.Answer = []dns.RR{c}
+			inputResponse := &Response{Msg: rmsg}
+
+			resolver.funcs.cname = cname
+			resolver.funcs.getExchanger = func() exchanger {
+				return &mockExchanger{
+					mockExchange: func(ctx context.Context, msg *dns.Msg) *Response {
+						if msg.Question[0].Qtype == dns.TypeA {
+							return tt.a
+						}
+						return &Response{Msg: &dns.Msg{Answer: []dns.RR{aaaa}}}
+					},
+				}
+			}
+
+			ResolveCNAMETarget = true
+			r := resolver.finaliseResponse(ctx, nil, qmsg, inputResponse)
+
+			// This is global, so we need to set it back!
+			ResolveCNAMETarget = DefaultResolveCNAMETarget
+
+			assert.Equal(t, inputResponse, r)
+			require.NoError(t, r.Err)
+			assert.Equal(t, dns.RcodeSuccess, r.Msg.Rcode)
+			require.Len(t, r.Msg.Answer, 2)
+			assert.Equal(t, c, r.Msg.Answer[0])
+			assert.Equal(t, aaaa, r.Msg.Answer[1])
+		})
+	}
+}
+
 func TestResolver_FinaliseResponse_CNameAnswer(t *testing.T) {
 
 	// When the QType is A, but the answer has a CNAME, we should resolve that CNAME.
@@ -947,6 +2162,66 @@ func TestResolver_FinaliseResponse_RCode(t *testing.T) {
 	assert.Equal(t, inputResponse, r)
 	assert.True(t, r.HasError())
 	assert.Contains(t, r.Err.Error(), "ServFail")
+
+	var rcodeErr *RcodeError
+	require.ErrorAs(t, r.Err, &rcodeErr)
+	assert.Equal(t, dns.RcodeServerFailure, rcodeErr.Rcode())
+
+	// A REFUSED can be told apart from a SERVFAIL.
+	rmsg.Rcode = dns.RcodeRefused
+	r = resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg})
+	require.ErrorAs(t, r.Err, &rcodeErr)
+	assert.Equal(t, dns.RcodeRefused, rcodeErr.Rcode())
+
+	// NXDOMAIN is not an error.
+	rmsg.Rcode = dns.RcodeNameError
+	r = resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg})
+	assert.False(t, r.HasError())
+}
+
+func TestResolver_Exchange_RcodeError(t *testing.T) {
+
+	// A SERVFAIL or REFUSED from the upstream nameservers reaches the client with its rcode, and an RcodeError that
+	// carries the category of the failure.
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	tests := []struct {
+		rcode    int
+		category error
+	}{
+		{dns.RcodeServerFailure, ErrServerFailure},
+		{dns.RcodeRefused, ErrServerRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(dns.RcodeToString[tt.rcode], func(t *testing.T) {
+			calls := 0
+			client := &testSessionDNSClient{
+				f: func(msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+					calls++
+					rmsg := new(dns.Msg).SetReply(msg)
+					rmsg.Rcode = tt.rcode
+					return rmsg, time.Millisecond, nil
+				},
+			}
+
+			qmsg := new(dns.Msg)
+			qmsg.SetQuestion("www.example.", dns.TypeA)
+
+			r := getTestSessionResolver(client).Exchange(context.Background(), qmsg)
+			require.False(t, r.IsEmpty())
+			assert.Equal(t, tt.rcode, r.Msg.Rcode)
+
+			var rcodeErr *RcodeError
+			require.ErrorAs(t, r.Err, &rcodeErr)
+			assert.Equal(t, tt.rcode, rcodeErr.Rcode())
+			assert.ErrorIs(t, r.Err, tt.category)
+			assert.NotErrorIs(t, r.Err, ErrUnableToResolveAnswer)
+			assert.NotZero(t, calls)
+		})
+	}
 }
 
 func TestResolver_FinaliseResponse_Opt(t *testing.T) {
@@ -985,3 +2260,494 @@ func TestResolver_FinaliseResponse_Opt(t *testing.T) {
 
 	assert.True(t, optSeen)
 }
+
+func TestResolver_FinaliseResponse_StableOrder(t *testing.T) {
+
+	// The same logical set of records, received in any order, should result in an identical message.
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), ctxStartTime, time.Now().Add(-5*time.Millisecond))
+
+	resolver.funcs.cname = func(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger) error {
+		return nil
+	}
+
+	// Order within an RRset is preserved, so we group the records we'll shuffle.
+	groups := [][]dns.RR{
+		{newRR("www.example.com. 300 IN CNAME other.example.net.")},
+		{newRR("www.example.com. 300 IN RRSIG CNAME 13 3 300 20300101000000 20200101000000 1234 example.com. aaaa")},
+		{newRR("other.example.net. 300 IN A 192.0.2.2"), newRR("other.example.net. 300 IN A 192.0.2.1")},
+		{newRR("other.example.net. 300 IN RRSIG A 13 3 300 20300101000000 20200101000000 1234 example.net. bbbb")},
+		{newRR("other.example.net. 300 IN AAAA 2001:db8::1")},
+	}
+
+	var first []byte
+	for i := 0; i < 10; i++ {
+		rand.Shuffle(len(groups), func(i, j int) {
+			groups[i], groups[j] = groups[j], groups[i]
+		})
+
+		rmsg := qmsg.SetReply(&dns.Msg{})
+		rmsg.Answer = slices.Concat(groups...)
+
+		r := resolver.finaliseResponse(ctx, nil, qmsg, &Response{Msg: rmsg})
+		require.False(t, r.HasError())
+
+		// The CNAME, owned by the QName, always comes first, followed by its signature.
+		assert.Equal(t, dns.TypeCNAME, r.Msg.Answer[0].Header().Rrtype)
+		assert.Equal(t, dns.TypeRRSIG, r.Msg.Answer[1].Header().Rrtype)
+
+		packed, err := r.Msg.Pack()
+		require.NoError(t, err)
+
+		if first == nil {
+			first = packed
+			continue
+		}
+		assert.Equal(t, first, packed)
+	}
+}
+
+func newRR(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+func TestResolver_Exchange_NameserverResolutionBudget(t *testing.T) {
+
+	// Resolving a nameserver's address has its own budget, separate from the main query's.
+
+	resolver := getTestResolverWithRoot()
+
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		// We never return a response
+		return getMockZone("test", ""), nil
+	}
+
+	MaxQueriesPerNameserverResolution = 2
+	defer func() { MaxQueriesPerNameserverResolution = DefaultMaxQueriesPerNameserverResolution }()
+
+	mainCounter := new(atomic.Uint32)
+	ctx := context.WithValue(context.Background(), ctxSessionQueries, mainCounter)
+
+	nsCtx, ok := nameserverResolutionContext(ctx, "ns1.example.net.")
+	require.True(t, ok)
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("ns1.example.net.", dns.TypeA)
+
+	response := resolver.Exchange(nsCtx, qmsg)
+
+	assert.ErrorIs(t, response.Err, ErrMaxQueriesPerRequestReached)
+
+	// The main query's budget is untouched.
+	assert.Equal(t, uint32(0), mainCounter.Load())
+
+	// Whilst the same query, as part of the main resolution, is bounded by MaxQueriesPerRequest.
+	response = resolver.Exchange(ctx, qmsg)
+	assert.ErrorIs(t, response.Err, ErrUnableToResolveAnswer)
+	assert.Greater(t, mainCounter.Load(), MaxQueriesPerNameserverResolution)
+}
+
+func TestResolver_Exchange_PrefetchesDNSKEYs(t *testing.T) {
+
+	// With DO set, the DNSKEYs of every known zone are fetched up-front.
+
+	resolver, root, com, example, _ := getTestResolverWithExample()
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	qmsg.SetEdns0(4096, true)
+
+	var rootCalls, comCalls, exampleCalls atomic.Int32
+	root.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+		rootCalls.Add(1)
+		return nil, nil
+	}
+	com.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+		comCalls.Add(1)
+		return nil, nil
+	}
+	example.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+		exampleCalls.Add(1)
+		return nil, nil
+	}
+
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		return nil, &Response{}
+	}
+
+	resolver.Exchange(context.Background(), qmsg)
+
+	assert.Eventually(t, func() bool {
+		return rootCalls.Load() > 0 && comCalls.Load() > 0 && exampleCalls.Load() > 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestPrefetchDNSKEYs_Concurrent(t *testing.T) {
+	const zoneCount = 3
+
+	started := make(chan string, zoneCount)
+	release := make(chan struct{})
+
+	zones := make([]zone, 0, zoneCount)
+	for _, name := range []string{"example.com.", "com.", "."} {
+		z := getMockZone(name, "")
+		z.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+			started <- name
+			<-release
+			return nil, nil
+		}
+		zones = append(zones, z)
+	}
+
+	prefetchDNSKEYs(context.Background(), zones)
+
+	// All fetches must be in-flight at the same time, as none return until released.
+	seen := make([]string, 0, zoneCount)
+	for i := 0; i < zoneCount; i++ {
+		select {
+		case name := <-started:
+			seen = append(seen, name)
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d dnskey fetches started concurrently", len(seen), zoneCount)
+		}
+	}
+	close(release)
+
+	assert.ElementsMatch(t, []string{"example.com.", "com.", "."}, seen)
+}
+
+func TestPrefetchDNSKEYs_Bounded(t *testing.T) {
+	defer func() { DNSKEYPrefetchConcurrency = DefaultDNSKEYPrefetchConcurrency }()
+	DNSKEYPrefetchConcurrency = 1
+
+	var inFlight, maxInFlight, calls atomic.Int32
+
+	zones := make([]zone, 0, 3)
+	for _, name := range []string{"example.com.", "com.", "."} {
+		z := getMockZone(name, "")
+		z.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			inFlight.Add(-1)
+			calls.Add(1)
+			return nil, nil
+		}
+		zones = append(zones, z)
+	}
+
+	prefetchDNSKEYs(context.Background(), zones)
+
+	assert.Eventually(t, func() bool {
+		return calls.Load() == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), maxInFlight.Load())
+}
+
+func TestPrefetchDNSKEYs_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var calls atomic.Int32
+	z := getMockZone("example.com.", "com.")
+	z.mockDnskeys = func(ctx context.Context) ([]dns.RR, error) {
+		calls.Add(1)
+		return nil, nil
+	}
+
+	prefetchDNSKEYs(ctx, []zone{z})
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Zero(t, calls.Load())
+}
+
+func TestResolver_Exchange_Path(t *testing.T) {
+
+	// The path lists the server used in each zone, from the root to the zone that answered.
+
+	root := getMockZone(".", "")
+	com := getMockZone("com.", ".")
+	example := getMockZone("example.com.", "com.")
+
+	resolver := getTestResolverWithRoot()
+	resolver.zones = mockZoneStore{
+		mockGet: func(name string) zone {
+			return nil
+		},
+		mockZoneList: func(name string) []zone {
+			return []zone{root}
+		},
+	}
+	resolver.funcs.resolveLabel = resolver.resolveLabel
+	resolver.funcs.finaliseResponse = resolver.finaliseResponse
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return z
+	}
+	resolver.funcs.processDelegation = func(ctx context.Context, z zone, rmsg *dns.Msg) (zone, *Response) {
+		switch z.name() {
+		case ".":
+			return com, nil
+		case "com.":
+			return example, nil
+		}
+		return nil, ResponseError(errors.New("unexpected zone"))
+	}
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	delegation := func(m *dns.Msg, name string) *dns.Msg {
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Ns = []dns.RR{newRR(name + " 3600 IN NS ns1." + name)}
+		return rmsg
+	}
+
+	root.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		return &Response{Msg: delegation(m, "com."), server: "192.0.2.1:53"}
+	}
+	com.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		return &Response{Msg: delegation(m, "example.com."), server: "192.0.2.2:53"}
+	}
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.80")}
+		return &Response{Msg: rmsg, server: "[2001:db8::3]:53"}
+	}
+
+	response := resolver.Exchange(context.Background(), qmsg)
+	require.False(t, response.HasError())
+	assert.Equal(t, []string{"192.0.2.1:53", "192.0.2.2:53", "[2001:db8::3]:53"}, response.Path)
+
+	//---
+
+	// A zone answered from the cache has no server, so isn't included.
+
+	com.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		return &Response{Msg: delegation(m, "example.com.")}
+	}
+
+	response = resolver.Exchange(context.Background(), qmsg)
+	require.False(t, response.HasError())
+	assert.Equal(t, []string{"192.0.2.1:53", "[2001:db8::3]:53"}, response.Path)
+}
+
+func TestResolver_Exchange_AlwaysValidate(t *testing.T) {
+	defer func() { AlwaysValidate = DefaultAlwaysValidate }()
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	var authSeen *authenticator
+	var doSeen bool
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		authSeen = auth
+		doSeen = isSetDO(qmsg)
+
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{
+			newRR("www.example.com. 300 IN A 192.0.2.1"),
+			newRR("www.example.com. 300 IN RRSIG A 13 3 300 20300101000000 20200101000000 1234 example.com. aaaa"),
+		}
+		rmsg.SetEdns0(4096, true)
+		return nil, &Response{Msg: rmsg}
+	}
+
+	// By default, a non-DO query is not validated.
+
+	response := resolver.Exchange(context.Background(), qmsg)
+	assert.Nil(t, authSeen)
+	assert.False(t, doSeen)
+	assert.Len(t, response.Msg.Answer, 2)
+
+	//---
+
+	// With AlwaysValidate, it is. But the DNSSEC records are not returned to the client.
+
+	AlwaysValidate = true
+
+	response = resolver.Exchange(context.Background(), qmsg)
+	assert.NotNil(t, authSeen)
+	assert.True(t, doSeen)
+	assert.Len(t, response.Msg.Answer, 1)
+	assert.False(t, recordsOfTypeExist(response.Msg.Answer, dns.TypeRRSIG))
+	assert.Nil(t, response.Msg.IsEdns0())
+
+	// The client's message is unchanged.
+	assert.Nil(t, qmsg.IsEdns0())
+
+	//---
+
+	// If the client used EDNS, but without DO, the OPT record is kept, but without DO.
+
+	qmsg.SetEdns0(4096, false)
+
+	response = resolver.Exchange(context.Background(), qmsg)
+	assert.NotNil(t, authSeen)
+	assert.Len(t, response.Msg.Answer, 1)
+	require.NotNil(t, response.Msg.IsEdns0())
+	assert.False(t, response.Msg.IsEdns0().Do())
+	assert.False(t, isSetDO(qmsg))
+}
+
+func TestResolver_Exchange_AuthoritativeZone(t *testing.T) {
+
+	resolver, _, _, example, _ := getTestResolverWithExample()
+	resolver.funcs.resolveLabel = resolver.resolveLabel
+	resolver.funcs.finaliseResponse = resolver.finaliseResponse
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return z
+	}
+
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Answer = []dns.RR{newRR(m.Question[0].Name + " 300 IN A 192.0.2.1")}
+		return &Response{Msg: rmsg}
+	}
+
+	for _, qname := range []string{"example.com.", "www.example.com."} {
+		qmsg := &dns.Msg{}
+		qmsg.SetQuestion(qname, dns.TypeA)
+
+		response := resolver.Exchange(context.Background(), qmsg)
+		require.False(t, response.HasError())
+		assert.Equal(t, "example.com.", response.AuthoritativeZone, qname)
+	}
+}
+
+func TestResolver_Exchange_OutOfBailiwick(t *testing.T) {
+
+	// If a misstep leaves us with an answering zone that's not an ancestor of the QName, the answer is rejected.
+
+	resolver, _, _, example, _ := getTestResolverWithExample()
+	resolver.funcs.resolveLabel = resolver.resolveLabel
+	resolver.funcs.finaliseResponse = resolver.finaliseResponse
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return getMockZone("example.net.", "net.")
+	}
+
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+		return &Response{Msg: rmsg}
+	}
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	response := resolver.Exchange(context.Background(), qmsg)
+	assert.ErrorIs(t, response.Err, ErrAnswerOutOfBailiwick)
+}
+
+func TestResolver_Exchange_DSQuerySentToParent(t *testing.T) {
+
+	// Even though we know the example.com. zone, its DS records must come from com.
+	// They should then be returned, with their RRSIG, in the Answer. DNSSEC validation is covered elsewhere.
+
+	resolver, root, com, example, _ := getTestResolverWithExample()
+	resolver.funcs.resolveLabel = resolver.resolveLabel
+	resolver.funcs.checkForMissingZones = resolver.checkForMissingZones
+	resolver.funcs.finaliseResponse = resolver.finaliseResponse
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("example.com.", dns.TypeDS)
+
+	ds := newRR("example.com. 3600 IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A86764247C")
+	rrsig := newRR("example.com. 3600 IN RRSIG DS 13 2 3600 20300101000000 20200101000000 12345 com. dGVzdA==")
+
+	root.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		return nil
+	}
+	com.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		if m.Question[0].Qtype != dns.TypeDS {
+			return nil
+		}
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Authoritative = true
+		rmsg.Answer = []dns.RR{ds, rrsig}
+		return &Response{Msg: rmsg}
+	}
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		if m.Question[0].Qtype == dns.TypeDS {
+			t.Error("the ds query should not be sent to the child zone")
+		}
+		return nil
+	}
+
+	response := resolver.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, "com.", response.AuthoritativeZone)
+	assert.Contains(t, response.Msg.Answer, ds)
+	assert.Contains(t, response.Msg.Answer, rrsig)
+}
+
+func TestResolver_FinaliseResponse_BestEffortOnDeadline(t *testing.T) {
+
+	// If the deadline fires part way through following a CNAME chain, we return the part of the chain we have.
+
+	defer func() { BestEffortOnDeadline = DefaultBestEffortOnDeadline }()
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
+	defer cancel()
+
+	resolver.funcs.cname = func(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger) error {
+		// We follow the first hop, but then run out of time.
+		r.Msg.Answer = append(r.Msg.Answer, newRR("a.example.net. 300 IN CNAME b.example.org."))
+		return ctx.Err()
+	}
+
+	getResponse := func() *Response {
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN CNAME a.example.net.")}
+		return &Response{Msg: rmsg, Auth: dnssec.Secure}
+	}
+
+	BestEffortOnDeadline = true
+
+	r := resolver.finaliseResponse(ctx, nil, qmsg, getResponse())
+	require.NoError(t, r.Err)
+	assert.True(t, r.Partial)
+	assert.Len(t, r.Msg.Answer, 2)
+	assert.False(t, r.Msg.AuthenticatedData)
+
+	//---
+
+	// An error unrelated to the deadline is still returned.
+
+	resolver.funcs.cname = func(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger) error {
+		return ErrEmptyResponse
+	}
+	r = resolver.finaliseResponse(context.Background(), nil, qmsg, getResponse())
+	assert.ErrorIs(t, r.Err, ErrEmptyResponse)
+	assert.False(t, r.Partial)
+
+	//---
+
+	// When disabled, the deadline error is returned.
+
+	BestEffortOnDeadline = false
+
+	resolver.funcs.cname = func(ctx context.Context, qmsg *dns.Msg, r *Response, exchanger exchanger) error {
+		return ctx.Err()
+	}
+	r = resolver.finaliseResponse(ctx, nil, qmsg, getResponse())
+	assert.ErrorIs(t, r.Err, context.DeadlineExceeded)
+	assert.False(t, r.Partial)
+}
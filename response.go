@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/miekg/dns"
 	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/nsmithuk/resolver/dnssec/doe"
 	"time"
 )
 
@@ -13,6 +14,116 @@ type Response struct {
 	Duration time.Duration
 	Deo      dnssec.DenialOfExistenceState
 	Auth     dnssec.AuthenticationResult
+
+	// Wildcard is the wildcard owner name (e.g. `*.example.com.`) the answer was synthesised from, as
+	// determined during DNSSEC validation. Empty if the answer was not from a wildcard, or was not validated.
+	Wildcard string
+
+	// DeoExplanation details the NSEC or NSEC3 records used to prove (or attempt to prove) Deo, as determined
+	// during DNSSEC validation. Nil if the answer was not validated, or no such records were seen.
+	DeoExplanation *doe.Explanation
+
+	// DenialRecords are the NSEC or NSEC3 records, with their RRSIGs, that proved a Secure negative answer.
+	// Only populated when IncludeDenialRecords is enabled.
+	DenialRecords []dns.RR
+
+	// Chain reports, for each zone in the DNSSEC validation chain, the DS digest types and DNSKEY algorithms
+	// observed. Ordered root to leaf. Nil if the answer was not validated.
+	Chain []dnssec.ChainLink
+
+	// DeferredValidation holds what's needed to validate the answer later, when CtxDeferValidation was set. Auth is
+	// then Unknown. Nil otherwise.
+	DeferredValidation *DeferredValidation
+
+	// FetchFailures lists the zones in Chain whose DNSKEY records, or DS records from their parent, could not be
+	// fetched; i.e. where the chain of trust broke. Nil if the answer was not validated, or nothing failed.
+	FetchFailures []string
+
+	// TrustAnchors are the root trust anchors (see dnssec.RootTrustAnchors) that the root zone's keys were validated
+	// against, and so that anchored Chain. Useful for confirming which anchor is in effect during a root KSK rollover.
+	// Nil if the answer was not validated, or the root's keys could not be validated.
+	TrustAnchors []*dns.DS
+
+	// BogusReason categorises why Auth is Bogus. NotBogus otherwise.
+	BogusReason dnssec.BogusReason
+
+	// InsecureReason categorises why the answer for the original QName was Insecure; e.g. distinguishing a delegation
+	// covered by an NSEC3 opt-out from a chain with no DNSSEC at all. NotInsecure otherwise.
+	InsecureReason dnssec.InsecureReason
+
+	// Validated is true if DNSSEC validation ran on the answer; i.e. the DO bit was set on the question, and validation
+	// was not deferred. Auth is only meaningful when this is true.
+	Validated bool
+
+	// EnforcementSkipped is true if validation ran, but its result was not enforced as the CD bit was set on the
+	// question. A Bogus or Indeterminate answer is then returned as-is, rather than as a SERVFAIL.
+	EnforcementSkipped bool
+
+	// ValidationDuration is the time spent waiting on DNSSEC validation, once the answer was found.
+	// Zero if DNSSEC validation was not requested.
+	ValidationDuration time.Duration
+
+	// Timings breaks down, per zone, the time spent resolving the answer; ordered as the zones were first used.
+	// Zones only used when following a CNAME chain are not included.
+	Timings []ZoneTiming
+
+	// Path lists, in order, the address of the server used in each zone to produce the answer. Zones answered from
+	// the cache are not included. For the full detail of a resolution, see Trace.
+	Path []string
+
+	// AuthoritativeZone is the apex of the zone that answered the question. e.g. `example.com.` for `www.example.com.`.
+	// When following a CNAME chain, this is the zone that answered the original QName.
+	AuthoritativeZone string
+
+	// ChainAuth lists the DNSSEC state of each hop, in order, when following a CNAME chain; starting with the answer
+	// for the original QName. Auth is then the weakest of these. Empty if no CNAME was followed.
+	ChainAuth []dnssec.AuthenticationResult
+
+	// ChainHops details each hop of a CNAME chain, in the same order as ChainAuth; including the zone that answered it.
+	// Empty if no CNAME was followed.
+	ChainHops []ChainHop
+
+	// SourceZones lists, in the order first used, the distinct zones whose answers were assembled into the response,
+	// when following a CNAME chain; starting with AuthoritativeZone. Empty if no CNAME was followed. See MultipleSources().
+	SourceZones []string
+
+	// Partial is true if the answer is incomplete; either as the context's deadline was reached before a CNAME chain
+	// could be fully followed (only when BestEffortOnDeadline is enabled), or as the chain was cut at
+	// MaxCNAMEChainAnswerRecords.
+	Partial bool
+
+	// Stale is true if the answer was served from an expired cache entry, as it couldn't be resolved before the
+	// context's deadline. Only set when BestEffortOnDeadline is enabled. See CacheStaleInterface.
+	Stale bool
+
+	// TruncatedTCP is true if the answer was taken from a response that was truncated, even over TCP, so may be
+	// incomplete. Only set when AcceptTruncatedTCPResponses is enabled.
+	TruncatedTCP bool
+
+	// Raw is a copy of the response for the QName, as received, before it was finalised. Only populated when
+	// IncludeRawResponse is enabled.
+	Raw *dns.Msg
+
+	// server is the address of the nameserver that returned this response, if it came from the network.
+	server string
+
+	// queryId identifies the upstream query, and its retries, that returned this response. See Trace.nextQueryID().
+	queryId string
+
+	// fromCache is true if the response was served from the cache.
+	fromCache bool
+}
+
+// ChainHop reports the DNSSEC state of a single hop in a CNAME chain.
+type ChainHop struct {
+	// Name is the name the hop answered for; i.e. the original QName, or a CNAME's target.
+	Name string
+
+	// Zone is the apex of the zone that answered for Name.
+	Zone string
+
+	// Auth is the DNSSEC state of the hop's answer.
+	Auth dnssec.AuthenticationResult
 }
 
 func (r *Response) HasError() bool {
@@ -23,6 +134,12 @@ func (r *Response) IsEmpty() bool {
 	return r == nil || r.Msg == nil
 }
 
+// MultipleSources returns true if the answer was assembled from more than one zone; e.g. a CNAME in `example.com.`
+// pointing to a name in `example.net.`. Each zone is its own trust boundary.
+func (r *Response) MultipleSources() bool {
+	return r != nil && len(r.SourceZones) > 1
+}
+
 func (r *Response) truncated() bool {
 	if r.IsEmpty() {
 		return false
@@ -30,6 +147,32 @@ func (r *Response) truncated() bool {
 	return r.Msg.Truncated
 }
 
+// oversized returns true if the response exceeds MaxResponseSize.
+func (r *Response) oversized() bool {
+	if r.IsEmpty() {
+		return false
+	}
+	_, oversized := oversizedResponse(r.Msg)
+	return oversized
+}
+
+// unsuccessful returns true if the response is a SERVFAIL or REFUSED. Another server may be able to do better.
+func (r *Response) unsuccessful() bool {
+	if r.IsEmpty() {
+		return false
+	}
+	return r.Msg.Rcode == dns.RcodeServerFailure || r.Msg.Rcode == dns.RcodeRefused
+}
+
+// emptyNoError returns true if the response is a NOERROR response with an empty Answer section, but is
+// neither NODATA (i.e. there's no SOA), nor a referral (i.e. there are no NS records).
+func (r *Response) emptyNoError() bool {
+	if r.IsEmpty() || r.HasError() || !r.Msg.Response || r.Msg.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	return len(r.Msg.Answer) == 0 && !recordsOfTypeExist(r.Msg.Ns, dns.TypeSOA) && !recordsOfTypeExist(r.Msg.Ns, dns.TypeNS)
+}
+
 func ResponseError(err error) *Response {
 	return &Response{
 		Err: err,
@@ -0,0 +1,131 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"sync"
+	"time"
+)
+
+var ErrSessionEntryNotFound = errors.New("no matching entry found in the session being replayed")
+
+// SessionEntry is a single query sent to a nameserver, and what was received back, during an Exchange.
+// Messages are stored in wire format so the entry can be serialised.
+type SessionEntry struct {
+	TraceID   string        `json:"trace_id"`
+	Iteration uint32        `json:"iteration"`
+	Zone      string        `json:"zone"`
+	Server    string        `json:"server"`
+	Query     []byte        `json:"query"`
+	Response  []byte        `json:"response,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Session records every query sent to a nameserver, and the response received, during an Exchange.
+// The recorded entries can later be replayed, re-running the exact resolution offline.
+//
+// To record or replay, the session is passed to Exchange via the context, using the key CtxSession.
+type Session struct {
+	lock sync.Mutex
+
+	Entries []SessionEntry `json:"entries"`
+
+	replay bool
+	used   []bool
+}
+
+// NewSessionRecorder returns a session that records all queries and responses.
+func NewSessionRecorder() *Session {
+	return &Session{
+		Entries: make([]SessionEntry, 0),
+	}
+}
+
+// NewSessionReplay returns a session that answers queries using the given, previously recorded, entries.
+// No queries will be sent to the network.
+func NewSessionReplay(entries []SessionEntry) *Session {
+	return &Session{
+		Entries: entries,
+		replay:  true,
+		used:    make([]bool, len(entries)),
+	}
+}
+
+func (s *Session) replaying() bool {
+	return s.replay
+}
+
+func (s *Session) record(ctx context.Context, server string, m *dns.Msg, r *Response) {
+	entry := SessionEntry{
+		Server:   server,
+		Duration: r.Duration,
+	}
+
+	if trace, ok := ctx.Value(CtxTrace).(*Trace); ok {
+		entry.TraceID = trace.ID()
+		entry.Iteration = trace.Iteration()
+	}
+	if z, ok := ctx.Value(ctxZoneName).(string); ok {
+		entry.Zone = z
+	}
+
+	var err error
+	if entry.Query, err = m.Pack(); err != nil {
+		Warn(fmt.Errorf("unable to record query in session: %w", err).Error())
+		return
+	}
+	if !r.IsEmpty() {
+		if entry.Response, err = r.Msg.Pack(); err != nil {
+			Warn(fmt.Errorf("unable to record response in session: %w", err).Error())
+			return
+		}
+	}
+	if r.HasError() {
+		entry.Error = r.Err.Error()
+	}
+
+	s.lock.Lock()
+	s.Entries = append(s.Entries, entry)
+	s.lock.Unlock()
+}
+
+// exchange answers the query from the recorded entries. Entries are matched on the question and the DO bit,
+// and each is used at most once, in the order they were recorded.
+func (s *Session) exchange(ctx context.Context, m *dns.Msg) *Response {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, entry := range s.Entries {
+		if s.used[i] {
+			continue
+		}
+
+		query := new(dns.Msg)
+		if err := query.Unpack(entry.Query); err != nil || len(query.Question) == 0 {
+			continue
+		}
+
+		if query.Question[0] != m.Question[0] || isSetDO(query) != isSetDO(m) {
+			continue
+		}
+
+		s.used[i] = true
+
+		r := &Response{Duration: entry.Duration}
+		if len(entry.Response) > 0 {
+			r.Msg = new(dns.Msg)
+			if err := r.Msg.Unpack(entry.Response); err != nil {
+				return ResponseError(fmt.Errorf("unable to replay response from session: %w", err))
+			}
+		}
+		if entry.Error != "" {
+			r.Err = errors.New(entry.Error)
+		}
+		return r
+	}
+
+	return ResponseError(fmt.Errorf("%w: [%s] %s", ErrSessionEntryNotFound, m.Question[0].Name, TypeToString(m.Question[0].Qtype)))
+}
@@ -0,0 +1,139 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type testSessionDNSClient struct {
+	f func(msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error)
+}
+
+func (c *testSessionDNSClient) ExchangeContext(ctx context.Context, msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+	return c.f(msg, addr)
+}
+
+// getTestSessionResolver returns a resolver that knows only the root zone, whose nameserver, plus the nameserver of
+// any zone created, use the passed client.
+func getTestSessionResolver(client dnsClient) *Resolver {
+	factory := func(protocol string) dnsClient {
+		return client
+	}
+
+	root := &zoneImpl{
+		zoneName: ".",
+		pool: &nameserverPool{
+			ipv4: []exchanger{&nameserver{hostname: "a.root-servers.net.", addr: "198.41.0.4", dnsClientFactory: factory}},
+		},
+	}
+	root.pool.(*nameserverPool).updateIPCount()
+
+	z := new(zones)
+	z.add(root)
+
+	resolver := &Resolver{zones: z}
+	resolver.funcs = resolverFunctions{
+		resolveLabel:         resolver.resolveLabel,
+		checkForMissingZones: resolver.checkForMissingZones,
+		createZone: func(ctx context.Context, name, parent string, nameservers []*dns.NS, extra []dns.RR, exchanger exchanger) (zone, error) {
+			pool := newNameserverPool(nameservers, extra)
+			for _, ns := range pool.ipv4 {
+				ns.(*nameserver).dnsClientFactory = factory
+			}
+			return &zoneImpl{zoneName: name, parentName: parent, pool: pool}, nil
+		},
+		finaliseResponse:  resolver.finaliseResponse,
+		processDelegation: resolver.processDelegation,
+		cname:             cname,
+		getExchanger:      resolver.getExchanger,
+	}
+	return resolver
+}
+
+func TestSession_RecordAndReplay(t *testing.T) {
+
+	ipv6Answered.Store(true)
+	ipv6Available.Store(false)
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.", dns.TypeA)
+
+	// The root delegates to example., which answers.
+	recording := &testSessionDNSClient{
+		f: func(msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+			rmsg := new(dns.Msg).SetReply(msg)
+			switch addr {
+			case "198.41.0.4:53":
+				rmsg.Ns = []dns.RR{
+					&dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "ns1.example."},
+				}
+				rmsg.Extra = []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Name: "ns1.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(192, 0, 2, 53)},
+				}
+			case "192.0.2.53:53":
+				rmsg.Authoritative = true
+				rmsg.Answer = []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(192, 0, 2, 1)},
+				}
+			default:
+				t.Errorf("unexpected server %s", addr)
+			}
+			return rmsg, time.Millisecond, nil
+		},
+	}
+
+	session := NewSessionRecorder()
+	ctx := context.WithValue(context.Background(), CtxSession, session)
+
+	recorded := getTestSessionResolver(recording).Exchange(ctx, qmsg)
+	require.False(t, recorded.HasError())
+	require.Len(t, session.Entries, 2)
+
+	assert.Equal(t, ".", session.Entries[0].Zone)
+	assert.Equal(t, "198.41.0.4:53", session.Entries[0].Server)
+	assert.Equal(t, "example.", session.Entries[1].Zone)
+	assert.Equal(t, "192.0.2.53:53", session.Entries[1].Server)
+
+	// We expect the session to survive serialisation.
+	data, err := json.Marshal(session)
+	require.NoError(t, err)
+
+	var log struct {
+		Entries []SessionEntry `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(data, &log))
+
+	//---
+
+	// When replaying, nothing should hit the network.
+	offline := &testSessionDNSClient{
+		f: func(msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+			t.Errorf("unexpected network query to %s", addr)
+			return nil, 0, nil
+		},
+	}
+
+	ctx = context.WithValue(context.Background(), CtxSession, NewSessionReplay(log.Entries))
+
+	replayed := getTestSessionResolver(offline).Exchange(ctx, qmsg)
+	require.False(t, replayed.HasError())
+
+	assert.Equal(t, recorded.Msg.String(), replayed.Msg.String())
+}
+
+func TestSession_ReplayEntryNotFound(t *testing.T) {
+	session := NewSessionReplay([]SessionEntry{})
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.", dns.TypeA)
+
+	r := session.exchange(context.Background(), qmsg)
+	assert.True(t, r.HasError())
+	assert.ErrorIs(t, r.Err, ErrSessionEntryNotFound)
+}
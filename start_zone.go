@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"github.com/miekg/dns"
+)
+
+// Zone is a handle on a zone known to a resolver; i.e. a delegation and its nameservers.
+//
+// A Zone can be used as the starting point of a resolution by passing it to Exchange via the context, using the key
+// CtxStartZone. For names within the zone, the walk down from the root is then skipped, and the zone's nameservers
+// are asked directly. This is useful when many names are being resolved under a zone that's already known.
+type Zone struct {
+	z zone
+}
+
+// Zone returns a handle on the zone with the given apex name, if the resolver currently knows of it. Otherwise nil.
+func (resolver *Resolver) Zone(name string) *Zone {
+	name = canonicalName(name)
+	z := resolver.zones.get(name)
+	if z == nil || !namesEqual(z.name(), name) || z.expired() {
+		return nil
+	}
+	return &Zone{z: z}
+}
+
+// Name returns the zone's apex domain name.
+func (z *Zone) Name() string {
+	return z.z.name()
+}
+
+// startZones returns the zones to start resolving question from, when the caller supplied start via CtxStartZone.
+// They're ordered most specific to least, as per zoneStore.getZoneList(). Nil is returned if start cannot be used,
+// in which case the resolution proceeds as normal.
+//
+// If DNSSEC validation is needed, the chain of trust must still be built from the root, so the known zones above
+// start are included. If they don't reach start's parent, we cannot fetch start's DS records, so it's not used.
+func (resolver *Resolver) startZones(start *Zone, question dns.Question, validating bool) []zone {
+	if start == nil || start.z == nil || start.z.expired() {
+		return nil
+	}
+
+	name := canonicalName(start.z.name())
+	qname := canonicalName(question.Name)
+
+	// The zone must be an ancestor of the QName, and not the zone the QName's DS records are served from.
+	if !dns.IsSubDomain(name, qname) || (question.Qtype == dns.TypeDS && name == qname) {
+		return nil
+	}
+
+	if name == "." || !validating {
+		return []zone{start.z}
+	}
+
+	ancestors := resolver.zones.getZoneList(start.z.parent())
+	for len(ancestors) > 0 && !namesEqual(ancestors[0].name(), start.z.parent()) {
+		ancestors = ancestors[1:]
+	}
+	if len(ancestors) == 0 {
+		return nil
+	}
+
+	return append([]zone{start.z}, ancestors...)
+}
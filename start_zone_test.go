@@ -0,0 +1,107 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestResolver_Exchange_StartZone(t *testing.T) {
+	r := getTestResolverWithRoot()
+
+	example := getMockZone("example.com.", "com.")
+
+	var zonesUsed []string
+	r.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		zonesUsed = append(zonesUsed, z.name())
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")}
+		return nil, &Response{Msg: rmsg}
+	}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	// The walk starts at example.com., so neither the root nor com. are queried.
+	ctx := context.WithValue(context.Background(), CtxStartZone, &Zone{z: example})
+	response := r.Exchange(ctx, qmsg)
+	require.NoError(t, response.Err)
+	assert.Len(t, response.Msg.Answer, 1)
+	assert.Equal(t, []string{"example.com."}, zonesUsed)
+
+	// A zone that's not an ancestor of the QName is ignored.
+	zonesUsed = nil
+	qmsg.SetQuestion("www.example.net.", dns.TypeA)
+	r.Exchange(ctx, qmsg)
+	assert.Equal(t, []string{"."}, zonesUsed)
+
+	// As is the zone when it's the QName of a DS question, as they're served by the parent.
+	zonesUsed = nil
+	qmsg.SetQuestion("example.com.", dns.TypeDS)
+	r.Exchange(ctx, qmsg)
+	assert.Equal(t, []string{"."}, zonesUsed)
+}
+
+func TestResolver_Exchange_StartZoneDNSSEC(t *testing.T) {
+	r, _, com, _, _ := getTestResolverWithExample()
+
+	// A handle on example.com. that's separate from the one in the resolver's store.
+	start := getMockZone("example.com.", "com.")
+
+	var lock sync.Mutex
+	var dsQuestions []dns.Question
+	com.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		lock.Lock()
+		defer lock.Unlock()
+		dsQuestions = append(dsQuestions, m.Question[0])
+		return nil
+	}
+
+	var zonesUsed []string
+	r.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		zonesUsed = append(zonesUsed, z.name())
+		auth.close() // Waits for the DS records to have been requested.
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		return nil, &Response{Msg: rmsg}
+	}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	qmsg.SetEdns0(4096, true)
+
+	ctx := context.WithValue(context.Background(), CtxStartZone, &Zone{z: start})
+	r.Exchange(ctx, qmsg)
+	assert.Equal(t, []string{"example.com."}, zonesUsed)
+
+	// The chain of trust is still seeded from the start zone's DS records, served by its parent.
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Contains(t, dsQuestions, dns.Question{Name: "example.com.", Qtype: dns.TypeDS, Qclass: dns.ClassINET})
+}
+
+func TestResolver_StartZonesRequireParentWhenValidating(t *testing.T) {
+	r := getTestResolverWithRoot()
+	start := &Zone{z: getMockZone("example.com.", "com.")}
+	question := dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	// We only know the root, so com. can't be asked for example.com.'s DS records.
+	assert.Nil(t, r.startZones(start, question, true))
+
+	// Which doesn't matter if we're not validating.
+	zones := r.startZones(start, question, false)
+	require.Len(t, zones, 1)
+	assert.Equal(t, "example.com.", zones[0].name())
+}
+
+func TestResolver_Zone(t *testing.T) {
+	r, _, _, _, _ := getTestResolverWithExample()
+
+	z := r.Zone("Example.com")
+	require.NotNil(t, z)
+	assert.Equal(t, "example.com.", z.Name())
+
+	assert.Nil(t, r.Zone("example.net."))
+}
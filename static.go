@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"sync"
+)
+
+// staticRecords are name to record mappings, like a hosts file, that are answered directly, without querying upstream.
+type staticRecords struct {
+	lock    sync.RWMutex
+	records map[string][]dns.RR
+	aliases map[string]*alias
+}
+
+// AddStaticRecord adds a record that questions for its owner name are answered with, without querying upstream.
+// Only A, AAAA and CNAME records are supported, and a CNAME cannot share its name with any other record. Answers are
+// marked as authoritative, with the TTL set to StaticRecordTTL. They're never DNSSEC validated, so the AD bit is not
+// set; if DNSSEC was requested, they're reported as Insecure.
+func (resolver *Resolver) AddStaticRecord(rr dns.RR) error {
+	switch rr.(type) {
+	case *dns.A, *dns.AAAA, *dns.CNAME:
+	default:
+		return fmt.Errorf("%w: %s", ErrStaticRecordUnsupported, TypeToString(rr.Header().Rrtype))
+	}
+
+	name := canonicalName(rr.Header().Name)
+	rr = dns.Copy(rr)
+	rr.Header().Name = name
+
+	s := &resolver.static
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	existing := s.records[name]
+	if s.aliases[name] != nil {
+		return fmt.Errorf("%w: %s", ErrStaticRecordConflict, name)
+	}
+	if len(existing) > 0 && (rr.Header().Rrtype == dns.TypeCNAME || recordsOfTypeExist(existing, dns.TypeCNAME)) {
+		return fmt.Errorf("%w: %s", ErrStaticRecordConflict, name)
+	}
+
+	if s.records == nil {
+		s.records = make(map[string][]dns.RR)
+	}
+	s.records[name] = append(existing, rr)
+	return nil
+}
+
+// RemoveStaticRecords removes all the static records for name.
+func (resolver *Resolver) RemoveStaticRecords(name string) {
+	s := &resolver.static
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.records, canonicalName(name))
+}
+
+// lookup returns the static records for the question. A name with records, but none of the qtype (nor a CNAME),
+// returns an empty, non-nil, slice. A nil slice is returned if we have no records for the name.
+func (s *staticRecords) lookup(question dns.Question) []dns.RR {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	records, ok := s.records[canonicalName(question.Name)]
+	if !ok {
+		return nil
+	}
+
+	answer := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		if rr.Header().Rrtype == question.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+			rr = dns.Copy(rr)
+			rr.Header().Ttl = StaticRecordTTL
+			answer = append(answer, rr)
+		}
+	}
+	return answer
+}
+
+// staticAnswer returns the synthesised response to qmsg, from the static records found by lookup().
+// If the answer is a CNAME, its target is followed as normal.
+func (resolver *Resolver) staticAnswer(ctx context.Context, qmsg *dns.Msg, answer []dns.RR) *Response {
+	msg := new(dns.Msg)
+	msg.SetReply(qmsg)
+	msg.Authoritative = true
+	msg.RecursionAvailable = true
+	msg.Answer = answer
+
+	response := &Response{Msg: msg}
+	if isSetDO(qmsg) {
+		response.Auth = dnssec.Insecure
+	}
+
+	if qmsg.Question[0].Qtype != dns.TypeCNAME && recordsOfTypeExist(answer, dns.TypeCNAME) {
+		if err := resolver.funcs.cname(ctx, qmsg, response, resolver.funcs.getExchanger()); err != nil {
+			return ResponseError(err)
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,116 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func getTestResolverWithStaticRecords(t *testing.T) *Resolver {
+	r := getTestResolverWithRoot()
+	r.funcs.cname = cname
+	r.funcs.getExchanger = func() exchanger {
+		return r
+	}
+	r.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		t.Errorf("unexpected upstream query for [%s]", qmsg.Question[0].Name)
+		return nil, ResponseError(ErrInternalError)
+	}
+	return r
+}
+
+func TestResolver_StaticRecordShortCircuits(t *testing.T) {
+	defer func() { StaticRecordTTL = DefaultStaticRecordTTL }()
+	StaticRecordTTL = 30
+
+	r := getTestResolverWithStaticRecords(t)
+	require.NoError(t, r.AddStaticRecord(newRR("Printer.Example.com. 3600 IN A 192.0.2.10")))
+	require.NoError(t, r.AddStaticRecord(newRR("printer.example.com. 3600 IN AAAA 2001:db8::10")))
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("printer.example.com.", dns.TypeA)
+	qmsg.SetEdns0(4096, true)
+
+	response := r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	require.False(t, response.IsEmpty())
+
+	assert.Equal(t, dns.RcodeSuccess, response.Msg.Rcode)
+	assert.True(t, response.Msg.Authoritative)
+	assert.False(t, response.Msg.AuthenticatedData)
+	assert.Equal(t, dnssec.Insecure, response.Auth)
+
+	require.Len(t, response.Msg.Answer, 1)
+	a, ok := response.Msg.Answer[0].(*dns.A)
+	require.True(t, ok)
+	assert.Equal(t, "printer.example.com.", a.Hdr.Name)
+	assert.Equal(t, "192.0.2.10", a.A.String())
+	assert.Equal(t, uint32(30), a.Hdr.Ttl)
+
+	// A type we have no record for is NODATA.
+	qmsg.SetQuestion("printer.example.com.", dns.TypeMX)
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	assert.Equal(t, dns.RcodeSuccess, response.Msg.Rcode)
+	assert.Empty(t, response.Msg.Answer)
+
+	// Once removed, the name is resolved upstream as normal.
+	r.RemoveStaticRecords("printer.example.com")
+	assert.Nil(t, r.static.lookup(dns.Question{Name: "printer.example.com.", Qtype: dns.TypeA}))
+}
+
+func TestResolver_StaticRecordCNAME(t *testing.T) {
+	r := getTestResolverWithStaticRecords(t)
+	require.NoError(t, r.AddStaticRecord(newRR("www.example.com. 3600 IN CNAME printer.example.com.")))
+	require.NoError(t, r.AddStaticRecord(newRR("printer.example.com. 3600 IN A 192.0.2.10")))
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	qmsg.RecursionDesired = true
+
+	// The CNAME's target is followed.
+	response := r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	require.Len(t, response.Msg.Answer, 2)
+	assert.IsType(t, &dns.CNAME{}, response.Msg.Answer[0])
+	assert.IsType(t, &dns.A{}, response.Msg.Answer[1])
+	assert.Equal(t, dnssec.Unknown, response.Auth)
+
+	// A question for the CNAME itself isn't followed.
+	qmsg.SetQuestion("www.example.com.", dns.TypeCNAME)
+	response = r.Exchange(context.Background(), qmsg)
+	require.NoError(t, response.Err)
+	require.Len(t, response.Msg.Answer, 1)
+	assert.IsType(t, &dns.CNAME{}, response.Msg.Answer[0])
+}
+
+func TestResolver_StaticRecordCNAMELoop(t *testing.T) {
+	r := getTestResolverWithStaticRecords(t)
+	require.NoError(t, r.AddStaticRecord(newRR("a.example.com. 3600 IN CNAME b.example.com.")))
+	require.NoError(t, r.AddStaticRecord(newRR("b.example.com. 3600 IN CNAME a.example.com.")))
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion("a.example.com.", dns.TypeA)
+	qmsg.RecursionDesired = true
+
+	response := r.Exchange(context.Background(), qmsg)
+	assert.ErrorIs(t, response.Err, ErrMaxQueriesPerRequestReached)
+}
+
+func TestResolver_AddStaticRecordErrors(t *testing.T) {
+	r := getTestResolverWithRoot()
+
+	err := r.AddStaticRecord(newRR("example.com. 3600 IN MX 10 mail.example.com."))
+	assert.ErrorIs(t, err, ErrStaticRecordUnsupported)
+
+	require.NoError(t, r.AddStaticRecord(newRR("www.example.com. 3600 IN A 192.0.2.10")))
+	err = r.AddStaticRecord(newRR("www.example.com. 3600 IN CNAME example.com."))
+	assert.ErrorIs(t, err, ErrStaticRecordConflict)
+
+	require.NoError(t, r.AddStaticRecord(newRR("alias.example.com. 3600 IN CNAME example.com.")))
+	err = r.AddStaticRecord(newRR("alias.example.com. 3600 IN A 192.0.2.10"))
+	assert.ErrorIs(t, err, ErrStaticRecordConflict)
+}
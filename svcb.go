@@ -0,0 +1,163 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"net"
+)
+
+// ServiceBinding is a parsed SVCB or HTTPS record, with its SvcParams extracted into usable values.
+// See https://datatracker.ietf.org/doc/html/rfc9460
+type ServiceBinding struct {
+	Name     string
+	Priority uint16
+	Target   string
+
+	ALPN          []string
+	NoDefaultALPN bool
+	Port          uint16
+	IPv4Hint      []net.IP
+	IPv6Hint      []net.IP
+
+	// ECH is the ECHConfigList, including its length prefix. Nil if not present, or if malformed.
+	ECH []byte
+
+	// Addresses are the A/AAAA records of the origin, when Target is "." in ServiceMode.
+	// Only populated by Resolver.ResolveServiceBindings().
+	Addresses []net.IP
+
+	// Auth is the DNSSEC state of the SVCB/HTTPS RRset the binding came from.
+	Auth dnssec.AuthenticationResult
+}
+
+// AliasMode returns true if the binding is in AliasMode (priority 0), rather than ServiceMode.
+func (b *ServiceBinding) AliasMode() bool {
+	return b.Priority == 0
+}
+
+// TargetIsOrigin returns true if the target is ".", meaning the owner name itself (in ServiceMode).
+func (b *ServiceBinding) TargetIsOrigin() bool {
+	return b.Target == "."
+}
+
+// ServiceBindings extracts all SVCB and HTTPS records from the response's Answer section.
+func ServiceBindings(response *Response) []*ServiceBinding {
+	if response.IsEmpty() {
+		return nil
+	}
+
+	bindings := make([]*ServiceBinding, 0, len(response.Msg.Answer))
+	for _, rr := range response.Msg.Answer {
+		var svcb *dns.SVCB
+		switch r := rr.(type) {
+		case *dns.SVCB:
+			svcb = r
+		case *dns.HTTPS:
+			svcb = &r.SVCB
+		default:
+			continue
+		}
+
+		b := parseServiceBinding(svcb)
+		b.Auth = response.Auth
+		bindings = append(bindings, b)
+	}
+
+	return bindings
+}
+
+func parseServiceBinding(rr *dns.SVCB) *ServiceBinding {
+	b := &ServiceBinding{
+		Name:     canonicalName(rr.Header().Name),
+		Priority: rr.Priority,
+		Target:   canonicalName(rr.Target),
+	}
+
+	if b.AliasMode() {
+		// https://datatracker.ietf.org/doc/html/rfc9460#section-2.4.2
+		// In AliasMode, records SHOULD NOT include any SvcParams, and recipients MUST ignore any SvcParams that are present.
+		return b
+	}
+
+	for _, kv := range rr.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			b.ALPN = v.Alpn
+		case *dns.SVCBNoDefaultAlpn:
+			b.NoDefaultALPN = true
+		case *dns.SVCBPort:
+			b.Port = v.Port
+		case *dns.SVCBIPv4Hint:
+			b.IPv4Hint = v.Hint
+		case *dns.SVCBIPv6Hint:
+			b.IPv6Hint = v.Hint
+		case *dns.SVCBECHConfig:
+			if validECHConfigList(v.ECH) {
+				b.ECH = v.ECH
+			} else {
+				Warn(fmt.Sprintf("ignoring malformed ech SvcParam on [%s]", b.Name))
+			}
+		}
+	}
+
+	return b
+}
+
+// validECHConfigList checks the ECHConfigList's 2 byte length prefix matches the length of the data that follows.
+// https://datatracker.ietf.org/doc/html/draft-ietf-tls-esni#section-4
+func validECHConfigList(ech []byte) bool {
+	if len(ech) <= 2 {
+		return false
+	}
+	return int(binary.BigEndian.Uint16(ech)) == len(ech)-2
+}
+
+// ResolveServiceBindings looks up the SVCB or HTTPS (qtype) records for name, returning them parsed.
+// For bindings in ServiceMode whose target is the origin ("."), the origin's A and AAAA records are also resolved.
+func (resolver *Resolver) ResolveServiceBindings(ctx context.Context, name string, qtype uint16) ([]*ServiceBinding, error) {
+	if qtype != dns.TypeSVCB && qtype != dns.TypeHTTPS {
+		return nil, fmt.Errorf("%w: %s", ErrNotServiceBindingType, TypeToString(qtype))
+	}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion(dns.Fqdn(name), qtype)
+	qmsg.SetEdns0(4096, true)
+
+	response := resolver.Exchange(ctx, qmsg)
+	if response.HasError() {
+		return nil, response.Err
+	}
+
+	bindings := ServiceBindings(response)
+
+	for _, b := range bindings {
+		if b.AliasMode() || !b.TargetIsOrigin() {
+			continue
+		}
+		for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			amsg := new(dns.Msg)
+			amsg.SetQuestion(dns.Fqdn(b.Name), t)
+			amsg.SetEdns0(4096, true)
+
+			r := resolver.Exchange(ctx, amsg)
+			if r.HasError() {
+				return nil, r.Err
+			}
+			if r.IsEmpty() {
+				continue
+			}
+
+			for _, rr := range extractRecords[*dns.A](r.Msg.Answer) {
+				b.Addresses = append(b.Addresses, rr.A)
+			}
+			for _, rr := range extractRecords[*dns.AAAA](r.Msg.Answer) {
+				b.Addresses = append(b.Addresses, rr.AAAA)
+			}
+		}
+	}
+
+	return bindings, nil
+}
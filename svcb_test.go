@@ -0,0 +1,97 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestServiceBindings_HTTPS(t *testing.T) {
+	response := &Response{
+		Msg: &dns.Msg{Answer: []dns.RR{
+			newRR("example.com. 300 IN HTTPS 1 . alpn=h2,h3 port=8443 ipv4hint=192.0.2.1,192.0.2.2 ipv6hint=2001:db8::1"),
+			newRR("example.com. 300 IN A 192.0.2.53"),
+		}},
+		Auth: dnssec.Secure,
+	}
+
+	bindings := ServiceBindings(response)
+	require.Len(t, bindings, 1)
+
+	b := bindings[0]
+	assert.Equal(t, "example.com.", b.Name)
+	assert.Equal(t, uint16(1), b.Priority)
+	assert.False(t, b.AliasMode())
+	assert.True(t, b.TargetIsOrigin())
+	assert.Equal(t, []string{"h2", "h3"}, b.ALPN)
+	assert.Equal(t, uint16(8443), b.Port)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1").To4(), net.ParseIP("192.0.2.2").To4()}, b.IPv4Hint)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::1")}, b.IPv6Hint)
+	assert.Nil(t, b.ECH)
+
+	// The DNSSEC state of the RRset is carried.
+	assert.Equal(t, dnssec.Secure, b.Auth)
+}
+
+func TestServiceBindings_AliasModeIgnoresParams(t *testing.T) {
+	svcb := newRR("example.com. 300 IN SVCB 0 svc.example.net.").(*dns.SVCB)
+	svcb.Value = []dns.SVCBKeyValue{&dns.SVCBAlpn{Alpn: []string{"h2"}}}
+
+	bindings := ServiceBindings(&Response{Msg: &dns.Msg{Answer: []dns.RR{svcb}}})
+	require.Len(t, bindings, 1)
+	assert.True(t, bindings[0].AliasMode())
+	assert.Equal(t, "svc.example.net.", bindings[0].Target)
+	assert.Nil(t, bindings[0].ALPN)
+}
+
+func TestServiceBindings_ECH(t *testing.T) {
+	https := newRR("example.com. 300 IN HTTPS 1 .").(*dns.HTTPS)
+
+	// Valid; the length prefix matches.
+	https.Value = []dns.SVCBKeyValue{&dns.SVCBECHConfig{ECH: []byte{0x00, 0x03, 0x01, 0x02, 0x03}}}
+	bindings := ServiceBindings(&Response{Msg: &dns.Msg{Answer: []dns.RR{https}}})
+	require.Len(t, bindings, 1)
+	assert.Equal(t, []byte{0x00, 0x03, 0x01, 0x02, 0x03}, bindings[0].ECH)
+
+	// Invalid; the length prefix does not match.
+	https.Value = []dns.SVCBKeyValue{&dns.SVCBECHConfig{ECH: []byte{0x00, 0x09, 0x01, 0x02, 0x03}}}
+	bindings = ServiceBindings(&Response{Msg: &dns.Msg{Answer: []dns.RR{https}}})
+	require.Len(t, bindings, 1)
+	assert.Nil(t, bindings[0].ECH)
+}
+
+func TestResolver_ResolveServiceBindings_TargetIsOrigin(t *testing.T) {
+	resolver := getTestResolverWithRoot()
+
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, auth *authenticator) (zone, *Response) {
+		assert.True(t, isSetDO(qmsg))
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		switch qmsg.Question[0].Qtype {
+		case dns.TypeHTTPS:
+			rmsg.Answer = []dns.RR{newRR("example.com. 300 IN HTTPS 1 . alpn=h2 ipv4hint=192.0.2.1")}
+		case dns.TypeA:
+			rmsg.Answer = []dns.RR{newRR("example.com. 300 IN A 192.0.2.53")}
+		case dns.TypeAAAA:
+			rmsg.Answer = []dns.RR{newRR("example.com. 300 IN AAAA 2001:db8::53")}
+		}
+		return nil, &Response{Msg: rmsg, Auth: dnssec.Secure}
+	}
+
+	bindings, err := resolver.ResolveServiceBindings(context.Background(), "example.com", dns.TypeHTTPS)
+	require.NoError(t, err)
+	require.Len(t, bindings, 1)
+
+	assert.Equal(t, []string{"h2"}, bindings[0].ALPN)
+	assert.Equal(t, dnssec.Secure, bindings[0].Auth)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.53"), net.ParseIP("2001:db8::53")}, bindings[0].Addresses)
+}
+
+func TestResolver_ResolveServiceBindings_InvalidType(t *testing.T) {
+	resolver := getTestResolverWithRoot()
+	_, err := resolver.ResolveServiceBindings(context.Background(), "example.com", dns.TypeA)
+	assert.ErrorIs(t, err, ErrNotServiceBindingType)
+}
@@ -0,0 +1,80 @@
+package resolver
+
+import (
+	"github.com/nsmithuk/resolver/dnssec"
+	"sync"
+	"time"
+)
+
+// ZoneTiming breaks down the time spent on a single zone while resolving a question.
+type ZoneTiming struct {
+	// Zone is the zone's apex.
+	Zone string
+
+	// Network is the time spent exchanging the question with the zone's nameservers, including any retries.
+	// Close to zero if the answer came from the cache.
+	Network time.Duration
+
+	// DNSKEY is the time spent waiting on the zone's DNSKEY records during DNSSEC validation.
+	DNSKEY time.Duration
+
+	// Validation is the time spent validating the zone's response, excluding DNSKEY.
+	Validation time.Duration
+}
+
+// Total returns the sum of the timings.
+func (t ZoneTiming) Total() time.Duration {
+	return t.Network + t.DNSKEY + t.Validation
+}
+
+// resolutionTimings records the time spent per zone during a single call to exchange(). It's safe for concurrent use,
+// as DNSSEC validation runs alongside the resolution.
+type resolutionTimings struct {
+	lock  sync.Mutex
+	zones []ZoneTiming
+}
+
+// get returns the timing for zone, adding it if it's not yet been seen. The caller must hold the lock.
+func (t *resolutionTimings) get(zone string) *ZoneTiming {
+	zone = canonicalName(zone)
+	for i := range t.zones {
+		if t.zones[i].Zone == zone {
+			return &t.zones[i]
+		}
+	}
+	t.zones = append(t.zones, ZoneTiming{Zone: zone})
+	return &t.zones[len(t.zones)-1]
+}
+
+func (t *resolutionTimings) addNetwork(zone string, d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.get(zone).Network += d
+}
+
+func (t *resolutionTimings) addDNSKEY(zone string, d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.get(zone).DNSKEY += d
+}
+
+// addValidation records the time spent validating each zone in the chain. The chain's durations include fetching
+// the DNSKEY records, so that time is subtracted.
+func (t *resolutionTimings) addValidation(chain []dnssec.ChainLink) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, link := range chain {
+		timing := t.get(link.Zone)
+		timing.Validation += max(link.Duration-timing.DNSKEY, 0)
+	}
+}
+
+// list returns the timings, in the order the zones were first seen.
+func (t *resolutionTimings) list() []ZoneTiming {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.zones) == 0 {
+		return nil
+	}
+	return append([]ZoneTiming(nil), t.zones...)
+}
@@ -0,0 +1,111 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestResolutionTimings(t *testing.T) {
+	timings := new(resolutionTimings)
+	assert.Nil(t, timings.list())
+
+	timings.addNetwork(".", 10*time.Millisecond)
+	timings.addNetwork("Example.com.", 20*time.Millisecond)
+	timings.addNetwork("example.com.", 5*time.Millisecond)
+	timings.addDNSKEY("example.com.", 3*time.Millisecond)
+
+	// The chain's durations include the DNSKEY fetch, which is removed.
+	timings.addValidation([]dnssec.ChainLink{
+		{Zone: ".", Duration: 2 * time.Millisecond},
+		{Zone: "example.com.", Duration: 7 * time.Millisecond},
+	})
+
+	list := timings.list()
+	require.Len(t, list, 2)
+
+	assert.Equal(t, ZoneTiming{Zone: ".", Network: 10 * time.Millisecond, Validation: 2 * time.Millisecond}, list[0])
+	assert.Equal(t, ZoneTiming{Zone: "example.com.", Network: 25 * time.Millisecond, DNSKEY: 3 * time.Millisecond, Validation: 4 * time.Millisecond}, list[1])
+	assert.Equal(t, 32*time.Millisecond, list[1].Total())
+}
+
+func TestResolver_Exchange_Timings(t *testing.T) {
+
+	// Each zone used should have a timing, which together account for (roughly) all of the response's duration.
+
+	root := getMockZone(".", "")
+	com := getMockZone("com.", ".")
+	example := getMockZone("example.com.", "com.")
+
+	resolver := getTestResolverWithRoot()
+	resolver.zones = mockZoneStore{
+		mockGet: func(name string) zone {
+			return nil
+		},
+		mockZoneList: func(name string) []zone {
+			return []zone{root}
+		},
+	}
+	resolver.funcs.resolveLabel = resolver.resolveLabel
+	resolver.funcs.finaliseResponse = resolver.finaliseResponse
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return z
+	}
+	resolver.funcs.processDelegation = func(ctx context.Context, z zone, rmsg *dns.Msg) (zone, *Response) {
+		switch z.name() {
+		case ".":
+			return com, nil
+		case "com.":
+			return example, nil
+		}
+		return nil, ResponseError(errors.New("unexpected zone"))
+	}
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+
+	delegation := func(m *dns.Msg, name string) *dns.Msg {
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Ns = []dns.RR{newRR(name + " 3600 IN NS ns1." + name)}
+		return rmsg
+	}
+
+	root.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		time.Sleep(5 * time.Millisecond)
+		return &Response{Msg: delegation(m, "com.")}
+	}
+	com.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		time.Sleep(10 * time.Millisecond)
+		return &Response{Msg: delegation(m, "example.com.")}
+	}
+	example.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		time.Sleep(15 * time.Millisecond)
+		rmsg := new(dns.Msg).SetReply(m)
+		rmsg.Answer = []dns.RR{newRR("www.example.com. 300 IN A 192.0.2.80")}
+		return &Response{Msg: rmsg}
+	}
+
+	response := resolver.Exchange(context.Background(), qmsg)
+	require.False(t, response.HasError())
+	require.Len(t, response.Timings, 3)
+
+	assert.Equal(t, ".", response.Timings[0].Zone)
+	assert.Equal(t, "com.", response.Timings[1].Zone)
+	assert.Equal(t, "example.com.", response.Timings[2].Zone)
+
+	assert.GreaterOrEqual(t, response.Timings[0].Network, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, response.Timings[1].Network, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, response.Timings[2].Network, 15*time.Millisecond)
+
+	var total time.Duration
+	for _, timing := range response.Timings {
+		total += timing.Total()
+	}
+	assert.LessOrEqual(t, total, response.Duration)
+	assert.Greater(t, total, response.Duration*3/4)
+}
@@ -0,0 +1,54 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"strings"
+)
+
+// tlsaName returns the owner name of the TLSA records for the service at port/proto on name.
+// e.g. _443._tcp.example.com. See https://datatracker.ietf.org/doc/html/rfc6698#section-3
+func tlsaName(name string, port int, proto string) (string, error) {
+	if port < 1 || port > 65535 {
+		return "", fmt.Errorf("%w: port %d", ErrInvalidTLSAService, port)
+	}
+
+	proto = strings.ToLower(strings.TrimPrefix(proto, "_"))
+	if proto == "" {
+		return "", fmt.Errorf("%w: empty protocol", ErrInvalidTLSAService)
+	}
+
+	return canonicalName(fmt.Sprintf("_%d._%s.%s", port, proto, dns.Fqdn(name))), nil
+}
+
+// LookupTLSA looks up the TLSA records for the service at port/proto on name, returning them along with the DNSSEC
+// state of the response. As TLSA records are only usable if they can be authenticated
+// (https://datatracker.ietf.org/doc/html/rfc6698#section-4.1), the records are only returned when they validated as
+// Secure. Otherwise ErrTLSANotSecure is returned.
+func (resolver *Resolver) LookupTLSA(ctx context.Context, name string, port int, proto string) ([]*dns.TLSA, dnssec.AuthenticationResult, error) {
+	qname, err := tlsaName(name, port, proto)
+	if err != nil {
+		return nil, dnssec.Unknown, err
+	}
+
+	qmsg := new(dns.Msg)
+	qmsg.SetQuestion(qname, dns.TypeTLSA)
+	qmsg.SetEdns0(4096, true)
+
+	response := resolver.Exchange(ctx, qmsg)
+	if response.HasError() {
+		return nil, response.Auth, response.Err
+	}
+
+	if response.Auth != dnssec.Secure {
+		return nil, response.Auth, fmt.Errorf("%w: [%s] was %s", ErrTLSANotSecure, qname, response.Auth.String())
+	}
+
+	if response.IsEmpty() {
+		return nil, response.Auth, nil
+	}
+
+	return extractRecords[*dns.TLSA](response.Msg.Answer), response.Auth, nil
+}
@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/nsmithuk/resolver/dnssec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTLSAName(t *testing.T) {
+	name, err := tlsaName("Example.com", 443, "tcp")
+	require.NoError(t, err)
+	assert.Equal(t, "_443._tcp.example.com.", name)
+
+	name, err = tlsaName("mail.example.com.", 25, "_TCP")
+	require.NoError(t, err)
+	assert.Equal(t, "_25._tcp.mail.example.com.", name)
+
+	_, err = tlsaName("example.com", 0, "tcp")
+	assert.ErrorIs(t, err, ErrInvalidTLSAService)
+
+	_, err = tlsaName("example.com", 65536, "tcp")
+	assert.ErrorIs(t, err, ErrInvalidTLSAService)
+
+	_, err = tlsaName("example.com", 443, "")
+	assert.ErrorIs(t, err, ErrInvalidTLSAService)
+}
+
+func TestResolver_LookupTLSA(t *testing.T) {
+	resolver := getTestResolverWithRoot()
+
+	auth := dnssec.Secure
+	resolver.funcs.resolveLabel = func(ctx context.Context, d *domain, z zone, qmsg *dns.Msg, _ *authenticator) (zone, *Response) {
+		assert.True(t, isSetDO(qmsg))
+		assert.Equal(t, "_443._tcp.example.com.", qmsg.Question[0].Name)
+		assert.Equal(t, dns.TypeTLSA, qmsg.Question[0].Qtype)
+
+		rmsg := new(dns.Msg).SetReply(qmsg)
+		rmsg.Answer = []dns.RR{
+			newRR("_443._tcp.example.com. 300 IN TLSA 3 1 1 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"),
+		}
+		return nil, &Response{Msg: rmsg, Auth: auth}
+	}
+
+	records, state, err := resolver.LookupTLSA(context.Background(), "example.com", 443, "tcp")
+	require.NoError(t, err)
+	assert.Equal(t, dnssec.Secure, state)
+	require.Len(t, records, 1)
+	assert.Equal(t, uint8(3), records[0].Usage)
+	assert.Equal(t, uint8(1), records[0].Selector)
+	assert.Equal(t, uint8(1), records[0].MatchingType)
+
+	// Anything other than Secure is not usable.
+	for _, auth = range []dnssec.AuthenticationResult{dnssec.Insecure, dnssec.Unknown, dnssec.Bogus, dnssec.Indeterminate} {
+		records, state, err = resolver.LookupTLSA(context.Background(), "example.com", 443, "tcp")
+		assert.ErrorIs(t, err, ErrTLSANotSecure, auth.String())
+		assert.Equal(t, auth, state)
+		assert.Nil(t, records, auth.String())
+	}
+}
@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"fmt"
 	"github.com/google/uuid"
 	"sync/atomic"
 	"time"
@@ -11,6 +12,9 @@ type Trace struct {
 	Start time.Time
 
 	Iterations atomic.Uint32
+
+	// The number of queries sent upstream.
+	queries atomic.Uint32
 }
 
 func NewTrace() *Trace {
@@ -38,3 +42,8 @@ func (t *Trace) ShortID() string {
 func (t *Trace) Iteration() uint32 {
 	return t.Iterations.Load()
 }
+
+// nextQueryID returns a new ID for a query sent upstream, unique within the trace. e.g. `a1b2c3d-q4`.
+func (t *Trace) nextQueryID() string {
+	return fmt.Sprintf("%s-q%d", t.ShortID(), t.queries.Add(1))
+}
@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+)
+
+// Attribute keys set on spans.
+const (
+	TraceAttrQName         = "dns.qname"
+	TraceAttrQType         = "dns.qtype"
+	TraceAttrZone          = "dns.zone"
+	TraceAttrServerAddr    = "dns.server.addr"
+	TraceAttrQueryID       = "dns.query.id"
+	TraceAttrRcode         = "dns.rcode"
+	TraceAttrDNSSECResult  = "dns.dnssec.result"
+	TraceAttrDNSSECDenial  = "dns.dnssec.denial"
+	TraceAttrErrorOccurred = "error"
+)
+
+// TracerInterface allows spans to be created around the phases of resolution, for distributed tracing.
+// Its shape mirrors OpenTelemetry's Tracer, such that a thin adapter can be used to plug one in, without this
+// module depending on it. The parent span is expected to be propagated via the returned context.
+type TracerInterface interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttribute(key string, value any)
+	End()
+}
+
+// noopTracer is the default tracer. It returns the context unchanged, and spans that do nothing.
+type noopTracer struct{}
+
+type noopSpan struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) SetAttribute(key string, value any) {}
+
+func (noopSpan) End() {}
+
+//---
+
+func traceQuestion(span Span, msg *dns.Msg) {
+	if msg == nil || len(msg.Question) == 0 {
+		return
+	}
+	span.SetAttribute(TraceAttrQName, msg.Question[0].Name)
+	span.SetAttribute(TraceAttrQType, TypeToString(msg.Question[0].Qtype))
+}
+
+func traceResponse(span Span, r *Response) {
+	if r.HasError() {
+		span.SetAttribute(TraceAttrErrorOccurred, r.Err.Error())
+	}
+	if !r.IsEmpty() {
+		span.SetAttribute(TraceAttrRcode, RcodeToString(r.Msg.Rcode))
+	}
+}
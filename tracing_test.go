@@ -0,0 +1,151 @@
+package resolver
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testRecordingSpanKey struct{}
+
+type testRecordingSpan struct {
+	name       string
+	parent     *testRecordingSpan
+	attributes map[string]any
+	ended      bool
+}
+
+func (s *testRecordingSpan) SetAttribute(key string, value any) {
+	s.attributes[key] = value
+}
+
+func (s *testRecordingSpan) End() {
+	s.ended = true
+}
+
+type testRecordingTracer struct {
+	lock  sync.Mutex
+	spans []*testRecordingSpan
+}
+
+func (t *testRecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(testRecordingSpanKey{}).(*testRecordingSpan)
+	span := &testRecordingSpan{name: name, parent: parent, attributes: make(map[string]any)}
+
+	t.lock.Lock()
+	t.spans = append(t.spans, span)
+	t.lock.Unlock()
+
+	return context.WithValue(ctx, testRecordingSpanKey{}, span), span
+}
+
+func (t *testRecordingTracer) find(name string) *testRecordingSpan {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+//---
+
+func TestTracing_NoopTracerLeavesContextUnchanged(t *testing.T) {
+	ctx := context.Background()
+	newCtx, span := noopTracer{}.Start(ctx, "test")
+	assert.Equal(t, ctx, newCtx)
+	assert.IsType(t, noopSpan{}, span)
+}
+
+func TestTracing_SpanHierarchy(t *testing.T) {
+
+	// We expect: resolver.Exchange -> resolver.resolveLabel -> resolver.nameserver.exchange
+
+	tracer := &testRecordingTracer{}
+	Tracer = tracer
+	defer func() { Tracer = noopTracer{} }()
+
+	resolver := getTestResolverWithRoot()
+	resolver.funcs.resolveLabel = resolver.resolveLabel
+	resolver.funcs.finaliseResponse = resolver.finaliseResponse
+	resolver.funcs.checkForMissingZones = func(ctx context.Context, d *domain, z zone, rmsg *dns.Msg, auth *authenticator) zone {
+		return z
+	}
+
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("example.com.", dns.TypeA)
+
+	rmsg := new(dns.Msg).SetReply(qmsg)
+	rmsg.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA}, A: net.IPv4(192, 0, 2, 1)},
+	}
+
+	mockClient := new(MockDNSClient)
+	mockClient.On("ExchangeContext", mock.Anything, mock.Anything, "192.0.2.53:53").Return(rmsg, time.Millisecond, nil)
+	ns := &nameserver{addr: "192.0.2.53", dnsClientFactory: func(protocol string) dnsClient {
+		return mockClient
+	}}
+
+	root := resolver.zones.getZoneList(".")[0].(*mockZone)
+	root.mockExchange = func(ctx context.Context, m *dns.Msg) *Response {
+		return ns.exchange(ctx, m)
+	}
+
+	response := resolver.Exchange(context.Background(), qmsg)
+	require.False(t, response.HasError())
+
+	exchangeSpan := tracer.find("resolver.Exchange")
+	require.NotNil(t, exchangeSpan)
+	assert.Nil(t, exchangeSpan.parent)
+	assert.True(t, exchangeSpan.ended)
+	assert.Equal(t, "example.com.", exchangeSpan.attributes[TraceAttrQName])
+	assert.Equal(t, "A", exchangeSpan.attributes[TraceAttrQType])
+	assert.Equal(t, "NoError", exchangeSpan.attributes[TraceAttrRcode])
+
+	labelSpan := tracer.find("resolver.resolveLabel")
+	require.NotNil(t, labelSpan)
+	assert.Equal(t, exchangeSpan, labelSpan.parent)
+	assert.True(t, labelSpan.ended)
+	assert.Equal(t, ".", labelSpan.attributes[TraceAttrZone])
+
+	nameserverSpan := tracer.find("resolver.nameserver.exchange")
+	require.NotNil(t, nameserverSpan)
+	assert.Equal(t, labelSpan, nameserverSpan.parent)
+	assert.True(t, nameserverSpan.ended)
+	assert.Equal(t, "192.0.2.53:53", nameserverSpan.attributes[TraceAttrServerAddr])
+	assert.Equal(t, "NoError", nameserverSpan.attributes[TraceAttrRcode])
+}
+
+func TestTracing_DNSSECSpan(t *testing.T) {
+
+	tracer := &testRecordingTracer{}
+	Tracer = tracer
+	defer func() { Tracer = noopTracer{} }()
+
+	resolver, _, _, _, _ := getTestResolverWithExample()
+	qmsg := &dns.Msg{}
+	qmsg.SetQuestion("www.example.com.", dns.TypeA)
+	qmsg.SetEdns0(4096, true)
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+
+	rmsg := qmsg.SetReply(&dns.Msg{})
+	auth := newAuthenticator(ctx, qmsg.Question[0])
+
+	resolver.finaliseResponse(ctx, auth, qmsg, &Response{Msg: rmsg})
+
+	span := tracer.find("resolver.dnssec")
+	require.NotNil(t, span)
+	assert.Equal(t, parent, span.parent)
+	assert.True(t, span.ended)
+	assert.Equal(t, "Unknown", span.attributes[TraceAttrDNSSECResult])
+	assert.Equal(t, "NotFound", span.attributes[TraceAttrDNSSECDenial])
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/miekg/dns"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,6 +20,7 @@ type zone interface {
 	clone(name, parent string) zone
 	soa(ctx context.Context, name string) (*dns.SOA, error)
 	dnskeys(ctx context.Context) ([]dns.RR, error)
+	linkDelegationSigners(records []dns.RR)
 }
 
 type zoneImpl struct {
@@ -28,9 +30,26 @@ type zoneImpl struct {
 	pool  expiringExchanger
 	calls atomic.Uint64
 
+	// enricher is used to resolve the addresses of nameservers if the pool is degraded.
+	enricher exchanger
+
 	dnskeyRecords []dns.RR
 	dnskeyExpiry  time.Time
 	dnskeyLock    sync.Mutex
+
+	// dsExpiry is when the DS records for the zone, as last seen from its parent, expire. The held DNSKEYs are only
+	// considered valid whilst both they, and the DS records they're validated against, are.
+	dsExpiry time.Time
+
+	// consistencyChecks holds the cacheConsistencyKey of each cache consistency check in progress, so that only one
+	// check is run for each cached answer at a time.
+	consistencyChecks sync.Map
+}
+
+// cacheConsistencyKey identifies a cached answer within a zone.
+type cacheConsistencyKey struct {
+	question dns.Question
+	do       bool
 }
 
 func (z *zoneImpl) name() string {
@@ -55,6 +74,7 @@ func (z *zoneImpl) clone(name, parent string) zone {
 		zoneName:   canonicalName(name),
 		parentName: canonicalName(parent),
 		pool:       z.pool,
+		enricher:   z.enricher,
 	}
 }
 
@@ -62,10 +82,24 @@ func (z *zoneImpl) exchange(ctx context.Context, m *dns.Msg) *Response {
 
 	z.calls.Add(1)
 
-	if Cache != nil {
+	do := isSetDO(m)
+
+	// A request can opt out of the cache entirely; e.g. a health check that must reach the nameservers.
+	noCache, _ := ctx.Value(CtxNoCache).(bool)
+
+	if Cache != nil && !noCache {
 		if msg, err := Cache.Get(z.zoneName, m.Question[0]); err != nil {
 			Warn(fmt.Errorf("error trying to perform a cache lookup for zone [%s]: %w", z.zoneName, err).Error())
-		} else if msg != nil {
+		} else if msg != nil && (!do || isSetDO(msg)) {
+			// A DO query can only be served by an entry cached from a DO query.
+			// A non-DO query can be served by either, but with any DNSSEC records removed.
+			msg = msg.Copy()
+			if !do && isSetDO(msg) {
+				msg.Answer = removeDNSSECRecords(msg.Answer, m.Question[0].Qtype)
+				msg.Ns = removeDNSSECRecords(msg.Ns, m.Question[0].Qtype)
+				msg.Extra = removeDNSSECRecords(removeRecordsOfType(msg.Extra, dns.TypeOPT), m.Question[0].Qtype)
+			}
+
 			trace, _ := ctx.Value(CtxTrace).(*Trace)
 			Query(fmt.Sprintf(
 				"%s-%d: response for [%s] %s in zone [%s] found in cache",
@@ -75,7 +109,28 @@ func (z *zoneImpl) exchange(ctx context.Context, m *dns.Msg) *Response {
 				TypeToString(m.Question[0].Qtype),
 				z.zoneName,
 			))
-			return &Response{Msg: msg.Copy()}
+
+			if CacheConsistencyCheck && minimumTTL(msg) <= CacheConsistencyCheckTTL {
+				key := cacheConsistencyKey{question: m.Question[0], do: do}
+				key.question.Name = canonicalName(key.question.Name)
+				if _, running := z.consistencyChecks.LoadOrStore(key, struct{}{}); !running {
+					go func() {
+						defer z.consistencyChecks.Delete(key)
+						z.checkCacheConsistency(context.WithoutCancel(ctx), m.Copy(), msg.Copy())
+					}()
+				}
+			}
+
+			return &Response{Msg: msg, fromCache: true}
+		}
+	}
+
+	recentResponses, _ := ctx.Value(ctxRecentResponses).(*coalescer)
+	if CoalescingWindow > 0 && !noCache {
+		if msg := recentResponses.get(z.zoneName, m.Question[0], do); msg != nil {
+			// The same question was answered moments ago, so we reuse that answer.
+			coalescedQueries.Add(1)
+			return &Response{Msg: msg}
 		}
 	}
 
@@ -85,20 +140,38 @@ func (z *zoneImpl) exchange(ctx context.Context, m *dns.Msg) *Response {
 		return ResponseError(fmt.Errorf("%w [%s]", ErrNoPoolConfiguredForZone, z.zoneName))
 	}
 
+	if breaker, ok := z.pool.(circuitBreaker); ok && !breaker.allow() {
+		// Every server in the pool has been failing; we give them a rest rather than trying them again.
+		Debug(fmt.Sprintf("circuit breaker open for zone [%s]; not sending query for [%s]", z.zoneName, m.Question[0].Name))
+		return &Response{
+			Msg: new(dns.Msg).SetRcode(m, dns.RcodeServerFailure),
+			Err: fmt.Errorf("%w [%s]: %w", ErrPoolUnavailable, z.zoneName, ErrServerFailure),
+		}
+	}
+
+	if pool, ok := z.pool.(*nameserverPool); ok && z.enricher != nil && pool.takeDegraded() {
+		// The pool had too few addresses after it was last enriched. We'll use what we have, and try again in the background.
+		go reenrichPool(context.WithoutCancel(ctx), z.zoneName, pool, z.enricher)
+	}
+
 	ctx = context.WithValue(ctx, ctxZoneName, z.zoneName)
 	response := z.pool.exchange(ctx, m)
 
 	//---
 
-	if Cache != nil && !response.IsEmpty() && !response.HasError() {
-		go func(zone string, question dns.Question, msg *dns.Msg) {
-			// We never cache OPT records.
-			msg.Extra = removeRecordsOfType(msg.Extra, dns.TypeOPT)
+	// Oversized responses are never copied to be held for later. These are normally rejected by the nameserver, but
+	// not every pool is made up of nameservers.
+	oversized := response.oversized()
 
-			if err := Cache.Update(zone, question, msg); err != nil {
-				Warn(fmt.Errorf("error trying to perform a cache update for zone [%s]: %w", z.zoneName, err).Error())
-			}
-		}(z.zoneName, m.Question[0], response.Msg.Copy())
+	if CoalescingWindow > 0 && !noCache && !oversized && !response.IsEmpty() && !response.HasError() {
+		recentResponses.add(z.zoneName, m.Question[0], do, response.Msg, CoalescingWindow)
+	}
+
+	if Cache != nil && !noCache && !oversized && !response.IsEmpty() && !response.HasError() {
+		question, msg := m.Question[0], response.Msg.Copy()
+		if !getCacheWriter().enqueue(func() { z.updateCache(question, msg, do) }) {
+			Debug(fmt.Sprintf("cache writer queue full; dropping cache update for [%s] in zone [%s]", question.Name, z.zoneName))
+		}
 	}
 
 	//---
@@ -106,6 +179,63 @@ func (z *zoneImpl) exchange(ctx context.Context, m *dns.Msg) *Response {
 	return response
 }
 
+func (z *zoneImpl) updateCache(question dns.Question, msg *dns.Msg, do bool) {
+	// We never cache OPT records received.
+	msg.Extra = removeRecordsOfType(msg.Extra, dns.TypeOPT)
+
+	// But we do record if the response was for a DO query, so we know if it can be served to DO queries later.
+	if do {
+		msg.SetEdns0(dns.DefaultMsgSize, true)
+	}
+
+	var err error
+	if cache, ok := Cache.(CacheRetentionInterface); ok {
+		err = cache.UpdateWithRetention(z.zoneName, question, msg, cacheRetention(msg))
+	} else {
+		err = Cache.Update(z.zoneName, question, msg)
+	}
+
+	if err != nil {
+		Warn(fmt.Errorf("error trying to perform a cache update for zone [%s]: %w", z.zoneName, err).Error())
+	}
+}
+
+// checkCacheConsistency queries the zone's nameservers for m, and compares the live answer with the cached one.
+// The result is never returned to the client; this exists to surface stale cache entries.
+func (z *zoneImpl) checkCacheConsistency(ctx context.Context, m *dns.Msg, cached *dns.Msg) {
+	if z.pool == nil {
+		return
+	}
+	if breaker, ok := z.pool.(circuitBreaker); ok && !breaker.allow() {
+		return
+	}
+
+	ctx = context.WithValue(ctx, ctxZoneName, z.zoneName)
+	response := z.pool.exchange(ctx, m)
+	if response.IsEmpty() || response.HasError() {
+		// We've nothing to compare against.
+		return
+	}
+
+	// Only the records that answer the question are compared, so a zone signed online, which produces fresh
+	// signatures with each response, isn't reported as inconsistent.
+	if !answersDiffer(questionAnswer(m.Question[0], cached), questionAnswer(m.Question[0], response.Msg)) {
+		return
+	}
+
+	cacheDiscrepancies.Add(1)
+	Warn(fmt.Sprintf(
+		"cached answer for [%s] %s in zone [%s] differs from the live answer",
+		m.Question[0].Name,
+		TypeToString(m.Question[0].Qtype),
+		z.zoneName,
+	))
+
+	if CacheConsistencyCheckUpdate && Cache != nil {
+		z.updateCache(m.Question[0], response.Msg.Copy(), isSetDO(m))
+	}
+}
+
 func (z *zoneImpl) soa(ctx context.Context, name string) (*dns.SOA, error) {
 	soaMsg := new(dns.Msg)
 	soaMsg.SetQuestion(dns.Fqdn(name), dns.TypeSOA)
@@ -122,25 +252,42 @@ func (z *zoneImpl) soa(ctx context.Context, name string) (*dns.SOA, error) {
 		return nil, nil
 	}
 
-	soas := extractRecords[*dns.SOA](response.Msg.Answer)
-	if len(soas) != 1 {
-		return nil, fmt.Errorf("we expect only a single SOA for a given name / zone. we got %d", len(soas))
+	// We're only interested in a SOA owned by the name itself. Others can be present, for example if the name is a
+	// CNAME to the apex of another zone, but they don't make the name a zone apex.
+	soas := make([]*dns.SOA, 0, 1)
+	for _, soa := range extractRecords[*dns.SOA](response.Msg.Answer) {
+		if canonicalName(soa.Header().Name) != canonicalName(name) {
+			continue
+		}
+		if !slices.ContainsFunc(soas, func(s *dns.SOA) bool { return dns.IsDuplicate(s, soa) }) {
+			soas = append(soas, soa)
+		}
 	}
 
-	return soas[0], nil
+	switch len(soas) {
+	case 0:
+		return nil, nil
+	case 1:
+		return soas[0], nil
+	default:
+		return nil, fmt.Errorf("%w: got %d for [%s] in zone [%s]", ErrAmbiguousSOA, len(soas), name, z.zoneName)
+	}
 }
 
 func (z *zoneImpl) dnskeys(ctx context.Context) ([]dns.RR, error) {
 	z.dnskeyLock.Lock()
 
 	// We base this check on the expiry only, as `z.dnskeyRecords` can be both nil and valid.
-	if !z.dnskeyExpiry.IsZero() && !z.dnskeyExpiry.Before(time.Now()) {
+	if expiry := z.linkExpiry(); !expiry.IsZero() && !expiry.Before(time.Now()) {
 		keys := z.dnskeyRecords
 		z.dnskeyLock.Unlock()
 		return keys, nil
 	}
 	defer z.dnskeyLock.Unlock()
 
+	// The keys are being refreshed, so they'll need linking to the DS records afresh.
+	z.dsExpiry = time.Time{}
+
 	msg := new(dns.Msg)
 	msg.SetQuestion(dns.Fqdn(z.zoneName), dns.TypeDNSKEY)
 	msg.SetEdns0(4096, true)
@@ -155,7 +302,12 @@ func (z *zoneImpl) dnskeys(ctx context.Context) ([]dns.RR, error) {
 
 	if len(response.Msg.Answer) == 0 {
 		// If we got no answer, we'll put a short cache on that, rather than the MaxAllowedTTL.
-		z.dnskeyExpiry = time.Now().Add(time.Second * 60)
+		// Any keys previously held are dropped, as they're no longer what the zone is serving.
+		z.dnskeyRecords = nil
+		z.dnskeyExpiry = time.Time{}
+		if EmptyDNSKEYRetention > 0 {
+			z.dnskeyExpiry = time.Now().Add(EmptyDNSKEYRetention)
+		}
 		return nil, nil
 	}
 
@@ -169,3 +321,33 @@ func (z *zoneImpl) dnskeys(ctx context.Context) ([]dns.RR, error) {
 
 	return z.dnskeyRecords, nil
 }
+
+// linkDelegationSigners records the zone's DS records, as served by its parent, such that the held DNSKEYs expire no
+// later than them. Otherwise a set of keys could outlive the DS records they were validated against. Any records
+// other than DS records for this zone are ignored.
+func (z *zoneImpl) linkDelegationSigners(records []dns.RR) {
+	var ttl = MaxAllowedTTL
+	var found bool
+	for _, rr := range records {
+		if rr.Header().Rrtype == dns.TypeDS && canonicalName(rr.Header().Name) == z.zoneName {
+			ttl = min(ttl, rr.Header().Ttl)
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+
+	z.dnskeyLock.Lock()
+	defer z.dnskeyLock.Unlock()
+	z.dsExpiry = time.Now().Add(time.Duration(ttl) * time.Second)
+}
+
+// linkExpiry returns when the held DNSKEYs, and the DS records they're linked to, expire. That's the earlier of the
+// two. The caller must hold dnskeyLock.
+func (z *zoneImpl) linkExpiry() time.Time {
+	if !z.dsExpiry.IsZero() && z.dsExpiry.Before(z.dnskeyExpiry) {
+		return z.dsExpiry
+	}
+	return z.dnskeyExpiry
+}
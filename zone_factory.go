@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/miekg/dns"
+	"slices"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +26,7 @@ func createZone(ctx context.Context, name, parent string, nameservers []*dns.NS,
 		if !LazyEnrichment {
 			go func() {
 				enrichPool(ctx, name, pool, exchanger)
+				pool.checkDegraded()
 			}()
 		}
 	case PoolPrimed:
@@ -33,6 +36,9 @@ func createZone(ctx context.Context, name, parent string, nameservers []*dns.NS,
 		if err != nil {
 			return nil, err
 		}
+		if pool.checkDegraded() {
+			Debug(fmt.Sprintf("zone pool for [%s] is degraded: fewer than %d usable addresses after enrichment", name, pool.minNameservers))
+		}
 	default:
 		// Covers PoolEmpty
 		return nil, fmt.Errorf("%w for [%s]: the nameserver pool is empty and we have no hostnames to enrich", ErrFailedCreatingZoneAndPool, name)
@@ -42,25 +48,75 @@ func createZone(ctx context.Context, name, parent string, nameservers []*dns.NS,
 		zoneName:   name,
 		parentName: parent,
 		pool:       pool,
+		enricher:   exchanger,
 	}
 
 	Debug(fmt.Sprintf("new zone created [%s]", name))
 
+	if PreferAuthoritativeNameserverAddresses {
+		if hosts := pool.hostnamesWithin(name); len(hosts) > 0 {
+			go refreshGlue(context.WithoutCancel(ctx), name, pool, hosts, exchanger)
+		}
+	}
+
 	// TODO: It would be good if we validated, via DNSSEC, nameserver details. Perhaps we could go do this.
 	// And use low TTLs until it's done.
 
 	return z, nil
 }
 
+// poolEnrichment is a single, in progress, enrichment of a pool. done is closed once err is set.
+type poolEnrichment struct {
+	done chan struct{}
+	err  error
+}
+
+// enrichPool resolves the addresses of the pool's nameservers that don't yet have any. If the pool is already being
+// enriched (e.g. in the background by createZone() whilst a degraded pool is re-enriched), we wait for, and share the
+// outcome of, that enrichment, rather than resolving the same hostnames again. As the enrichment is shared, it's not
+// stopped by any one caller's context ending; only that caller stops waiting for it.
 func enrichPool(ctx context.Context, zoneName string, pool *nameserverPool, exchanger exchanger) error {
-	if len(pool.hostsWithoutAddresses) == 0 {
-		return fmt.Errorf("%w [%s]: the nameserver pool is empty so we have no hostnames to enrich", ErrFailedEnrichingPool, zoneName)
+	pool.enrichmentLock.Lock()
+	e := pool.enrichment
+	if e != nil {
+		pool.enrichmentLock.Unlock()
+		enrichmentJoined(zoneName)
+	} else {
+		e = &poolEnrichment{done: make(chan struct{})}
+		pool.enrichment = e
+		pool.enrichmentLock.Unlock()
+
+		go func() {
+			e.err = resolvePoolAddresses(context.WithoutCancel(ctx), zoneName, pool, exchanger)
+
+			pool.enrichmentLock.Lock()
+			pool.enrichment = nil
+			pool.enrichmentLock.Unlock()
+			close(e.done)
+		}()
+	}
+
+	select {
+	case <-e.done:
+		return e.err
+	case <-ctx.Done():
+		return fmt.Errorf("%w [%s]: %w", ErrFailedEnrichingPool, zoneName, ctx.Err())
 	}
+}
+
+// enrichmentJoined is called when enrichPool() waits for an enrichment already in progress. It's a variable to aid
+// testing.
+var enrichmentJoined = func(zoneName string) {
+	Debug(fmt.Sprintf("waiting for the enrichment already in progress for zone [%s]", zoneName))
+}
 
-	hosts := pool.hostsWithoutAddresses
+func resolvePoolAddresses(ctx context.Context, zoneName string, pool *nameserverPool, exchanger exchanger) error {
+	pool.updating.RLock()
+	hosts := slices.Clone(pool.hostsWithoutAddresses)
+	pool.updating.RUnlock()
 
-	if len(hosts) > DesireNumberOfNameserversPerZone {
-		hosts = hosts[:DesireNumberOfNameserversPerZone]
+	if len(hosts) == 0 {
+		return fmt.Errorf("%w [%s]: the nameserver pool is empty so we have no hostnames to enrich", ErrFailedEnrichingPool, zoneName)
 	}
 
 	types := make([]uint16, 0, 2)
@@ -71,24 +127,48 @@ func enrichPool(ctx context.Context, zoneName string, pool *nameserverPool, exch
 
 	//---
 
-	done := make(chan bool)
+	// We try every host until DesireNumberOfNameserversPerZone of them have resolved. If none of them resolve, we
+	// know as soon as we've tried them all, rather than waiting for the timeout. done is buffered, as once we've timed
+	// out, nothing is left to receive from it.
+	done := make(chan bool, 1)
+	failed := make(chan struct{})
 	go func() {
 		doneCalled := false
-		for _, t := range types {
-			for _, domain := range hosts {
+		resolved := 0
+		for _, domain := range hosts {
+			if resolved >= DesireNumberOfNameserversPerZone {
+				break
+			}
+
+			nsCtx, ok := nameserverResolutionContext(ctx, domain)
+			if !ok {
+				Debug(fmt.Sprintf("skipping nameserver [%s] for zone [%s] as we're already resolving it", domain, zoneName))
+				continue
+			}
+
+			found := false
+			for _, t := range types {
 				qmsg := new(dns.Msg)
 				qmsg.SetQuestion(dns.Fqdn(domain), t)
 
-				response := exchanger.exchange(ctx, qmsg)
+				response := exchanger.exchange(nsCtx, qmsg)
 				if !response.HasError() && !response.IsEmpty() && len(response.Msg.Answer) > 0 {
 					// enrich if the response is good.
 					pool.enrich(response.Msg.Answer)
+					found = true
 					if !doneCalled {
 						done <- true
 						doneCalled = true
 					}
 				}
 			}
+
+			if found {
+				resolved++
+			}
+		}
+		if !doneCalled {
+			close(failed)
 		}
 	}()
 
@@ -100,6 +180,8 @@ func enrichPool(ctx context.Context, zoneName string, pool *nameserverPool, exch
 		default:
 			return fmt.Errorf("%w [%s]: the nameserver pool still not primed after enrichment", ErrFailedEnrichingPool, zoneName)
 		}
+	case <-failed:
+		return fmt.Errorf("%w [%s]: %w: tried %d hostnames", ErrFailedEnrichingPool, zoneName, ErrNoResolvableNameservers, len(hosts))
 	case <-time.After(3 * time.Second):
 		return fmt.Errorf("%w [%s]: enrichment timeout", ErrFailedEnrichingPool, zoneName)
 	}
@@ -108,3 +190,90 @@ func enrichPool(ctx context.Context, zoneName string, pool *nameserverPool, exch
 
 	return nil
 }
+
+// reenrichPool tries again to enrich a pool that was flagged as degraded. If the pool has since reached its minimum
+// number of nameservers, e.g. via enrichment that was still running in the background, nothing is done.
+func reenrichPool(ctx context.Context, zoneName string, pool *nameserverPool, exchanger exchanger) {
+	if !pool.checkDegraded() {
+		return
+	}
+
+	Debug(fmt.Sprintf("re-enriching degraded zone pool for [%s]", zoneName))
+
+	if err := enrichPool(ctx, zoneName, pool, exchanger); err != nil {
+		Warn(fmt.Errorf("error re-enriching degraded zone pool: %w", err).Error())
+	}
+
+	pool.checkDegraded()
+}
+
+var glueDiscrepancies atomic.Uint64
+
+// GlueDiscrepancies returns the number of times a nameserver's glue addresses, given by a parent zone, were found to
+// differ from the child zone's authoritative addresses. Only populated when PreferAuthoritativeNameserverAddresses
+// is enabled.
+func GlueDiscrepancies() uint64 {
+	return glueDiscrepancies.Load()
+}
+
+// refreshGlue resolves the addresses of the given nameservers, which are within the zone and so were given to us as
+// glue by the parent. Where the zone's authoritative addresses differ from the glue, the pool is updated to use them.
+func refreshGlue(ctx context.Context, zoneName string, pool *nameserverPool, hosts []string, exchanger exchanger) {
+	types := make([]uint16, 0, 2)
+	types = append(types, dns.TypeA)
+	if IPv6Available() {
+		types = append(types, dns.TypeAAAA)
+	}
+
+	for _, hostname := range hosts {
+		nsCtx, ok := nameserverResolutionContext(ctx, hostname)
+		if !ok {
+			continue
+		}
+
+		for _, t := range types {
+			qmsg := new(dns.Msg)
+			qmsg.SetQuestion(dns.Fqdn(hostname), t)
+
+			response := exchanger.exchange(nsCtx, qmsg)
+			if response.HasError() || response.IsEmpty() || len(response.Msg.Answer) == 0 {
+				continue
+			}
+
+			if pool.replaceAddresses(hostname, response.Msg.Answer) {
+				glueDiscrepancies.Add(1)
+				Warn(fmt.Sprintf(
+					"glue %s records for nameserver [%s] of zone [%s] differ from its authoritative records; using the authoritative records",
+					TypeToString(t),
+					hostname,
+					zoneName,
+				))
+			}
+		}
+	}
+}
+
+// nameserverResolutionContext returns the context to use when resolving the address of a nameserver's hostname.
+// These "sideways" resolutions have their own budget, MaxQueriesPerNameserverResolution, which is shared by all
+// nameserver resolutions within a request. They therefore don't consume the main query's MaxQueriesPerRequest.
+// Returns false if the hostname is already being resolved further up the chain; i.e. we've found a loop.
+func nameserverResolutionContext(ctx context.Context, hostname string) (context.Context, bool) {
+	hostname = canonicalName(hostname)
+
+	chain, _ := ctx.Value(ctxNameserverResolutions).([]string)
+	if slices.Contains(chain, hostname) {
+		return ctx, false
+	}
+	ctx = context.WithValue(ctx, ctxNameserverResolutions, append(slices.Clip(chain), hostname))
+
+	counter, ok := ctx.Value(ctxNameserverQueries).(*atomic.Uint32)
+	if !ok {
+		counter = new(atomic.Uint32)
+		ctx = context.WithValue(ctx, ctxNameserverQueries, counter)
+	}
+
+	ctx = context.WithValue(ctx, ctxSessionQueries, counter)
+	ctx = context.WithValue(ctx, ctxQueryLimit, MaxQueriesPerNameserverResolution)
+
+	return ctx, true
+}
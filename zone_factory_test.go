@@ -3,6 +3,9 @@ package resolver
 import (
 	"context"
 	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -175,3 +178,292 @@ func TestCreateZone_SuccessWithOptionalEnrichment(t *testing.T) {
 	}
 	assert.Equal(t, pool.status(), PoolPrimed)
 }
+
+func TestCreateZone_NoNameserversResolvable(t *testing.T) {
+	// None of the NS targets have glue, and none resolve. We expect a specific error, without waiting for the timeout.
+
+	nameservers := []*dns.NS{
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns1.unresolvable.test."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns2.unresolvable.test."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns3.unresolvable.test."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns4.unresolvable.test."},
+	}
+
+	tried := make(map[string]bool)
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, m *dns.Msg) *Response {
+			tried[m.Question[0].Name] = true
+			return &Response{Msg: new(dns.Msg).SetRcode(m, dns.RcodeNameError)}
+		},
+	}
+
+	start := time.Now()
+	z, err := createZone(context.TODO(), "example.com.", "com.", nameservers, []dns.RR{}, exchanger)
+
+	assert.Nil(t, z)
+	assert.ErrorIs(t, err, ErrFailedEnrichingPool)
+	assert.ErrorIs(t, err, ErrNoResolvableNameservers)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Every hostname should have been tried, not just the first DesireNumberOfNameserversPerZone.
+	assert.Len(t, tried, len(nameservers))
+}
+
+func TestCreateZone_LaterNameserverResolvable(t *testing.T) {
+	// The first few NS targets don't resolve, but a later one does.
+
+	nameservers := []*dns.NS{
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns1.unresolvable.test."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns2.unresolvable.test."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns3.unresolvable.test."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns4.example.net."},
+	}
+
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, m *dns.Msg) *Response {
+			rmsg := new(dns.Msg).SetReply(m)
+			if m.Question[0].Name == "ns4.example.net." && m.Question[0].Qtype == dns.TypeA {
+				rmsg.Answer = []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Name: "ns4.example.net.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.53")},
+				}
+			}
+			return &Response{Msg: rmsg}
+		},
+	}
+
+	z, err := createZone(context.TODO(), "example.com.", "com.", nameservers, []dns.RR{}, exchanger)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, z)
+}
+
+func TestCreateZone_DegradedPool(t *testing.T) {
+	// Enrichment yields only one address, which is below MinNameserversPerZone. The zone is still created, but its
+	// pool is flagged as degraded. Once the second nameserver becomes resolvable, re-enrichment clears the flag.
+
+	original := MinNameserversPerZone
+	MinNameserversPerZone = 2
+	defer func() { MinNameserversPerZone = original }()
+
+	nameservers := []*dns.NS{
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns1.example.net."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns2.example.net."},
+	}
+
+	var ns2Resolvable atomic.Bool
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, m *dns.Msg) *Response {
+			rmsg := new(dns.Msg).SetReply(m)
+			if m.Question[0].Qtype != dns.TypeA {
+				return &Response{Msg: rmsg}
+			}
+			switch m.Question[0].Name {
+			case "ns1.example.net.":
+				rmsg.Answer = []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Name: "ns1.example.net.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.53")},
+				}
+			case "ns2.example.net.":
+				if ns2Resolvable.Load() {
+					rmsg.Answer = []dns.RR{
+						&dns.A{Hdr: dns.RR_Header{Name: "ns2.example.net.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.54")},
+					}
+				}
+			}
+			return &Response{Msg: rmsg}
+		},
+	}
+
+	z, err := createZone(context.TODO(), "example.com.", "com.", nameservers, []dns.RR{}, exchanger)
+	assert.NoError(t, err)
+	assert.NotNil(t, z)
+
+	pool, ok := z.(*zoneImpl).pool.(*nameserverPool)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), pool.countIPv4())
+	assert.True(t, pool.degraded.Load())
+
+	// The flag is only taken once, so only one re-enrichment is started.
+	assert.True(t, pool.takeDegraded())
+	assert.False(t, pool.takeDegraded())
+
+	ns2Resolvable.Store(true)
+	reenrichPool(context.TODO(), "example.com.", pool, exchanger)
+
+	assert.Equal(t, uint32(2), pool.countIPv4())
+	assert.False(t, pool.degraded.Load())
+}
+
+func TestEnrichPool_Concurrent(t *testing.T) {
+	// Concurrent enrichments of the same pool share a single set of queries, and never duplicate an address.
+
+	pool := newNameserverPool([]*dns.NS{
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns1.example.net."},
+	}, []dns.RR{})
+
+	var queries atomic.Uint32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, m *dns.Msg) *Response {
+			rmsg := new(dns.Msg).SetReply(m)
+			if m.Question[0].Qtype != dns.TypeA {
+				return &Response{Msg: rmsg}
+			}
+			if queries.Add(1) == 1 {
+				close(started)
+			}
+			<-release
+			rmsg.Answer = []dns.RR{
+				&dns.A{Hdr: dns.RR_Header{Name: "ns1.example.net.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.53")},
+			}
+			return &Response{Msg: rmsg}
+		},
+	}
+
+	joined := make(chan struct{})
+	defer func(f func(string)) { enrichmentJoined = f }(enrichmentJoined)
+	enrichmentJoined = func(zoneName string) {
+		joined <- struct{}{}
+	}
+
+	// The first caller starts the enrichment; but gives up waiting for it.
+	firstCtx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		first <- enrichPool(firstCtx, "example.com.", pool, exchanger)
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 9)
+	for i := 0; i < 9; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- enrichPool(context.TODO(), "example.com.", pool, exchanger)
+		}()
+	}
+
+	// Every other caller waits for the enrichment already in progress, before the query is answered.
+	for i := 0; i < 9; i++ {
+		<-joined
+	}
+
+	// The first caller's context ending doesn't stop the enrichment for the others.
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint32(1), queries.Load())
+	assert.Equal(t, uint32(1), pool.countIPv4())
+
+	// Enriching again with the same records doesn't add the address twice.
+	pool.hostsWithoutAddresses = []string{"ns1.example.net."}
+	pool.enrich([]dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "ns1.example.net.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.53")},
+	})
+	assert.Equal(t, uint32(1), pool.countIPv4())
+}
+
+func TestNameserverResolutionContext(t *testing.T) {
+	ctx := context.Background()
+
+	ctx1, ok := nameserverResolutionContext(ctx, "ns1.example.net.")
+	assert.True(t, ok)
+	assert.Equal(t, MaxQueriesPerNameserverResolution, ctx1.Value(ctxQueryLimit))
+
+	// A nested resolution shares the same budget.
+	ctx2, ok := nameserverResolutionContext(ctx1, "ns1.example.org.")
+	assert.True(t, ok)
+	assert.Same(t, ctx1.Value(ctxSessionQueries), ctx2.Value(ctxSessionQueries))
+
+	// But a loop is detected.
+	_, ok = nameserverResolutionContext(ctx2, "NS1.example.net.")
+	assert.False(t, ok)
+
+	// Siblings are not considered a loop.
+	_, ok = nameserverResolutionContext(ctx1, "ns2.example.net.")
+	assert.True(t, ok)
+}
+
+func TestCreateZone_PreferAuthoritativeNameserverAddresses(t *testing.T) {
+	// The parent's glue for ns1 differs from the child's authoritative address, whilst ns2's matches.
+
+	original := PreferAuthoritativeNameserverAddresses
+	PreferAuthoritativeNameserverAddresses = true
+	defer func() { PreferAuthoritativeNameserverAddresses = original }()
+
+	nameservers := []*dns.NS{
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns1.example.com."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns2.example.com."},
+		{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS}, Ns: "ns3.example.net."},
+	}
+	extra := []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "ns1.example.com.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.53")},
+		&dns.A{Hdr: dns.RR_Header{Name: "ns2.example.com.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.54")},
+		&dns.A{Hdr: dns.RR_Header{Name: "ns3.example.net.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.55")},
+	}
+
+	var lock sync.Mutex
+	var asked []string
+	exchanger := &mockExchanger{
+		mockExchange: func(ctx context.Context, m *dns.Msg) *Response {
+			lock.Lock()
+			asked = append(asked, m.Question[0].Name)
+			lock.Unlock()
+
+			rmsg := new(dns.Msg).SetReply(m)
+			if m.Question[0].Qtype != dns.TypeA {
+				return &Response{Msg: rmsg}
+			}
+			switch m.Question[0].Name {
+			case "ns1.example.com.":
+				rmsg.Answer = []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Name: "ns1.example.com.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.153")},
+				}
+			case "ns2.example.com.":
+				rmsg.Answer = []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Name: "ns2.example.com.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP("192.0.2.54")},
+				}
+			}
+			return &Response{Msg: rmsg}
+		},
+	}
+
+	discrepancies := GlueDiscrepancies()
+
+	z, err := createZone(context.TODO(), "example.com.", "com.", nameservers, extra, exchanger)
+	assert.NoError(t, err)
+
+	pool := z.(*zoneImpl).pool.(*nameserverPool)
+
+	addresses := func() []string {
+		pool.updating.RLock()
+		defer pool.updating.RUnlock()
+		var addrs []string
+		for _, e := range pool.ipv4 {
+			addrs = append(addrs, e.(*nameserver).addr)
+		}
+		return addrs
+	}
+
+	// Once refreshed, the authoritative address is used in place of the glue.
+	assert.Eventually(t, func() bool {
+		return slices.Contains(addresses(), "192.0.2.153")
+	}, time.Second, 10*time.Millisecond)
+
+	assert.ElementsMatch(t, []string{"192.0.2.153", "192.0.2.54", "192.0.2.55"}, addresses())
+	assert.Equal(t, uint32(3), pool.countIPv4())
+	assert.Equal(t, discrepancies+1, GlueDiscrepancies())
+
+	// Only the nameservers within the zone are checked.
+	lock.Lock()
+	defer lock.Unlock()
+	assert.NotContains(t, asked, "ns3.example.net.")
+}
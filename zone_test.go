@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"github.com/stretchr/testify/mock"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -187,3 +189,593 @@ func TestZone_DNSKeys_EmptyAnswer(t *testing.T) {
 	// We expect expiry to be in the future.
 	assert.Greater(t, z.dnskeyExpiry, time.Now())
 }
+
+func TestZone_DNSKeys_EmptyAnswerRetention(t *testing.T) {
+	// An empty answer shouldn't mask the keys once the zone starts serving them again.
+
+	z := &zoneImpl{zoneName: "example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+
+	empty := &Response{Msg: &dns.Msg{Answer: []dns.RR{}}}
+	withKeys := &Response{
+		Msg: &dns.Msg{
+			Answer: []dns.RR{&dns.DNSKEY{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300}}},
+		},
+	}
+
+	mockPool.On("exchange", mock.Anything, mock.AnythingOfType("*dns.Msg")).Return(empty).Once()
+	mockPool.On("exchange", mock.Anything, mock.AnythingOfType("*dns.Msg")).Return(withKeys).Once()
+	mockPool.On("exchange", mock.Anything, mock.AnythingOfType("*dns.Msg")).Return(empty)
+
+	keys, err := z.dnskeys(context.TODO())
+	assert.NoError(t, err)
+	assert.Nil(t, keys)
+
+	// Within EmptyDNSKEYRetention, the empty answer is remembered.
+	keys, err = z.dnskeys(context.TODO())
+	assert.NoError(t, err)
+	assert.Nil(t, keys)
+	mockPool.AssertNumberOfCalls(t, "exchange", 1)
+
+	// Once it's passed, the keys are fetched.
+	z.dnskeyExpiry = time.Now().Add(-time.Second)
+	keys, err = z.dnskeys(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, withKeys.Msg.Answer, keys)
+	mockPool.AssertNumberOfCalls(t, "exchange", 2)
+
+	// If the zone then stops serving keys, the old ones are not returned.
+	z.dnskeyExpiry = time.Now().Add(-time.Second)
+	keys, err = z.dnskeys(context.TODO())
+	assert.NoError(t, err)
+	assert.Nil(t, keys)
+	keys, _ = z.dnskeys(context.TODO())
+	assert.Nil(t, keys)
+	mockPool.AssertNumberOfCalls(t, "exchange", 3)
+
+	// With no retention, we ask every time.
+	original := EmptyDNSKEYRetention
+	EmptyDNSKEYRetention = 0
+	defer func() { EmptyDNSKEYRetention = original }()
+
+	z.dnskeyExpiry = time.Now().Add(-time.Second)
+	_, _ = z.dnskeys(context.TODO())
+	_, _ = z.dnskeys(context.TODO())
+	mockPool.AssertNumberOfCalls(t, "exchange", 5)
+}
+
+func TestZone_DNSKeys_LinkedToDS(t *testing.T) {
+	z := &zoneImpl{zoneName: "example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+
+	withKeys := &Response{
+		Msg: &dns.Msg{
+			Answer: []dns.RR{&dns.DNSKEY{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600}}},
+		},
+	}
+	mockPool.On("exchange", mock.Anything, mock.AnythingOfType("*dns.Msg")).Return(withKeys)
+
+	_, err := z.dnskeys(context.TODO())
+	assert.NoError(t, err)
+
+	// With no DS records seen, the keys are held for their own TTL.
+	assert.WithinDuration(t, time.Now().Add(time.Hour), z.linkExpiry(), time.Second)
+
+	// DS records with a lower TTL bring the expiry forward. Only DS records for the zone itself are considered.
+	z.linkDelegationSigners([]dns.RR{
+		newRR("example.com. 300 IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A86764247C"),
+		newRR("sub.example.com. 60 IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A86764247C"),
+		newRR("example.com. 60 IN NS ns1.example.com."),
+	})
+	assert.WithinDuration(t, time.Now().Add(300*time.Second), z.linkExpiry(), time.Second)
+
+	// But DS records with a higher TTL don't extend it.
+	z.linkDelegationSigners([]dns.RR{
+		newRR("example.com. 86400 IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A86764247C"),
+	})
+	assert.WithinDuration(t, time.Now().Add(time.Hour), z.linkExpiry(), time.Second)
+
+	// Whilst the linkage is valid, the keys are held.
+	_, _ = z.dnskeys(context.TODO())
+	mockPool.AssertNumberOfCalls(t, "exchange", 1)
+
+	// Once the DS records expire, the keys are fetched again, even though their own TTL has not passed.
+	z.dsExpiry = time.Now().Add(-time.Second)
+	_, err = z.dnskeys(context.TODO())
+	assert.NoError(t, err)
+	mockPool.AssertNumberOfCalls(t, "exchange", 2)
+
+	// Until the DS records are seen again, the keys are held for their own TTL.
+	assert.True(t, z.dsExpiry.IsZero())
+	_, _ = z.dnskeys(context.TODO())
+	mockPool.AssertNumberOfCalls(t, "exchange", 2)
+}
+
+type testZoneMockCache struct {
+	msg     *dns.Msg
+	updated chan *dns.Msg
+}
+
+func (c *testZoneMockCache) Get(zone string, question dns.Question) (*dns.Msg, error) {
+	return c.msg, nil
+}
+
+func (c *testZoneMockCache) Update(zone string, question dns.Question, msg *dns.Msg) error {
+	c.updated <- msg
+	return nil
+}
+
+func getTestCacheResponse(do bool) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.Response = true
+	msg.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA}},
+	}
+	if do {
+		msg.Answer = append(msg.Answer, &dns.RRSIG{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeRRSIG}, TypeCovered: dns.TypeA})
+		msg.SetEdns0(4096, true)
+	}
+	return msg
+}
+
+func TestZone_Exchange_CacheNonDOQueryStripsDNSSEC(t *testing.T) {
+
+	// A non-DO query served from an entry cached by a DO query should not receive any RRSIGs.
+
+	z := &zoneImpl{zoneName: "example.com."}
+	z.pool = new(MockExpiringExchanger)
+
+	Cache = &testZoneMockCache{msg: getTestCacheResponse(true)}
+	defer func() { Cache = nil }()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	response := z.exchange(ctx, msg)
+
+	assert.NoError(t, response.Err)
+	assert.Len(t, response.Msg.Answer, 1)
+	assert.False(t, recordsOfTypeExist(response.Msg.Answer, dns.TypeRRSIG))
+	assert.False(t, isSetDO(response.Msg))
+}
+
+func TestZone_Exchange_CacheDOQueryNotServedNonDOEntry(t *testing.T) {
+
+	// A DO query should not be served an entry cached by a non-DO query. Instead, the pool is queried.
+
+	z := &zoneImpl{zoneName: "example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+
+	cache := &testZoneMockCache{msg: getTestCacheResponse(false), updated: make(chan *dns.Msg, 1)}
+	Cache = cache
+	defer func() { Cache = nil }()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, true)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	expectedResponse := &Response{Msg: getTestCacheResponse(true)}
+	mockPool.On("exchange", mock.Anything, msg).Return(expectedResponse)
+
+	response := z.exchange(ctx, msg)
+
+	assert.NoError(t, response.Err)
+	assert.Equal(t, expectedResponse, response)
+	mockPool.AssertCalled(t, "exchange", mock.Anything, msg)
+
+	// The entry we then cache should record that it was from a DO query.
+	select {
+	case updated := <-cache.updated:
+		assert.True(t, isSetDO(updated))
+		assert.True(t, recordsOfTypeExist(updated.Answer, dns.TypeRRSIG))
+	case <-time.After(time.Second):
+		t.Error("expected the cache to be updated")
+	}
+}
+
+func TestZone_Exchange_NoCache(t *testing.T) {
+
+	// A request flagged with CtxNoCache neither reads from, nor writes to, the cache.
+
+	z := &zoneImpl{zoneName: "example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+
+	cache := &testZoneMockCache{msg: getTestCacheResponse(false), updated: make(chan *dns.Msg, 1)}
+	Cache = cache
+	defer func() { Cache = nil }()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+	ctx = context.WithValue(ctx, CtxNoCache, true)
+
+	expectedResponse := &Response{Msg: getTestCacheResponse(false)}
+	mockPool.On("exchange", mock.Anything, msg).Return(expectedResponse)
+
+	response := z.exchange(ctx, msg)
+
+	// Even though the cache held an answer, the pool was asked.
+	assert.NoError(t, response.Err)
+	assert.Equal(t, expectedResponse, response)
+	mockPool.AssertCalled(t, "exchange", mock.Anything, msg)
+
+	select {
+	case <-cache.updated:
+		t.Error("the cache should not have been updated")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestZone_Exchange_OversizedNotCached(t *testing.T) {
+
+	// A response over MaxResponseSize is never copied into the cache.
+
+	z := &zoneImpl{zoneName: "example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+
+	cache := &testZoneMockCache{updated: make(chan *dns.Msg, 1)}
+	Cache = cache
+	defer func() { Cache = nil }()
+
+	MaxResponseSize = 16
+	defer func() { MaxResponseSize = DefaultMaxResponseSize }()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	expectedResponse := &Response{Msg: getTestCacheResponse(false)}
+	mockPool.On("exchange", mock.Anything, msg).Return(expectedResponse)
+
+	response := z.exchange(ctx, msg)
+	assert.Equal(t, expectedResponse, response)
+
+	select {
+	case <-cache.updated:
+		t.Error("the cache should not have been updated")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestZone_Exchange_CacheDOQueryServedDOEntry(t *testing.T) {
+
+	z := &zoneImpl{zoneName: "example.com."}
+	z.pool = new(MockExpiringExchanger)
+
+	Cache = &testZoneMockCache{msg: getTestCacheResponse(true)}
+	defer func() { Cache = nil }()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, true)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	response := z.exchange(ctx, msg)
+
+	assert.NoError(t, response.Err)
+	assert.Len(t, response.Msg.Answer, 2)
+	assert.True(t, recordsOfTypeExist(response.Msg.Answer, dns.TypeRRSIG))
+}
+
+type testZoneMockRetentionCache struct {
+	testZoneMockCache
+	retention chan time.Duration
+}
+
+func (c *testZoneMockRetentionCache) UpdateWithRetention(zone string, question dns.Question, msg *dns.Msg, retention time.Duration) error {
+	c.retention <- retention
+	return c.Update(zone, question, msg)
+}
+
+func TestZone_Exchange_CacheRetentionFloor(t *testing.T) {
+
+	// A TTL-0 answer should still be cached for MinCacheRetention, but its TTL must stay at 0.
+
+	z := &zoneImpl{zoneName: "example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+
+	cache := &testZoneMockRetentionCache{
+		testZoneMockCache: testZoneMockCache{updated: make(chan *dns.Msg, 1)},
+		retention:         make(chan time.Duration, 1),
+	}
+	Cache = cache
+	defer func() { Cache = nil }()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	rmsg := new(dns.Msg).SetReply(msg)
+	rmsg.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Ttl: 0}},
+	}
+	mockPool.On("exchange", mock.Anything, msg).Return(&Response{Msg: rmsg})
+
+	response := z.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+	assert.Equal(t, uint32(0), response.Msg.Answer[0].Header().Ttl)
+
+	select {
+	case retention := <-cache.retention:
+		assert.Equal(t, MinCacheRetention, retention)
+	case <-time.After(time.Second):
+		t.Fatal("expected the cache to be updated")
+	}
+
+	cached := <-cache.updated
+	assert.Equal(t, uint32(0), cached.Answer[0].Header().Ttl)
+
+	//---
+
+	// When served from the cache, the TTL remains 0.
+
+	cache.msg = cached
+	response = z.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+	assert.Equal(t, uint32(0), response.Msg.Answer[0].Header().Ttl)
+	mockPool.AssertNumberOfCalls(t, "exchange", 1)
+}
+
+func TestCacheRetention(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Ttl: 300}},
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Ttl: 120}},
+	}
+	msg.SetEdns0(4096, true)
+
+	// The lowest TTL is used, ignoring the OPT record.
+	assert.Equal(t, 120*time.Second, cacheRetention(msg))
+
+	msg.Answer[1].Header().Ttl = 1
+	assert.Equal(t, MinCacheRetention, cacheRetention(msg))
+}
+
+func TestZone_Exchange_CacheConsistencyCheck(t *testing.T) {
+	defer func() {
+		CacheConsistencyCheck = DefaultCacheConsistencyCheck
+		CacheConsistencyCheckUpdate = DefaultCacheConsistencyCheckUpdate
+		Cache = nil
+	}()
+	CacheConsistencyCheck = true
+	CacheConsistencyCheckUpdate = true
+
+	cached := getTestCacheResponse(false)
+	cached.Answer[0].(*dns.A).A = net.ParseIP("192.0.2.1")
+	cached.Answer[0].Header().Ttl = 10
+
+	live := getTestCacheResponse(false)
+	live.Answer[0].(*dns.A).A = net.ParseIP("192.0.2.2")
+	live.Answer[0].Header().Ttl = 300
+
+	cache := &testZoneMockCache{msg: cached, updated: make(chan *dns.Msg, 1)}
+	Cache = cache
+
+	z := &zoneImpl{zoneName: "example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+	mockPool.On("exchange", mock.Anything, mock.Anything).Return(&Response{Msg: live})
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	before := CacheDiscrepancies()
+
+	// The client should still be given the cached answer.
+	response := z.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+	assert.Equal(t, "192.0.2.1", response.Msg.Answer[0].(*dns.A).A.String())
+
+	// Then the cache updated with the live answer.
+	select {
+	case updated := <-cache.updated:
+		assert.Equal(t, "192.0.2.2", updated.Answer[0].(*dns.A).A.String())
+	case <-time.After(time.Second):
+		t.Error("expected the cache to be updated")
+	}
+
+	assert.Equal(t, before+1, CacheDiscrepancies())
+}
+
+func TestZone_Exchange_CacheConsistencyCheckNotNearExpiry(t *testing.T) {
+	defer func() {
+		CacheConsistencyCheck = DefaultCacheConsistencyCheck
+		Cache = nil
+	}()
+	CacheConsistencyCheck = true
+
+	cached := getTestCacheResponse(false)
+	cached.Answer[0].Header().Ttl = CacheConsistencyCheckTTL + 1
+	Cache = &testZoneMockCache{msg: cached}
+
+	// The mock has no expectations set, so any live query would panic.
+	z := &zoneImpl{zoneName: "example.com."}
+	z.pool = new(MockExpiringExchanger)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	response := z.exchange(ctx, msg)
+	assert.NoError(t, response.Err)
+}
+
+func TestZone_Exchange_CacheConsistencyCheckDeduplicated(t *testing.T) {
+	defer func() {
+		CacheConsistencyCheck = DefaultCacheConsistencyCheck
+		Cache = nil
+	}()
+	CacheConsistencyCheck = true
+
+	cached := getTestCacheResponse(true)
+	cached.Answer[0].(*dns.A).A = net.ParseIP("192.0.2.1")
+	cached.Answer[0].Header().Ttl = 10
+	Cache = &testZoneMockCache{msg: cached}
+
+	// The live answer has a different signature, as a zone signed online would give, but the same data.
+	live := cached.Copy()
+	live.Answer[1].(*dns.RRSIG).Signature = "c2lnbmF0dXJl"
+
+	release := make(chan struct{})
+	z := &zoneImpl{zoneName: "example.com."}
+	mockPool := new(MockExpiringExchanger)
+	z.pool = mockPool
+	mockPool.On("exchange", mock.Anything, mock.Anything).Run(func(mock.Arguments) { <-release }).Return(&Response{Msg: live})
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+	msg.SetEdns0(4096, true)
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	before := CacheDiscrepancies()
+
+	// Whilst a check is in progress, further hits on the same answer don't start another.
+	for i := 0; i < 5; i++ {
+		response := z.exchange(ctx, msg)
+		assert.NoError(t, response.Err)
+	}
+	close(release)
+
+	key := cacheConsistencyKey{question: msg.Question[0], do: true}
+	assert.Eventually(t, func() bool {
+		_, running := z.consistencyChecks.Load(key)
+		return !running
+	}, time.Second, time.Millisecond)
+	mockPool.AssertNumberOfCalls(t, "exchange", 1)
+
+	// Only the signature differed, so it's not a discrepancy.
+	assert.Equal(t, before, CacheDiscrepancies())
+}
+
+func TestAnswersDiffer(t *testing.T) {
+	a := getTestCacheResponse(false)
+	a.Answer = []dns.RR{
+		newRR("example.com. 10 IN A 192.0.2.1"),
+		newRR("example.com. 10 IN A 192.0.2.2"),
+	}
+
+	// Order, TTLs and case are ignored.
+	b := a.Copy()
+	b.Answer = []dns.RR{
+		newRR("EXAMPLE.com. 300 IN A 192.0.2.2"),
+		newRR("example.com. 300 IN A 192.0.2.1"),
+	}
+	assert.False(t, answersDiffer(a, b))
+
+	b.Answer[0] = newRR("example.com. 300 IN A 192.0.2.3")
+	assert.True(t, answersDiffer(a, b))
+
+	c := a.Copy()
+	c.Rcode = dns.RcodeNameError
+	assert.True(t, answersDiffer(a, c))
+
+	c = a.Copy()
+	c.Answer = c.Answer[:1]
+	assert.True(t, answersDiffer(a, c))
+}
+
+func TestCacheWriter_Bounded(t *testing.T) {
+
+	// A burst of updates, while the workers are blocked, should never run more than the configured number at once.
+	// Once the queue is also full, the remaining updates are dropped.
+
+	const workers, queueSize, burst = 2, 4, 50
+
+	w := newCacheWriter(workers, queueSize)
+
+	release := make(chan struct{})
+	var running, peak, completed atomic.Int32
+
+	update := func() {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		completed.Add(1)
+	}
+
+	before := CacheUpdatesDropped()
+
+	// Wait for the workers to pick up the first updates, so the queue's occupancy is deterministic.
+	for i := 0; i < workers; i++ {
+		assert.True(t, w.enqueue(update))
+	}
+	assert.Eventually(t, func() bool { return running.Load() == workers }, time.Second, time.Millisecond)
+
+	accepted := workers
+	for i := workers; i < burst; i++ {
+		if w.enqueue(update) {
+			accepted++
+		}
+	}
+
+	assert.Equal(t, workers+queueSize, accepted)
+	assert.Equal(t, uint64(burst-accepted), CacheUpdatesDropped()-before)
+
+	close(release)
+	assert.Eventually(t, func() bool { return completed.Load() == int32(accepted) }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(workers), peak.Load())
+}
+
+func TestZone_SOA_MultipleOwners(t *testing.T) {
+
+	// Only a SOA owned by the queried name should be considered; others should be ignored.
+
+	getZone := func(answer ...dns.RR) *zoneImpl {
+		mockPool := new(MockExpiringExchanger)
+		mockPool.On("exchange", mock.Anything, mock.Anything).Return(&Response{Msg: &dns.Msg{Answer: answer}})
+		return &zoneImpl{zoneName: "example.com.", pool: mockPool}
+	}
+
+	ctx := context.WithValue(context.Background(), CtxTrace, NewTrace())
+
+	apex := newRR("test.example.com. 300 IN SOA ns1.test.example.com. admin.test.example.com. 1 7200 3600 1209600 300")
+	other := newRR("example.net. 300 IN SOA ns1.example.net. admin.example.net. 1 7200 3600 1209600 300")
+
+	z := getZone(other, apex)
+	soa, err := z.soa(ctx, "test.example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, apex, soa)
+
+	//---
+
+	// If none are owned by the name, it's not a zone apex.
+
+	z = getZone(newRR("test.example.com. 300 IN CNAME example.net."), other)
+	soa, err = z.soa(ctx, "test.example.com.")
+	assert.NoError(t, err)
+	assert.Nil(t, soa)
+
+	//---
+
+	// Duplicates of the same SOA are not ambiguous.
+
+	z = getZone(apex, dns.Copy(apex))
+	soa, err = z.soa(ctx, "test.example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, apex, soa)
+
+	//---
+
+	// Differing SOAs for the name itself are.
+
+	z = getZone(apex, newRR("test.example.com. 300 IN SOA ns2.test.example.com. admin.test.example.com. 2 7200 3600 1209600 300"))
+	soa, err = z.soa(ctx, "test.example.com.")
+	assert.ErrorIs(t, err, ErrAmbiguousSOA)
+	assert.Nil(t, soa)
+}
@@ -47,7 +47,7 @@ func (zones *zones) getZoneList(name string) []zone {
 		}
 
 		// If the zone is found, but the parent don't alight with the last seen zone, then we're done.
-		if last != nil && z.parent() != last.name() {
+		if last != nil && !namesEqual(z.parent(), last.name()) {
 			break
 		}
 
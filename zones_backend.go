@@ -0,0 +1,260 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/miekg/dns"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ZoneStoreBackend is an external key-value store, used to share discovered zones (delegations and their
+// nameservers) between multiple resolver instances. Get should return nil, nil if the key is not found.
+type ZoneStoreBackend interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+const zoneStoreBackendKeyPrefix = "resolver/zone/"
+
+// zoneStoreMissesSweepSize is the number of remembered misses above which expired ones are removed.
+const zoneStoreMissesSweepSize = 1024
+
+// backedZones is a read-through zone store. Zones are held locally, as per `zones`, but are additionally written
+// to the backend when added, and read from the backend when not known locally. Names not found in the backend are
+// remembered for ZoneStoreMissRetention, so each lookup doesn't ask the backend for every label again.
+type backedZones struct {
+	zones
+	backend ZoneStoreBackend
+
+	// enricher is given to the zones loaded from the backend, to resolve their nameservers' addresses if needed.
+	enricher exchanger
+
+	missesLock sync.Mutex
+	misses     map[string]time.Time
+}
+
+func newBackedZones(backend ZoneStoreBackend, enricher exchanger) *backedZones {
+	return &backedZones{
+		backend:  backend,
+		enricher: enricher,
+		misses:   make(map[string]time.Time),
+	}
+}
+
+// storedZone is the serialised form of a zone, and its nameserver pool.
+type storedZone struct {
+	Name                  string             `json:"name"`
+	Parent                string             `json:"parent"`
+	Expires               int64              `json:"expires"`
+	IPv4                  []storedNameserver `json:"ipv4,omitempty"`
+	IPv6                  []storedNameserver `json:"ipv6,omitempty"`
+	HostsWithoutAddresses []string           `json:"hosts_without_addresses,omitempty"`
+}
+
+type storedNameserver struct {
+	Hostname string `json:"hostname"`
+	Addr     string `json:"addr"`
+}
+
+func (zones *backedZones) getZoneList(name string) []zone {
+	name = canonicalName(name)
+
+	// We ensure any zones not known locally, but known to the backend, are loaded before building the list.
+	for _, idx := range append(dns.Split(name), len(name)-1) {
+		zones.load(name[idx:])
+	}
+
+	return zones.zones.getZoneList(name)
+}
+
+func (zones *backedZones) get(name string) zone {
+	if z := zones.zones.get(name); z != nil {
+		return z
+	}
+	return zones.load(name)
+}
+
+func (zones *backedZones) add(z zone) {
+	zones.zones.add(z)
+
+	zones.missesLock.Lock()
+	delete(zones.misses, canonicalName(z.name()))
+	zones.missesLock.Unlock()
+
+	value, ttl, err := marshalZone(z)
+	if err != nil {
+		Warn(fmt.Errorf("unable to store zone [%s] in the backend: %w", z.name(), err).Error())
+		return
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	if err = zones.backend.Set(zoneStoreBackendKeyPrefix+canonicalName(z.name()), value, ttl); err != nil {
+		Warn(fmt.Errorf("unable to store zone [%s] in the backend: %w", z.name(), err).Error())
+	}
+}
+
+// load returns the zone from the backend, if it's there and not expired, adding it to the local store.
+// If the zone is already known locally, and not expired, nothing is loaded and nil is returned.
+func (zones *backedZones) load(name string) zone {
+	name = canonicalName(name)
+
+	zones.lock.RLock()
+	local, _ := zones.zones.zones[name]
+	zones.lock.RUnlock()
+	if local != nil && !local.expired() {
+		return nil
+	}
+
+	if zones.missed(name) {
+		return nil
+	}
+
+	key := zoneStoreBackendKeyPrefix + name
+
+	value, err := zones.backend.Get(key)
+	if err != nil {
+		Warn(fmt.Errorf("unable to load zone [%s] from the backend: %w", name, err).Error())
+		return nil
+	}
+	if value == nil {
+		zones.addMiss(name)
+		return nil
+	}
+
+	z, err := unmarshalZone(value, zones.enricher)
+	if err != nil || z.expired() || canonicalName(z.name()) != name {
+		if err != nil {
+			Warn(fmt.Errorf("unable to load zone [%s] from the backend: %w", name, err).Error())
+		}
+		if err = zones.backend.Delete(key); err != nil {
+			Warn(fmt.Errorf("unable to delete zone [%s] from the backend: %w", name, err).Error())
+		}
+		zones.addMiss(name)
+		return nil
+	}
+
+	Debug(fmt.Sprintf("zone [%s] loaded from the backend", name))
+
+	// Added locally only; there's no need to write it back.
+	zones.zones.add(z)
+	return z
+}
+
+// missed reports if name was recently not found in the backend.
+func (zones *backedZones) missed(name string) bool {
+	zones.missesLock.Lock()
+	defer zones.missesLock.Unlock()
+
+	expires, ok := zones.misses[name]
+	if ok && !time.Now().Before(expires) {
+		delete(zones.misses, name)
+		return false
+	}
+	return ok
+}
+
+// addMiss remembers that name was not found in the backend, for ZoneStoreMissRetention.
+func (zones *backedZones) addMiss(name string) {
+	if ZoneStoreMissRetention <= 0 {
+		return
+	}
+
+	zones.missesLock.Lock()
+	defer zones.missesLock.Unlock()
+
+	now := time.Now()
+	if len(zones.misses) >= zoneStoreMissesSweepSize {
+		for n, expires := range zones.misses {
+			if !now.Before(expires) {
+				delete(zones.misses, n)
+			}
+		}
+	}
+	zones.misses[name] = now.Add(ZoneStoreMissRetention)
+}
+
+//---
+
+func marshalZone(z zone) ([]byte, time.Duration, error) {
+	impl, ok := z.(*zoneImpl)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: unsupported zone type %T", ErrInternalError, z)
+	}
+	pool, ok := impl.pool.(*nameserverPool)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: unsupported pool type %T", ErrInternalError, impl.pool)
+	}
+
+	stored := storedZone{
+		Name:    impl.zoneName,
+		Parent:  impl.parentName,
+		Expires: pool.expires.Load(),
+	}
+
+	pool.updating.RLock()
+	stored.IPv4 = storedNameservers(pool.ipv4)
+	stored.IPv6 = storedNameservers(pool.ipv6)
+	stored.HostsWithoutAddresses = slices.Clone(pool.hostsWithoutAddresses)
+	pool.updating.RUnlock()
+
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// A pool without an expiry (e.g. the root) is retained for the MaxAllowedTTL.
+	ttl := time.Duration(MaxAllowedTTL) * time.Second
+	if stored.Expires > 0 {
+		ttl = time.Until(time.Unix(stored.Expires, 0))
+	}
+
+	return value, ttl, nil
+}
+
+func storedNameservers(exchangers []exchanger) []storedNameserver {
+	stored := make([]storedNameserver, 0, len(exchangers))
+	for _, ex := range exchangers {
+		if ns, ok := ex.(*nameserver); ok {
+			stored = append(stored, storedNameserver{Hostname: ns.hostname, Addr: ns.addr})
+		}
+	}
+	return stored
+}
+
+// unmarshalZone rebuilds a zone from its stored form. The zone is given enricher, as per those created by createZone().
+func unmarshalZone(value []byte, enricher exchanger) (*zoneImpl, error) {
+	var stored storedZone
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, err
+	}
+
+	pool := &nameserverPool{
+		hostsWithoutAddresses: stored.HostsWithoutAddresses,
+	}
+	for _, ns := range stored.IPv4 {
+		pool.ipv4 = append(pool.ipv4, newNameserver(ns.Hostname, ns.Addr))
+	}
+	for _, ns := range stored.IPv6 {
+		pool.ipv6 = append(pool.ipv6, newNameserver(ns.Hostname, ns.Addr))
+	}
+	pool.expires.Store(stored.Expires)
+	pool.updateIPCount()
+
+	// The backend may be shared with other writers, so we don't assume the names were stored in canonical form.
+	parent := stored.Parent
+	if parent != "" {
+		parent = canonicalName(parent)
+	}
+
+	return &zoneImpl{
+		zoneName:   canonicalName(stored.Name),
+		parentName: parent,
+		pool:       pool,
+		enricher:   enricher,
+	}, nil
+}
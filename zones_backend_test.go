@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMemoryZoneStoreBackend struct {
+	lock   sync.Mutex
+	values map[string][]byte
+	gets   int
+}
+
+func (b *testMemoryZoneStoreBackend) Get(key string) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.gets++
+	return b.values[key], nil
+}
+
+func (b *testMemoryZoneStoreBackend) Set(key string, value []byte, ttl time.Duration) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.values[key] = value
+	return nil
+}
+
+func (b *testMemoryZoneStoreBackend) Delete(key string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.values, key)
+	return nil
+}
+
+func getTestBackendZone(name, parent, nsAddr string) *zoneImpl {
+	nameservers := []*dns.NS{
+		{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNS, Ttl: 3600}, Ns: "ns1." + name},
+	}
+	extra := []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "ns1." + name, Rrtype: dns.TypeA, Ttl: 3600}, A: net.ParseIP(nsAddr)},
+	}
+	return &zoneImpl{
+		zoneName:   name,
+		parentName: parent,
+		pool:       newNameserverPool(nameservers, extra),
+	}
+}
+
+func TestZoneStoreBackend_SharedBetweenResolvers(t *testing.T) {
+	backend := &testMemoryZoneStoreBackend{values: make(map[string][]byte)}
+
+	ZoneStore = backend
+	defer func() { ZoneStore = nil }()
+
+	// The first resolver discovers the zones.
+	r1 := NewResolver()
+	r1.zones.add(getTestBackendZone("com.", ".", "192.0.2.1"))
+	r1.zones.add(getTestBackendZone("example.com.", "com.", "192.0.2.2"))
+
+	// The second resolver starts cold, but benefits from them.
+	r2 := NewResolver()
+	assert.Equal(t, 1, r2.CountZones())
+
+	z := r2.zones.get("example.com.")
+	require.NotNil(t, z)
+	assert.Equal(t, "example.com.", z.name())
+	assert.Equal(t, "com.", z.parent())
+	assert.False(t, z.expired())
+
+	pool, ok := z.(*zoneImpl).pool.(*nameserverPool)
+	require.True(t, ok)
+	require.Len(t, pool.ipv4, 1)
+	assert.Equal(t, "192.0.2.2", pool.ipv4[0].(*nameserver).addr)
+	assert.Equal(t, "ns1.example.com.", pool.ipv4[0].(*nameserver).hostname)
+
+	list := r2.zones.getZoneList("www.example.com.")
+	require.Len(t, list, 3)
+	assert.Equal(t, "example.com.", list[0].name())
+	assert.Equal(t, "com.", list[1].name())
+	assert.Equal(t, ".", list[2].name())
+
+	// Zones loaded from the backend can re-enrich their pools, as those created by the resolver can.
+	assert.Equal(t, r2, z.(*zoneImpl).enricher)
+}
+
+func TestZoneStoreBackend_MissesRemembered(t *testing.T) {
+	backend := &testMemoryZoneStoreBackend{values: make(map[string][]byte)}
+	zones := newBackedZones(backend, nil)
+
+	// Each label is looked for once.
+	assert.Empty(t, zones.getZoneList("www.example.com."))
+	assert.Equal(t, 4, backend.gets)
+
+	// But not again, whilst the misses are remembered.
+	assert.Empty(t, zones.getZoneList("www.example.com."))
+	assert.Nil(t, zones.get("example.com."))
+	assert.Equal(t, 4, backend.gets)
+
+	// Once a miss expires, the backend is asked again.
+	value, _, err := marshalZone(getTestBackendZone("example.com.", "com.", "192.0.2.2"))
+	require.NoError(t, err)
+	backend.values[zoneStoreBackendKeyPrefix+"example.com."] = value
+	zones.misses["example.com."] = time.Now().Add(-time.Second)
+
+	assert.NotNil(t, zones.get("example.com."))
+	assert.Equal(t, 5, backend.gets)
+
+	// Adding a zone forgets that it was missed.
+	zones.add(getTestBackendZone("com.", ".", "192.0.2.1"))
+	assert.NotContains(t, zones.misses, "com.")
+}
+
+func TestZoneStoreBackend_ExpiredZoneDeleted(t *testing.T) {
+	backend := &testMemoryZoneStoreBackend{values: make(map[string][]byte)}
+	zones := newBackedZones(backend, nil)
+
+	// We'll set the zone's expiry to be in the past.
+	z := getTestBackendZone("example.com.", "com.", "192.0.2.2")
+	z.pool.(*nameserverPool).expires.Store(time.Now().Add(-time.Minute).Unix())
+
+	value, _, err := marshalZone(z)
+	require.NoError(t, err)
+	backend.values[zoneStoreBackendKeyPrefix+"example.com."] = value
+
+	assert.Nil(t, zones.get("example.com."))
+	assert.NotContains(t, backend.values, zoneStoreBackendKeyPrefix+"example.com.")
+}
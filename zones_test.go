@@ -137,3 +137,27 @@ func TestZones_GetZoneList_BrokenChain(t *testing.T) {
 	assert.Len(t, list, 1)
 	assert.Equal(t, root, list[0])
 }
+
+func TestZones_MixedCaseNames(t *testing.T) {
+
+	// Zones whose names aren't in canonical form are still found, and still chain together.
+
+	root := getMockZone(".", "")
+	com := getMockZone("COM.", ".")
+	example := getMockZone("Example.Com.", "com.")
+
+	zs := &zones{}
+	zs.add(root)
+	zs.add(com)
+	zs.add(example)
+
+	assert.Equal(t, example, zs.get("example.com."))
+	assert.Equal(t, example, zs.get("EXAMPLE.COM."))
+	assert.Equal(t, com, zs.get("com"))
+
+	list := zs.getZoneList("WWW.example.COM.")
+	assert.Len(t, list, 3)
+	assert.Equal(t, example, list[0])
+	assert.Equal(t, com, list[1])
+	assert.Equal(t, root, list[2])
+}